@@ -0,0 +1,180 @@
+package command
+
+//go:generate go run github.com/v2fly/v2ray-core/v4/common/errors/errorgen
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+
+	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/app/dispatcher"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/features/routing"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
+)
+
+// connectionManager is implemented by dispatchers keeping a connection table.
+type connectionManager interface {
+	TrackConnections()
+	ListConnections() []*dispatcher.Connection
+	CloseConnection(id uint64) error
+	ConnectionEvents() stats.Channel
+}
+
+// connectionServer is an implementation of ConnectionService.
+type connectionServer struct {
+	dispatcher routing.Dispatcher
+}
+
+// NewConnectionServer creates a connection service with the dispatcher.
+func NewConnectionServer(dispatcher routing.Dispatcher) ConnectionServiceServer {
+	return &connectionServer{dispatcher: dispatcher}
+}
+
+func (s *connectionServer) manager() (connectionManager, error) {
+	cm, ok := s.dispatcher.(connectionManager)
+	if !ok {
+		return nil, newError("unsupported dispatcher implementation")
+	}
+	return cm, nil
+}
+
+func toProtoConnection(c *dispatcher.Connection) *Connection {
+	return &Connection{
+		Id:          c.ID,
+		SessionId:   c.SessionID,
+		InboundTag:  c.InboundTag,
+		User:        c.User,
+		Source:      c.Source.String(),
+		Target:      c.Target.String(),
+		Domain:      c.Domain,
+		Protocol:    c.Protocol,
+		OutboundTag: c.OutboundTag,
+		StartTime:   c.Start.Unix(),
+		Uplink:      c.Uplink,
+		Downlink:    c.Downlink,
+	}
+}
+
+// connectionFilter is implemented by the requests that filter connections.
+type connectionFilter interface {
+	GetInboundTag() string
+	GetOutboundTag() string
+	GetUser() string
+	GetSource() string
+	GetTarget() string
+}
+
+func match(f connectionFilter, c *Connection) bool {
+	switch {
+	case f.GetInboundTag() != "" && c.InboundTag != f.GetInboundTag():
+		return false
+	case f.GetOutboundTag() != "" && c.OutboundTag != f.GetOutboundTag():
+		return false
+	case f.GetUser() != "" && c.User != f.GetUser():
+		return false
+	case f.GetSource() != "" && !strings.Contains(c.Source, f.GetSource()):
+		return false
+	case f.GetTarget() != "" && !strings.Contains(c.Target, f.GetTarget()) && !strings.Contains(c.Domain, f.GetTarget()):
+		return false
+	}
+	return true
+}
+
+func (s *connectionServer) ListConnections(ctx context.Context, request *ListConnectionsRequest) (*ListConnectionsResponse, error) {
+	cm, err := s.manager()
+	if err != nil {
+		return nil, err
+	}
+	response := &ListConnectionsResponse{}
+	for _, c := range cm.ListConnections() {
+		if conn := toProtoConnection(c); match(request, conn) {
+			response.Connection = append(response.Connection, conn)
+		}
+	}
+	return response, nil
+}
+
+func (s *connectionServer) StreamConnectionEvents(request *StreamConnectionEventsRequest, stream ConnectionService_StreamConnectionEventsServer) error {
+	cm, err := s.manager()
+	if err != nil {
+		return err
+	}
+	events := cm.ConnectionEvents()
+	if events == nil {
+		return newError("connections are not tracked")
+	}
+	subscriber, err := stats.SubscribeRunnableChannel(events)
+	if err != nil {
+		return err
+	}
+	defer stats.UnsubscribeClosableChannel(events, subscriber)
+	for {
+		select {
+		case value, ok := <-subscriber:
+			if !ok {
+				return newError("Upstream closed the subscriber channel.")
+			}
+			event, ok := value.(*dispatcher.ConnectionEvent)
+			if !ok {
+				return newError("Upstream sent malformed connection event.")
+			}
+			conn := toProtoConnection(event.Connection)
+			if !match(request, conn) {
+				continue
+			}
+			eventType := ConnectionEvent_Opened
+			if event.Type == dispatcher.ConnectionClosed {
+				eventType = ConnectionEvent_Closed
+			}
+			if err := stream.Send(&ConnectionEvent{
+				Type:       eventType,
+				Connection: conn,
+			}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func (s *connectionServer) CloseConnection(ctx context.Context, request *CloseConnectionRequest) (*CloseConnectionResponse, error) {
+	cm, err := s.manager()
+	if err != nil {
+		return nil, err
+	}
+	if err := cm.CloseConnection(request.Id); err != nil {
+		return nil, err
+	}
+	return &CloseConnectionResponse{}, nil
+}
+
+func (s *connectionServer) mustEmbedUnimplementedConnectionServiceServer() {}
+
+type service struct {
+	v *core.Instance
+}
+
+func (s *service) Register(server *grpc.Server) {
+	common.Must(s.v.RequireFeatures(func(d routing.Dispatcher) {
+		RegisterConnectionServiceServer(server, NewConnectionServer(d))
+	}))
+}
+
+func init() {
+	common.Must(common.RegisterConfig((*Config)(nil), func(ctx context.Context, cfg interface{}) (interface{}, error) {
+		s := core.MustFromContext(ctx)
+		// The dispatcher only keeps a connection table for this service.
+		if err := s.RequireFeatures(func(d routing.Dispatcher) {
+			if cm, ok := d.(connectionManager); ok {
+				cm.TrackConnections()
+			}
+		}); err != nil {
+			return nil, err
+		}
+		return &service{v: s}, nil
+	}))
+}
@@ -0,0 +1,201 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package command
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ConnectionServiceClient is the client API for ConnectionService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ConnectionServiceClient interface {
+	ListConnections(ctx context.Context, in *ListConnectionsRequest, opts ...grpc.CallOption) (*ListConnectionsResponse, error)
+	StreamConnectionEvents(ctx context.Context, in *StreamConnectionEventsRequest, opts ...grpc.CallOption) (ConnectionService_StreamConnectionEventsClient, error)
+	CloseConnection(ctx context.Context, in *CloseConnectionRequest, opts ...grpc.CallOption) (*CloseConnectionResponse, error)
+}
+
+type connectionServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewConnectionServiceClient(cc grpc.ClientConnInterface) ConnectionServiceClient {
+	return &connectionServiceClient{cc}
+}
+
+func (c *connectionServiceClient) ListConnections(ctx context.Context, in *ListConnectionsRequest, opts ...grpc.CallOption) (*ListConnectionsResponse, error) {
+	out := new(ListConnectionsResponse)
+	err := c.cc.Invoke(ctx, "/v2ray.core.app.dispatcher.command.ConnectionService/ListConnections", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectionServiceClient) StreamConnectionEvents(ctx context.Context, in *StreamConnectionEventsRequest, opts ...grpc.CallOption) (ConnectionService_StreamConnectionEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ConnectionService_ServiceDesc.Streams[0], "/v2ray.core.app.dispatcher.command.ConnectionService/StreamConnectionEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &connectionServiceStreamConnectionEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ConnectionService_StreamConnectionEventsClient interface {
+	Recv() (*ConnectionEvent, error)
+	grpc.ClientStream
+}
+
+type connectionServiceStreamConnectionEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *connectionServiceStreamConnectionEventsClient) Recv() (*ConnectionEvent, error) {
+	m := new(ConnectionEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *connectionServiceClient) CloseConnection(ctx context.Context, in *CloseConnectionRequest, opts ...grpc.CallOption) (*CloseConnectionResponse, error) {
+	out := new(CloseConnectionResponse)
+	err := c.cc.Invoke(ctx, "/v2ray.core.app.dispatcher.command.ConnectionService/CloseConnection", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ConnectionServiceServer is the server API for ConnectionService service.
+// All implementations must embed UnimplementedConnectionServiceServer
+// for forward compatibility
+type ConnectionServiceServer interface {
+	ListConnections(context.Context, *ListConnectionsRequest) (*ListConnectionsResponse, error)
+	StreamConnectionEvents(*StreamConnectionEventsRequest, ConnectionService_StreamConnectionEventsServer) error
+	CloseConnection(context.Context, *CloseConnectionRequest) (*CloseConnectionResponse, error)
+	mustEmbedUnimplementedConnectionServiceServer()
+}
+
+// UnimplementedConnectionServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedConnectionServiceServer struct {
+}
+
+func (UnimplementedConnectionServiceServer) ListConnections(context.Context, *ListConnectionsRequest) (*ListConnectionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListConnections not implemented")
+}
+func (UnimplementedConnectionServiceServer) StreamConnectionEvents(*StreamConnectionEventsRequest, ConnectionService_StreamConnectionEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamConnectionEvents not implemented")
+}
+func (UnimplementedConnectionServiceServer) CloseConnection(context.Context, *CloseConnectionRequest) (*CloseConnectionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseConnection not implemented")
+}
+func (UnimplementedConnectionServiceServer) mustEmbedUnimplementedConnectionServiceServer() {}
+
+// UnsafeConnectionServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ConnectionServiceServer will
+// result in compilation errors.
+type UnsafeConnectionServiceServer interface {
+	mustEmbedUnimplementedConnectionServiceServer()
+}
+
+func RegisterConnectionServiceServer(s grpc.ServiceRegistrar, srv ConnectionServiceServer) {
+	s.RegisterService(&ConnectionService_ServiceDesc, srv)
+}
+
+func _ConnectionService_ListConnections_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListConnectionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectionServiceServer).ListConnections(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/v2ray.core.app.dispatcher.command.ConnectionService/ListConnections",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectionServiceServer).ListConnections(ctx, req.(*ListConnectionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ConnectionService_StreamConnectionEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamConnectionEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConnectionServiceServer).StreamConnectionEvents(m, &connectionServiceStreamConnectionEventsServer{stream})
+}
+
+type ConnectionService_StreamConnectionEventsServer interface {
+	Send(*ConnectionEvent) error
+	grpc.ServerStream
+}
+
+type connectionServiceStreamConnectionEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *connectionServiceStreamConnectionEventsServer) Send(m *ConnectionEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ConnectionService_CloseConnection_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloseConnectionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectionServiceServer).CloseConnection(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/v2ray.core.app.dispatcher.command.ConnectionService/CloseConnection",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectionServiceServer).CloseConnection(ctx, req.(*CloseConnectionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ConnectionService_ServiceDesc is the grpc.ServiceDesc for ConnectionService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ConnectionService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "v2ray.core.app.dispatcher.command.ConnectionService",
+	HandlerType: (*ConnectionServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListConnections",
+			Handler:    _ConnectionService_ListConnections_Handler,
+		},
+		{
+			MethodName: "CloseConnection",
+			Handler:    _ConnectionService_CloseConnection_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamConnectionEvents",
+			Handler:       _ConnectionService_StreamConnectionEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "app/dispatcher/command/command.proto",
+}
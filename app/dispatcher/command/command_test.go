@@ -0,0 +1,176 @@
+package command_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc"
+
+	"github.com/v2fly/v2ray-core/v4/app/dispatcher"
+	. "github.com/v2fly/v2ray-core/v4/app/dispatcher/command"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/buf"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/protocol"
+	"github.com/v2fly/v2ray-core/v4/common/session"
+	"github.com/v2fly/v2ray-core/v4/features/policy"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
+	"github.com/v2fly/v2ray-core/v4/testing/mocks"
+	"github.com/v2fly/v2ray-core/v4/transport"
+)
+
+// sinkHandler is an outbound handler that discards uplink traffic until the
+// connection is closed.
+type sinkHandler struct{}
+
+func (sinkHandler) Start() error { return nil }
+func (sinkHandler) Close() error { return nil }
+func (sinkHandler) Tag() string  { return "direct" }
+
+func (sinkHandler) Dispatch(ctx context.Context, link *transport.Link) {
+	buf.Copy(link.Reader, buf.Discard)
+	common.Interrupt(link.Writer)
+}
+
+func waitEvent(t *testing.T, events chan interface{}, eventType dispatcher.ConnectionEventType) *dispatcher.Connection {
+	t.Helper()
+	select {
+	case value := <-events:
+		event := value.(*dispatcher.ConnectionEvent)
+		if event.Type != eventType {
+			t.Fatal("expected event ", eventType, ", got ", event.Type)
+		}
+		return event.Connection
+	case <-time.After(time.Second * 2):
+		t.Fatal("timeout waiting for connection event ", eventType)
+	}
+	return nil
+}
+
+func TestConnectionService(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+
+	om := mocks.NewOutboundManager(mockCtl)
+	om.EXPECT().GetDefaultHandler().Return(sinkHandler{}).AnyTimes()
+
+	d := new(dispatcher.DefaultDispatcher)
+	common.Must(d.Init(&dispatcher.Config{}, om, nil, policy.DefaultManager{}, stats.NoopManager{}))
+	d.TrackConnections()
+	defer d.Close()
+
+	events, err := stats.SubscribeRunnableChannel(d.ConnectionEvents())
+	common.Must(err)
+	s := NewConnectionServer(d)
+
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{
+		Tag:    "socks",
+		Source: net.TCPDestination(net.ParseAddress("1.2.3.4"), 5678),
+		User:   &protocol.MemoryUser{Email: "love@v2fly.org"},
+	})
+	link, err := d.Dispatch(ctx, net.TCPDestination(net.DomainAddress("example.com"), 80))
+	common.Must(err)
+	common.Must(link.Writer.WriteMultiBuffer(buf.MergeBytes(nil, []byte("hello"))))
+
+	opened := waitEvent(t, events, dispatcher.ConnectionOpened)
+	if opened.OutboundTag != "direct" || opened.InboundTag != "socks" {
+		t.Error("unexpected opened connection ", opened)
+	}
+
+	list, err := s.ListConnections(context.Background(), &ListConnectionsRequest{User: "love@v2fly.org", Target: "example.com"})
+	common.Must(err)
+	if len(list.Connection) != 1 {
+		t.Fatal("expected 1 connection, got ", len(list.Connection))
+	}
+	c := list.Connection[0]
+	if c.Id != opened.ID || c.Source != "tcp:1.2.3.4:5678" || c.Target != "tcp:example.com:80" || c.Uplink != 5 {
+		t.Error("unexpected connection ", c)
+	}
+
+	list, err = s.ListConnections(context.Background(), &ListConnectionsRequest{OutboundTag: "proxy"})
+	common.Must(err)
+	if len(list.Connection) != 0 {
+		t.Error("expected no connection through proxy, got ", list.Connection)
+	}
+
+	_, err = s.CloseConnection(context.Background(), &CloseConnectionRequest{Id: c.Id})
+	common.Must(err)
+	closed := waitEvent(t, events, dispatcher.ConnectionClosed)
+	if closed.ID != c.Id || closed.Uplink != 5 {
+		t.Error("unexpected closed connection ", closed)
+	}
+
+	list, err = s.ListConnections(context.Background(), &ListConnectionsRequest{})
+	common.Must(err)
+	if len(list.Connection) != 0 {
+		t.Error("expected no connection after closing, got ", list.Connection)
+	}
+	if _, err := s.CloseConnection(context.Background(), &CloseConnectionRequest{Id: c.Id}); err == nil {
+		t.Error("expected error closing unknown connection")
+	}
+}
+
+// eventStream is a ConnectionService_StreamConnectionEventsServer that hands
+// the events to a channel.
+type eventStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events chan *ConnectionEvent
+}
+
+func (s *eventStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *eventStream) Send(event *ConnectionEvent) error {
+	s.events <- event
+	return nil
+}
+
+func TestStreamConnectionEvents(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+
+	om := mocks.NewOutboundManager(mockCtl)
+	om.EXPECT().GetDefaultHandler().Return(sinkHandler{}).AnyTimes()
+
+	d := new(dispatcher.DefaultDispatcher)
+	common.Must(d.Init(&dispatcher.Config{}, om, nil, policy.DefaultManager{}, stats.NoopManager{}))
+	s := NewConnectionServer(d)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &eventStream{ctx: ctx, events: make(chan *ConnectionEvent, 4)}
+	if err := s.StreamConnectionEvents(&StreamConnectionEventsRequest{}, stream); err == nil {
+		t.Error("expected error streaming events of untracked connections")
+	}
+
+	d.TrackConnections()
+	defer d.Close()
+	go s.StreamConnectionEvents(&StreamConnectionEventsRequest{InboundTag: "socks"}, stream)
+	for len(d.ConnectionEvents().Subscribers()) == 0 {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	for _, tag := range []string{"http", "socks"} {
+		ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Tag: tag})
+		_, err := d.Dispatch(ctx, net.TCPDestination(net.DomainAddress("example.com"), 80))
+		common.Must(err)
+	}
+
+	select {
+	case event := <-stream.events:
+		if event.Type != ConnectionEvent_Opened || event.Connection.InboundTag != "socks" {
+			t.Error("unexpected event ", event)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("timeout waiting for connection event")
+	}
+	select {
+	case event := <-stream.events:
+		t.Error("unexpected event ", event)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
@@ -0,0 +1,207 @@
+package dispatcher
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	app_stats "github.com/v2fly/v2ray-core/v4/app/stats"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/session"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
+	"github.com/v2fly/v2ray-core/v4/transport"
+	"github.com/v2fly/v2ray-core/v4/transport/pipe"
+)
+
+// Connection is a snapshot of a session dispatched by DefaultDispatcher.
+type Connection struct {
+	// ID identifies the connection in the connection table.
+	ID uint64
+	// SessionID is the ID of the session in the logs.
+	SessionID   uint32
+	InboundTag  string
+	User        string
+	Source      net.Destination
+	Target      net.Destination
+	Domain      string
+	Protocol    string
+	OutboundTag string
+	Start       time.Time
+	Uplink      int64
+	Downlink    int64
+}
+
+// ConnectionEventType is the type of a ConnectionEvent.
+type ConnectionEventType int
+
+const (
+	// ConnectionOpened is published once a connection is routed.
+	ConnectionOpened ConnectionEventType = iota
+	// ConnectionClosed is published once both directions of a connection ended.
+	ConnectionClosed
+)
+
+// ConnectionEvent is published to the connection events channel of
+// DefaultDispatcher.
+type ConnectionEvent struct {
+	Type       ConnectionEventType
+	Connection *Connection
+}
+
+// connection is an entry of the connection table.
+type connection struct {
+	sync.Mutex
+	info     Connection
+	uplink   app_stats.Counter
+	downlink app_stats.Counter
+
+	uplinkReader   *pipe.Reader
+	downlinkReader *pipe.Reader
+}
+
+func (c *connection) snapshot() *Connection {
+	c.Lock()
+	info := c.info
+	c.Unlock()
+	info.Uplink = c.uplink.Value()
+	info.Downlink = c.downlink.Value()
+	return &info
+}
+
+func (c *connection) update(f func(info *Connection)) {
+	c.Lock()
+	f(&c.info)
+	c.Unlock()
+}
+
+// close interrupts both directions of the connection.
+func (c *connection) close() {
+	c.uplinkReader.Interrupt()
+	c.downlinkReader.Interrupt()
+}
+
+// connectionTable keeps the sessions in flight.
+type connectionTable struct {
+	access      sync.RWMutex
+	lastID      uint64
+	connections map[uint64]*connection
+	events      *app_stats.Channel
+}
+
+func newConnectionTable() *connectionTable {
+	return &connectionTable{
+		connections: make(map[uint64]*connection),
+		events: app_stats.NewChannel(&app_stats.ChannelConfig{
+			SubscriberLimit: 16,
+			BufferSize:      64,
+		}),
+	}
+}
+
+// add registers the session of ctx, whose links are given, until both
+// directions of it end.
+func (t *connectionTable) add(ctx context.Context, destination net.Destination, inboundLink *transport.Link, outboundLink *transport.Link) *connection {
+	c := &connection{
+		info: Connection{
+			SessionID: uint32(session.IDFromContext(ctx)),
+			Target:    destination,
+			Start:     time.Now(),
+		},
+		uplinkReader:   outboundLink.Reader.(*pipe.Reader),
+		downlinkReader: inboundLink.Reader.(*pipe.Reader),
+	}
+	if inbound := session.InboundFromContext(ctx); inbound != nil {
+		c.info.InboundTag = inbound.Tag
+		c.info.Source = inbound.Source
+		if inbound.User != nil {
+			c.info.User = inbound.User.Email
+		}
+	}
+	inboundLink.Writer = &SizeStatWriter{Counter: &c.uplink, Writer: inboundLink.Writer}
+	outboundLink.Writer = &SizeStatWriter{Counter: &c.downlink, Writer: outboundLink.Writer}
+
+	t.access.Lock()
+	t.lastID++
+	c.info.ID = t.lastID
+	t.connections[c.info.ID] = c
+	t.access.Unlock()
+
+	go func() {
+		<-c.uplinkReader.Done()
+		<-c.downlinkReader.Done()
+
+		t.access.Lock()
+		delete(t.connections, c.info.ID)
+		t.access.Unlock()
+		t.publish(ConnectionClosed, c)
+	}()
+	return c
+}
+
+func (t *connectionTable) publish(eventType ConnectionEventType, c *connection) {
+	if len(t.events.Subscribers()) == 0 {
+		return
+	}
+	t.events.Publish(context.Background(), &ConnectionEvent{
+		Type:       eventType,
+		Connection: c.snapshot(),
+	})
+}
+
+// TrackConnections enables the connection table, which keeps the sessions
+// dispatched from then on. It is called by the connection service before the
+// dispatcher starts, so that other setups don't pay for the table.
+func (d *DefaultDispatcher) TrackConnections() {
+	if d.connections == nil {
+		d.connections = newConnectionTable()
+	}
+}
+
+// ListConnections returns the sessions in flight, ordered by ID.
+func (d *DefaultDispatcher) ListConnections() []*Connection {
+	t := d.connections
+	if t == nil {
+		return nil
+	}
+	t.access.RLock()
+	connections := make([]*Connection, 0, len(t.connections))
+	for _, c := range t.connections {
+		connections = append(connections, c.snapshot())
+	}
+	t.access.RUnlock()
+
+	sort.Slice(connections, func(i, j int) bool { return connections[i].ID < connections[j].ID })
+	return connections
+}
+
+// CloseConnection interrupts the session with the given ID.
+func (d *DefaultDispatcher) CloseConnection(id uint64) error {
+	t := d.connections
+	if t == nil {
+		return newError("connections are not tracked")
+	}
+	t.access.RLock()
+	c, found := t.connections[id]
+	t.access.RUnlock()
+	if !found {
+		return newError("connection ", id, " not found")
+	}
+	newError("closing connection ", id, " to ", c.snapshot().Target).AtInfo().WriteToLog()
+	c.close()
+	return nil
+}
+
+// ConnectionEvents returns the channel that ConnectionEvents are published to,
+// or nil if connections are not tracked.
+func (d *DefaultDispatcher) ConnectionEvents() stats.Channel {
+	if d.connections == nil {
+		return nil
+	}
+	return d.connections.events
+}
+
+func (t *connectionTable) Close() error {
+	return common.Close(t.events)
+}
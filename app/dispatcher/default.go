@@ -91,6 +91,8 @@ type DefaultDispatcher struct {
 	router routing.Router
 	policy policy.Manager
 	stats  stats.Manager
+
+	connections *connectionTable
 }
 
 func init() {
@@ -125,7 +127,12 @@ func (*DefaultDispatcher) Start() error {
 }
 
 // Close implements common.Closable.
-func (*DefaultDispatcher) Close() error { return nil }
+func (d *DefaultDispatcher) Close() error {
+	if d.connections == nil {
+		return nil
+	}
+	return d.connections.Close()
+}
 
 func (d *DefaultDispatcher) getLink(ctx context.Context) (*transport.Link, *transport.Link) {
 	opt := pipe.OptionsFromContext(ctx)
@@ -173,6 +180,27 @@ func (d *DefaultDispatcher) getLink(ctx context.Context) (*transport.Link, *tran
 	return inboundLink, outboundLink
 }
 
+func (d *DefaultDispatcher) wrapRuleStats(link *transport.Link, ruleTag string) {
+	p := d.policy.ForSystem()
+	if p.Stats.RuleUplink {
+		// Counters are registered by the router for as long as the rule exists.
+		if c := d.stats.GetCounter(routing.RuleUplinkCounterName(ruleTag)); c != nil {
+			link.Reader = &SizeStatReader{
+				Counter: c,
+				Reader:  link.Reader,
+			}
+		}
+	}
+	if p.Stats.RuleDownlink {
+		if c := d.stats.GetCounter(routing.RuleDownlinkCounterName(ruleTag)); c != nil {
+			link.Writer = &SizeStatWriter{
+				Counter: c,
+				Writer:  link.Writer,
+			}
+		}
+	}
+}
+
 func shouldOverride(result SniffResult, domainOverride []string) bool {
 	if result.Domain() == "" {
 		return false
@@ -205,6 +233,10 @@ func (d *DefaultDispatcher) Dispatch(ctx context.Context, destination net.Destin
 	ctx = session.ContextWithOutbound(ctx, ob)
 
 	inbound, outbound := d.getLink(ctx)
+	var conn *connection
+	if d.connections != nil {
+		conn = d.connections.add(ctx, destination, inbound, outbound)
+	}
 	content := session.ContentFromContext(ctx)
 	if content == nil {
 		content = new(session.Content)
@@ -212,7 +244,7 @@ func (d *DefaultDispatcher) Dispatch(ctx context.Context, destination net.Destin
 	}
 	sniffingRequest := content.SniffingRequest
 	if !sniffingRequest.Enabled {
-		go d.routedDispatch(ctx, outbound, destination)
+		go d.routedDispatch(ctx, outbound, destination, conn)
 	} else {
 		go func() {
 			cReader := &cachedReader{
@@ -222,6 +254,12 @@ func (d *DefaultDispatcher) Dispatch(ctx context.Context, destination net.Destin
 			result, err := sniffer(ctx, cReader, sniffingRequest.MetadataOnly, destination.Network)
 			if err == nil {
 				content.Protocol = result.Protocol()
+				if conn != nil {
+					conn.update(func(info *Connection) {
+						info.Protocol = result.Protocol()
+						info.Domain = result.Domain()
+					})
+				}
 			}
 			if err == nil && shouldOverride(result, sniffingRequest.OverrideDestinationForProtocol) {
 				domain := result.Domain()
@@ -229,7 +267,7 @@ func (d *DefaultDispatcher) Dispatch(ctx context.Context, destination net.Destin
 				destination.Address = net.ParseAddress(domain)
 				ob.Target = destination
 			}
-			d.routedDispatch(ctx, outbound, destination)
+			d.routedDispatch(ctx, outbound, destination, conn)
 		}()
 	}
 
@@ -282,8 +320,9 @@ func sniffer(ctx context.Context, cReader *cachedReader, metadataOnly bool, netw
 	return contentResult, contentErr
 }
 
-func (d *DefaultDispatcher) routedDispatch(ctx context.Context, link *transport.Link, destination net.Destination) {
+func (d *DefaultDispatcher) routedDispatch(ctx context.Context, link *transport.Link, destination net.Destination, conn *connection) {
 	var handler outbound.Handler
+	var route routing.Route
 
 	if forcedOutboundTag := session.GetForcedOutboundTagFromContext(ctx); forcedOutboundTag != "" {
 		ctx = session.SetForcedOutboundTagToContext(ctx, "")
@@ -297,11 +336,12 @@ func (d *DefaultDispatcher) routedDispatch(ctx context.Context, link *transport.
 			return
 		}
 	} else if d.router != nil {
-		if route, err := d.router.PickRoute(routing_session.AsRoutingContext(ctx)); err == nil {
-			tag := route.GetOutboundTag()
+		if r, err := d.router.PickRoute(routing_session.AsRoutingContext(ctx)); err == nil {
+			tag := r.GetOutboundTag()
 			if h := d.ohm.GetHandler(tag); h != nil {
 				newError("taking detour [", tag, "] for [", destination, "]").WriteToLog(session.ExportIDToError(ctx))
 				handler = h
+				route = r
 			} else {
 				newError("non existing tag: ", tag).AtWarning().WriteToLog(session.ExportIDToError(ctx))
 			}
@@ -321,10 +361,27 @@ func (d *DefaultDispatcher) routedDispatch(ctx context.Context, link *transport.
 		return
 	}
 
+	if route != nil {
+		if ruleTag := route.GetRuleTag(); ruleTag != "" {
+			d.wrapRuleStats(link, ruleTag)
+		}
+	}
+
+	if conn != nil {
+		conn.update(func(info *Connection) {
+			info.OutboundTag = handler.Tag()
+		})
+		d.connections.publish(ConnectionOpened, conn)
+	}
+
 	if accessMessage := log.AccessMessageFromContext(ctx); accessMessage != nil {
 		if tag := handler.Tag(); tag != "" {
 			accessMessage.Detour = tag
 		}
+		if route != nil {
+			accessMessage.RuleTag = route.GetRuleTag()
+			accessMessage.MatchedConditions = route.GetMatchedConditions()
+		}
 		log.Record(accessMessage)
 	}
 
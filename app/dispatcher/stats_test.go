@@ -1,6 +1,7 @@
 package dispatcher_test
 
 import (
+	"bytes"
 	"testing"
 
 	. "github.com/v2fly/v2ray-core/v4/app/dispatcher"
@@ -42,3 +43,19 @@ func TestStatsWriter(t *testing.T) {
 		t.Fatal("unexpected counter value. want 7, but got ", c.Value())
 	}
 }
+
+func TestStatsReader(t *testing.T) {
+	var c TestCounter
+	reader := &SizeStatReader{
+		Counter: &c,
+		Reader:  buf.NewReader(bytes.NewReader([]byte("abcdefg"))),
+	}
+
+	mb, err := reader.ReadMultiBuffer()
+	common.Must(err)
+	buf.ReleaseMulti(mb)
+
+	if c.Value() != 7 {
+		t.Fatal("unexpected counter value. want 7, but got ", c.Value())
+	}
+}
@@ -0,0 +1,184 @@
+//go:build !confonly
+// +build !confonly
+
+package dns
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/features/dns"
+)
+
+// staticHostsName is reported as the server of answers from static hosts.
+const staticHostsName = "hosts"
+
+// CacheEntry is a cached answer of a name server.
+type CacheEntry struct {
+	Server string
+	Domain string
+	Type   dnsmessage.Type
+	IP     []net.IP
+	RCode  dnsmessage.RCode
+	Expire time.Time
+}
+
+// cacheManager is implemented by name servers whose cache can be inspected
+// and flushed at runtime.
+type cacheManager interface {
+	listCache() []CacheEntry
+	flushCache(domain string) int
+}
+
+func listRecords(mu *sync.RWMutex, ips map[string]record) []CacheEntry {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var entries []CacheEntry
+	list := func(domain string, rec *IPRecord, qType dnsmessage.Type) {
+		if rec == nil {
+			return
+		}
+		entry := CacheEntry{
+			Domain: domain,
+			Type:   qType,
+			RCode:  rec.RCode,
+			Expire: rec.Expire,
+		}
+		for _, ip := range rec.IP {
+			entry.IP = append(entry.IP, ip.IP())
+		}
+		entries = append(entries, entry)
+	}
+	for domain, rec := range ips {
+		list(domain, rec.A, dnsmessage.TypeA)
+		list(domain, rec.AAAA, dnsmessage.TypeAAAA)
+	}
+	return entries
+}
+
+// flushRecords removes the records of domain from ips, or all records if
+// domain is empty. It returns the number of records removed.
+func flushRecords(mu *sync.RWMutex, ips map[string]record, domain string) int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	count := func(rec record) int {
+		n := 0
+		if rec.A != nil {
+			n++
+		}
+		if rec.AAAA != nil {
+			n++
+		}
+		return n
+	}
+	n := 0
+	for key, rec := range ips {
+		if domain == "" || isKeyOf(key, domain) {
+			n += count(rec)
+			delete(ips, key)
+		}
+	}
+	return n
+}
+
+func (c *recordCache) listRecordCache() []CacheEntry {
+	c.recordAccess.Lock()
+	defer c.recordAccess.Unlock()
+
+	entries := make([]CacheEntry, 0, len(c.records))
+	for key, e := range c.records {
+		entry := CacheEntry{
+			Domain: key.domain,
+			Type:   key.qType,
+			RCode:  e.rcode,
+			Expire: e.expire,
+		}
+		for _, answer := range e.answers {
+			switch body := answer.Body.(type) {
+			case *dnsmessage.AResource:
+				entry.IP = append(entry.IP, net.IP(body.A[:]))
+			case *dnsmessage.AAAAResource:
+				entry.IP = append(entry.IP, net.IP(body.AAAA[:]))
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// flushRecordCache removes the records of domain, or all records if domain is
+// empty. It returns the number of entries removed.
+func (c *recordCache) flushRecordCache(domain string) int {
+	c.recordAccess.Lock()
+	defer c.recordAccess.Unlock()
+
+	n := 0
+	for key := range c.records {
+		if domain == "" || isKeyOf(key.domain, domain) {
+			delete(c.records, key)
+			n++
+		}
+	}
+	return n
+}
+
+// Resolve looks up the IPs of domain with the given option, and returns them
+// with the name of the server that answered. Answers from static hosts are
+// reported as coming from "hosts".
+func (s *DNS) Resolve(domain string, option dns.IPOption, disableCache bool) ([]net.IP, string, error) {
+	return s.lookupIPInternal(domain, option, s.disableCache || disableCache, nil)
+}
+
+// FlushCache removes domain from the caches of all name servers, or clears
+// the caches if domain is empty. It returns the number of entries removed.
+func (s *DNS) FlushCache(domain string) int {
+	if domain != "" {
+		domain = Fqdn(domain)
+	}
+	n := 0
+	for _, client := range s.clients {
+		if cm, ok := client.server.(cacheManager); ok {
+			n += cm.flushCache(domain)
+		}
+	}
+	if n > 0 {
+		newError("flushed ", n, " cached entries for ", domainOrAll(domain)).AtInfo().WriteToLog()
+	}
+	return n
+}
+
+// ListCache returns the entries in the caches of all name servers, sorted by
+// domain.
+func (s *DNS) ListCache() []CacheEntry {
+	var entries []CacheEntry
+	for _, client := range s.clients {
+		cm, ok := client.server.(cacheManager)
+		if !ok {
+			continue
+		}
+		for _, entry := range cm.listCache() {
+			entry.Server = client.Name()
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Domain != entries[j].Domain {
+			return entries[i].Domain < entries[j].Domain
+		}
+		return entries[i].Type < entries[j].Type
+	})
+	return entries
+}
+
+func domainOrAll(domain string) string {
+	if domain == "" {
+		return "all domains"
+	}
+	return domain
+}
@@ -0,0 +1,144 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/testing/storage"
+)
+
+func TestFlushAndListCache(t *testing.T) {
+	now := time.Now()
+	newServer := func(name string) *ClassicNameServer {
+		return &ClassicNameServer{
+			name: name,
+			ips: map[string]record{
+				"v2fly.org.": {
+					A:    &IPRecord{IP: []net.Address{net.ParseAddress("1.2.3.4")}, Expire: now.Add(time.Hour)},
+					AAAA: &IPRecord{IP: []net.Address{net.ParseAddress("2001::1")}, Expire: now.Add(time.Hour)},
+				},
+				"example.com.": {
+					A: &IPRecord{Expire: now.Add(time.Minute), RCode: dnsmessage.RCodeNameError},
+				},
+			},
+		}
+	}
+	first := newServer("UDP:1.1.1.1:53")
+	first.putRecords(recordKey{domain: "v2fly.org.", qType: dnsmessage.TypeTXT}, recordEntry{expire: now.Add(time.Hour)})
+	s := &DNS{clients: []*Client{{server: first}, {server: newServer("UDP:8.8.8.8:53")}}}
+
+	entries := s.ListCache()
+	if len(entries) != 7 {
+		t.Fatal("expected 7 cache entries, got ", len(entries))
+	}
+	if e := entries[0]; e.Domain != "example.com." || e.RCode != dnsmessage.RCodeNameError {
+		t.Error("unexpected first entry ", e)
+	}
+	if e := entries[2]; e.Domain != "v2fly.org." || e.Type != dnsmessage.TypeA || !e.IP[0].Equal(net.IP{1, 2, 3, 4}) {
+		t.Error("unexpected A entry ", e)
+	}
+
+	if n := s.FlushCache("v2fly.org"); n != 5 {
+		t.Error("expected 5 entries flushed, got ", n)
+	}
+	if n := s.FlushCache("v2fly.org"); n != 0 {
+		t.Error("expected nothing left to flush, got ", n)
+	}
+	if n := s.FlushCache(""); n != 2 {
+		t.Error("expected 2 entries flushed, got ", n)
+	}
+	if entries := s.ListCache(); len(entries) != 0 {
+		t.Error("expected empty cache, got ", entries)
+	}
+}
+
+func TestParseRecordResponseCaching(t *testing.T) {
+	name := dnsmessage.MustNewName("v2fly.org.")
+	soa := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 3600},
+		Body: &dnsmessage.SOAResource{
+			NS:     dnsmessage.MustNewName("ns.v2fly.org."),
+			MBox:   dnsmessage.MustNewName("admin.v2fly.org."),
+			MinTTL: 300,
+		},
+	}
+	txt := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: 60},
+		Body:   &dnsmessage.TXTResource{TXT: []string{"v2ray"}},
+	}
+
+	cases := []struct {
+		name        string
+		rcode       dnsmessage.RCode
+		answers     []dnsmessage.Resource
+		authorities []dnsmessage.Resource
+		cacheable   bool
+		ttl         time.Duration
+	}{
+		{name: "answer", answers: []dnsmessage.Resource{txt}, cacheable: true, ttl: time.Minute},
+		{name: "nodata", authorities: []dnsmessage.Resource{soa}, cacheable: true, ttl: 5 * time.Minute},
+		{name: "nxdomain", rcode: dnsmessage.RCodeNameError, authorities: []dnsmessage.Resource{soa}, cacheable: true, ttl: 5 * time.Minute},
+		{name: "nodata without soa"},
+		{name: "nxdomain without soa", rcode: dnsmessage.RCodeNameError},
+		{name: "servfail", rcode: dnsmessage.RCodeServerFailure, authorities: []dnsmessage.Resource{soa}},
+		{name: "refused", rcode: dnsmessage.RCodeRefused},
+	}
+	for _, c := range cases {
+		msg := dnsmessage.Message{
+			Header:      dnsmessage.Header{Response: true, RCode: c.rcode},
+			Questions:   []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}},
+			Answers:     c.answers,
+			Authorities: c.authorities,
+		}
+		payload, err := msg.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, ttl, cacheable, err := parseRecordResponse(payload)
+		if err != nil {
+			t.Fatal(c.name, ": ", err)
+		}
+		if entry.rcode != c.rcode {
+			t.Error(c.name, ": unexpected rcode ", entry.rcode)
+		}
+		if cacheable != c.cacheable || ttl != c.ttl {
+			t.Error(c.name, ": expected cacheable ", c.cacheable, " for ", c.ttl, ", got ", cacheable, " for ", ttl)
+		}
+	}
+}
+
+func TestPersistCachePeriodically(t *testing.T) {
+	v, err := core.New(&core.Config{})
+	common.Must(err)
+	common.Must(v.AddFeature(storage.NewMemoryStorage()))
+
+	newDNS := func() (*DNS, *ClassicNameServer) {
+		obj, err := core.CreateObject(v, &Config{PersistCache: true})
+		common.Must(err)
+		s := obj.(*DNS)
+		server := NewClassicNameServer(net.UDPDestination(net.ParseAddress("1.1.1.1"), 53), nil)
+		s.clients = []*Client{{server: server}}
+		return s, server
+	}
+
+	s, server := newDNS()
+	server.ips["v2fly.org."] = record{
+		A: &IPRecord{IP: []net.Address{net.ParseAddress("1.2.3.4")}, Expire: time.Now().Add(time.Hour)},
+	}
+	common.Must(s.Start())
+	common.Must(s.persistTask.Execute())
+
+	// The cache is restored without being closed, as after a crash.
+	restored, _ := newDNS()
+	common.Must(restored.Start())
+	if entries := restored.ListCache(); len(entries) != 1 || !entries[0].IP[0].Equal(net.IP{1, 2, 3, 4}) {
+		t.Error("expected saved cache to be restored, got ", entries)
+	}
+	common.Must(restored.Close())
+	common.Must(s.Close())
+}
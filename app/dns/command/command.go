@@ -0,0 +1,165 @@
+//go:build !confonly
+// +build !confonly
+
+package command
+
+//go:generate go run github.com/v2fly/v2ray-core/v4/common/errors/errorgen
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+
+	core "github.com/v2fly/v2ray-core/v4"
+	appdns "github.com/v2fly/v2ray-core/v4/app/dns"
+	"github.com/v2fly/v2ray-core/v4/app/dns/fakedns"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/features/dns"
+)
+
+// dnsManager is implemented by DNS clients supporting cache management at
+// runtime.
+type dnsManager interface {
+	Resolve(domain string, option dns.IPOption, disableCache bool) ([]net.IP, string, error)
+	FlushCache(domain string) int
+	ListCache() []appdns.CacheEntry
+}
+
+// mappingLister is implemented by fake DNS engines that can list the fake IPs
+// they assigned.
+type mappingLister interface {
+	ListMappings() []fakedns.Mapping
+}
+
+// dnsServer is an implementation of DNSService.
+type dnsServer struct {
+	client  dns.Client
+	fakeDNS dns.FakeDNSEngine
+}
+
+// NewDNSServer creates a DNS service with the DNS client and, if not nil, the
+// fake DNS engine.
+func NewDNSServer(client dns.Client, fakeDNS dns.FakeDNSEngine) DNSServiceServer {
+	return &dnsServer{
+		client:  client,
+		fakeDNS: fakeDNS,
+	}
+}
+
+func (s *dnsServer) Lookup(ctx context.Context, request *LookupRequest) (*LookupResponse, error) {
+	dm, ok := s.client.(dnsManager)
+	if !ok {
+		return nil, newError("unsupported DNS client implementation")
+	}
+	if request.Domain == "" {
+		return nil, newError("empty domain name")
+	}
+	if request.Ipv4Only && request.Ipv6Only {
+		return nil, newError("ipv4_only and ipv6_only are mutually exclusive")
+	}
+
+	option := dns.IPOption{IPv4Enable: true, IPv6Enable: true}
+	if c, ok := s.client.(dns.ClientWithIPOption); ok {
+		option = *c.GetIPOption()
+	}
+	if request.Ipv4Only {
+		option.IPv6Enable = false
+	}
+	if request.Ipv6Only {
+		option.IPv4Enable = false
+	}
+
+	ips, server, err := dm.Resolve(request.Domain, option, request.DisableCache)
+	if err != nil {
+		return nil, newError("failed to lookup ", request.Domain).Base(err)
+	}
+	return &LookupResponse{Ip: mapIPsToBytes(ips), Server: server}, nil
+}
+
+func (s *dnsServer) FlushCache(ctx context.Context, request *FlushCacheRequest) (*FlushCacheResponse, error) {
+	dm, ok := s.client.(dnsManager)
+	if !ok {
+		return nil, newError("unsupported DNS client implementation")
+	}
+	return &FlushCacheResponse{Count: uint32(dm.FlushCache(request.Domain))}, nil
+}
+
+func (s *dnsServer) ListCache(ctx context.Context, request *ListCacheRequest) (*ListCacheResponse, error) {
+	dm, ok := s.client.(dnsManager)
+	if !ok {
+		return nil, newError("unsupported DNS client implementation")
+	}
+	resp := &ListCacheResponse{}
+	for _, entry := range dm.ListCache() {
+		if request.Domain != "" && !strings.Contains(entry.Domain, request.Domain) {
+			continue
+		}
+		resp.Entry = append(resp.Entry, &CacheEntry{
+			Server: entry.Server,
+			Domain: entry.Domain,
+			Type:   strings.TrimPrefix(entry.Type.String(), "Type"),
+			Ip:     mapIPsToBytes(entry.IP),
+			Rcode:  strings.TrimPrefix(entry.RCode.String(), "RCode"),
+			Expire: entry.Expire.Unix(),
+		})
+	}
+	return resp, nil
+}
+
+func (s *dnsServer) ListFakeDNSMappings(ctx context.Context, request *ListFakeDNSMappingsRequest) (*ListFakeDNSMappingsResponse, error) {
+	if s.fakeDNS == nil {
+		return nil, newError("fake DNS is not enabled")
+	}
+	ml, ok := s.fakeDNS.(mappingLister)
+	if !ok {
+		return nil, newError("unsupported fake DNS implementation")
+	}
+	resp := &ListFakeDNSMappingsResponse{}
+	for _, mapping := range ml.ListMappings() {
+		resp.Mapping = append(resp.Mapping, &FakeDNSMapping{
+			Domain: mapping.Domain,
+			Ip:     mapping.IP.IP(),
+		})
+	}
+	return resp, nil
+}
+
+func (s *dnsServer) ReverseFakeIP(ctx context.Context, request *ReverseFakeIPRequest) (*ReverseFakeIPResponse, error) {
+	if s.fakeDNS == nil {
+		return nil, newError("fake DNS is not enabled")
+	}
+	if len(request.Ip) != net.IPv4len && len(request.Ip) != net.IPv6len {
+		return nil, newError("invalid IP address")
+	}
+	return &ReverseFakeIPResponse{Domain: s.fakeDNS.GetDomainFromFakeDNS(net.IPAddress(request.Ip))}, nil
+}
+
+func (s *dnsServer) mustEmbedUnimplementedDNSServiceServer() {}
+
+func mapIPsToBytes(ips []net.IP) [][]byte {
+	var bytes [][]byte
+	for _, ip := range ips {
+		bytes = append(bytes, []byte(ip))
+	}
+	return bytes
+}
+
+type service struct {
+	v *core.Instance
+}
+
+func (s *service) Register(server *grpc.Server) {
+	common.Must(s.v.RequireFeatures(func(client dns.Client) {
+		fakeDNS, _ := s.v.GetFeature((*dns.FakeDNSEngine)(nil)).(dns.FakeDNSEngine)
+		RegisterDNSServiceServer(server, NewDNSServer(client, fakeDNS))
+	}))
+}
+
+func init() {
+	common.Must(common.RegisterConfig((*Config)(nil), func(ctx context.Context, cfg interface{}) (interface{}, error) {
+		s := core.MustFromContext(ctx)
+		return &service{v: s}, nil
+	}))
+}
@@ -0,0 +1,245 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package command
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// DNSServiceClient is the client API for DNSService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DNSServiceClient interface {
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error)
+	FlushCache(ctx context.Context, in *FlushCacheRequest, opts ...grpc.CallOption) (*FlushCacheResponse, error)
+	ListCache(ctx context.Context, in *ListCacheRequest, opts ...grpc.CallOption) (*ListCacheResponse, error)
+	ListFakeDNSMappings(ctx context.Context, in *ListFakeDNSMappingsRequest, opts ...grpc.CallOption) (*ListFakeDNSMappingsResponse, error)
+	ReverseFakeIP(ctx context.Context, in *ReverseFakeIPRequest, opts ...grpc.CallOption) (*ReverseFakeIPResponse, error)
+}
+
+type dNSServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDNSServiceClient(cc grpc.ClientConnInterface) DNSServiceClient {
+	return &dNSServiceClient{cc}
+}
+
+func (c *dNSServiceClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error) {
+	out := new(LookupResponse)
+	err := c.cc.Invoke(ctx, "/v2ray.core.app.dns.command.DNSService/Lookup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSServiceClient) FlushCache(ctx context.Context, in *FlushCacheRequest, opts ...grpc.CallOption) (*FlushCacheResponse, error) {
+	out := new(FlushCacheResponse)
+	err := c.cc.Invoke(ctx, "/v2ray.core.app.dns.command.DNSService/FlushCache", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSServiceClient) ListCache(ctx context.Context, in *ListCacheRequest, opts ...grpc.CallOption) (*ListCacheResponse, error) {
+	out := new(ListCacheResponse)
+	err := c.cc.Invoke(ctx, "/v2ray.core.app.dns.command.DNSService/ListCache", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSServiceClient) ListFakeDNSMappings(ctx context.Context, in *ListFakeDNSMappingsRequest, opts ...grpc.CallOption) (*ListFakeDNSMappingsResponse, error) {
+	out := new(ListFakeDNSMappingsResponse)
+	err := c.cc.Invoke(ctx, "/v2ray.core.app.dns.command.DNSService/ListFakeDNSMappings", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSServiceClient) ReverseFakeIP(ctx context.Context, in *ReverseFakeIPRequest, opts ...grpc.CallOption) (*ReverseFakeIPResponse, error) {
+	out := new(ReverseFakeIPResponse)
+	err := c.cc.Invoke(ctx, "/v2ray.core.app.dns.command.DNSService/ReverseFakeIP", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DNSServiceServer is the server API for DNSService service.
+// All implementations must embed UnimplementedDNSServiceServer
+// for forward compatibility
+type DNSServiceServer interface {
+	Lookup(context.Context, *LookupRequest) (*LookupResponse, error)
+	FlushCache(context.Context, *FlushCacheRequest) (*FlushCacheResponse, error)
+	ListCache(context.Context, *ListCacheRequest) (*ListCacheResponse, error)
+	ListFakeDNSMappings(context.Context, *ListFakeDNSMappingsRequest) (*ListFakeDNSMappingsResponse, error)
+	ReverseFakeIP(context.Context, *ReverseFakeIPRequest) (*ReverseFakeIPResponse, error)
+	mustEmbedUnimplementedDNSServiceServer()
+}
+
+// UnimplementedDNSServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedDNSServiceServer struct {
+}
+
+func (UnimplementedDNSServiceServer) Lookup(context.Context, *LookupRequest) (*LookupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedDNSServiceServer) FlushCache(context.Context, *FlushCacheRequest) (*FlushCacheResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FlushCache not implemented")
+}
+func (UnimplementedDNSServiceServer) ListCache(context.Context, *ListCacheRequest) (*ListCacheResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCache not implemented")
+}
+func (UnimplementedDNSServiceServer) ListFakeDNSMappings(context.Context, *ListFakeDNSMappingsRequest) (*ListFakeDNSMappingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFakeDNSMappings not implemented")
+}
+func (UnimplementedDNSServiceServer) ReverseFakeIP(context.Context, *ReverseFakeIPRequest) (*ReverseFakeIPResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReverseFakeIP not implemented")
+}
+func (UnimplementedDNSServiceServer) mustEmbedUnimplementedDNSServiceServer() {}
+
+// UnsafeDNSServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DNSServiceServer will
+// result in compilation errors.
+type UnsafeDNSServiceServer interface {
+	mustEmbedUnimplementedDNSServiceServer()
+}
+
+func RegisterDNSServiceServer(s grpc.ServiceRegistrar, srv DNSServiceServer) {
+	s.RegisterService(&DNSService_ServiceDesc, srv)
+}
+
+func _DNSService_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSServiceServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/v2ray.core.app.dns.command.DNSService/Lookup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSServiceServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DNSService_FlushCache_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FlushCacheRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSServiceServer).FlushCache(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/v2ray.core.app.dns.command.DNSService/FlushCache",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSServiceServer).FlushCache(ctx, req.(*FlushCacheRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DNSService_ListCache_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCacheRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSServiceServer).ListCache(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/v2ray.core.app.dns.command.DNSService/ListCache",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSServiceServer).ListCache(ctx, req.(*ListCacheRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DNSService_ListFakeDNSMappings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFakeDNSMappingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSServiceServer).ListFakeDNSMappings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/v2ray.core.app.dns.command.DNSService/ListFakeDNSMappings",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSServiceServer).ListFakeDNSMappings(ctx, req.(*ListFakeDNSMappingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DNSService_ReverseFakeIP_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReverseFakeIPRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSServiceServer).ReverseFakeIP(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/v2ray.core.app.dns.command.DNSService/ReverseFakeIP",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSServiceServer).ReverseFakeIP(ctx, req.(*ReverseFakeIPRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// DNSService_ServiceDesc is the grpc.ServiceDesc for DNSService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DNSService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "v2ray.core.app.dns.command.DNSService",
+	HandlerType: (*DNSServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lookup",
+			Handler:    _DNSService_Lookup_Handler,
+		},
+		{
+			MethodName: "FlushCache",
+			Handler:    _DNSService_FlushCache_Handler,
+		},
+		{
+			MethodName: "ListCache",
+			Handler:    _DNSService_ListCache_Handler,
+		},
+		{
+			MethodName: "ListFakeDNSMappings",
+			Handler:    _DNSService_ListFakeDNSMappings_Handler,
+		},
+		{
+			MethodName: "ReverseFakeIP",
+			Handler:    _DNSService_ReverseFakeIP_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "app/dns/command/command.proto",
+}
@@ -0,0 +1,95 @@
+package command_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/app/dispatcher"
+	"github.com/v2fly/v2ray-core/v4/app/dns"
+	. "github.com/v2fly/v2ray-core/v4/app/dns/command"
+	"github.com/v2fly/v2ray-core/v4/app/dns/fakedns"
+	"github.com/v2fly/v2ray-core/v4/app/proxyman"
+	_ "github.com/v2fly/v2ray-core/v4/app/proxyman/outbound"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/serial"
+	feature_dns "github.com/v2fly/v2ray-core/v4/features/dns"
+)
+
+func TestDNSService(t *testing.T) {
+	config := &core.Config{
+		App: []*anypb.Any{
+			serial.ToTypedMessage(&dns.Config{
+				StaticHosts: []*dns.HostMapping{
+					{
+						Type:   dns.DomainMatchingType_Full,
+						Domain: "v2fly.org",
+						Ip:     [][]byte{{127, 0, 0, 1}, {0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&fakedns.FakeDnsPool{
+				IpPool:  "198.18.0.0/15",
+				LruSize: 256,
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+	v, err := core.New(config)
+	common.Must(err)
+	common.Must(v.Start())
+	defer v.Close()
+
+	client := v.GetFeature(feature_dns.ClientType()).(feature_dns.Client)
+	fakeDNS := v.GetFeature((*feature_dns.FakeDNSEngine)(nil)).(feature_dns.FakeDNSEngine)
+	s := NewDNSServer(client, fakeDNS)
+	ctx := context.Background()
+
+	lookup, err := s.Lookup(ctx, &LookupRequest{Domain: "v2fly.org", Ipv4Only: true})
+	common.Must(err)
+	if r := cmp.Diff(lookup, &LookupResponse{Ip: [][]byte{{127, 0, 0, 1}}, Server: "hosts"}, cmp.Comparer(proto.Equal)); r != "" {
+		t.Error(r)
+	}
+	if _, err := s.Lookup(ctx, &LookupRequest{Domain: "v2fly.org", Ipv4Only: true, Ipv6Only: true}); err == nil {
+		t.Error("expected error for conflicting options")
+	}
+
+	flush, err := s.FlushCache(ctx, &FlushCacheRequest{})
+	common.Must(err)
+	if flush.Count != 0 {
+		t.Error("expected empty cache, flushed ", flush.Count)
+	}
+
+	fakeIP := fakeDNS.GetFakeIPForDomain("example.com")[0]
+	mappings, err := s.ListFakeDNSMappings(ctx, &ListFakeDNSMappingsRequest{})
+	common.Must(err)
+	if r := cmp.Diff(mappings.Mapping, []*FakeDNSMapping{{Domain: "example.com", Ip: fakeIP.IP()}}, cmp.Comparer(proto.Equal)); r != "" {
+		t.Error(r)
+	}
+
+	reverse, err := s.ReverseFakeIP(ctx, &ReverseFakeIPRequest{Ip: fakeIP.IP()})
+	common.Must(err)
+	if reverse.Domain != "example.com" {
+		t.Error("expected example.com, got ", reverse.Domain)
+	}
+	reverse, err = s.ReverseFakeIP(ctx, &ReverseFakeIPRequest{Ip: []byte{198, 18, 255, 255}})
+	common.Must(err)
+	if reverse.Domain != "" {
+		t.Error("expected unassigned fake IP, got ", reverse.Domain)
+	}
+}
+
+func TestDNSServiceWithoutFakeDNS(t *testing.T) {
+	s := NewDNSServer(nil, nil)
+	if _, err := s.ListFakeDNSMappings(context.Background(), &ListFakeDNSMappingsRequest{}); err == nil {
+		t.Error("expected error without fake DNS")
+	}
+	if _, err := s.ReverseFakeIP(context.Background(), &ReverseFakeIPRequest{Ip: []byte{198, 18, 0, 1}}); err == nil {
+		t.Error("expected error without fake DNS")
+	}
+}
@@ -11,7 +11,11 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
+	"golang.org/x/net/dns/dnsmessage"
+
+	core "github.com/v2fly/v2ray-core/v4"
 	"github.com/v2fly/v2ray-core/v4/app/router"
 	"github.com/v2fly/v2ray-core/v4/common"
 	"github.com/v2fly/v2ray-core/v4/common/errors"
@@ -19,8 +23,12 @@ import (
 	"github.com/v2fly/v2ray-core/v4/common/platform"
 	"github.com/v2fly/v2ray-core/v4/common/session"
 	"github.com/v2fly/v2ray-core/v4/common/strmatcher"
+	"github.com/v2fly/v2ray-core/v4/common/task"
 	"github.com/v2fly/v2ray-core/v4/features"
 	"github.com/v2fly/v2ray-core/v4/features/dns"
+	"github.com/v2fly/v2ray-core/v4/features/policy"
+	"github.com/v2fly/v2ray-core/v4/features/routing"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
 	"github.com/v2fly/v2ray-core/v4/infra/conf/cfgcommon"
 	"github.com/v2fly/v2ray-core/v4/infra/conf/geodata"
 )
@@ -30,6 +38,7 @@ type DNS struct {
 	sync.Mutex
 	tag                    string
 	disableCache           bool
+	persistCache           bool
 	disableFallback        bool
 	disableFallbackIfMatch bool
 	ipOption               *dns.IPOption
@@ -38,6 +47,7 @@ type DNS struct {
 	ctx                    context.Context
 	domainMatcher          strmatcher.IndexMatcher
 	matcherInfos           []DomainMatcherInfo
+	persistTask            *task.Periodic
 }
 
 // DomainMatcherInfo contains information attached to index returned by Server.domainMatcher
@@ -138,6 +148,45 @@ func New(ctx context.Context, config *Config) (*DNS, error) {
 		clients = append(clients, NewLocalDNSClient())
 	}
 
+	cacheOpts := cacheOptions{
+		minTTL:   time.Duration(config.CacheMinTtl) * time.Second,
+		maxTTL:   time.Duration(config.CacheMaxTtl) * time.Second,
+		staleTTL: time.Duration(config.ServeStaleTtl) * time.Second,
+		prefetch: config.Prefetch,
+	}
+	var validator *dnssecValidator
+	if config.Dnssec {
+		validator, err = newDNSSECValidator(config.DnssecTrustAnchor)
+		if err != nil {
+			return nil, newError("failed to create DNSSEC validator").Base(err)
+		}
+	}
+
+	// Remote name servers are only created once the dispatcher is available.
+	if err := core.RequireFeatures(ctx, func(_ routing.Dispatcher, sm stats.Manager, pm policy.Manager) error {
+		for _, client := range clients {
+			if pm.ForSystem().Stats.DNSServer {
+				client.metrics = newServerMetrics(sm, client.Name())
+			}
+			if s, ok := client.server.(interface{ setCacheOptions(cacheOptions) }); ok {
+				s.setCacheOptions(cacheOpts)
+			}
+			if validator == nil {
+				continue
+			}
+			switch s := client.server.(type) {
+			case interface{ setValidator(*dnssecValidator) }:
+				s.setValidator(validator)
+			case *FakeDNSServer:
+			default:
+				newError("DNSSEC validation is not supported by ", client.Name(), ", its answers are not validated").AtWarning().WriteToLog()
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
 	return &DNS{
 		tag:                    tag,
 		hosts:                  hosts,
@@ -147,6 +196,7 @@ func New(ctx context.Context, config *Config) (*DNS, error) {
 		domainMatcher:          domainMatcher,
 		matcherInfos:           matcherInfos,
 		disableCache:           config.DisableCache,
+		persistCache:           config.PersistCache,
 		disableFallback:        config.DisableFallback,
 		disableFallbackIfMatch: config.DisableFallbackIfMatch,
 	}, nil
@@ -159,11 +209,21 @@ func (*DNS) Type() interface{} {
 
 // Start implements common.Runnable.
 func (s *DNS) Start() error {
+	if s.persistCache {
+		s.loadCache()
+		return s.startPersisting()
+	}
 	return nil
 }
 
 // Close implements common.Closable.
 func (s *DNS) Close() error {
+	if s.persistTask != nil {
+		common.Must(s.persistTask.Close())
+	}
+	if s.persistCache {
+		return s.saveCache()
+	}
 	return nil
 }
 
@@ -175,7 +235,8 @@ func (s *DNS) IsOwnLink(ctx context.Context) bool {
 
 // LookupIP implements dns.Client.
 func (s *DNS) LookupIP(domain string) ([]net.IP, error) {
-	return s.lookupIPInternal(domain, *s.ipOption)
+	ips, _, err := s.lookupIPInternal(domain, *s.ipOption, s.disableCache, nil)
+	return ips, err
 }
 
 // LookupIPv4 implements dns.IPv4Lookup.
@@ -185,7 +246,8 @@ func (s *DNS) LookupIPv4(domain string) ([]net.IP, error) {
 	}
 	o := *s.ipOption
 	o.IPv6Enable = false
-	return s.lookupIPInternal(domain, o)
+	ips, _, err := s.lookupIPInternal(domain, o, s.disableCache, nil)
+	return ips, err
 }
 
 // LookupIPv6 implements dns.IPv6Lookup.
@@ -195,10 +257,30 @@ func (s *DNS) LookupIPv6(domain string) ([]net.IP, error) {
 	}
 	o := *s.ipOption
 	o.IPv4Enable = false
-	return s.lookupIPInternal(domain, o)
+	ips, _, err := s.lookupIPInternal(domain, o, s.disableCache, nil)
+	return ips, err
 }
 
-func (s *DNS) lookupIPInternal(domain string, option dns.IPOption) ([]net.IP, error) {
+// LookupIPWithContext implements dns.ContextLookup.
+func (s *DNS) LookupIPWithContext(ctx context.Context, domain string, option dns.IPOption) ([]net.IP, error) {
+	o := *s.ipOption
+	o.IPv4Enable = o.IPv4Enable && option.IPv4Enable
+	o.IPv6Enable = o.IPv6Enable && option.IPv6Enable
+	o.FakeEnable = option.FakeEnable
+	if !o.IPv4Enable && !o.IPv6Enable {
+		return nil, dns.ErrEmptyResponse
+	}
+
+	var source net.IP
+	if inbound := session.InboundFromContext(ctx); inbound != nil && inbound.Source.IsValid() && inbound.Source.Address.Family().IsIP() {
+		source = inbound.Source.Address.IP()
+	}
+	ips, _, err := s.lookupIPInternal(domain, o, s.disableCache, source)
+	return ips, err
+}
+
+// LookupRecords implements dns.RecordLookup.
+func (s *DNS) LookupRecords(domain string, qType dnsmessage.Type, fakeEnable bool) ([]dnsmessage.Resource, error) {
 	if domain == "" {
 		return nil, newError("empty domain name")
 	}
@@ -206,42 +288,109 @@ func (s *DNS) lookupIPInternal(domain string, option dns.IPOption) ([]net.IP, er
 	// Normalize the FQDN form query
 	domain = strings.TrimSuffix(domain, ".")
 
+	// Static hosts take precedence over records of any type
+	switch addrs := s.hosts.Lookup(domain, dns.IPOption{IPv4Enable: true, IPv6Enable: true}); {
+	case addrs == nil: // Domain not recorded in static host
+		break
+	case len(addrs) == 1 && addrs[0].Family().IsDomain(): // Domain replacement
+		newError("domain replaced: ", domain, " -> ", addrs[0].Domain()).WriteToLog()
+		domain = addrs[0].Domain()
+	default:
+		return nil, dns.ErrEmptyResponse
+	}
+
+	errs := []error{}
+	ctx := session.ContextWithInbound(s.ctx, &session.Inbound{Tag: s.tag})
+	for _, client := range s.sortClients(domain) {
+		if strings.EqualFold(client.Name(), "FakeDNS") {
+			if fakeEnable {
+				// Records of a domain answered with fake IPs must not reveal its real addresses.
+				return nil, dns.ErrEmptyResponse
+			}
+			continue
+		}
+		records, err := client.QueryRecords(ctx, domain, qType, s.disableCache)
+		if len(records) > 0 {
+			return records, nil
+		}
+		if err == errRecordTypeNotSupported {
+			newError("skip ", qType, " lookup for domain ", domain, " at server ", client.Name()).AtDebug().WriteToLog()
+			continue
+		}
+		if err != nil {
+			newError("failed to lookup ", qType, " records for domain ", domain, " at server ", client.Name()).Base(err).WriteToLog()
+			errs = append(errs, err)
+		}
+		if !isRetryableError(err) {
+			return nil, err
+		}
+	}
+
+	return nil, newError("returning nil for domain ", domain).Base(errors.Combine(errs...))
+}
+
+// lookupIPInternal looks up the IPs of domain and returns them with the name
+// of the server that answered, or "hosts" if they come from static hosts.
+// source is the address of the client the lookup is made for, if known.
+func (s *DNS) lookupIPInternal(domain string, option dns.IPOption, disableCache bool, source net.IP) ([]net.IP, string, error) {
+	if domain == "" {
+		return nil, "", newError("empty domain name")
+	}
+
+	// Normalize the FQDN form query
+	domain = strings.TrimSuffix(domain, ".")
+
 	// Static host lookup
 	switch addrs := s.hosts.Lookup(domain, option); {
 	case addrs == nil: // Domain not recorded in static host
 		break
 	case len(addrs) == 0: // Domain recorded, but no valid IP returned (e.g. IPv4 address with only IPv6 enabled)
-		return nil, dns.ErrEmptyResponse
+		return nil, staticHostsName, dns.ErrEmptyResponse
 	case len(addrs) == 1 && addrs[0].Family().IsDomain(): // Domain replacement
 		newError("domain replaced: ", domain, " -> ", addrs[0].Domain()).WriteToLog()
 		domain = addrs[0].Domain()
 	default: // Successfully found ip records in static host
 		newError("returning ", len(addrs), " IP(s) for domain ", domain, " -> ", addrs).WriteToLog()
-		return toNetIP(addrs)
+		ips, err := toNetIP(addrs)
+		return ips, staticHostsName, err
 	}
 
 	// Name servers lookup
 	errs := []error{}
 	ctx := session.ContextWithInbound(s.ctx, &session.Inbound{Tag: s.tag})
+	if source != nil {
+		ctx = contextWithSourceIP(ctx, source)
+	}
+	clients := make([]*Client, 0, len(s.clients))
 	for _, client := range s.sortClients(domain) {
 		if !option.FakeEnable && strings.EqualFold(client.Name(), "FakeDNS") {
 			newError("skip DNS resolution for domain ", domain, " at server ", client.Name()).AtDebug().WriteToLog()
 			continue
 		}
-		ips, err := client.QueryIP(ctx, domain, option, s.disableCache)
+		clients = append(clients, client)
+	}
+	for _, stage := range groupClients(clients) {
+		var ips []net.IP
+		var err error
+		winner := stage[0]
+		if len(stage) == 1 {
+			ips, err = winner.QueryIP(ctx, domain, option, disableCache)
+		} else {
+			ips, winner, err = raceQueryIP(ctx, stage, domain, option, disableCache)
+		}
 		if len(ips) > 0 {
-			return ips, nil
+			return ips, winner.Name(), nil
 		}
 		if err != nil {
-			newError("failed to lookup ip for domain ", domain, " at server ", client.Name()).Base(err).WriteToLog()
+			newError("failed to lookup ip for domain ", domain, " at server ", stageName(stage)).Base(err).WriteToLog()
 			errs = append(errs, err)
 		}
-		if err != context.Canceled && err != context.DeadlineExceeded && err != errExpectedIPNonMatch {
-			return nil, err
+		if !isRetryableError(err) {
+			return nil, stageName(stage), err
 		}
 	}
 
-	return nil, newError("returning nil for domain ", domain).Base(errors.Combine(errs...))
+	return nil, "", newError("returning nil for domain ", domain).Base(errors.Combine(errs...))
 }
 
 // GetIPOption implements ClientWithIPOption.
@@ -355,28 +504,40 @@ func init() {
 
 		for _, v := range simplifiedConfig.NameServer {
 			nameserver := &NameServer{
-				Address:      v.Address,
-				ClientIp:     net.ParseIP(v.ClientIp),
-				SkipFallback: v.SkipFallback,
-				Geoip:        v.Geoip,
+				Address:                v.Address,
+				ClientIp:               net.ParseIP(v.ClientIp),
+				SkipFallback:           v.SkipFallback,
+				Geoip:                  v.Geoip,
+				RaceGroup:              v.RaceGroup,
+				ClientSubnetFromSource: v.ClientSubnetFromSource,
+				ClientSubnetIpv4Prefix: v.ClientSubnetIpv4Prefix,
+				ClientSubnetIpv6Prefix: v.ClientSubnetIpv6Prefix,
 			}
 			for _, prioritizedDomain := range v.PrioritizedDomain {
 				nameserver.PrioritizedDomain = append(nameserver.PrioritizedDomain, &NameServer_PriorityDomain{
-					Type:   prioritizedDomain.Type,
-					Domain: prioritizedDomain.Domain,
+					Type:         prioritizedDomain.Type,
+					Domain:       prioritizedDomain.Domain,
+					ClientSubnet: prioritizedDomain.ClientSubnet,
 				})
 			}
 			nameservers = append(nameservers, nameserver)
 		}
 
 		fullConfig := &Config{
-			NameServer:      nameservers,
-			ClientIp:        net.ParseIP(simplifiedConfig.ClientIp),
-			StaticHosts:     simplifiedConfig.StaticHosts,
-			Tag:             simplifiedConfig.Tag,
-			DisableCache:    simplifiedConfig.DisableCache,
-			QueryStrategy:   simplifiedConfig.QueryStrategy,
-			DisableFallback: simplifiedConfig.DisableFallback,
+			NameServer:        nameservers,
+			ClientIp:          net.ParseIP(simplifiedConfig.ClientIp),
+			StaticHosts:       simplifiedConfig.StaticHosts,
+			Tag:               simplifiedConfig.Tag,
+			DisableCache:      simplifiedConfig.DisableCache,
+			QueryStrategy:     simplifiedConfig.QueryStrategy,
+			DisableFallback:   simplifiedConfig.DisableFallback,
+			CacheMinTtl:       simplifiedConfig.CacheMinTtl,
+			CacheMaxTtl:       simplifiedConfig.CacheMaxTtl,
+			ServeStaleTtl:     simplifiedConfig.ServeStaleTtl,
+			Prefetch:          simplifiedConfig.Prefetch,
+			PersistCache:      simplifiedConfig.PersistCache,
+			Dnssec:            simplifiedConfig.Dnssec,
+			DnssecTrustAnchor: simplifiedConfig.DnssecTrustAnchor,
 		}
 		return common.CreateObject(ctx, fullConfig)
 	}))
@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/miekg/dns"
+	"golang.org/x/net/dns/dnsmessage"
 	"google.golang.org/protobuf/types/known/anypb"
 
 	core "github.com/v2fly/v2ray-core/v4"
@@ -74,9 +75,19 @@ func (*staticHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			common.Must(err)
 			ans.Answer = append(ans.Answer, rr)
 
-		case q.Name == "notexist.google.com." && q.Qtype == dns.TypeAAAA:
+		case q.Name == "notexist.google.com." && (q.Qtype == dns.TypeAAAA || q.Qtype == dns.TypeHTTPS):
 			ans.MsgHdr.Rcode = dns.RcodeNameError
 
+		case q.Name == "google.com." && q.Qtype == dns.TypeHTTPS:
+			rr, err := dns.NewRR("google.com. 300 IN HTTPS 1 . alpn=h2,h3")
+			common.Must(err)
+			ans.Answer = append(ans.Answer, rr)
+
+		case q.Name == "google.com." && q.Qtype == dns.TypeMX:
+			rr, err := dns.NewRR("google.com. 300 IN MX 10 smtp.google.com.")
+			common.Must(err)
+			ans.Answer = append(ans.Answer, rr)
+
 		case q.Name == "hostname." && q.Qtype == dns.TypeA:
 			rr, _ := dns.NewRR("hostname. IN A 127.0.0.1")
 			ans.Answer = append(ans.Answer, rr)
@@ -969,3 +980,168 @@ func TestMultiMatchPrioritizedDomain(t *testing.T) {
 		t.Error("DNS query doesn't finish in 2 seconds.")
 	}
 }
+
+func TestRaceGroup(t *testing.T) {
+	port := udp.PickPort()
+
+	dnsServer := dns.Server{
+		Addr:    "127.0.0.1:" + port.String(),
+		Net:     "udp",
+		Handler: &staticHandler{},
+		UDPSize: 1200,
+	}
+
+	go dnsServer.ListenAndServe()
+	time.Sleep(time.Second)
+
+	nameServer := func(port net.Port) *NameServer {
+		return &NameServer{
+			Address: &net.Endpoint{
+				Network: net.Network_UDP,
+				Address: &net.IPOrDomain{
+					Address: &net.IPOrDomain_Ip{
+						Ip: []byte{127, 0, 0, 1},
+					},
+				},
+				Port: uint32(port),
+			},
+			RaceGroup: "fast",
+		}
+	}
+
+	config := &core.Config{
+		App: []*anypb.Any{
+			serial.ToTypedMessage(&Config{
+				NameServer: []*NameServer{
+					// Nothing listens on this port, so queries to it time out.
+					nameServer(udp.PickPort()),
+					nameServer(port),
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(&policy.Config{}),
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&freedom.Config{}),
+			},
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	client := v.GetFeature(feature_dns.ClientType()).(feature_dns.Client)
+
+	startTime := time.Now()
+	ips, err := client.LookupIP("google.com")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if r := cmp.Diff(ips, []net.IP{{8, 8, 8, 8}}); r != "" {
+		t.Fatal(r)
+	}
+	if elapsed := time.Since(startTime); elapsed > time.Second*2 {
+		t.Error("expected the fastest answer, but lookup took ", elapsed)
+	}
+
+	_, err = client.LookupIP("notexist.google.com")
+	if r := feature_dns.RCodeFromError(err); r != uint16(dns.RcodeNameError) {
+		t.Error("expected NameError, but got ", err)
+	}
+
+	dnsServer.Shutdown()
+}
+
+func TestLookupRecords(t *testing.T) {
+	const typeHTTPS = dnsmessage.Type(dns.TypeHTTPS)
+
+	port := udp.PickPort()
+
+	dnsServer := dns.Server{
+		Addr:    "127.0.0.1:" + port.String(),
+		Net:     "udp",
+		Handler: &staticHandler{},
+		UDPSize: 1200,
+	}
+
+	go dnsServer.ListenAndServe()
+	time.Sleep(time.Second)
+
+	config := &core.Config{
+		App: []*anypb.Any{
+			serial.ToTypedMessage(&Config{
+				NameServer: []*NameServer{
+					{
+						Address: &net.Endpoint{
+							Network: net.Network_UDP,
+							Address: &net.IPOrDomain{
+								Address: &net.IPOrDomain_Ip{
+									Ip: []byte{127, 0, 0, 1},
+								},
+							},
+							Port: uint32(port),
+						},
+					},
+				},
+				StaticHosts: []*HostMapping{
+					{
+						Type:   DomainMatchingType_Full,
+						Domain: "v2fly.org",
+						Ip:     [][]byte{{127, 0, 0, 1}},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(&policy.Config{}),
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&freedom.Config{}),
+			},
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	client := v.GetFeature(feature_dns.ClientType()).(feature_dns.RecordLookup)
+
+	records, err := client.LookupRecords("google.com", typeHTTPS, false)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if len(records) != 1 || records[0].Header.Type != typeHTTPS {
+		t.Fatal("unexpected HTTPS records: ", records)
+	}
+
+	records, err = client.LookupRecords("google.com", dnsmessage.TypeMX, false)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if len(records) != 1 || records[0].Body.(*dnsmessage.MXResource).MX.String() != "smtp.google.com." {
+		t.Fatal("unexpected MX records: ", records)
+	}
+
+	_, err = client.LookupRecords("notexist.google.com", typeHTTPS, false)
+	if r := feature_dns.RCodeFromError(err); r != uint16(dns.RcodeNameError) {
+		t.Error("expected NameError, but got ", err)
+	}
+
+	if _, err := client.LookupRecords("v2fly.org", typeHTTPS, false); err != feature_dns.ErrEmptyResponse {
+		t.Error("expected empty response for static host, but got ", err)
+	}
+
+	dnsServer.Shutdown()
+
+	// Answered from cache.
+	records, err = client.LookupRecords("google.com", typeHTTPS, false)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if len(records) != 1 || records[0].Header.TTL > 300 {
+		t.Fatal("unexpected cached HTTPS records: ", records)
+	}
+}
@@ -3,6 +3,7 @@ package dns
 import (
 	"encoding/binary"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/dns/dnsmessage"
@@ -32,16 +33,25 @@ type IPRecord struct {
 	IP     []net.Address
 	Expire time.Time
 	RCode  dnsmessage.RCode
+
+	ttl       time.Duration
+	hits      uint32
+	refreshAt uint32
+
+	// err tells why an answer that failed DNSSEC validation is kept as a
+	// server failure.
+	err error
 }
 
-func (r *IPRecord) getIPs() ([]net.Address, error) {
-	if r == nil || r.Expire.Before(time.Now()) {
-		return nil, errRecordNotFound
-	}
-	if r.RCode != dnsmessage.RCodeSuccess {
-		return nil, dns_feature.RCodeError(r.RCode)
+// startRefresh reports whether the caller should refresh this record. At most
+// one refresh is started per record within refreshInterval.
+func (r *IPRecord) startRefresh(now time.Time) bool {
+	last := atomic.LoadUint32(&r.refreshAt)
+	current := uint32(now.Unix())
+	if last != 0 && current-last < uint32(refreshInterval/time.Second) {
+		return false
 	}
-	return r.IP, nil
+	return atomic.CompareAndSwapUint32(&r.refreshAt, last, current)
 }
 
 func isNewer(baseRec *IPRecord, newRec *IPRecord) bool {
@@ -54,6 +64,89 @@ func isNewer(baseRec *IPRecord, newRec *IPRecord) bool {
 	return baseRec.Expire.Before(newRec.Expire)
 }
 
+const refreshInterval = time.Second * 5
+
+// cacheOptions controls how a name server caches the records it resolves.
+type cacheOptions struct {
+	minTTL   time.Duration
+	maxTTL   time.Duration
+	staleTTL time.Duration
+	prefetch bool
+}
+
+func (o *cacheOptions) setCacheOptions(opts cacheOptions) {
+	*o = opts
+}
+
+// clampTTL applies the configured TTL bounds to a freshly parsed record.
+func (o *cacheOptions) clampTTL(rec *IPRecord) {
+	if rec == nil {
+		return
+	}
+	now := time.Now()
+	ttl := o.clamp(rec.Expire.Sub(now))
+	rec.Expire = now.Add(ttl)
+	rec.ttl = ttl
+}
+
+func (o *cacheOptions) clamp(ttl time.Duration) time.Duration {
+	if o.minTTL > 0 && ttl < o.minTTL {
+		ttl = o.minTTL
+	}
+	if o.maxTTL > 0 && ttl > o.maxTTL {
+		ttl = o.maxTTL
+	}
+	return ttl
+}
+
+// isNewer is like the package level isNewer, but keeps a servable record in
+// place when a refresh only fails with SERVFAIL (RFC 8767).
+func (o *cacheOptions) isNewer(baseRec *IPRecord, newRec *IPRecord) bool {
+	if o.staleTTL > 0 && baseRec != nil && newRec != nil &&
+		baseRec.RCode == dnsmessage.RCodeSuccess && newRec.RCode == dnsmessage.RCodeServerFailure &&
+		!o.expired(baseRec, time.Now()) {
+		return false
+	}
+	return isNewer(baseRec, newRec)
+}
+
+// expired reports whether rec can no longer be served, stale or not.
+func (o *cacheOptions) expired(rec *IPRecord, now time.Time) bool {
+	if rec == nil {
+		return false
+	}
+	if rec.RCode == dnsmessage.RCodeSuccess {
+		return rec.Expire.Add(o.staleTTL).Before(now)
+	}
+	return rec.Expire.Before(now)
+}
+
+// getIPs returns the addresses in rec, serving expired records for up to
+// staleTTL. The returned bool tells the caller to refresh the record in the
+// background, either because a stale answer was served or because a popular
+// record is about to expire.
+func (o *cacheOptions) getIPs(rec *IPRecord) ([]net.Address, bool, error) {
+	if rec == nil {
+		return nil, false, errRecordNotFound
+	}
+	now := time.Now()
+	if rec.Expire.Before(now) {
+		if o.expired(rec, now) || rec.RCode != dnsmessage.RCodeSuccess {
+			return nil, false, errRecordNotFound
+		}
+		return rec.IP, rec.startRefresh(now), nil
+	}
+	if rec.RCode != dnsmessage.RCodeSuccess {
+		if rec.err != nil {
+			return nil, false, rec.err
+		}
+		return nil, false, dns_feature.RCodeError(rec.RCode)
+	}
+	hits := atomic.AddUint32(&rec.hits, 1)
+	refresh := o.prefetch && hits > 1 && rec.ttl > 0 && rec.Expire.Sub(now) < rec.ttl/10 && rec.startRefresh(now)
+	return rec.IP, refresh, nil
+}
+
 var errRecordNotFound = errors.New("record not found")
 
 type dnsRequest struct {
@@ -62,6 +155,9 @@ type dnsRequest struct {
 	start   time.Time
 	expire  time.Time
 	msg     *dnsmessage.Message
+	// response receives the raw response if set, instead of it being cached
+	// as an IP record.
+	response chan<- []byte
 }
 
 func genEDNS0Options(clientIP net.IP) *dnsmessage.Resource {
@@ -69,31 +165,36 @@ func genEDNS0Options(clientIP net.IP) *dnsmessage.Resource {
 		return nil
 	}
 
-	var netmask int
+	// 24 for IPV4, 96 for IPv6
+	mask := net.CIDRMask(24, net.IPv4len*8)
+	if len(clientIP) != net.IPv4len {
+		mask = net.CIDRMask(96, net.IPv6len*8)
+	}
+	return genClientSubnetOption(&net.IPNet{IP: clientIP, Mask: mask})
+}
+
+// genClientSubnetOption returns an EDNS0 option carrying subnet as EDNS Client
+// Subnet (RFC 7871). A zero prefix length asks the server not to use the
+// address of the client.
+func genClientSubnetOption(subnet *net.IPNet) *dnsmessage.Resource {
 	var family uint16
+	var ip net.IP
 
-	if len(clientIP) == 4 {
+	if len(subnet.Mask) == net.IPv4len {
 		family = 1
-		netmask = 24 // 24 for IPV4, 96 for IPv6
+		ip = subnet.IP.To4()
 	} else {
 		family = 2
-		netmask = 96
+		ip = subnet.IP.To16()
 	}
+	netmask, _ := subnet.Mask.Size()
 
 	b := make([]byte, 4)
 	binary.BigEndian.PutUint16(b[0:], family)
 	b[2] = byte(netmask)
 	b[3] = 0
-	switch family {
-	case 1:
-		ip := clientIP.To4().Mask(net.CIDRMask(netmask, net.IPv4len*8))
-		needLength := (netmask + 8 - 1) / 8 // division rounding up
-		b = append(b, ip[:needLength]...)
-	case 2:
-		ip := clientIP.Mask(net.CIDRMask(netmask, net.IPv6len*8))
-		needLength := (netmask + 8 - 1) / 8 // division rounding up
-		b = append(b, ip[:needLength]...)
-	}
+	needLength := (netmask + 8 - 1) / 8 // division rounding up
+	b = append(b, ip.Mask(subnet.Mask)[:needLength]...)
 
 	const EDNS0SUBNET = 0x08
 
@@ -1,6 +1,7 @@
 package dns
 
 import (
+	"context"
 	"math/rand"
 	"testing"
 	"time"
@@ -9,9 +10,12 @@ import (
 	"github.com/miekg/dns"
 	"golang.org/x/net/dns/dnsmessage"
 
+	core "github.com/v2fly/v2ray-core/v4"
 	"github.com/v2fly/v2ray-core/v4/common"
 	"github.com/v2fly/v2ray-core/v4/common/net"
 	dns_feature "github.com/v2fly/v2ray-core/v4/features/dns"
+	"github.com/v2fly/v2ray-core/v4/features/routing"
+	"github.com/v2fly/v2ray-core/v4/transport"
 )
 
 func Test_parseResponse(t *testing.T) {
@@ -52,7 +56,7 @@ func Test_parseResponse(t *testing.T) {
 	}{
 		{
 			"empty",
-			&IPRecord{0, []net.Address(nil), time.Time{}, dnsmessage.RCodeSuccess},
+			&IPRecord{ReqID: 0, IP: []net.Address(nil), RCode: dnsmessage.RCodeSuccess},
 			false,
 		},
 		{
@@ -63,16 +67,15 @@ func Test_parseResponse(t *testing.T) {
 		{
 			"a record",
 			&IPRecord{
-				1,
-				[]net.Address{net.ParseAddress("8.8.8.8"), net.ParseAddress("8.8.4.4")},
-				time.Time{},
-				dnsmessage.RCodeSuccess,
+				ReqID: 1,
+				IP:    []net.Address{net.ParseAddress("8.8.8.8"), net.ParseAddress("8.8.4.4")},
+				RCode: dnsmessage.RCodeSuccess,
 			},
 			false,
 		},
 		{
 			"aaaa record",
-			&IPRecord{2, []net.Address{net.ParseAddress("2001::123:8888"), net.ParseAddress("2001::123:8844")}, time.Time{}, dnsmessage.RCodeSuccess},
+			&IPRecord{ReqID: 2, IP: []net.Address{net.ParseAddress("2001::123:8888"), net.ParseAddress("2001::123:8844")}, RCode: dnsmessage.RCodeSuccess},
 			false,
 		},
 	}
@@ -88,8 +91,8 @@ func Test_parseResponse(t *testing.T) {
 				// reset the time
 				got.Expire = time.Time{}
 			}
-			if cmp.Diff(got, tt.want) != "" {
-				t.Errorf(cmp.Diff(got, tt.want))
+			if r := cmp.Diff(got, tt.want, cmp.AllowUnexported(IPRecord{})); r != "" {
+				t.Error(r)
 				// t.Errorf("handleResponse() = %#v, want %#v", got, tt.want)
 			}
 		})
@@ -182,3 +185,121 @@ func TestFqdn(t *testing.T) {
 		})
 	}
 }
+
+func TestCacheOptionsClampTTL(t *testing.T) {
+	opts := &cacheOptions{minTTL: time.Minute, maxTTL: time.Hour}
+
+	short := &IPRecord{Expire: time.Now().Add(time.Second)}
+	opts.clampTTL(short)
+	if short.ttl != time.Minute || time.Until(short.Expire) < time.Second*59 {
+		t.Error("expected ttl raised to min ttl, got ", short.ttl)
+	}
+
+	long := &IPRecord{Expire: time.Now().Add(time.Hour * 24)}
+	opts.clampTTL(long)
+	if long.ttl != time.Hour || time.Until(long.Expire) > time.Hour {
+		t.Error("expected ttl lowered to max ttl, got ", long.ttl)
+	}
+}
+
+func TestCacheOptionsServeStale(t *testing.T) {
+	ips := []net.Address{net.ParseAddress("8.8.8.8")}
+	rec := &IPRecord{IP: ips, Expire: time.Now().Add(-time.Second), RCode: dnsmessage.RCodeSuccess}
+
+	strict := &cacheOptions{}
+	if _, _, err := strict.getIPs(rec); err != errRecordNotFound {
+		t.Error("expected expired record not found, got ", err)
+	}
+	if !strict.expired(rec, time.Now()) {
+		t.Error("expected record to be expired")
+	}
+
+	stale := &cacheOptions{staleTTL: time.Minute}
+	got, refresh, err := stale.getIPs(rec)
+	common.Must(err)
+	if r := cmp.Diff(got, ips); r != "" {
+		t.Error(r)
+	}
+	if !refresh {
+		t.Error("expected refresh of stale record")
+	}
+	if _, refresh, _ := stale.getIPs(rec); refresh {
+		t.Error("expected a single refresh within refresh interval")
+	}
+	if stale.expired(rec, time.Now()) {
+		t.Error("expected stale record to be kept")
+	}
+
+	servfail := &IPRecord{Expire: time.Now().Add(time.Minute), RCode: dnsmessage.RCodeServerFailure}
+	if stale.isNewer(rec, servfail) {
+		t.Error("expected SERVFAIL not to replace a stale record")
+	}
+	if !strict.isNewer(rec, servfail) {
+		t.Error("expected SERVFAIL to replace record without serve-stale")
+	}
+
+	rec.Expire = time.Now().Add(-time.Minute * 2)
+	if _, _, err := stale.getIPs(rec); err != errRecordNotFound {
+		t.Error("expected record beyond stale ttl not found, got ", err)
+	}
+}
+
+func TestCacheOptionsPrefetch(t *testing.T) {
+	opts := &cacheOptions{prefetch: true}
+	rec := &IPRecord{
+		IP:     []net.Address{net.ParseAddress("8.8.8.8")},
+		Expire: time.Now().Add(time.Second * 5),
+		RCode:  dnsmessage.RCodeSuccess,
+		ttl:    time.Minute,
+	}
+
+	if _, refresh, _ := opts.getIPs(rec); refresh {
+		t.Error("expected no prefetch on first hit")
+	}
+	if _, refresh, _ := opts.getIPs(rec); !refresh {
+		t.Error("expected prefetch of popular record near expiry")
+	}
+
+	fresh := &IPRecord{Expire: time.Now().Add(time.Minute), RCode: dnsmessage.RCodeSuccess, ttl: time.Minute}
+	for i := 0; i < 3; i++ {
+		if _, refresh, _ := opts.getIPs(fresh); refresh {
+			t.Error("expected no prefetch of fresh record")
+		}
+	}
+}
+
+type lateDispatcher struct{}
+
+func (lateDispatcher) Type() interface{} { return routing.DispatcherType() }
+func (lateDispatcher) Start() error      { return nil }
+func (lateDispatcher) Close() error      { return nil }
+func (lateDispatcher) Dispatch(context.Context, net.Destination) (*transport.Link, error) {
+	return nil, newError("not implemented")
+}
+
+func TestCacheOptionsBeforeDispatcher(t *testing.T) {
+	v, err := core.New(&core.Config{})
+	common.Must(err)
+
+	obj, err := core.CreateObject(v, &Config{
+		NameServer: []*NameServer{{
+			Address: &net.Endpoint{
+				Network: net.Network_UDP,
+				Address: &net.IPOrDomain{Address: &net.IPOrDomain_Ip{Ip: []byte{8, 8, 8, 8}}},
+				Port:    53,
+			},
+		}},
+		CacheMinTtl: 60,
+		Prefetch:    true,
+	})
+	common.Must(err)
+	common.Must(v.AddFeature(lateDispatcher{}))
+
+	server, ok := obj.(*DNS).clients[0].server.(*ClassicNameServer)
+	if !ok {
+		t.Fatal("expected udp name server, got ", obj.(*DNS).clients[0].server)
+	}
+	if server.minTTL != time.Minute || !server.prefetch {
+		t.Error("cache options not applied: ", server.cacheOptions)
+	}
+}
@@ -0,0 +1,917 @@
+//go:build !confonly
+// +build !confonly
+
+package dns
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+)
+
+// defaultTrustAnchors are the DS records of the root zone key signing keys
+// KSK-2017 and KSK-2024, as published by IANA.
+var defaultTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+const (
+	// dnssecUDPSize is the UDP payload size advertised when the DO bit is set,
+	// small enough to avoid IP fragmentation.
+	dnssecUDPSize = 1232
+	// bogusTTL is how long an answer that failed validation is cached.
+	bogusTTL = time.Minute
+	// maxZoneTTL bounds how long validated keys and delegations are cached.
+	maxZoneTTL = time.Hour
+	// maxCachedZones bounds the number of names the chain of trust is cached for.
+	maxCachedZones = 4096
+)
+
+type dnssecStatus byte
+
+const (
+	dnssecUnchecked dnssecStatus = iota
+	dnssecSecure
+	dnssecInsecure
+	dnssecBogus
+)
+
+func (s dnssecStatus) String() string {
+	switch s {
+	case dnssecSecure:
+		return "secure"
+	case dnssecInsecure:
+		return "insecure"
+	case dnssecBogus:
+		return "bogus"
+	default:
+		return "unchecked"
+	}
+}
+
+// dnssecServer is a name server whose answers can be validated. The queries
+// needed to build the chain of trust are sent through the server itself.
+type dnssecServer interface {
+	exchanger
+	Name() string
+	newReqID() uint16
+}
+
+// tcpExchanger is implemented by name servers over UDP, which retry a query
+// over TCP when its response is truncated.
+type tcpExchanger interface {
+	exchangeTCP(ctx context.Context, req *dnsRequest) ([]byte, error)
+}
+
+// dnssecOptions enables DNSSEC validation of the answers of a name server.
+type dnssecOptions struct {
+	validator *dnssecValidator
+}
+
+func (o *dnssecOptions) setValidator(v *dnssecValidator) {
+	o.validator = v
+}
+
+// ednsOptions returns the EDNS0 option of queries, with the DO bit set if
+// answers are validated. The client subnet option always has it set.
+func (o *dnssecOptions) ednsOptions(ctx context.Context, clientIP net.IP) *dnsmessage.Resource {
+	opt := genEDNS0Options(clientIP)
+	if subnet := clientSubnetFromContext(ctx); subnet != nil {
+		opt = genClientSubnetOption(subnet)
+	}
+	if opt == nil && o.validator != nil {
+		opt = dnssecOKOption()
+	}
+	return opt
+}
+
+func dnssecOKOption() *dnsmessage.Resource {
+	opt := &dnsmessage.Resource{Body: &dnsmessage.OPTResource{}}
+	common.Must(opt.Header.SetEDNS0(dnssecUDPSize, dnsmessage.RCodeSuccess, true))
+	return opt
+}
+
+// validate validates the response to req. A bogus answer is dropped, and rec
+// turns into a server failure that carries the validation error.
+func (o *dnssecOptions) validate(ctx context.Context, s dnssecServer, req *dnsRequest, payload []byte, rec *IPRecord) {
+	if o.validator == nil || rec == nil {
+		return
+	}
+	status, err := o.validator.validate(ctx, s, payload)
+	if status != dnssecBogus {
+		newError(s.Name(), " DNSSEC ", status, " answer: ", req.domain, " ", req.reqType).AtDebug().WriteToLog()
+		return
+	}
+	bogus := newError("DNSSEC validation failed for ", req.domain, " ", req.reqType, " at ", s.Name()).Base(err).AtWarning()
+	bogus.WriteToLog()
+	rec.IP = nil
+	rec.RCode = dnsmessage.RCodeServerFailure
+	rec.Expire = time.Now().Add(bogusTTL)
+	rec.err = bogus
+}
+
+// zoneEntry is the outcome of following the chain of trust down to a name.
+type zoneEntry struct {
+	// zone is the apex of the closest enclosing signed zone.
+	zone string
+	keys []*dns.DNSKEY
+	// insecure is set if there is an unsigned delegation above the name.
+	insecure bool
+	// nonexistent is set if the name is proven not to exist, and so is any
+	// name below it.
+	nonexistent bool
+	expire      time.Time
+}
+
+// dnssecValidator validates answers against a chain of trust that starts at
+// its trust anchors.
+type dnssecValidator struct {
+	anchors map[string][]*dns.DS
+
+	access sync.Mutex
+	zones  map[string]*zoneEntry
+}
+
+func newDNSSECValidator(trustAnchors []string) (*dnssecValidator, error) {
+	if len(trustAnchors) == 0 {
+		trustAnchors = defaultTrustAnchors
+	}
+	v := &dnssecValidator{
+		anchors: make(map[string][]*dns.DS),
+		zones:   make(map[string]*zoneEntry),
+	}
+	for _, anchor := range trustAnchors {
+		rr, err := dns.NewRR(anchor)
+		if err != nil {
+			return nil, newError("invalid DNSSEC trust anchor: ", anchor).Base(err)
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			return nil, newError("DNSSEC trust anchor is not a DS record: ", anchor)
+		}
+		name := dns.CanonicalName(ds.Hdr.Name)
+		v.anchors[name] = append(v.anchors[name], ds)
+	}
+	return v, nil
+}
+
+// validate validates the answer, or the denial of existence, in a response.
+// Wildcard expansions in the answer must come with the proof that the
+// expanded name does not exist.
+func (v *dnssecValidator) validate(ctx context.Context, s dnssecServer, payload []byte) (dnssecStatus, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Second*10)
+		defer cancel()
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(payload); err != nil {
+		return dnssecBogus, newError("failed to unpack response").Base(err)
+	}
+	if len(msg.Question) != 1 {
+		return dnssecBogus, newError("unexpected number of questions: ", len(msg.Question))
+	}
+	if msg.Rcode != dns.RcodeSuccess && msg.Rcode != dns.RcodeNameError {
+		// There is nothing to validate in a failure.
+		return dnssecUnchecked, nil
+	}
+	question := msg.Question[0]
+
+	rrsets, sigs := splitRRsets(msg.Answer)
+	target, err := checkAnswerChain(question.Name, rrsets)
+	if err != nil {
+		return dnssecBogus, err
+	}
+
+	status := dnssecSecure
+	answered := false
+	for _, rrset := range rrsets {
+		header := rrset[0].Header()
+		if header.Rrtype == question.Qtype || question.Qtype == dns.TypeCNAME {
+			answered = true
+		}
+		if isSynthesizedCNAME(rrset, rrsets) {
+			continue
+		}
+		st, sig, err := v.validateRRset(ctx, s, rrset, sigs)
+		if err != nil {
+			return dnssecBogus, err
+		}
+		if st == dnssecInsecure {
+			status = dnssecInsecure
+		}
+		if sig != nil && isWildcardExpansion(header.Name, sig) {
+			st, err := v.validateWildcard(ctx, s, header.Name, sig.Labels, msg)
+			if err != nil {
+				return dnssecBogus, err
+			}
+			if st == dnssecInsecure {
+				status = dnssecInsecure
+			}
+		}
+	}
+	if answered && msg.Rcode == dns.RcodeSuccess {
+		return status, nil
+	}
+
+	// The name at the end of the alias chain does not exist, or has no
+	// record of the requested type.
+	st, err := v.validateDenial(ctx, s, target, question.Qtype, msg)
+	if err != nil {
+		return dnssecBogus, err
+	}
+	if st == dnssecInsecure {
+		status = dnssecInsecure
+	}
+	return status, nil
+}
+
+// validateDenial validates a negative response, which must prove that name,
+// or the record type at name, does not exist.
+func (v *dnssecValidator) validateDenial(ctx context.Context, s dnssecServer, name string, qType uint16, msg *dns.Msg) (dnssecStatus, error) {
+	rrsets, sigs := splitRRsets(msg.Ns)
+	if len(rrsets) == 0 {
+		entry, err := v.zoneFor(ctx, s, name)
+		if err != nil {
+			return dnssecBogus, err
+		}
+		if entry.insecure {
+			return dnssecInsecure, nil
+		}
+		return dnssecBogus, newError("missing denial of existence for ", name)
+	}
+
+	var nsecs []*dns.NSEC
+	var nsec3s []*dns.NSEC3
+	for _, rrset := range rrsets {
+		st, _, err := v.validateRRset(ctx, s, rrset, sigs)
+		if err != nil {
+			return dnssecBogus, err
+		}
+		if st == dnssecInsecure {
+			return dnssecInsecure, nil
+		}
+		for _, rr := range rrset {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				nsecs = append(nsecs, rr)
+			case *dns.NSEC3:
+				nsec3s = append(nsec3s, rr)
+			}
+		}
+	}
+	if !deniesExistence(name, qType, msg.Rcode == dns.RcodeNameError, nsecs, nsec3s) {
+		return dnssecBogus, newError("missing proof of nonexistence for ", name, " ", dns.TypeToString[qType])
+	}
+	return dnssecSecure, nil
+}
+
+// validateWildcard validates the proof that name, which an answer was
+// expanded from a wildcard for, does not exist. labels is the number of labels
+// of the wildcard without its asterisk (RFC 4035 5.3.4).
+func (v *dnssecValidator) validateWildcard(ctx context.Context, s dnssecServer, name string, labels uint8, msg *dns.Msg) (dnssecStatus, error) {
+	rrsets, sigs := splitRRsets(msg.Ns)
+	var nsecs []*dns.NSEC
+	var nsec3s []*dns.NSEC3
+	for _, rrset := range rrsets {
+		header := rrset[0].Header()
+		if header.Rrtype != dns.TypeNSEC && header.Rrtype != dns.TypeNSEC3 {
+			continue
+		}
+		st, _, err := v.validateRRset(ctx, s, rrset, sigs)
+		if err != nil {
+			return dnssecBogus, err
+		}
+		if st == dnssecInsecure {
+			return dnssecInsecure, nil
+		}
+		for _, rr := range rrset {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				nsecs = append(nsecs, rr)
+			case *dns.NSEC3:
+				nsec3s = append(nsec3s, rr)
+			}
+		}
+	}
+	if !provesExpansion(name, labels, nsecs, nsec3s) {
+		return dnssecBogus, newError("missing proof that ", name, " does not exist for its wildcard expansion")
+	}
+	return dnssecSecure, nil
+}
+
+// validateRRset verifies the signature of an RRset with the keys of the zone
+// that signed it, and returns the signature that verified it, if the RRset is
+// secure.
+func (v *dnssecValidator) validateRRset(ctx context.Context, s dnssecServer, rrset []dns.RR, sigs []*dns.RRSIG) (dnssecStatus, *dns.RRSIG, error) {
+	header := rrset[0].Header()
+	covering := sigsFor(sigs, header.Name, header.Rrtype)
+	if len(covering) == 0 {
+		entry, err := v.zoneFor(ctx, s, header.Name)
+		if err != nil {
+			return dnssecBogus, nil, err
+		}
+		if entry.insecure {
+			return dnssecInsecure, nil, nil
+		}
+		return dnssecBogus, nil, newError("missing signature for ", header.Name, " ", dns.TypeToString[header.Rrtype])
+	}
+
+	signer := dns.CanonicalName(covering[0].SignerName)
+	if !dns.IsSubDomain(signer, dns.CanonicalName(header.Name)) {
+		return dnssecBogus, nil, newError(header.Name, " is signed by ", signer, " outside of its zone")
+	}
+	entry, err := v.zoneFor(ctx, s, signer)
+	if err != nil {
+		return dnssecBogus, nil, err
+	}
+	if entry.insecure {
+		return dnssecInsecure, nil, nil
+	}
+	if entry.zone != signer {
+		return dnssecBogus, nil, newError(header.Name, " is signed by ", signer, ", which is not a zone apex")
+	}
+	sig, err := verifyingSig(rrset, covering, entry.zone, entry.keys)
+	if err != nil {
+		return dnssecBogus, nil, err
+	}
+	return dnssecSecure, sig, nil
+}
+
+// zoneFor follows the chain of trust from the closest trust anchor down to
+// name.
+func (v *dnssecValidator) zoneFor(ctx context.Context, s dnssecServer, name string) (*zoneEntry, error) {
+	name = dns.CanonicalName(name)
+	anchor := v.anchorFor(name)
+	if anchor == "" {
+		return &zoneEntry{insecure: true}, nil
+	}
+
+	current := v.cached(anchor)
+	if current == nil {
+		var err error
+		current, err = v.trustedKeys(ctx, s, anchor, v.anchors[anchor], time.Now().Add(maxZoneTTL))
+		if err != nil {
+			return nil, err
+		}
+		v.store(anchor, current)
+	}
+
+	labels := dns.SplitDomainName(name)
+	for i := len(labels) - dns.CountLabel(anchor) - 1; i >= 0 && !current.insecure && !current.nonexistent; i-- {
+		child := dns.Fqdn(strings.Join(labels[i:], "."))
+		if entry := v.cached(child); entry != nil {
+			current = entry
+			continue
+		}
+		entry, err := v.delegation(ctx, s, current, child)
+		if err != nil {
+			return nil, err
+		}
+		v.store(child, entry)
+		current = entry
+	}
+	return current, nil
+}
+
+// delegation looks up the DS records of child, a name one label below the
+// name current was resolved for, and returns the entry for child.
+func (v *dnssecValidator) delegation(ctx context.Context, s dnssecServer, current *zoneEntry, child string) (*zoneEntry, error) {
+	msg, err := v.query(ctx, s, child, dns.TypeDS)
+	if err != nil {
+		return nil, err
+	}
+
+	answers, answerSigs := splitRRsets(msg.Answer)
+	for _, rrset := range answers {
+		header := rrset[0].Header()
+		if !strings.EqualFold(header.Name, child) {
+			continue
+		}
+		if err := verifyRRset(rrset, sigsFor(answerSigs, header.Name, header.Rrtype), current.zone, current.keys); err != nil {
+			return nil, err
+		}
+		switch header.Rrtype {
+		case dns.TypeDS:
+			dsSet := make([]*dns.DS, 0, len(rrset))
+			for _, rr := range rrset {
+				dsSet = append(dsSet, rr.(*dns.DS))
+			}
+			return v.trustedKeys(ctx, s, child, dsSet, minExpire(current.expire, rrset))
+		case dns.TypeCNAME:
+			// An alias is never a zone cut.
+			return v.within(current, rrset), nil
+		}
+	}
+
+	authorities, authoritySigs := splitRRsets(msg.Ns)
+	var proof []dns.RR
+	for _, rrset := range authorities {
+		header := rrset[0].Header()
+		if header.Rrtype != dns.TypeNSEC && header.Rrtype != dns.TypeNSEC3 {
+			continue
+		}
+		if err := verifyRRset(rrset, sigsFor(authoritySigs, header.Name, header.Rrtype), current.zone, current.keys); err != nil {
+			return nil, err
+		}
+		proof = append(proof, rrset...)
+	}
+
+	for _, rr := range proof {
+		var types []uint16
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			if !strings.EqualFold(rr.Hdr.Name, child) {
+				continue
+			}
+			types = rr.TypeBitMap
+		case *dns.NSEC3:
+			if !rr.Match(child) {
+				continue
+			}
+			types = rr.TypeBitMap
+		}
+		switch {
+		case hasType(types, dns.TypeDS):
+			return nil, newError("DS records of ", child, " are both present and denied")
+		case hasType(types, dns.TypeNS) && !hasType(types, dns.TypeSOA):
+			newError("unsigned delegation to ", child).AtDebug().WriteToLog()
+			entry := &zoneEntry{zone: child, insecure: true, expire: minExpire(current.expire, proof)}
+			return entry, nil
+		default:
+			return v.within(current, proof), nil
+		}
+	}
+	for _, rr := range proof {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			if nsecCovers(rr, child) {
+				entry := v.within(current, proof)
+				entry.nonexistent = true
+				return entry, nil
+			}
+		case *dns.NSEC3:
+			if rr.Cover(child) {
+				if rr.Flags&1 != 0 {
+					// Opt-out spans may hide unsigned delegations.
+					entry := &zoneEntry{zone: child, insecure: true, expire: minExpire(current.expire, proof)}
+					return entry, nil
+				}
+				entry := v.within(current, proof)
+				entry.nonexistent = true
+				return entry, nil
+			}
+		}
+	}
+	return nil, newError("missing proof that ", child, " is not a signed zone")
+}
+
+// within returns the entry of a name that belongs to the same zone as current.
+func (v *dnssecValidator) within(current *zoneEntry, proof []dns.RR) *zoneEntry {
+	return &zoneEntry{
+		zone:   current.zone,
+		keys:   current.keys,
+		expire: minExpire(current.expire, proof),
+	}
+}
+
+// trustedKeys fetches the DNSKEY records of zone and authenticates them with
+// the DS records of the zone.
+func (v *dnssecValidator) trustedKeys(ctx context.Context, s dnssecServer, zone string, dsSet []*dns.DS, expire time.Time) (*zoneEntry, error) {
+	msg, err := v.query(ctx, s, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+
+	var rrset []dns.RR
+	var keys []*dns.DNSKEY
+	for _, rr := range msg.Answer {
+		if key, ok := rr.(*dns.DNSKEY); ok && strings.EqualFold(key.Hdr.Name, zone) {
+			rrset = append(rrset, key)
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, newError("missing DNSKEY records of ", zone)
+	}
+	_, answerSigs := splitRRsets(msg.Answer)
+	sigs := sigsFor(answerSigs, zone, dns.TypeDNSKEY)
+
+	supported := false
+	for _, ds := range dsSet {
+		if !supportedAlgorithm(ds.Algorithm) || !supportedDigest(ds.DigestType) {
+			continue
+		}
+		supported = true
+		for _, key := range keys {
+			if key.KeyTag() != ds.KeyTag || key.Algorithm != ds.Algorithm {
+				continue
+			}
+			if digest := key.ToDS(ds.DigestType); digest == nil || !strings.EqualFold(digest.Digest, ds.Digest) {
+				continue
+			}
+			if err := verifyRRset(rrset, sigs, zone, []*dns.DNSKEY{key}); err == nil {
+				return &zoneEntry{zone: zone, keys: keys, expire: minExpire(expire, rrset)}, nil
+			}
+		}
+	}
+	if !supported {
+		// RFC 4035 5.2: a zone whose DS records only use unsupported
+		// algorithms is treated as insecure.
+		newError("unsupported DNSSEC algorithms for ", zone).AtDebug().WriteToLog()
+		return &zoneEntry{zone: zone, insecure: true, expire: expire}, nil
+	}
+	return nil, newError("no DNSKEY of ", zone, " matches its DS records")
+}
+
+// query sends a query needed to build the chain of trust. A truncated response
+// is retried over TCP if s supports it, and rejected otherwise.
+func (v *dnssecValidator) query(ctx context.Context, s dnssecServer, name string, qType uint16) (*dns.Msg, error) {
+	req := buildRecordReqMsg(name, dnsmessage.Type(qType), s.newReqID(), dnssecOKOption())
+	msg, err := unpackExchange(s.exchange(ctx, req))
+	if err == nil && msg.Truncated {
+		if tcp, ok := s.(tcpExchanger); ok {
+			newError("truncated response for ", name, " ", dns.TypeToString[qType], ", retrying over TCP").AtDebug().WriteToLog()
+			msg, err = unpackExchange(tcp.exchangeTCP(ctx, req))
+		}
+	}
+	if err != nil {
+		return nil, newError("failed to query ", name, " ", dns.TypeToString[qType]).Base(err)
+	}
+	if msg.Truncated {
+		return nil, newError("truncated response for ", name, " ", dns.TypeToString[qType])
+	}
+	if msg.Rcode != dns.RcodeSuccess && msg.Rcode != dns.RcodeNameError {
+		return nil, newError("failed to query ", name, " ", dns.TypeToString[qType], ": ", dns.RcodeToString[msg.Rcode])
+	}
+	return msg, nil
+}
+
+func unpackExchange(payload []byte, err error) (*dns.Msg, error) {
+	if err != nil {
+		return nil, err
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(payload); err != nil {
+		return nil, newError("failed to unpack response").Base(err)
+	}
+	return msg, nil
+}
+
+// anchorFor returns the closest trust anchor above or at name.
+func (v *dnssecValidator) anchorFor(name string) string {
+	var anchor string
+	for owner := range v.anchors {
+		if dns.IsSubDomain(owner, name) && (anchor == "" || dns.CountLabel(owner) > dns.CountLabel(anchor)) {
+			anchor = owner
+		}
+	}
+	return anchor
+}
+
+func (v *dnssecValidator) cached(name string) *zoneEntry {
+	v.access.Lock()
+	defer v.access.Unlock()
+
+	entry, found := v.zones[name]
+	if !found {
+		return nil
+	}
+	if entry.expire.Before(time.Now()) {
+		delete(v.zones, name)
+		return nil
+	}
+	return entry
+}
+
+func (v *dnssecValidator) store(name string, entry *zoneEntry) {
+	v.access.Lock()
+	defer v.access.Unlock()
+
+	if len(v.zones) >= maxCachedZones {
+		now := time.Now()
+		for k, e := range v.zones {
+			if e.expire.Before(now) {
+				delete(v.zones, k)
+			}
+		}
+		if len(v.zones) >= maxCachedZones {
+			v.zones = make(map[string]*zoneEntry)
+		}
+	}
+	v.zones[name] = entry
+}
+
+// verifyRRset checks that one of sigs is a currently valid signature of
+// rrset, made by one of the keys of zone.
+func verifyRRset(rrset []dns.RR, sigs []*dns.RRSIG, zone string, keys []*dns.DNSKEY) error {
+	_, err := verifyingSig(rrset, sigs, zone, keys)
+	return err
+}
+
+// verifyingSig is like verifyRRset, and returns the signature that verified
+// rrset.
+func verifyingSig(rrset []dns.RR, sigs []*dns.RRSIG, zone string, keys []*dns.DNSKEY) (*dns.RRSIG, error) {
+	header := rrset[0].Header()
+	now := time.Now()
+	for _, sig := range sigs {
+		if !strings.EqualFold(sig.SignerName, zone) || !sig.ValidityPeriod(now) {
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() == sig.KeyTag && key.Algorithm == sig.Algorithm && sig.Verify(key, rrset) == nil {
+				return sig, nil
+			}
+		}
+	}
+	return nil, newError("no valid signature by ", zone, " for ", header.Name, " ", dns.TypeToString[header.Rrtype])
+}
+
+func supportedAlgorithm(algorithm uint8) bool {
+	switch algorithm {
+	case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512,
+		dns.ECDSAP256SHA256, dns.ECDSAP384SHA384, dns.ED25519:
+		return true
+	default:
+		return false
+	}
+}
+
+func supportedDigest(digestType uint8) bool {
+	switch digestType {
+	case dns.SHA1, dns.SHA256, dns.SHA384:
+		return true
+	default:
+		return false
+	}
+}
+
+// splitRRsets groups records into RRsets, in the order they first appear,
+// and returns the signatures separately.
+func splitRRsets(rrs []dns.RR) ([][]dns.RR, []*dns.RRSIG) {
+	type setKey struct {
+		name   string
+		rrtype uint16
+	}
+	var rrsets [][]dns.RR
+	var sigs []*dns.RRSIG
+	index := make(map[setKey]int)
+	for _, rr := range rrs {
+		switch rr := rr.(type) {
+		case *dns.RRSIG:
+			sigs = append(sigs, rr)
+			continue
+		case *dns.OPT:
+			continue
+		}
+		key := setKey{name: dns.CanonicalName(rr.Header().Name), rrtype: rr.Header().Rrtype}
+		if i, found := index[key]; found {
+			rrsets[i] = append(rrsets[i], rr)
+			continue
+		}
+		index[key] = len(rrsets)
+		rrsets = append(rrsets, []dns.RR{rr})
+	}
+	return rrsets, sigs
+}
+
+func sigsFor(sigs []*dns.RRSIG, name string, rrtype uint16) []*dns.RRSIG {
+	var covering []*dns.RRSIG
+	for _, sig := range sigs {
+		if sig.TypeCovered == rrtype && strings.EqualFold(sig.Hdr.Name, name) {
+			covering = append(covering, sig)
+		}
+	}
+	return covering
+}
+
+// checkAnswerChain makes sure that every RRset in an answer belongs to the
+// chain of aliases that starts at the question name, and returns the name at
+// the end of the chain.
+func checkAnswerChain(qName string, rrsets [][]dns.RR) (string, error) {
+	target := dns.CanonicalName(qName)
+	names := map[string]bool{target: true}
+	for _, rrset := range rrsets {
+		for _, rr := range rrset {
+			if cname, ok := rr.(*dns.CNAME); ok && names[dns.CanonicalName(cname.Hdr.Name)] {
+				target = dns.CanonicalName(cname.Target)
+				names[target] = true
+			}
+		}
+	}
+	for _, rrset := range rrsets {
+		header := rrset[0].Header()
+		if header.Rrtype == dns.TypeDNAME {
+			continue
+		}
+		if !names[dns.CanonicalName(header.Name)] {
+			return "", newError("unexpected record for ", header.Name, " in answer to ", qName)
+		}
+	}
+	return target, nil
+}
+
+// isSynthesizedCNAME reports whether rrset is an unsigned CNAME synthesized
+// from a DNAME in the same answer (RFC 6672).
+func isSynthesizedCNAME(rrset []dns.RR, rrsets [][]dns.RR) bool {
+	if rrset[0].Header().Rrtype != dns.TypeCNAME {
+		return false
+	}
+	owner := dns.CanonicalName(rrset[0].Header().Name)
+	for _, other := range rrsets {
+		if dname, ok := other[0].(*dns.DNAME); ok {
+			apex := dns.CanonicalName(dname.Hdr.Name)
+			if owner != apex && dns.IsSubDomain(apex, owner) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deniesExistence reports whether the NSEC or NSEC3 records prove that name
+// does not exist, or has no record of qType. Unless name itself has a record,
+// they must also prove that no wildcard at its closest encloser answers it
+// (RFC 4035 5.4, RFC 5155 8.4).
+func deniesExistence(name string, qType uint16, nxdomain bool, nsecs []*dns.NSEC, nsec3s []*dns.NSEC3) bool {
+	for _, nsec := range nsecs {
+		if strings.EqualFold(nsec.Hdr.Name, name) {
+			return !nxdomain && !hasType(nsec.TypeBitMap, qType) && !hasType(nsec.TypeBitMap, dns.TypeCNAME)
+		}
+	}
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, name) {
+			return deniesWildcard(wildcardName(nsecClosestEncloser(nsec, name)), qType, nxdomain, nsecs)
+		}
+	}
+	if len(nsec3s) == 0 {
+		return false
+	}
+
+	// Find the closest encloser of name, and check that the next closer name
+	// is covered (RFC 5155 8.3).
+	labels := dns.SplitDomainName(name)
+	for i := 0; i <= len(labels); i++ {
+		encloser := dns.Fqdn(strings.Join(labels[i:], "."))
+		for _, nsec3 := range nsec3s {
+			if !nsec3.Match(encloser) {
+				continue
+			}
+			if i == 0 {
+				return !nxdomain && !hasType(nsec3.TypeBitMap, qType) && !hasType(nsec3.TypeBitMap, dns.TypeCNAME)
+			}
+			nextCloser := dns.Fqdn(strings.Join(labels[i-1:], "."))
+			for _, cover := range nsec3s {
+				if !cover.Cover(nextCloser) {
+					continue
+				}
+				// An opt-out span proves that there is no secure delegation
+				// (RFC 5155 8.6).
+				if !nxdomain && qType == dns.TypeDS && cover.Flags&1 == 1 {
+					return true
+				}
+				return deniesWildcard3(wildcardName(encloser), qType, nxdomain, nsec3s)
+			}
+			return false
+		}
+	}
+	return false
+}
+
+// deniesWildcard reports whether the NSEC records prove that wildcard does not
+// exist for an NXDOMAIN response, or has no record of qType otherwise.
+func deniesWildcard(wildcard string, qType uint16, nxdomain bool, nsecs []*dns.NSEC) bool {
+	for _, nsec := range nsecs {
+		if strings.EqualFold(nsec.Hdr.Name, wildcard) {
+			return !nxdomain && !hasType(nsec.TypeBitMap, qType) && !hasType(nsec.TypeBitMap, dns.TypeCNAME)
+		}
+	}
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, wildcard) {
+			return nxdomain
+		}
+	}
+	return false
+}
+
+// deniesWildcard3 is deniesWildcard with NSEC3 records.
+func deniesWildcard3(wildcard string, qType uint16, nxdomain bool, nsec3s []*dns.NSEC3) bool {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(wildcard) {
+			return !nxdomain && !hasType(nsec3.TypeBitMap, qType) && !hasType(nsec3.TypeBitMap, dns.TypeCNAME)
+		}
+	}
+	for _, nsec3 := range nsec3s {
+		if nsec3.Cover(wildcard) {
+			return nxdomain
+		}
+	}
+	return false
+}
+
+// nsecClosestEncloser returns the closest encloser of name, which nsec covers.
+// It is the longest ancestor of name that the owner or the next name of nsec
+// is under.
+func nsecClosestEncloser(nsec *dns.NSEC, name string) string {
+	labels := dns.SplitDomainName(name)
+	if len(labels) == 0 {
+		return "."
+	}
+	n := dns.CompareDomainName(name, nsec.Hdr.Name)
+	if m := dns.CompareDomainName(name, nsec.NextDomain); m > n {
+		n = m
+	}
+	if n >= len(labels) {
+		n = len(labels) - 1
+	}
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+func wildcardName(encloser string) string {
+	if encloser == "." {
+		return "*."
+	}
+	return "*." + encloser
+}
+
+// isWildcardExpansion reports whether sig, the signature of the RRset of name,
+// was made for a wildcard that name was expanded from.
+func isWildcardExpansion(name string, sig *dns.RRSIG) bool {
+	labels := dns.SplitDomainName(name)
+	if len(labels) > 0 && labels[0] == "*" {
+		labels = labels[1:]
+	}
+	return int(sig.Labels) < len(labels)
+}
+
+// provesExpansion reports whether the NSEC or NSEC3 records prove that name
+// does not exist, so that an answer for it may be expanded from the wildcard
+// with labels labels. With NSEC3, the next closer name of the wildcard's
+// closest encloser must be covered (RFC 5155 8.8).
+func provesExpansion(name string, labels uint8, nsecs []*dns.NSEC, nsec3s []*dns.NSEC3) bool {
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, name) {
+			return true
+		}
+	}
+	names := dns.SplitDomainName(name)
+	if int(labels) >= len(names) {
+		return false
+	}
+	nextCloser := dns.Fqdn(strings.Join(names[len(names)-int(labels)-1:], "."))
+	for _, nsec3 := range nsec3s {
+		if nsec3.Cover(nextCloser) {
+			return true
+		}
+	}
+	return false
+}
+
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+	owner := nsec.Hdr.Name
+	next := nsec.NextDomain
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+	// The last NSEC record of a zone wraps around to the apex.
+	return canonicalCompare(owner, name) < 0 || canonicalCompare(name, next) < 0
+}
+
+// canonicalCompare compares two names in canonical DNS order (RFC 4034 6.1).
+func canonicalCompare(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+func hasType(types []uint16, rrtype uint16) bool {
+	for _, t := range types {
+		if t == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+func minExpire(expire time.Time, rrs []dns.RR) time.Time {
+	for _, rr := range rrs {
+		if e := time.Now().Add(time.Duration(rr.Header().Ttl) * time.Second); e.Before(expire) {
+			expire = e
+		}
+	}
+	return expire
+}
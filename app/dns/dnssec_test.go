@@ -0,0 +1,487 @@
+package dns
+
+import (
+	"context"
+	"crypto"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/miekg/dns"
+	"golang.org/x/net/dns/dnsmessage"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/app/dispatcher"
+	"github.com/v2fly/v2ray-core/v4/app/policy"
+	"github.com/v2fly/v2ray-core/v4/app/proxyman"
+	_ "github.com/v2fly/v2ray-core/v4/app/proxyman/outbound"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	dns_proto "github.com/v2fly/v2ray-core/v4/common/protocol/dns"
+	"github.com/v2fly/v2ray-core/v4/common/serial"
+	feature_dns "github.com/v2fly/v2ray-core/v4/features/dns"
+	"github.com/v2fly/v2ray-core/v4/proxy/freedom"
+	"github.com/v2fly/v2ray-core/v4/testing/servers/udp"
+	_ "github.com/v2fly/v2ray-core/v4/transport/internet/tcp"
+)
+
+type signedZone struct {
+	name string
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newSignedZone(t *testing.T, name string) *signedZone {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	common.Must(err)
+	return &signedZone{name: name, key: key, priv: priv.(crypto.Signer)}
+}
+
+func (z *signedZone) ds() string {
+	return z.key.ToDS(dns.SHA256).String()
+}
+
+// sign returns the RRset followed by its signature.
+func (z *signedZone) sign(rrset ...dns.RR) []dns.RR {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+		Algorithm:  z.key.Algorithm,
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+	}
+	common.Must(sig.Sign(z.priv, rrset))
+	return append(rrset, sig)
+}
+
+func mustRR(s string) dns.RR {
+	return common.Must2(dns.NewRR(s)).(dns.RR)
+}
+
+// fakeDNSSECServer answers queries from a table of responses, keyed by name
+// and type.
+type fakeDNSSECServer struct {
+	reqID     uint32
+	responses map[string]*dns.Msg
+}
+
+func (s *fakeDNSSECServer) Name() string {
+	return "fake"
+}
+
+func (s *fakeDNSSECServer) newReqID() uint16 {
+	return uint16(atomic.AddUint32(&s.reqID, 1))
+}
+
+func (s *fakeDNSSECServer) exchange(ctx context.Context, req *dnsRequest) ([]byte, error) {
+	b, err := dns_proto.PackMessage(req.msg)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Release()
+	query := new(dns.Msg)
+	common.Must(query.Unpack(b.Bytes()))
+	if opt := query.IsEdns0(); opt == nil || !opt.Do() {
+		return nil, newError("DO bit is not set")
+	}
+	return s.reply(query).Pack()
+}
+
+func (s *fakeDNSSECServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	common.Must(w.WriteMsg(s.reply(r)))
+}
+
+func (s *fakeDNSSECServer) reply(query *dns.Msg) *dns.Msg {
+	q := query.Question[0]
+	resp, found := s.responses[strings.ToLower(q.Name)+" "+dns.TypeToString[q.Qtype]]
+	if !found {
+		return new(dns.Msg).SetRcode(query, dns.RcodeServerFailure)
+	}
+	resp = resp.Copy()
+	resp.Id = query.Id
+	resp.Response = true
+	resp.Question = query.Question
+	return resp
+}
+
+func (s *fakeDNSSECServer) answer(name string, qType uint16, rcode int, answer []dns.RR, ns ...dns.RR) {
+	if s.responses == nil {
+		s.responses = make(map[string]*dns.Msg)
+	}
+	s.responses[name+" "+dns.TypeToString[qType]] = &dns.Msg{
+		MsgHdr: dns.MsgHdr{Rcode: rcode},
+		Answer: answer,
+		Ns:     ns,
+	}
+}
+
+func (s *fakeDNSSECServer) query(name string, qType uint16) []byte {
+	req := buildRecordReqMsg(name, dnsmessage.Type(qType), s.newReqID(), dnssecOKOption())
+	return common.Must2(s.exchange(context.Background(), req)).([]byte)
+}
+
+// nsec3Hash returns the NSEC3 hash of name, without salt or iterations.
+func nsec3Hash(name string) string {
+	return dns.HashName(name, dns.SHA1, 0, "")
+}
+
+// coveringNSEC3 returns the NSEC3 record of zone whose owner is just before
+// the hash of name and whose next hash is just after it, so that it covers
+// name only.
+func coveringNSEC3(zone, name string) *dns.NSEC3 {
+	hash := nsec3Hash(name)
+	if hash[len(hash)-1] == 'V' {
+		panic("no next hash for " + name)
+	}
+	return newNSEC3(zone, hash[:len(hash)-1], hash[:len(hash)-1]+"V")
+}
+
+func newNSEC3(zone, ownerHash, nextHash string, types ...uint16) *dns.NSEC3 {
+	return &dns.NSEC3{
+		Hdr:        dns.RR_Header{Name: ownerHash + "." + zone, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 300},
+		Hash:       dns.SHA1,
+		HashLength: 20,
+		NextDomain: nextHash,
+		TypeBitMap: types,
+	}
+}
+
+func newSignedTestServer(t *testing.T) (*fakeDNSSECServer, *signedZone) {
+	root := newSignedZone(t, ".")
+	example := newSignedZone(t, "example.")
+
+	s := new(fakeDNSSECServer)
+	s.answer(".", dns.TypeDNSKEY, dns.RcodeSuccess, root.sign(root.key))
+	s.answer("example.", dns.TypeDS, dns.RcodeSuccess, root.sign(mustRR(example.ds())))
+	s.answer("example.", dns.TypeDNSKEY, dns.RcodeSuccess, example.sign(example.key))
+
+	soa := example.sign(mustRR("example. 300 IN SOA ns.example. admin.example. 1 7200 3600 1209600 300"))
+	apexNSEC := example.sign(mustRR("example. 300 IN NSEC www.example. NS SOA RRSIG NSEC DNSKEY"))
+	wwwNSEC := example.sign(mustRR("www.example. 300 IN NSEC example. A RRSIG NSEC"))
+
+	s.answer("www.example.", dns.TypeA, dns.RcodeSuccess, example.sign(mustRR("www.example. 300 IN A 1.2.3.4")))
+	s.answer("www.example.", dns.TypeAAAA, dns.RcodeSuccess, nil, append(soa, wwwNSEC...)...)
+	s.answer("nx.example.", dns.TypeA, dns.RcodeNameError, nil, append(soa, apexNSEC...)...)
+	s.answer("nx.example.", dns.TypeDS, dns.RcodeNameError, nil, append(soa, apexNSEC...)...)
+	s.answer("unproven.example.", dns.TypeA, dns.RcodeNameError, nil, soa...)
+
+	// The NSEC of *.example. covers replayed.example., but does not deny the
+	// wildcard that answers it.
+	wildcardNSEC := example.sign(mustRR("*.example. 300 IN NSEC www.example. A RRSIG NSEC"))
+	s.answer("replayed.example.", dns.TypeA, dns.RcodeNameError, nil, append(soa, wildcardNSEC...)...)
+
+	// NSEC3 proofs of the closest encloser example. and the next closer name,
+	// which only the first one comes with the denial of *.example. for.
+	apexNSEC3 := example.sign(newNSEC3("example.", nsec3Hash("example."), coveringNSEC3("example.", "example.").NextDomain,
+		dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeNSEC3PARAM))
+	nextCloserProof := func(name string) []dns.RR {
+		return append(append(append([]dns.RR{}, soa...), apexNSEC3...), example.sign(coveringNSEC3("example.", name))...)
+	}
+	s.answer("nx3.example.", dns.TypeA, dns.RcodeNameError, nil, append(nextCloserProof("nx3.example."), example.sign(coveringNSEC3("example.", "*.example."))...)...)
+	s.answer("replayed3.example.", dns.TypeA, dns.RcodeNameError, nil, nextCloserProof("replayed3.example.")...)
+	s.answer("alias.example.", dns.TypeA, dns.RcodeSuccess, append(
+		example.sign(mustRR("alias.example. 300 IN CNAME www.example.")),
+		example.sign(mustRR("www.example. 300 IN A 1.2.3.4"))...))
+	s.answer("other.example.", dns.TypeA, dns.RcodeSuccess, example.sign(mustRR("www.example. 300 IN A 1.2.3.4")))
+
+	// Answers expanded from *.example., which only the first one comes with
+	// the proof that the expanded name does not exist.
+	expand := func(name string) []dns.RR {
+		rrs := example.sign(mustRR("*.example. 300 IN A 1.2.3.5"))
+		for _, rr := range rrs {
+			rr.Header().Name = name
+		}
+		return rrs
+	}
+	s.answer("any.example.", dns.TypeA, dns.RcodeSuccess, expand("any.example."), apexNSEC...)
+	s.answer("unexpanded.example.", dns.TypeA, dns.RcodeSuccess, expand("unexpanded.example."))
+
+	forged := example.sign(mustRR("forged.example. 300 IN A 1.2.3.4"))
+	forged[0].(*dns.A).A = []byte{6, 6, 6, 6}
+	s.answer("forged.example.", dns.TypeA, dns.RcodeSuccess, forged)
+	s.answer("nosig.example.", dns.TypeA, dns.RcodeSuccess, []dns.RR{mustRR("nosig.example. 300 IN A 1.2.3.4")})
+	s.answer("nosig.example.", dns.TypeDS, dns.RcodeNameError, nil, append(soa, apexNSEC...)...)
+
+	s.answer("insecure.", dns.TypeDS, dns.RcodeSuccess, nil, root.sign(mustRR("insecure. 300 IN NSEC zz. NS RRSIG NSEC"))...)
+	s.answer("host.insecure.", dns.TypeA, dns.RcodeSuccess, []dns.RR{mustRR("host.insecure. 300 IN A 5.6.7.8")})
+
+	return s, root
+}
+
+func TestDNSSECValidate(t *testing.T) {
+	s, root := newSignedTestServer(t)
+	v, err := newDNSSECValidator([]string{root.ds()})
+	common.Must(err)
+
+	cases := []struct {
+		name   string
+		qType  uint16
+		status dnssecStatus
+		err    string
+	}{
+		{name: "www.example.", qType: dns.TypeA, status: dnssecSecure},
+		{name: "www.example.", qType: dns.TypeAAAA, status: dnssecSecure},
+		{name: "nx.example.", qType: dns.TypeA, status: dnssecSecure},
+		{name: "alias.example.", qType: dns.TypeA, status: dnssecSecure},
+		{name: "any.example.", qType: dns.TypeA, status: dnssecSecure},
+		{name: "host.insecure.", qType: dns.TypeA, status: dnssecInsecure},
+		{name: "forged.example.", qType: dns.TypeA, status: dnssecBogus, err: "no valid signature"},
+		{name: "nosig.example.", qType: dns.TypeA, status: dnssecBogus, err: "missing signature"},
+		{name: "unproven.example.", qType: dns.TypeA, status: dnssecBogus, err: "missing proof of nonexistence"},
+		{name: "replayed.example.", qType: dns.TypeA, status: dnssecBogus, err: "missing proof of nonexistence"},
+		{name: "nx3.example.", qType: dns.TypeA, status: dnssecSecure},
+		{name: "replayed3.example.", qType: dns.TypeA, status: dnssecBogus, err: "missing proof of nonexistence"},
+		{name: "other.example.", qType: dns.TypeA, status: dnssecBogus, err: "unexpected record"},
+		{name: "unexpanded.example.", qType: dns.TypeA, status: dnssecBogus, err: "does not exist for its wildcard expansion"},
+	}
+	for _, c := range cases {
+		status, err := v.validate(context.Background(), s, s.query(c.name, c.qType))
+		if status != c.status {
+			t.Error(c.name, " ", dns.TypeToString[c.qType], ": expected ", c.status, ", got ", status, " ", err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Error(c.name, " ", dns.TypeToString[c.qType], ": expected error containing ", c.err, ", got ", err)
+		}
+	}
+}
+
+// truncatingServer truncates the responses to DNSKEY queries.
+type truncatingServer struct {
+	*fakeDNSSECServer
+}
+
+func (s truncatingServer) exchange(ctx context.Context, req *dnsRequest) ([]byte, error) {
+	payload, err := s.fakeDNSSECServer.exchange(ctx, req)
+	if err != nil || req.reqType != dnsmessage.Type(dns.TypeDNSKEY) {
+		return payload, err
+	}
+	msg := new(dns.Msg)
+	common.Must(msg.Unpack(payload))
+	msg.Truncated = true
+	msg.Answer = nil
+	return msg.Pack()
+}
+
+// tcpFallbackServer answers in full over TCP what truncatingServer truncates.
+type tcpFallbackServer struct {
+	truncatingServer
+}
+
+func (s tcpFallbackServer) exchangeTCP(ctx context.Context, req *dnsRequest) ([]byte, error) {
+	return s.fakeDNSSECServer.exchange(ctx, req)
+}
+
+func TestDNSSECTruncatedResponse(t *testing.T) {
+	s, root := newSignedTestServer(t)
+
+	v, err := newDNSSECValidator([]string{root.ds()})
+	common.Must(err)
+	status, err := v.validate(context.Background(), tcpFallbackServer{truncatingServer{s}}, s.query("www.example.", dns.TypeA))
+	if status != dnssecSecure {
+		t.Error("expected secure answer after retrying over TCP, got ", status, " ", err)
+	}
+
+	v, err = newDNSSECValidator([]string{root.ds()})
+	common.Must(err)
+	status, err = v.validate(context.Background(), truncatingServer{s}, s.query("www.example.", dns.TypeA))
+	if status != dnssecBogus || err == nil || !strings.Contains(err.Error(), "truncated response") {
+		t.Error("expected bogus answer, got ", status, " ", err)
+	}
+}
+
+func TestDNSSECWrongTrustAnchor(t *testing.T) {
+	s, _ := newSignedTestServer(t)
+	other := newSignedZone(t, ".")
+	v, err := newDNSSECValidator([]string{other.ds()})
+	common.Must(err)
+
+	status, err := v.validate(context.Background(), s, s.query("www.example.", dns.TypeA))
+	if status != dnssecBogus || err == nil || !strings.Contains(err.Error(), "no DNSKEY of . matches its DS records") {
+		t.Error("expected bogus answer, got ", status, " ", err)
+	}
+}
+
+func TestDNSSECDropBogusAnswer(t *testing.T) {
+	s, root := newSignedTestServer(t)
+	v, err := newDNSSECValidator([]string{root.ds()})
+	common.Must(err)
+	opts := &dnssecOptions{validator: v}
+
+	req := buildRecordReqMsg("forged.example.", dnsmessage.TypeA, s.newReqID(), opts.ednsOptions(context.Background(), nil))
+	payload := common.Must2(s.exchange(context.Background(), req)).([]byte)
+	rec := common.Must2(parseResponse(payload)).(*IPRecord)
+	if len(rec.IP) != 1 {
+		t.Fatal("expected forged address in response, got ", rec.IP)
+	}
+	opts.validate(context.Background(), s, req, payload, rec)
+	if rec.RCode != dnsmessage.RCodeServerFailure || len(rec.IP) != 0 {
+		t.Error("expected bogus answer to be dropped, got ", rec.RCode, " ", rec.IP)
+	}
+	if _, _, err := (&cacheOptions{}).getIPs(rec); err == nil || !strings.Contains(err.Error(), "DNSSEC validation failed for forged.example.") {
+		t.Error("expected DNSSEC error, got ", err)
+	}
+
+	var cache recordCache
+	if _, err := cache.queryRecords(context.Background(), s, &cacheOptions{}, opts, "forged.example.", dnsmessage.TypeA, nil, true); err == nil {
+		t.Error("expected bogus records to be dropped")
+	}
+	records, err := cache.queryRecords(context.Background(), s, &cacheOptions{}, opts, "www.example.", dnsmessage.TypeA, nil, true)
+	if err != nil || len(records) != 1 {
+		t.Error("expected one validated record, got ", records, " ", err)
+	}
+}
+
+func TestDNSSECLookupIP(t *testing.T) {
+	s, root := newSignedTestServer(t)
+	port := udp.PickPort()
+
+	dnsServer := dns.Server{
+		Addr:    "127.0.0.1:" + port.String(),
+		Net:     "udp",
+		Handler: s,
+		UDPSize: 1200,
+	}
+
+	go dnsServer.ListenAndServe()
+	time.Sleep(time.Second)
+
+	config := &core.Config{
+		App: []*anypb.Any{
+			serial.ToTypedMessage(&Config{
+				NameServer: []*NameServer{
+					{
+						Address: &net.Endpoint{
+							Network: net.Network_UDP,
+							Address: &net.IPOrDomain{
+								Address: &net.IPOrDomain_Ip{
+									Ip: []byte{127, 0, 0, 1},
+								},
+							},
+							Port: uint32(port),
+						},
+					},
+				},
+				QueryStrategy:     QueryStrategy_USE_IP4,
+				Dnssec:            true,
+				DnssecTrustAnchor: []string{root.ds()},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(&policy.Config{}),
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&freedom.Config{}),
+			},
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	client := v.GetFeature(feature_dns.ClientType()).(feature_dns.Client)
+
+	ips, err := client.LookupIP("www.example")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if r := cmp.Diff(ips, []net.IP{{1, 2, 3, 4}}); r != "" {
+		t.Error(r)
+	}
+
+	ips, err = client.LookupIP("host.insecure")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if r := cmp.Diff(ips, []net.IP{{5, 6, 7, 8}}); r != "" {
+		t.Error(r)
+	}
+
+	_, err = client.LookupIP("forged.example")
+	if err == nil || !strings.Contains(err.Error(), "DNSSEC validation failed for forged.example.") {
+		t.Error("expected DNSSEC validation error, but got ", err)
+	}
+
+	dnsServer.Shutdown()
+}
+
+func TestDNSSECLookupIPRetryOverTCP(t *testing.T) {
+	s, root := newSignedTestServer(t)
+	port := udp.PickPort()
+
+	// DNSKEY responses only fit over TCP.
+	udpServer := dns.Server{
+		Addr: "127.0.0.1:" + port.String(),
+		Net:  "udp",
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			resp := s.reply(r)
+			if r.Question[0].Qtype == dns.TypeDNSKEY {
+				resp.Truncated = true
+				resp.Answer = nil
+			}
+			common.Must(w.WriteMsg(resp))
+		}),
+		UDPSize: 1200,
+	}
+	tcpServer := dns.Server{
+		Addr:    "127.0.0.1:" + port.String(),
+		Net:     "tcp",
+		Handler: s,
+	}
+
+	go udpServer.ListenAndServe()
+	go tcpServer.ListenAndServe()
+	time.Sleep(time.Second)
+	defer udpServer.Shutdown()
+	defer tcpServer.Shutdown()
+
+	config := &core.Config{
+		App: []*anypb.Any{
+			serial.ToTypedMessage(&Config{
+				NameServer: []*NameServer{
+					{
+						Address: &net.Endpoint{
+							Network: net.Network_UDP,
+							Address: net.NewIPOrDomain(net.LocalHostIP),
+							Port:    uint32(port),
+						},
+					},
+				},
+				QueryStrategy:     QueryStrategy_USE_IP4,
+				Dnssec:            true,
+				DnssecTrustAnchor: []string{root.ds()},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(&policy.Config{}),
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&freedom.Config{}),
+			},
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	client := v.GetFeature(feature_dns.ClientType()).(feature_dns.Client)
+
+	ips, err := client.LookupIP("www.example")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if r := cmp.Diff(ips, []net.IP{{1, 2, 3, 4}}); r != "" {
+		t.Error(r)
+	}
+}
@@ -0,0 +1,112 @@
+//go:build !confonly
+// +build !confonly
+
+package dns
+
+import (
+	"context"
+	"strings"
+
+	"github.com/v2fly/v2ray-core/v4/app/router/routercommon"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/strmatcher"
+)
+
+type ecsKey int
+
+const (
+	sourceIPContextKey ecsKey = iota
+	clientSubnetContextKey
+)
+
+const (
+	defaultSubnetIPv4Prefix = 24
+	defaultSubnetIPv6Prefix = 56
+)
+
+func contextWithSourceIP(ctx context.Context, ip net.IP) context.Context {
+	return context.WithValue(ctx, sourceIPContextKey, ip)
+}
+
+func sourceIPFromContext(ctx context.Context) net.IP {
+	ip, _ := ctx.Value(sourceIPContextKey).(net.IP)
+	return ip
+}
+
+func contextWithClientSubnet(ctx context.Context, subnet *net.IPNet) context.Context {
+	return context.WithValue(ctx, clientSubnetContextKey, subnet)
+}
+
+func clientSubnetFromContext(ctx context.Context) *net.IPNet {
+	subnet, _ := ctx.Value(clientSubnetContextKey).(*net.IPNet)
+	return subnet
+}
+
+// queryKey returns the key the answers for domain are cached and published
+// under. Answers for a client subnet other than the static client IP of the
+// name server are kept apart from the others.
+func queryKey(ctx context.Context, domain string) string {
+	if subnet := clientSubnetFromContext(ctx); subnet != nil {
+		return domain + "@" + subnet.String()
+	}
+	return domain
+}
+
+// isKeyOf reports whether key is a query key of domain.
+func isKeyOf(key string, domain string) bool {
+	return key == domain || strings.HasPrefix(key, domain+"@")
+}
+
+// subnetOverride is the client subnet sent for the domains matched by a
+// prioritized domain rule.
+type subnetOverride struct {
+	matcher strmatcher.Matcher
+	subnet  *net.IPNet
+}
+
+func toIPNet(cidr *routercommon.CIDR) (*net.IPNet, error) {
+	ip := net.IP(cidr.Ip)
+	switch len(ip) {
+	case net.IPv4len, net.IPv6len:
+	default:
+		return nil, newError("invalid client subnet IP length ", len(ip))
+	}
+	if cidr.Prefix > uint32(len(ip)*8) {
+		return nil, newError("invalid client subnet prefix ", cidr.Prefix)
+	}
+	mask := net.CIDRMask(int(cidr.Prefix), len(ip)*8)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, nil
+}
+
+// clientSubnet returns the subnet to send as EDNS Client Subnet in queries for
+// domain, or nil if the client IP of the name server is to be used.
+func (c *Client) clientSubnet(ctx context.Context, domain string) *net.IPNet {
+	for _, override := range c.subnetOverrides {
+		if override.matcher.Match(domain) {
+			return override.subnet
+		}
+	}
+	if !c.subnetFromSource {
+		return nil
+	}
+
+	source := sourceIPFromContext(ctx)
+	if len(source) == 0 || !source.IsGlobalUnicast() || source.IsPrivate() {
+		return nil
+	}
+	if ip4 := source.To4(); ip4 != nil {
+		mask := net.CIDRMask(int(c.subnetIPv4Prefix), net.IPv4len*8)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+	mask := net.CIDRMask(int(c.subnetIPv6Prefix), net.IPv6len*8)
+	return &net.IPNet{IP: source.Mask(mask), Mask: mask}
+}
+
+// withClientSubnet returns the context of a query for domain, carrying the
+// client subnet to send if it is not the client IP of the name server.
+func (c *Client) withClientSubnet(ctx context.Context, domain string) context.Context {
+	if subnet := c.clientSubnet(ctx, domain); subnet != nil {
+		return contextWithClientSubnet(ctx, subnet)
+	}
+	return ctx
+}
@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/v2fly/v2ray-core/v4/app/router/routercommon"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+)
+
+func TestClientSubnet(t *testing.T) {
+	matcher, err := toStrMatcher(DomainMatchingType_Subdomain, "v2fly.org")
+	common.Must(err)
+	override, err := toIPNet(&routercommon.CIDR{Ip: []byte{1, 2, 3, 4}, Prefix: 24})
+	common.Must(err)
+	c := &Client{
+		subnetOverrides:  []subnetOverride{{matcher: matcher, subnet: override}},
+		subnetFromSource: true,
+		subnetIPv4Prefix: 16,
+		subnetIPv6Prefix: 48,
+	}
+
+	testCases := []struct {
+		domain string
+		source net.IP
+		subnet string
+	}{
+		{domain: "www.v2fly.org", source: net.ParseIP("8.8.8.8"), subnet: "1.2.3.0/24"},
+		{domain: "example.com", source: net.ParseIP("8.8.8.8"), subnet: "8.8.0.0/16"},
+		{domain: "example.com", source: net.ParseIP("2001:db8:1:2::1"), subnet: "2001:db8:1::/48"},
+		{domain: "example.com", source: net.ParseIP("192.168.1.1")},
+		{domain: "example.com", source: net.ParseIP("127.0.0.1")},
+		{domain: "example.com"},
+	}
+	for _, tc := range testCases {
+		ctx := context.Background()
+		if tc.source != nil {
+			ctx = contextWithSourceIP(ctx, tc.source)
+		}
+		subnet := c.clientSubnet(ctx, tc.domain)
+		switch {
+		case tc.subnet == "" && subnet != nil:
+			t.Error("expected no subnet for ", tc.source, ", got ", subnet)
+		case tc.subnet != "" && (subnet == nil || subnet.String() != tc.subnet):
+			t.Error("expected subnet ", tc.subnet, " for ", tc.domain, " from ", tc.source, ", got ", subnet)
+		}
+	}
+
+	ctx := contextWithClientSubnet(context.Background(), override)
+	if key := queryKey(ctx, "v2fly.org."); key != "v2fly.org.@1.2.3.0/24" || !isKeyOf(key, "v2fly.org.") {
+		t.Error("unexpected query key ", key)
+	}
+	if isKeyOf("www.v2fly.org.@1.2.3.0/24", "v2fly.org.") {
+		t.Error("key of another domain")
+	}
+}
+
+func TestGenClientSubnetOption(t *testing.T) {
+	subnet, err := toIPNet(&routercommon.CIDR{Ip: net.ParseIP("2001:db8:1::"), Prefix: 48})
+	common.Must(err)
+	opt := genClientSubnetOption(subnet).Body.(*dnsmessage.OPTResource).Options[0]
+	if opt.Code != 8 {
+		t.Fatal("unexpected option code ", opt.Code)
+	}
+	expected := []byte{0, 2, 48, 0, 0x20, 0x01, 0x0d, 0xb8, 0x00, 0x01}
+	if string(opt.Data) != string(expected) {
+		t.Error("expected ", expected, ", got ", opt.Data)
+	}
+
+	subnet, err = toIPNet(&routercommon.CIDR{Ip: []byte{1, 2, 3, 4}, Prefix: 0})
+	common.Must(err)
+	opt = genClientSubnetOption(subnet).Body.(*dnsmessage.OPTResource).Options[0]
+	if string(opt.Data) != string([]byte{0, 1, 0, 0}) {
+		t.Error("unexpected option for /0: ", opt.Data)
+	}
+}
@@ -8,20 +8,27 @@ import (
 	"math"
 	"math/big"
 	gonet "net"
+	"sync"
 
 	"github.com/v2fly/v2ray-core/v4/common"
 	"github.com/v2fly/v2ray-core/v4/common/cache"
 	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/task"
 	"github.com/v2fly/v2ray-core/v4/features/dns"
 )
 
 type Holder struct {
+	// access guards nextIP, and the pool against being closed while it is
+	// saved.
+	access     sync.Mutex
 	domainToIP cache.Lru
 	nextIP     *big.Int
 
 	ipRange *gonet.IPNet
 
-	config *FakeDnsPool
+	config      *FakeDnsPool
+	ctx         context.Context
+	persistTask *task.Periodic
 }
 
 func (fkdns *Holder) IsIPInIPPool(ip net.Address) bool {
@@ -45,12 +52,24 @@ func (*Holder) Type() interface{} {
 
 func (fkdns *Holder) Start() error {
 	if fkdns.config != nil && fkdns.config.IpPool != "" && fkdns.config.LruSize != 0 {
-		return fkdns.initializeFromConfig()
+		if err := fkdns.initializeFromConfig(); err != nil {
+			return err
+		}
+		fkdns.restore()
+		return fkdns.startPersisting()
 	}
 	return newError("invalid fakeDNS setting")
 }
 
 func (fkdns *Holder) Close() error {
+	if fkdns.persistTask != nil {
+		common.Must(fkdns.persistTask.Close())
+	}
+	if err := fkdns.persist(); err != nil {
+		newError("failed to persist fake DNS pool").Base(err).AtWarning().WriteToLog()
+	}
+	fkdns.access.Lock()
+	defer fkdns.access.Unlock()
 	fkdns.domainToIP = nil
 	fkdns.nextIP = nil
 	fkdns.ipRange = nil
@@ -72,7 +91,7 @@ func NewFakeDNSHolder() (*Holder, error) {
 }
 
 func NewFakeDNSHolderConfigOnly(conf *FakeDnsPool) (*Holder, error) {
-	return &Holder{nil, nil, nil, conf}, nil
+	return &Holder{config: conf}, nil
 }
 
 func (fkdns *Holder) initializeFromConfig() error {
@@ -107,6 +126,9 @@ func (fkdns *Holder) initialize(ipPoolCidr string, lruSize int) error {
 
 // GetFakeIPForDomain check and generate a fake IP for a domain name
 func (fkdns *Holder) GetFakeIPForDomain(domain string) []net.Address {
+	fkdns.access.Lock()
+	defer fkdns.access.Unlock()
+
 	if v, ok := fkdns.domainToIP.Get(domain); ok {
 		return []net.Address{v.(net.Address)}
 	}
@@ -139,6 +161,26 @@ func (fkdns *Holder) GetDomainFromFakeDNS(ip net.Address) string {
 	return ""
 }
 
+// Mapping is a domain and the fake IP assigned to it.
+type Mapping struct {
+	Domain string
+	IP     net.Address
+}
+
+// ListMappings returns the domains that have a fake IP in this pool, from the
+// least to the most recently used one.
+func (fkdns *Holder) ListMappings() []Mapping {
+	if fkdns.domainToIP == nil {
+		return nil
+	}
+	var mappings []Mapping
+	fkdns.domainToIP.Range(func(key, value interface{}) bool {
+		mappings = append(mappings, Mapping{Domain: key.(string), IP: value.(net.Address)})
+		return true
+	})
+	return mappings
+}
+
 type HolderMulti struct {
 	holders []*Holder
 
@@ -182,6 +224,15 @@ func (h *HolderMulti) GetDomainFromFakeDNS(ip net.Address) string {
 	return ""
 }
 
+// ListMappings returns the domains that have a fake IP in any of the pools.
+func (h *HolderMulti) ListMappings() []Mapping {
+	var mappings []Mapping
+	for _, v := range h.holders {
+		mappings = append(mappings, v.ListMappings()...)
+	}
+	return mappings
+}
+
 func (h *HolderMulti) Type() interface{} {
 	return (*dns.FakeDNSEngine)(nil)
 }
@@ -234,6 +285,7 @@ func init() {
 		if f, err = NewFakeDNSHolderConfigOnly(config.(*FakeDnsPool)); err != nil {
 			return nil, err
 		}
+		f.ctx = ctx
 		return f, nil
 	}))
 
@@ -243,6 +295,9 @@ func init() {
 		if f, err = NewFakeDNSHolderMulti(config.(*FakeDnsPoolMulti)); err != nil {
 			return nil, err
 		}
+		for _, holder := range f.holders {
+			holder.ctx = ctx
+		}
 		return f, nil
 	}))
 }
@@ -6,9 +6,11 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	core "github.com/v2fly/v2ray-core/v4"
 	"github.com/v2fly/v2ray-core/v4/common"
 	"github.com/v2fly/v2ray-core/v4/common/net"
 	"github.com/v2fly/v2ray-core/v4/common/uuid"
+	"github.com/v2fly/v2ray-core/v4/testing/storage"
 )
 
 func TestNewFakeDnsHolder(_ *testing.T) {
@@ -190,3 +192,69 @@ func TestFakeDNSMulti(t *testing.T) {
 		})
 	})
 }
+
+func TestFakeDNSPersist(t *testing.T) {
+	v, err := core.New(&core.Config{})
+	common.Must(err)
+	common.Must(v.AddFeature(storage.NewMemoryStorage()))
+
+	newHolder := func() *Holder {
+		obj, err := core.CreateObject(v, &FakeDnsPool{
+			IpPool:  "198.18.0.0/15",
+			LruSize: 16,
+		})
+		common.Must(err)
+		holder := obj.(*Holder)
+		common.Must(holder.Start())
+		return holder
+	}
+
+	holder := newHolder()
+	ip1 := holder.GetFakeIPForDomain("v2fly.org")
+	ip2 := holder.GetFakeIPForDomain("example.com")
+	common.Must(holder.Close())
+
+	holder = newHolder()
+	assert.Equal(t, "v2fly.org", holder.GetDomainFromFakeDNS(ip1[0]))
+	assert.Equal(t, "example.com", holder.GetDomainFromFakeDNS(ip2[0]))
+	assert.Equal(t, ip1, holder.GetFakeIPForDomain("v2fly.org"))
+
+	ip3 := holder.GetFakeIPForDomain("v2ray.com")
+	assert.NotEqual(t, ip1, ip3)
+	assert.NotEqual(t, ip2, ip3)
+}
+
+func TestFakeDNSPersistPeriodically(t *testing.T) {
+	v, err := core.New(&core.Config{})
+	common.Must(err)
+	common.Must(v.AddFeature(storage.NewMemoryStorage()))
+
+	newHolder := func() *Holder {
+		obj, err := core.CreateObject(v, &FakeDnsPool{
+			IpPool:  "198.18.0.0/15",
+			LruSize: 16,
+		})
+		common.Must(err)
+		holder := obj.(*Holder)
+		common.Must(holder.Start())
+		return holder
+	}
+
+	holder := newHolder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		holder.GetFakeIPForDomain("example.com")
+	}()
+	ip := holder.GetFakeIPForDomain("v2fly.org")
+	common.Must(holder.persistTask.Execute())
+	<-done
+	common.Must(holder.persistTask.Execute())
+
+	// The pool is restored without being closed, as after a crash.
+	restored := newHolder()
+	assert.Equal(t, "v2fly.org", restored.GetDomainFromFakeDNS(ip[0]))
+	assert.Equal(t, ip, restored.GetFakeIPForDomain("v2fly.org"))
+	common.Must(restored.Close())
+	common.Must(holder.Close())
+}
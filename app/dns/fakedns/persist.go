@@ -0,0 +1,131 @@
+//go:build !confonly
+// +build !confonly
+
+package fakedns
+
+import (
+	"math/big"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/v2fly/v2ray-core/v4/app/persistentstorage"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/task"
+)
+
+// persistInterval is how often the pool is saved, so that its mapping
+// survives a crash too.
+const persistInterval = time.Minute
+
+const storageKeyPrefix = "fakedns/"
+
+func (fkdns *Holder) storageKey() []byte {
+	return []byte(storageKeyPrefix + fkdns.ipRange.String())
+}
+
+// restore loads the domain to IP mapping saved by persist, so that clients
+// keep their fake IPs across restarts.
+func (fkdns *Holder) restore() {
+	storage := persistentstorage.FromContext(fkdns.ctx)
+	if storage == nil {
+		return
+	}
+
+	data, err := storage.Get(fkdns.ctx, fkdns.storageKey())
+	if persistentstorage.IsNotFound(data, err) {
+		newError("no saved state for fake DNS pool ", fkdns.ipRange).AtDebug().WriteToLog()
+		return
+	}
+	if err != nil {
+		newError("failed to read saved state for fake DNS pool ", fkdns.ipRange).Base(err).AtWarning().WriteToLog()
+		return
+	}
+	state := new(FakeDnsPoolState)
+	if err := proto.Unmarshal(data, state); err != nil {
+		newError("failed to parse saved state for fake DNS pool ", fkdns.ipRange).Base(err).AtWarning().WriteToLog()
+		return
+	}
+	if state.IpPool != fkdns.ipRange.String() {
+		return
+	}
+
+	restored := 0
+	for _, entry := range state.Entries {
+		if entry.Domain == "" || (len(entry.Ip) != net.IPv4len && len(entry.Ip) != net.IPv6len) {
+			continue
+		}
+		ip := net.IPAddress(entry.Ip)
+		if !fkdns.ipRange.Contains(ip.IP()) {
+			continue
+		}
+		fkdns.domainToIP.Put(entry.Domain, ip)
+		restored++
+	}
+	if nextIP := big.NewInt(0).SetBytes(state.NextIp); fkdns.ipRange.Contains(nextIP.Bytes()) {
+		fkdns.nextIP = nextIP
+	}
+	newError("restored ", restored, " entries for fake DNS pool ", fkdns.ipRange).AtInfo().WriteToLog()
+}
+
+// startPersisting saves the pool periodically, if a persistent storage
+// engine is available.
+func (fkdns *Holder) startPersisting() error {
+	if persistentstorage.FromContext(fkdns.ctx) == nil {
+		return nil
+	}
+	fkdns.persistTask = &task.Periodic{
+		Interval: persistInterval,
+		Execute: func() error {
+			if err := fkdns.persist(); err != nil {
+				newError("failed to persist fake DNS pool").Base(err).AtWarning().WriteToLog()
+			}
+			return nil
+		},
+	}
+	return fkdns.persistTask.Start()
+}
+
+// snapshot returns the state of this pool, or nil if it is closed.
+func (fkdns *Holder) snapshot() *FakeDnsPoolState {
+	fkdns.access.Lock()
+	defer fkdns.access.Unlock()
+
+	if fkdns.domainToIP == nil {
+		return nil
+	}
+	state := &FakeDnsPoolState{
+		IpPool: fkdns.ipRange.String(),
+		NextIp: fkdns.nextIP.Bytes(),
+	}
+	fkdns.domainToIP.Range(func(key, value interface{}) bool {
+		state.Entries = append(state.Entries, &FakeDnsPoolState_Entry{
+			Domain: key.(string),
+			Ip:     value.(net.Address).IP(),
+		})
+		return true
+	})
+	return state
+}
+
+// persist saves the domain to IP mapping of this pool to the persistent
+// storage engine, if one is available.
+func (fkdns *Holder) persist() error {
+	storage := persistentstorage.FromContext(fkdns.ctx)
+	if storage == nil {
+		return nil
+	}
+	state := fkdns.snapshot()
+	if state == nil {
+		return nil
+	}
+
+	data, err := proto.Marshal(state)
+	if err != nil {
+		return newError("failed to encode state for fake DNS pool ", state.IpPool).Base(err)
+	}
+	if err := storage.Put(fkdns.ctx, []byte(storageKeyPrefix+state.IpPool), data); err != nil {
+		return newError("failed to save state for fake DNS pool ", state.IpPool).Base(err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+//go:build !confonly
+// +build !confonly
+
+package dns
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/v2fly/v2ray-core/v4/common/errors"
+	"github.com/v2fly/v2ray-core/v4/common/log"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	dns_feature "github.com/v2fly/v2ray-core/v4/features/dns"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
+)
+
+type queryInfoKey int
+
+const queryInfoContextKey queryInfoKey = iota
+
+// queryInfo collects what only the name server knows about a query.
+type queryInfo struct {
+	cached bool
+}
+
+func contextWithQueryInfo(ctx context.Context, info *queryInfo) context.Context {
+	return context.WithValue(ctx, queryInfoContextKey, info)
+}
+
+// markCacheHit records that the query in ctx was answered from the cache.
+func markCacheHit(ctx context.Context) {
+	if info, ok := ctx.Value(queryInfoContextKey).(*queryInfo); ok {
+		info.cached = true
+	}
+}
+
+// serverMetrics keeps the counters of a name server in the stats manager, and
+// the latencies of queries that were not answered from the cache in a
+// histogram, in milliseconds. The moving average latency used to compare
+// name servers is kept in a gauge.
+type serverMetrics struct {
+	queries    stats.Counter
+	failures   stats.Counter
+	timeouts   stats.Counter
+	cacheHits  stats.Counter
+	latency    stats.Histogram
+	latencyAvg stats.Gauge
+}
+
+// newServerMetrics registers the counters of the named server. It returns nil
+// if the stats manager does not support counters.
+func newServerMetrics(m stats.Manager, server string) *serverMetrics {
+	var err error
+	counter := func(name string) stats.Counter {
+		if err != nil {
+			return nil
+		}
+		var c stats.Counter
+		c, err = stats.GetOrRegisterCounter(m, "dns>>>"+server+">>>"+name)
+		return c
+	}
+	metrics := &serverMetrics{
+		queries:   counter("queries"),
+		failures:  counter("failures"),
+		timeouts:  counter("timeouts"),
+		cacheHits: counter("cache_hits"),
+	}
+	if err != nil {
+		return nil
+	}
+	// Histograms and gauges are optional, so that the counters work with any manager.
+	metrics.latency, _ = stats.GetOrRegisterHistogram(m, "dns>>>"+server+">>>latency", stats.LatencyBuckets)
+	metrics.latencyAvg, _ = stats.GetOrRegisterGauge(m, "dns>>>"+server+">>>latency_avg_ms")
+	return metrics
+}
+
+func (m *serverMetrics) record(err error, cached bool, latency time.Duration) {
+	m.queries.Add(1)
+	switch {
+	case cached:
+		m.cacheHits.Add(1)
+		return
+	case errors.Cause(err) == context.DeadlineExceeded:
+		m.timeouts.Add(1)
+		return
+	case errors.Cause(err) == context.Canceled:
+		return
+	case isServerFailure(err):
+		m.failures.Add(1)
+	}
+
+	if m.latency != nil {
+		stats.ObserveDuration(m.latency, latency, time.Millisecond)
+	}
+}
+
+// isServerFailure reports whether err means that the name server failed to
+// answer, as opposed to answering that there are no records.
+func isServerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch errors.Cause(err) {
+	case dns_feature.ErrEmptyResponse, errRecordTypeNotSupported:
+		return false
+	}
+	return dns_feature.RCodeFromError(err) != uint16(dnsmessage.RCodeNameError)
+}
+
+func ipQueryType(option dns_feature.IPOption) string {
+	switch {
+	case option.IPv4Enable && option.IPv6Enable:
+		return "A/AAAA"
+	case option.IPv6Enable:
+		return "AAAA"
+	default:
+		return "A"
+	}
+}
+
+// recordQuery updates the metrics of the name server and writes the query to
+// the DNS log.
+func (c *Client) recordQuery(domain string, qType string, answer interface{}, err error, info *queryInfo, latency time.Duration) {
+	if !info.cached {
+		c.latency.observe(err, latency)
+	}
+	if c.metrics != nil {
+		c.metrics.record(err, info.cached, latency)
+		if c.metrics.latencyAvg != nil {
+			c.metrics.latencyAvg.Set(int64(c.Latency() / time.Millisecond))
+		}
+	}
+	log.Record(&log.DNSMessage{
+		Server:  c.Name(),
+		Domain:  domain,
+		QType:   qType,
+		Answer:  answer,
+		Latency: latency,
+		Cached:  info.cached,
+		Error:   err,
+	})
+}
+
+// recordAnswers formats the answers of a query in the DNS log.
+type recordAnswers []dnsmessage.Resource
+
+func (a recordAnswers) String() string {
+	values := make([]string, 0, len(a))
+	for _, answer := range a {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			values = append(values, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			values = append(values, net.IP(body.AAAA[:]).String())
+		case *dnsmessage.CNAMEResource:
+			values = append(values, body.CNAME.String())
+		case *dnsmessage.TXTResource:
+			values = append(values, strings.Join(body.TXT, ""))
+		default:
+			values = append(values, answer.Header.Type.String())
+		}
+	}
+	return "[" + strings.Join(values, " ") + "]"
+}
@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/v2fly/v2ray-core/v4/app/stats"
+	"github.com/v2fly/v2ray-core/v4/common"
+	dns_feature "github.com/v2fly/v2ray-core/v4/features/dns"
+	feature_stats "github.com/v2fly/v2ray-core/v4/features/stats"
+)
+
+func TestServerMetrics(t *testing.T) {
+	if m := newServerMetrics(feature_stats.NoopManager{}, "UDP:8.8.8.8:53"); m != nil {
+		t.Error("expected no metrics without stats manager")
+	}
+
+	manager, err := stats.NewManager(context.Background(), &stats.Config{})
+	common.Must(err)
+	m := newServerMetrics(manager, "UDP:8.8.8.8:53")
+
+	m.record(nil, false, 10*time.Millisecond)
+	m.record(nil, false, 30*time.Millisecond)
+	m.record(nil, true, 0)
+	m.record(dns_feature.ErrEmptyResponse, false, 20*time.Millisecond)
+	m.record(dns_feature.RCodeError(dnsmessage.RCodeNameError), false, 20*time.Millisecond)
+	m.record(dns_feature.RCodeError(dnsmessage.RCodeServerFailure), false, 40*time.Millisecond)
+	m.record(newError("failed to send query").Base(context.DeadlineExceeded), false, 4*time.Second)
+	m.record(context.Canceled, false, time.Millisecond)
+
+	expected := map[string]int64{
+		"queries":    8,
+		"failures":   1,
+		"timeouts":   1,
+		"cache_hits": 1,
+	}
+	for name, value := range expected {
+		counter := manager.GetCounter("dns>>>UDP:8.8.8.8:53>>>" + name)
+		if counter == nil {
+			t.Error("counter ", name, " not registered")
+			continue
+		}
+		if counter.Value() != value {
+			t.Error("expected ", name, " to be ", value, ", got ", counter.Value())
+		}
+	}
+
+	histogram := manager.GetHistogram("dns>>>UDP:8.8.8.8:53>>>latency")
+	if histogram == nil {
+		t.Fatal("latency histogram not registered")
+	}
+	if snapshot := histogram.Snapshot(); snapshot.Count != 5 || snapshot.Sum != 120 {
+		t.Error("expected 5 latencies of 120ms in total, got ", snapshot.Count, " of ", snapshot.Sum)
+	}
+}
+
+func TestClientLatency(t *testing.T) {
+	manager, err := stats.NewManager(context.Background(), &stats.Config{})
+	common.Must(err)
+	c := &Client{server: NewLocalNameServer()}
+	c.metrics = newServerMetrics(manager, c.Name())
+
+	c.recordQuery("v2fly.org.", "A", nil, nil, &queryInfo{}, 100*time.Millisecond)
+	c.recordQuery("v2fly.org.", "A", nil, nil, &queryInfo{cached: true}, 0)
+	c.recordQuery("v2fly.org.", "A", nil, context.Canceled, &queryInfo{}, time.Millisecond)
+	if latency := c.Latency(); latency != 100*time.Millisecond {
+		t.Error("expected latency of 100ms, got ", latency)
+	}
+
+	// A server that keeps failing must not look fast.
+	for i := 0; i < 16; i++ {
+		c.recordQuery("v2fly.org.", "A", nil, dns_feature.RCodeError(dnsmessage.RCodeServerFailure), &queryInfo{}, time.Millisecond)
+	}
+	if latency := c.Latency(); latency < time.Second*3 {
+		t.Error("expected latency of failing server to approach ", failedQueryLatency, ", got ", latency)
+	}
+
+	gauge := manager.GetGauge("dns>>>" + c.Name() + ">>>latency_avg_ms")
+	if gauge == nil {
+		t.Fatal("latency gauge not registered")
+	}
+	if v := gauge.Value(); v != int64(c.Latency()/time.Millisecond) {
+		t.Error("expected latency gauge to be ", c.Latency(), ", got ", v, "ms")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"strings"
 	"time"
 
+	"golang.org/x/net/dns/dnsmessage"
+
 	core "github.com/v2fly/v2ray-core/v4"
 	"github.com/v2fly/v2ray-core/v4/app/router"
 	"github.com/v2fly/v2ray-core/v4/common/errors"
@@ -28,8 +30,16 @@ type Client struct {
 	server       Server
 	clientIP     net.IP
 	skipFallback bool
+	raceGroup    string
 	domains      []string
 	expectIPs    []*router.GeoIPMatcher
+	metrics      *serverMetrics
+	latency      latencyTracker
+
+	subnetOverrides  []subnetOverride
+	subnetFromSource bool
+	subnetIPv4Prefix uint32
+	subnetIPv6Prefix uint32
 }
 
 var errExpectedIPNonMatch = errors.New("expectIPs not match")
@@ -54,6 +64,10 @@ func NewServer(dest net.Destination, dispatcher routing.Dispatcher) (Server, err
 			return NewTCPNameServer(u, dispatcher)
 		case strings.EqualFold(u.Scheme, "tcp+local"): // DNS-over-TCP Local mode
 			return NewTCPLocalNameServer(u)
+		case strings.EqualFold(u.Scheme, "tls"): // DNS-over-TLS Remote mode
+			return NewTLSNameServer(u, dispatcher)
+		case strings.EqualFold(u.Scheme, "tls+local"): // DNS-over-TLS Local mode
+			return NewTLSLocalNameServer(u)
 		case strings.EqualFold(u.String(), "fakedns"):
 			return NewFakeDNSServer(), nil
 		}
@@ -99,6 +113,7 @@ func NewClient(ctx context.Context, ns *NameServer, clientIP net.IP, container r
 
 		// Establish domain rules
 		var rules []string
+		var subnetOverrides []subnetOverride
 		ruleCurr := 0
 		ruleIter := 0
 		for _, domain := range ns.PrioritizedDomain {
@@ -125,6 +140,24 @@ func NewClient(ctx context.Context, ns *NameServer, clientIP net.IP, container r
 			if err != nil {
 				return newError("failed to create prioritized domain").Base(err).AtWarning()
 			}
+			if domain.ClientSubnet != nil {
+				subnet, err := toIPNet(domain.ClientSubnet)
+				if err != nil {
+					return newError("invalid client subnet for domain ", domain.Domain).Base(err).AtWarning()
+				}
+				subnetOverrides = append(subnetOverrides, subnetOverride{matcher: domainRule, subnet: subnet})
+			}
+		}
+
+		subnetIPv4Prefix, subnetIPv6Prefix := ns.ClientSubnetIpv4Prefix, ns.ClientSubnetIpv6Prefix
+		if subnetIPv4Prefix == 0 {
+			subnetIPv4Prefix = defaultSubnetIPv4Prefix
+		}
+		if subnetIPv6Prefix == 0 {
+			subnetIPv6Prefix = defaultSubnetIPv6Prefix
+		}
+		if subnetIPv4Prefix > net.IPv4len*8 || subnetIPv6Prefix > net.IPv6len*8 {
+			return newError("invalid client subnet prefix ", subnetIPv4Prefix, " ", subnetIPv6Prefix).AtWarning()
 		}
 
 		// Establish expected IPs
@@ -149,8 +182,13 @@ func NewClient(ctx context.Context, ns *NameServer, clientIP net.IP, container r
 		client.server = server
 		client.clientIP = clientIP
 		client.skipFallback = ns.SkipFallback
+		client.raceGroup = ns.RaceGroup
 		client.domains = rules
 		client.expectIPs = matchers
+		client.subnetOverrides = subnetOverrides
+		client.subnetFromSource = ns.ClientSubnetFromSource
+		client.subnetIPv4Prefix = subnetIPv4Prefix
+		client.subnetIPv6Prefix = subnetIPv6Prefix
 		return nil
 	})
 	return client, err
@@ -186,10 +224,20 @@ func (c *Client) Name() string {
 	return c.server.Name()
 }
 
+// Latency returns the average response time of the name server, or zero if
+// it is unknown.
+func (c *Client) Latency() time.Duration {
+	return c.latency.Latency()
+}
+
 // QueryIP send DNS query to the name server with the client's IP.
 func (c *Client) QueryIP(ctx context.Context, domain string, option dns.IPOption, disableCache bool) ([]net.IP, error) {
 	ctx, cancel := context.WithTimeout(ctx, 4*time.Second)
-	ips, err := c.server.QueryIP(ctx, domain, c.clientIP, option, disableCache)
+	ctx = c.withClientSubnet(ctx, domain)
+	info := &queryInfo{}
+	start := time.Now()
+	ips, err := c.server.QueryIP(contextWithQueryInfo(ctx, info), domain, c.clientIP, option, disableCache)
+	c.recordQuery(domain, ipQueryType(option), ips, err, info, time.Since(start))
 	cancel()
 
 	if err != nil {
@@ -198,6 +246,26 @@ func (c *Client) QueryIP(ctx context.Context, domain string, option dns.IPOption
 	return c.MatchExpectedIPs(domain, ips)
 }
 
+// QueryRecords sends a DNS query for records of qType to the name server with
+// the client's IP.
+func (c *Client) QueryRecords(ctx context.Context, domain string, qType dnsmessage.Type, disableCache bool) ([]dnsmessage.Resource, error) {
+	querier, ok := c.server.(recordQuerier)
+	if !ok {
+		return nil, errRecordTypeNotSupported
+	}
+	ctx, cancel := context.WithTimeout(ctx, 4*time.Second)
+	defer cancel()
+	ctx = c.withClientSubnet(ctx, domain)
+	info := &queryInfo{}
+	start := time.Now()
+	records, err := querier.QueryRecords(contextWithQueryInfo(ctx, info), domain, qType, c.clientIP, disableCache)
+	// The log is written asynchronously, so it gets a copy of the records, whose
+	// headers are updated when the records are packed into a response.
+	answers := append(recordAnswers(nil), records...)
+	c.recordQuery(domain, strings.TrimPrefix(qType.String(), "Type"), answers, err, info, time.Since(start))
+	return records, err
+}
+
 // MatchExpectedIPs matches queried domain IPs with expected IPs and returns matched ones.
 func (c *Client) MatchExpectedIPs(domain string, ips []net.IP) ([]net.IP, error) {
 	if len(c.expectIPs) == 0 {
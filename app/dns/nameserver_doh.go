@@ -16,6 +16,7 @@ import (
 
 	"golang.org/x/net/dns/dnsmessage"
 
+	core "github.com/v2fly/v2ray-core/v4"
 	"github.com/v2fly/v2ray-core/v4/common"
 	"github.com/v2fly/v2ray-core/v4/common/net"
 	"github.com/v2fly/v2ray-core/v4/common/protocol/dns"
@@ -32,6 +33,9 @@ import (
 // thus most of the DOH implementation is copied from udpns.go
 type DoHNameServer struct {
 	sync.RWMutex
+	cacheOptions
+	recordCache
+	dnssecOptions
 	ips        map[string]record
 	pub        *pubsub.Service
 	cleanup    *task.Periodic
@@ -140,10 +144,10 @@ func (s *DoHNameServer) Cleanup() error {
 	}
 
 	for domain, record := range s.ips {
-		if record.A != nil && record.A.Expire.Before(now) {
+		if s.expired(record.A, now) {
 			record.A = nil
 		}
-		if record.AAAA != nil && record.AAAA.Expire.Before(now) {
+		if s.expired(record.AAAA, now) {
 			record.AAAA = nil
 		}
 
@@ -162,7 +166,26 @@ func (s *DoHNameServer) Cleanup() error {
 	return nil
 }
 
+func (s *DoHNameServer) exportCache() []*CacheState_Record {
+	return s.exportRecords(&s.RWMutex, s.ips)
+}
+
+func (s *DoHNameServer) importCache(records []*CacheState_Record) {
+	if s.importRecords(&s.RWMutex, s.ips, records) > 0 {
+		common.Must(s.cleanup.Start())
+	}
+}
+
+func (s *DoHNameServer) listCache() []CacheEntry {
+	return append(listRecords(&s.RWMutex, s.ips), s.listRecordCache()...)
+}
+
+func (s *DoHNameServer) flushCache(domain string) int {
+	return flushRecords(&s.RWMutex, s.ips, domain) + s.flushRecordCache(domain)
+}
+
 func (s *DoHNameServer) updateIP(req *dnsRequest, ipRec *IPRecord) {
+	s.clampTTL(ipRec)
 	elapsed := time.Since(req.start)
 
 	s.Lock()
@@ -171,7 +194,7 @@ func (s *DoHNameServer) updateIP(req *dnsRequest, ipRec *IPRecord) {
 
 	switch req.reqType {
 	case dnsmessage.TypeA:
-		if isNewer(rec.A, ipRec) {
+		if s.isNewer(rec.A, ipRec) {
 			rec.A = ipRec
 			updated = true
 		}
@@ -183,7 +206,7 @@ func (s *DoHNameServer) updateIP(req *dnsRequest, ipRec *IPRecord) {
 			}
 		}
 		ipRec.IP = addr
-		if isNewer(rec.AAAA, ipRec) {
+		if s.isNewer(rec.AAAA, ipRec) {
 			rec.AAAA = ipRec
 			updated = true
 		}
@@ -210,44 +233,13 @@ func (s *DoHNameServer) newReqID() uint16 {
 func (s *DoHNameServer) sendQuery(ctx context.Context, domain string, clientIP net.IP, option dns_feature.IPOption) {
 	newError(s.name, " querying: ", domain).AtInfo().WriteToLog(session.ExportIDToError(ctx))
 
-	reqs := buildReqMsgs(domain, option, s.newReqID, genEDNS0Options(clientIP))
-
-	var deadline time.Time
-	if d, ok := ctx.Deadline(); ok {
-		deadline = d
-	} else {
-		deadline = time.Now().Add(time.Second * 5)
-	}
+	reqs := buildReqMsgs(domain, option, s.newReqID, s.ednsOptions(ctx, clientIP))
+	key := queryKey(ctx, domain)
 
 	for _, req := range reqs {
+		req.domain = key
 		go func(r *dnsRequest) {
-			// generate new context for each req, using same context
-			// may cause reqs all aborted if any one encounter an error
-			dnsCtx := ctx
-
-			// reserve internal dns server requested Inbound
-			if inbound := session.InboundFromContext(ctx); inbound != nil {
-				dnsCtx = session.ContextWithInbound(dnsCtx, inbound)
-			}
-
-			dnsCtx = session.ContextWithContent(dnsCtx, &session.Content{
-				Protocol:       "https",
-				SkipDNSResolve: true,
-			})
-
-			// forced to use mux for DOH
-			dnsCtx = session.ContextWithMuxPrefered(dnsCtx, true)
-
-			var cancel context.CancelFunc
-			dnsCtx, cancel = context.WithDeadline(dnsCtx, deadline)
-			defer cancel()
-
-			b, err := dns.PackMessage(r.msg)
-			if err != nil {
-				newError("failed to pack dns query").Base(err).AtError().WriteToLog()
-				return
-			}
-			resp, err := s.dohHTTPSContext(dnsCtx, b.Bytes())
+			resp, err := s.exchange(ctx, r)
 			if err != nil {
 				newError("failed to retrieve response").Base(err).AtError().WriteToLog()
 				return
@@ -257,11 +249,50 @@ func (s *DoHNameServer) sendQuery(ctx context.Context, domain string, clientIP n
 				newError("failed to handle DOH response").Base(err).AtError().WriteToLog()
 				return
 			}
+			s.validate(ctx, s, r, resp, rec)
 			s.updateIP(r, rec)
 		}(req)
 	}
 }
 
+// exchange posts the request to the server and returns the raw response.
+func (s *DoHNameServer) exchange(ctx context.Context, req *dnsRequest) ([]byte, error) {
+	// generate new context for each req, using same context
+	// may cause reqs all aborted if any one encounter an error
+	dnsCtx := ctx
+
+	// reserve internal dns server requested Inbound
+	if inbound := session.InboundFromContext(ctx); inbound != nil {
+		dnsCtx = session.ContextWithInbound(dnsCtx, inbound)
+	}
+
+	dnsCtx = session.ContextWithContent(dnsCtx, &session.Content{
+		Protocol:       "https",
+		SkipDNSResolve: true,
+	})
+
+	// forced to use mux for DOH
+	dnsCtx = session.ContextWithMuxPrefered(dnsCtx, true)
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		dnsCtx, cancel = context.WithTimeout(dnsCtx, time.Second*5)
+		defer cancel()
+	}
+
+	b, err := dns.PackMessage(req.msg)
+	if err != nil {
+		return nil, newError("failed to pack dns query").Base(err)
+	}
+	defer b.Release()
+	return s.dohHTTPSContext(dnsCtx, b.Bytes())
+}
+
+// QueryRecords implements recordQuerier.
+func (s *DoHNameServer) QueryRecords(ctx context.Context, domain string, qType dnsmessage.Type, clientIP net.IP, disableCache bool) ([]dnsmessage.Resource, error) {
+	return s.queryRecords(ctx, s, &s.cacheOptions, &s.dnssecOptions, Fqdn(domain), qType, clientIP, disableCache)
+}
+
 func (s *DoHNameServer) dohHTTPSContext(ctx context.Context, b []byte) ([]byte, error) {
 	body := bytes.NewBuffer(b)
 	req, err := http.NewRequest("POST", s.dohURL, body)
@@ -286,19 +317,21 @@ func (s *DoHNameServer) dohHTTPSContext(ctx context.Context, b []byte) ([]byte,
 	return io.ReadAll(resp.Body)
 }
 
-func (s *DoHNameServer) findIPsForDomain(domain string, option dns_feature.IPOption) ([]net.IP, error) {
+func (s *DoHNameServer) findIPsForDomain(domain string, option dns_feature.IPOption) ([]net.IP, bool, error) {
 	s.RLock()
 	record, found := s.ips[domain]
 	s.RUnlock()
 
 	if !found {
-		return nil, errRecordNotFound
+		return nil, false, errRecordNotFound
 	}
 
 	var ips []net.Address
 	var lastErr error
+	var refresh bool
 	if option.IPv6Enable && record.AAAA != nil && record.AAAA.RCode == dnsmessage.RCodeSuccess {
-		aaaa, err := record.AAAA.getIPs()
+		aaaa, r, err := s.getIPs(record.AAAA)
+		refresh = refresh || r
 		if err != nil {
 			lastErr = err
 		}
@@ -306,7 +339,8 @@ func (s *DoHNameServer) findIPsForDomain(domain string, option dns_feature.IPOpt
 	}
 
 	if option.IPv4Enable && record.A != nil && record.A.RCode == dnsmessage.RCodeSuccess {
-		a, err := record.A.getIPs()
+		a, r, err := s.getIPs(record.A)
+		refresh = refresh || r
 		if err != nil {
 			lastErr = err
 		}
@@ -314,29 +348,35 @@ func (s *DoHNameServer) findIPsForDomain(domain string, option dns_feature.IPOpt
 	}
 
 	if len(ips) > 0 {
-		return toNetIP(ips)
+		netIPs, err := toNetIP(ips)
+		return netIPs, refresh, err
 	}
 
 	if lastErr != nil {
-		return nil, lastErr
+		return nil, false, lastErr
 	}
 
 	if (option.IPv4Enable && record.A != nil) || (option.IPv6Enable && record.AAAA != nil) {
-		return nil, dns_feature.ErrEmptyResponse
+		return nil, false, dns_feature.ErrEmptyResponse
 	}
 
-	return nil, errRecordNotFound
+	return nil, false, errRecordNotFound
 }
 
 // QueryIP implements Server.
 func (s *DoHNameServer) QueryIP(ctx context.Context, domain string, clientIP net.IP, option dns_feature.IPOption, disableCache bool) ([]net.IP, error) { // nolint: dupl
 	fqdn := Fqdn(domain)
+	key := queryKey(ctx, fqdn)
 
 	if disableCache {
 		newError("DNS cache is disabled. Querying IP for ", domain, " at ", s.name).AtDebug().WriteToLog()
 	} else {
-		ips, err := s.findIPsForDomain(fqdn, option)
+		ips, refresh, err := s.findIPsForDomain(key, option)
 		if err != errRecordNotFound {
+			if refresh {
+				go s.sendQuery(core.ToBackgroundDetachedContext(ctx), fqdn, clientIP, option)
+			}
+			markCacheHit(ctx)
 			newError(s.name, " cache HIT ", domain, " -> ", ips).Base(err).AtDebug().WriteToLog()
 			return ips, err
 		}
@@ -345,11 +385,11 @@ func (s *DoHNameServer) QueryIP(ctx context.Context, domain string, clientIP net
 	// ipv4 and ipv6 belong to different subscription groups
 	var sub4, sub6 *pubsub.Subscriber
 	if option.IPv4Enable {
-		sub4 = s.pub.Subscribe(fqdn + "4")
+		sub4 = s.pub.Subscribe(key + "4")
 		defer sub4.Close()
 	}
 	if option.IPv6Enable {
-		sub6 = s.pub.Subscribe(fqdn + "6")
+		sub6 = s.pub.Subscribe(key + "6")
 		defer sub6.Close()
 	}
 	done := make(chan interface{})
@@ -371,7 +411,7 @@ func (s *DoHNameServer) QueryIP(ctx context.Context, domain string, clientIP net
 	s.sendQuery(ctx, fqdn, clientIP, option)
 
 	for {
-		ips, err := s.findIPsForDomain(fqdn, option)
+		ips, _, err := s.findIPsForDomain(key, option)
 		if err != errRecordNotFound {
 			return ips, err
 		}
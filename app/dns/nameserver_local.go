@@ -5,6 +5,9 @@ package dns
 
 import (
 	"context"
+	gonet "net"
+
+	"golang.org/x/net/dns/dnsmessage"
 
 	"github.com/v2fly/v2ray-core/v4/common/net"
 	"github.com/v2fly/v2ray-core/v4/features/dns"
@@ -37,6 +40,89 @@ func (s *LocalNameServer) QueryIP(_ context.Context, domain string, _ net.IP, op
 	return ips, err
 }
 
+// QueryRecords implements recordQuerier. Only the record types supported by the
+// system resolver can be looked up, and their TTL is unknown.
+func (s *LocalNameServer) QueryRecords(ctx context.Context, domain string, qType dnsmessage.Type, _ net.IP, _ bool) ([]dnsmessage.Resource, error) {
+	name, err := dnsmessage.NewName(Fqdn(domain))
+	if err != nil {
+		return nil, newError("invalid domain name ", domain).Base(err)
+	}
+
+	var bodies []dnsmessage.ResourceBody
+	resolver := gonet.DefaultResolver
+	switch qType {
+	case dnsmessage.TypeTXT:
+		var txts []string
+		txts, err = resolver.LookupTXT(ctx, domain)
+		for _, txt := range txts {
+			bodies = append(bodies, &dnsmessage.TXTResource{TXT: splitTXT(txt)})
+		}
+	case dnsmessage.TypeMX:
+		var mxs []*gonet.MX
+		mxs, err = resolver.LookupMX(ctx, domain)
+		for _, mx := range mxs {
+			if host, err := dnsmessage.NewName(Fqdn(mx.Host)); err == nil {
+				bodies = append(bodies, &dnsmessage.MXResource{Pref: mx.Pref, MX: host})
+			}
+		}
+	case dnsmessage.TypeNS:
+		var nss []*gonet.NS
+		nss, err = resolver.LookupNS(ctx, domain)
+		for _, ns := range nss {
+			if host, err := dnsmessage.NewName(Fqdn(ns.Host)); err == nil {
+				bodies = append(bodies, &dnsmessage.NSResource{NS: host})
+			}
+		}
+	case dnsmessage.TypeSRV:
+		var srvs []*gonet.SRV
+		_, srvs, err = resolver.LookupSRV(ctx, "", "", domain)
+		for _, srv := range srvs {
+			if target, err := dnsmessage.NewName(Fqdn(srv.Target)); err == nil {
+				bodies = append(bodies, &dnsmessage.SRVResource{Priority: srv.Priority, Weight: srv.Weight, Port: srv.Port, Target: target})
+			}
+		}
+	case dnsmessage.TypeCNAME:
+		var cname string
+		cname, err = resolver.LookupCNAME(ctx, domain)
+		if target, err := dnsmessage.NewName(Fqdn(cname)); err == nil && cname != Fqdn(domain) {
+			bodies = append(bodies, &dnsmessage.CNAMEResource{CNAME: target})
+		}
+	default:
+		return nil, errRecordTypeNotSupported
+	}
+
+	if dnsErr, ok := err.(*gonet.DNSError); ok && dnsErr.IsNotFound {
+		return nil, dns.ErrEmptyResponse
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(bodies) == 0 {
+		return nil, dns.ErrEmptyResponse
+	}
+
+	records := make([]dnsmessage.Resource, 0, len(bodies))
+	for _, body := range bodies {
+		records = append(records, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: qType, Class: dnsmessage.ClassINET, TTL: 600},
+			Body:   body,
+		})
+	}
+	newError("Localhost got answer: ", domain, " ", qType, " -> ", len(records), " records").AtInfo().WriteToLog()
+	return records, nil
+}
+
+// splitTXT splits a TXT record joined by the system resolver into character
+// strings of at most 255 bytes.
+func splitTXT(txt string) []string {
+	var parts []string
+	for len(txt) > 255 {
+		parts = append(parts, txt[:255])
+		txt = txt[255:]
+	}
+	return append(parts, txt)
+}
+
 // Name implements Server.
 func (s *LocalNameServer) Name() string {
 	return "localhost"
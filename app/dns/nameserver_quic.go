@@ -11,6 +11,7 @@ import (
 	"golang.org/x/net/dns/dnsmessage"
 	"golang.org/x/net/http2"
 
+	core "github.com/v2fly/v2ray-core/v4"
 	"github.com/v2fly/v2ray-core/v4/common"
 	"github.com/v2fly/v2ray-core/v4/common/buf"
 	"github.com/v2fly/v2ray-core/v4/common/net"
@@ -31,6 +32,9 @@ const handshakeIdleTimeout = time.Second * 8
 // QUICNameServer implemented DNS over QUIC
 type QUICNameServer struct {
 	sync.RWMutex
+	cacheOptions
+	recordCache
+	dnssecOptions
 	ips         map[string]record
 	pub         *pubsub.Service
 	cleanup     *task.Periodic
@@ -84,10 +88,10 @@ func (s *QUICNameServer) Cleanup() error {
 	}
 
 	for domain, record := range s.ips {
-		if record.A != nil && record.A.Expire.Before(now) {
+		if s.expired(record.A, now) {
 			record.A = nil
 		}
-		if record.AAAA != nil && record.AAAA.Expire.Before(now) {
+		if s.expired(record.AAAA, now) {
 			record.AAAA = nil
 		}
 
@@ -106,7 +110,26 @@ func (s *QUICNameServer) Cleanup() error {
 	return nil
 }
 
+func (s *QUICNameServer) exportCache() []*CacheState_Record {
+	return s.exportRecords(&s.RWMutex, s.ips)
+}
+
+func (s *QUICNameServer) importCache(records []*CacheState_Record) {
+	if s.importRecords(&s.RWMutex, s.ips, records) > 0 {
+		common.Must(s.cleanup.Start())
+	}
+}
+
+func (s *QUICNameServer) listCache() []CacheEntry {
+	return append(listRecords(&s.RWMutex, s.ips), s.listRecordCache()...)
+}
+
+func (s *QUICNameServer) flushCache(domain string) int {
+	return flushRecords(&s.RWMutex, s.ips, domain) + s.flushRecordCache(domain)
+}
+
 func (s *QUICNameServer) updateIP(req *dnsRequest, ipRec *IPRecord) {
+	s.clampTTL(ipRec)
 	elapsed := time.Since(req.start)
 
 	s.Lock()
@@ -115,7 +138,7 @@ func (s *QUICNameServer) updateIP(req *dnsRequest, ipRec *IPRecord) {
 
 	switch req.reqType {
 	case dnsmessage.TypeA:
-		if isNewer(rec.A, ipRec) {
+		if s.isNewer(rec.A, ipRec) {
 			rec.A = ipRec
 			updated = true
 		}
@@ -127,7 +150,7 @@ func (s *QUICNameServer) updateIP(req *dnsRequest, ipRec *IPRecord) {
 			}
 		}
 		ipRec.IP = addr
-		if isNewer(rec.AAAA, ipRec) {
+		if s.isNewer(rec.AAAA, ipRec) {
 			rec.AAAA = ipRec
 			updated = true
 		}
@@ -154,86 +177,99 @@ func (s *QUICNameServer) newReqID() uint16 {
 func (s *QUICNameServer) sendQuery(ctx context.Context, domain string, clientIP net.IP, option dns_feature.IPOption) {
 	newError(s.name, " querying: ", domain).AtInfo().WriteToLog(session.ExportIDToError(ctx))
 
-	reqs := buildReqMsgs(domain, option, s.newReqID, genEDNS0Options(clientIP))
-
-	var deadline time.Time
-	if d, ok := ctx.Deadline(); ok {
-		deadline = d
-	} else {
-		deadline = time.Now().Add(time.Second * 5)
-	}
+	reqs := buildReqMsgs(domain, option, s.newReqID, s.ednsOptions(ctx, clientIP))
+	key := queryKey(ctx, domain)
 
 	for _, req := range reqs {
+		req.domain = key
 		go func(r *dnsRequest) {
-			// generate new context for each req, using same context
-			// may cause reqs all aborted if any one encounter an error
-			dnsCtx := ctx
-
-			// reserve internal dns server requested Inbound
-			if inbound := session.InboundFromContext(ctx); inbound != nil {
-				dnsCtx = session.ContextWithInbound(dnsCtx, inbound)
-			}
-
-			dnsCtx = session.ContextWithContent(dnsCtx, &session.Content{
-				Protocol:       "quic",
-				SkipDNSResolve: true,
-			})
-
-			var cancel context.CancelFunc
-			dnsCtx, cancel = context.WithDeadline(dnsCtx, deadline)
-			defer cancel()
-
-			b, err := dns.PackMessage(r.msg)
+			resp, err := s.exchange(ctx, r)
 			if err != nil {
-				newError("failed to pack dns query").Base(err).AtError().WriteToLog()
+				newError(s.name, " failed to query ", r.domain).Base(err).AtError().WriteToLog()
 				return
 			}
 
-			conn, err := s.openStream(dnsCtx)
+			rec, err := parseResponse(resp)
 			if err != nil {
-				newError("failed to open quic session").Base(err).AtError().WriteToLog()
+				newError("failed to handle response").Base(err).AtError().WriteToLog()
 				return
 			}
+			s.validate(ctx, s, r, resp, rec)
+			s.updateIP(r, rec)
+		}(req)
+	}
+}
 
-			_, err = conn.Write(b.Bytes())
-			if err != nil {
-				newError("failed to send query").Base(err).AtError().WriteToLog()
-				return
-			}
+// exchange sends the request on a new stream and returns the raw response.
+func (s *QUICNameServer) exchange(ctx context.Context, req *dnsRequest) ([]byte, error) {
+	// generate new context for each req, using same context
+	// may cause reqs all aborted if any one encounter an error
+	dnsCtx := ctx
 
-			_ = conn.Close()
+	// reserve internal dns server requested Inbound
+	if inbound := session.InboundFromContext(ctx); inbound != nil {
+		dnsCtx = session.ContextWithInbound(dnsCtx, inbound)
+	}
 
-			respBuf := buf.New()
-			defer respBuf.Release()
-			n, err := respBuf.ReadFrom(conn)
-			if err != nil && n == 0 {
-				newError("failed to read response").Base(err).AtError().WriteToLog()
-				return
-			}
+	dnsCtx = session.ContextWithContent(dnsCtx, &session.Content{
+		Protocol:       "quic",
+		SkipDNSResolve: true,
+	})
 
-			rec, err := parseResponse(respBuf.Bytes())
-			if err != nil {
-				newError("failed to handle response").Base(err).AtError().WriteToLog()
-				return
-			}
-			s.updateIP(r, rec)
-		}(req)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		dnsCtx, cancel = context.WithTimeout(dnsCtx, time.Second*5)
+		defer cancel()
+	}
+
+	b, err := dns.PackMessage(req.msg)
+	if err != nil {
+		return nil, newError("failed to pack dns query").Base(err)
 	}
+	defer b.Release()
+
+	conn, err := s.openStream(dnsCtx)
+	if err != nil {
+		return nil, newError("failed to open quic session").Base(err)
+	}
+
+	_, err = conn.Write(b.Bytes())
+	if err != nil {
+		return nil, newError("failed to send query").Base(err)
+	}
+
+	_ = conn.Close()
+
+	respBuf := buf.New()
+	defer respBuf.Release()
+	n, err := respBuf.ReadFrom(conn)
+	if err != nil && n == 0 {
+		return nil, newError("failed to read response").Base(err)
+	}
+
+	return append([]byte(nil), respBuf.Bytes()...), nil
 }
 
-func (s *QUICNameServer) findIPsForDomain(domain string, option dns_feature.IPOption) ([]net.IP, error) {
+// QueryRecords implements recordQuerier.
+func (s *QUICNameServer) QueryRecords(ctx context.Context, domain string, qType dnsmessage.Type, clientIP net.IP, disableCache bool) ([]dnsmessage.Resource, error) {
+	return s.queryRecords(ctx, s, &s.cacheOptions, &s.dnssecOptions, Fqdn(domain), qType, clientIP, disableCache)
+}
+
+func (s *QUICNameServer) findIPsForDomain(domain string, option dns_feature.IPOption) ([]net.IP, bool, error) {
 	s.RLock()
 	record, found := s.ips[domain]
 	s.RUnlock()
 
 	if !found {
-		return nil, errRecordNotFound
+		return nil, false, errRecordNotFound
 	}
 
 	var ips []net.Address
 	var lastErr error
+	var refresh bool
 	if option.IPv6Enable && record.AAAA != nil && record.AAAA.RCode == dnsmessage.RCodeSuccess {
-		aaaa, err := record.AAAA.getIPs()
+		aaaa, r, err := s.getIPs(record.AAAA)
+		refresh = refresh || r
 		if err != nil {
 			lastErr = err
 		}
@@ -241,7 +277,8 @@ func (s *QUICNameServer) findIPsForDomain(domain string, option dns_feature.IPOp
 	}
 
 	if option.IPv4Enable && record.A != nil && record.A.RCode == dnsmessage.RCodeSuccess {
-		a, err := record.A.getIPs()
+		a, r, err := s.getIPs(record.A)
+		refresh = refresh || r
 		if err != nil {
 			lastErr = err
 		}
@@ -249,29 +286,35 @@ func (s *QUICNameServer) findIPsForDomain(domain string, option dns_feature.IPOp
 	}
 
 	if len(ips) > 0 {
-		return toNetIP(ips)
+		netIPs, err := toNetIP(ips)
+		return netIPs, refresh, err
 	}
 
 	if lastErr != nil {
-		return nil, lastErr
+		return nil, false, lastErr
 	}
 
 	if (option.IPv4Enable && record.A != nil) || (option.IPv6Enable && record.AAAA != nil) {
-		return nil, dns_feature.ErrEmptyResponse
+		return nil, false, dns_feature.ErrEmptyResponse
 	}
 
-	return nil, errRecordNotFound
+	return nil, false, errRecordNotFound
 }
 
 // QueryIP is called from dns.Server->queryIPTimeout
 func (s *QUICNameServer) QueryIP(ctx context.Context, domain string, clientIP net.IP, option dns_feature.IPOption, disableCache bool) ([]net.IP, error) {
 	fqdn := Fqdn(domain)
+	key := queryKey(ctx, fqdn)
 
 	if disableCache {
 		newError("DNS cache is disabled. Querying IP for ", domain, " at ", s.name).AtDebug().WriteToLog()
 	} else {
-		ips, err := s.findIPsForDomain(fqdn, option)
+		ips, refresh, err := s.findIPsForDomain(key, option)
 		if err != errRecordNotFound {
+			if refresh {
+				go s.sendQuery(core.ToBackgroundDetachedContext(ctx), fqdn, clientIP, option)
+			}
+			markCacheHit(ctx)
 			newError(s.name, " cache HIT ", domain, " -> ", ips).Base(err).AtDebug().WriteToLog()
 			return ips, err
 		}
@@ -280,11 +323,11 @@ func (s *QUICNameServer) QueryIP(ctx context.Context, domain string, clientIP ne
 	// ipv4 and ipv6 belong to different subscription groups
 	var sub4, sub6 *pubsub.Subscriber
 	if option.IPv4Enable {
-		sub4 = s.pub.Subscribe(fqdn + "4")
+		sub4 = s.pub.Subscribe(key + "4")
 		defer sub4.Close()
 	}
 	if option.IPv6Enable {
-		sub6 = s.pub.Subscribe(fqdn + "6")
+		sub6 = s.pub.Subscribe(key + "6")
 		defer sub6.Close()
 	}
 	done := make(chan interface{})
@@ -306,7 +349,7 @@ func (s *QUICNameServer) QueryIP(ctx context.Context, domain string, clientIP ne
 	s.sendQuery(ctx, fqdn, clientIP, option)
 
 	for {
-		ips, err := s.findIPsForDomain(fqdn, option)
+		ips, _, err := s.findIPsForDomain(key, option)
 		if err != errRecordNotFound {
 			return ips, err
 		}
@@ -4,9 +4,9 @@
 package dns
 
 import (
-	"bytes"
 	"context"
 	"encoding/binary"
+	"io"
 	"net/url"
 	"sync"
 	"sync/atomic"
@@ -14,6 +14,7 @@ import (
 
 	"golang.org/x/net/dns/dnsmessage"
 
+	core "github.com/v2fly/v2ray-core/v4"
 	"github.com/v2fly/v2ray-core/v4/common"
 	"github.com/v2fly/v2ray-core/v4/common/buf"
 	"github.com/v2fly/v2ray-core/v4/common/net"
@@ -29,6 +30,9 @@ import (
 // TCPNameServer implemented DNS over TCP (RFC7766).
 type TCPNameServer struct {
 	sync.RWMutex
+	cacheOptions
+	recordCache
+	dnssecOptions
 	name        string
 	destination net.Destination
 	ips         map[string]record
@@ -115,10 +119,10 @@ func (s *TCPNameServer) Cleanup() error {
 	}
 
 	for domain, record := range s.ips {
-		if record.A != nil && record.A.Expire.Before(now) {
+		if s.expired(record.A, now) {
 			record.A = nil
 		}
-		if record.AAAA != nil && record.AAAA.Expire.Before(now) {
+		if s.expired(record.AAAA, now) {
 			record.AAAA = nil
 		}
 
@@ -137,7 +141,26 @@ func (s *TCPNameServer) Cleanup() error {
 	return nil
 }
 
+func (s *TCPNameServer) exportCache() []*CacheState_Record {
+	return s.exportRecords(&s.RWMutex, s.ips)
+}
+
+func (s *TCPNameServer) importCache(records []*CacheState_Record) {
+	if s.importRecords(&s.RWMutex, s.ips, records) > 0 {
+		common.Must(s.cleanup.Start())
+	}
+}
+
+func (s *TCPNameServer) listCache() []CacheEntry {
+	return append(listRecords(&s.RWMutex, s.ips), s.listRecordCache()...)
+}
+
+func (s *TCPNameServer) flushCache(domain string) int {
+	return flushRecords(&s.RWMutex, s.ips, domain) + s.flushRecordCache(domain)
+}
+
 func (s *TCPNameServer) updateIP(req *dnsRequest, ipRec *IPRecord) {
+	s.clampTTL(ipRec)
 	elapsed := time.Since(req.start)
 
 	s.Lock()
@@ -146,7 +169,7 @@ func (s *TCPNameServer) updateIP(req *dnsRequest, ipRec *IPRecord) {
 
 	switch req.reqType {
 	case dnsmessage.TypeA:
-		if isNewer(rec.A, ipRec) {
+		if s.isNewer(rec.A, ipRec) {
 			rec.A = ipRec
 			updated = true
 		}
@@ -158,7 +181,7 @@ func (s *TCPNameServer) updateIP(req *dnsRequest, ipRec *IPRecord) {
 			}
 		}
 		ipRec.IP = addr
-		if isNewer(rec.AAAA, ipRec) {
+		if s.isNewer(rec.AAAA, ipRec) {
 			rec.AAAA = ipRec
 			updated = true
 		}
@@ -185,100 +208,109 @@ func (s *TCPNameServer) newReqID() uint16 {
 func (s *TCPNameServer) sendQuery(ctx context.Context, domain string, clientIP net.IP, option dns_feature.IPOption) {
 	newError(s.name, " querying DNS for: ", domain).AtDebug().WriteToLog(session.ExportIDToError(ctx))
 
-	reqs := buildReqMsgs(domain, option, s.newReqID, genEDNS0Options(clientIP))
-
-	var deadline time.Time
-	if d, ok := ctx.Deadline(); ok {
-		deadline = d
-	} else {
-		deadline = time.Now().Add(time.Second * 5)
-	}
+	reqs := buildReqMsgs(domain, option, s.newReqID, s.ednsOptions(ctx, clientIP))
+	key := queryKey(ctx, domain)
 
 	for _, req := range reqs {
+		req.domain = key
 		go func(r *dnsRequest) {
-			dnsCtx := ctx
-
-			if inbound := session.InboundFromContext(ctx); inbound != nil {
-				dnsCtx = session.ContextWithInbound(dnsCtx, inbound)
-			}
-
-			dnsCtx = session.ContextWithContent(dnsCtx, &session.Content{
-				Protocol:       "dns",
-				SkipDNSResolve: true,
-			})
-
-			var cancel context.CancelFunc
-			dnsCtx, cancel = context.WithDeadline(dnsCtx, deadline)
-			defer cancel()
-
-			b, err := dns.PackMessage(r.msg)
-			if err != nil {
-				newError("failed to pack dns query").Base(err).AtError().WriteToLog()
-				return
-			}
-
-			conn, err := s.dial(dnsCtx)
-			if err != nil {
-				newError("failed to dial namesever").Base(err).AtError().WriteToLog()
-				return
-			}
-			defer conn.Close()
-			dnsReqBuf := buf.New()
-			binary.Write(dnsReqBuf, binary.BigEndian, uint16(b.Len()))
-			dnsReqBuf.Write(b.Bytes())
-			b.Release()
-
-			_, err = conn.Write(dnsReqBuf.Bytes())
+			resp, err := s.exchange(ctx, r)
 			if err != nil {
-				newError("failed to send query").Base(err).AtError().WriteToLog()
+				newError(s.name, " failed to query ", r.domain).Base(err).AtError().WriteToLog()
 				return
 			}
-			dnsReqBuf.Release()
 
-			respBuf := buf.New()
-			defer respBuf.Release()
-			n, err := respBuf.ReadFullFrom(conn, 2)
-			if err != nil && n == 0 {
-				newError("failed to read response length").Base(err).AtError().WriteToLog()
-				return
-			}
-			var length int16
-			err = binary.Read(bytes.NewReader(respBuf.Bytes()), binary.BigEndian, &length)
-			if err != nil {
-				newError("failed to parse response length").Base(err).AtError().WriteToLog()
-				return
-			}
-			respBuf.Clear()
-			n, err = respBuf.ReadFullFrom(conn, int32(length))
-			if err != nil && n == 0 {
-				newError("failed to read response length").Base(err).AtError().WriteToLog()
-				return
-			}
-
-			rec, err := parseResponse(respBuf.Bytes())
+			rec, err := parseResponse(resp)
 			if err != nil {
 				newError("failed to parse DNS over TCP response").Base(err).AtError().WriteToLog()
 				return
 			}
 
+			s.validate(ctx, s, r, resp, rec)
 			s.updateIP(r, rec)
 		}(req)
 	}
 }
 
-func (s *TCPNameServer) findIPsForDomain(domain string, option dns_feature.IPOption) ([]net.IP, error) {
+// exchange sends the request over a new connection and returns the raw response.
+func (s *TCPNameServer) exchange(ctx context.Context, req *dnsRequest) ([]byte, error) {
+	return exchangeOverStream(ctx, s.dial, req)
+}
+
+// exchangeOverStream sends the request over a new connection from dial, in the
+// framing of DNS over TCP, and returns the raw response.
+func exchangeOverStream(ctx context.Context, dial func(context.Context) (net.Conn, error), req *dnsRequest) ([]byte, error) {
+	dnsCtx := ctx
+
+	if inbound := session.InboundFromContext(ctx); inbound != nil {
+		dnsCtx = session.ContextWithInbound(dnsCtx, inbound)
+	}
+
+	dnsCtx = session.ContextWithContent(dnsCtx, &session.Content{
+		Protocol:       "dns",
+		SkipDNSResolve: true,
+	})
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		dnsCtx, cancel = context.WithTimeout(dnsCtx, time.Second*5)
+		defer cancel()
+	}
+
+	b, err := dns.PackMessage(req.msg)
+	if err != nil {
+		return nil, newError("failed to pack dns query").Base(err)
+	}
+
+	conn, err := dial(dnsCtx)
+	if err != nil {
+		b.Release()
+		return nil, newError("failed to dial namesever").Base(err)
+	}
+	defer conn.Close()
+	dnsReqBuf := buf.New()
+	binary.Write(dnsReqBuf, binary.BigEndian, uint16(b.Len()))
+	dnsReqBuf.Write(b.Bytes())
+	b.Release()
+
+	_, err = conn.Write(dnsReqBuf.Bytes())
+	dnsReqBuf.Release()
+	if err != nil {
+		return nil, newError("failed to send query").Base(err)
+	}
+
+	var length uint16
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, newError("failed to read response length").Base(err)
+	}
+	resp := make([]byte, length)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, newError("failed to read response").Base(err)
+	}
+
+	return resp, nil
+}
+
+// QueryRecords implements recordQuerier.
+func (s *TCPNameServer) QueryRecords(ctx context.Context, domain string, qType dnsmessage.Type, clientIP net.IP, disableCache bool) ([]dnsmessage.Resource, error) {
+	return s.queryRecords(ctx, s, &s.cacheOptions, &s.dnssecOptions, Fqdn(domain), qType, clientIP, disableCache)
+}
+
+func (s *TCPNameServer) findIPsForDomain(domain string, option dns_feature.IPOption) ([]net.IP, bool, error) {
 	s.RLock()
 	record, found := s.ips[domain]
 	s.RUnlock()
 
 	if !found {
-		return nil, errRecordNotFound
+		return nil, false, errRecordNotFound
 	}
 
 	var ips []net.Address
 	var lastErr error
+	var refresh bool
 	if option.IPv4Enable {
-		a, err := record.A.getIPs()
+		a, r, err := s.getIPs(record.A)
+		refresh = refresh || r
 		if err != nil {
 			lastErr = err
 		}
@@ -286,7 +318,8 @@ func (s *TCPNameServer) findIPsForDomain(domain string, option dns_feature.IPOpt
 	}
 
 	if option.IPv6Enable {
-		aaaa, err := record.AAAA.getIPs()
+		aaaa, r, err := s.getIPs(record.AAAA)
+		refresh = refresh || r
 		if err != nil {
 			lastErr = err
 		}
@@ -294,25 +327,31 @@ func (s *TCPNameServer) findIPsForDomain(domain string, option dns_feature.IPOpt
 	}
 
 	if len(ips) > 0 {
-		return toNetIP(ips)
+		netIPs, err := toNetIP(ips)
+		return netIPs, refresh, err
 	}
 
 	if lastErr != nil {
-		return nil, lastErr
+		return nil, false, lastErr
 	}
 
-	return nil, dns_feature.ErrEmptyResponse
+	return nil, false, dns_feature.ErrEmptyResponse
 }
 
 // QueryIP implements Server.
 func (s *TCPNameServer) QueryIP(ctx context.Context, domain string, clientIP net.IP, option dns_feature.IPOption, disableCache bool) ([]net.IP, error) {
 	fqdn := Fqdn(domain)
+	key := queryKey(ctx, fqdn)
 
 	if disableCache {
 		newError("DNS cache is disabled. Querying IP for ", domain, " at ", s.name).AtDebug().WriteToLog()
 	} else {
-		ips, err := s.findIPsForDomain(fqdn, option)
+		ips, refresh, err := s.findIPsForDomain(key, option)
 		if err != errRecordNotFound {
+			if refresh {
+				go s.sendQuery(core.ToBackgroundDetachedContext(ctx), fqdn, clientIP, option)
+			}
+			markCacheHit(ctx)
 			newError(s.name, " cache HIT ", domain, " -> ", ips).Base(err).AtDebug().WriteToLog()
 			return ips, err
 		}
@@ -321,11 +360,11 @@ func (s *TCPNameServer) QueryIP(ctx context.Context, domain string, clientIP net
 	// ipv4 and ipv6 belong to different subscription groups
 	var sub4, sub6 *pubsub.Subscriber
 	if option.IPv4Enable {
-		sub4 = s.pub.Subscribe(fqdn + "4")
+		sub4 = s.pub.Subscribe(key + "4")
 		defer sub4.Close()
 	}
 	if option.IPv6Enable {
-		sub6 = s.pub.Subscribe(fqdn + "6")
+		sub6 = s.pub.Subscribe(key + "6")
 		defer sub6.Close()
 	}
 	done := make(chan interface{})
@@ -347,7 +386,7 @@ func (s *TCPNameServer) QueryIP(ctx context.Context, domain string, clientIP net
 	s.sendQuery(ctx, fqdn, clientIP, option)
 
 	for {
-		ips, err := s.findIPsForDomain(fqdn, option)
+		ips, _, err := s.findIPsForDomain(key, option)
 		if err != errRecordNotFound {
 			return ips, err
 		}
@@ -0,0 +1,610 @@
+//go:build !confonly
+// +build !confonly
+
+package dns
+
+import (
+	"context"
+	gotls "crypto/tls"
+	"encoding/binary"
+	"io"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/protocol/dns"
+	"github.com/v2fly/v2ray-core/v4/common/session"
+	"github.com/v2fly/v2ray-core/v4/common/signal"
+	"github.com/v2fly/v2ray-core/v4/common/signal/pubsub"
+	"github.com/v2fly/v2ray-core/v4/common/task"
+	dns_feature "github.com/v2fly/v2ray-core/v4/features/dns"
+	"github.com/v2fly/v2ray-core/v4/features/routing"
+	"github.com/v2fly/v2ray-core/v4/transport/internet"
+	"github.com/v2fly/v2ray-core/v4/transport/internet/tls"
+)
+
+// tlsIdleTimeout is the time to keep an idle DNS over TLS connection open for reuse.
+const tlsIdleTimeout = time.Second * 10
+
+// TLSNameServer implemented DNS over TLS (RFC7858). Queries are pipelined over a reused connection.
+type TLSNameServer struct {
+	sync.RWMutex
+	cacheOptions
+	recordCache
+	dnssecOptions
+	name        string
+	destination net.Destination
+	tlsConfig   *gotls.Config
+	ips         map[string]record
+	pub         *pubsub.Service
+	cleanup     *task.Periodic
+	reqID       uint32
+	dial        func(context.Context) (net.Conn, error)
+
+	connAccess sync.Mutex
+	conn       *tlsConn
+}
+
+// tlsConn is an established DNS over TLS connection with queries waiting for responses.
+type tlsConn struct {
+	sync.Mutex
+	conn     net.Conn
+	timer    *signal.ActivityTimer
+	requests map[uint16]*dnsRequest
+	closed   bool
+}
+
+// NewTLSNameServer creates DNS over TLS server object for remote resolving.
+func NewTLSNameServer(url *url.URL, dispatcher routing.Dispatcher) (*TLSNameServer, error) {
+	s, err := baseTLSNameServer(url, "DOT")
+	if err != nil {
+		return nil, err
+	}
+
+	s.dial = func(ctx context.Context) (net.Conn, error) {
+		link, err := dispatcher.Dispatch(ctx, s.destination)
+		if err != nil {
+			return nil, err
+		}
+
+		return net.NewConnection(
+			net.ConnectionInputMulti(link.Writer),
+			net.ConnectionOutputMulti(link.Reader),
+		), nil
+	}
+
+	return s, nil
+}
+
+// NewTLSLocalNameServer creates DNS over TLS client object for local resolving
+func NewTLSLocalNameServer(url *url.URL) (*TLSNameServer, error) {
+	s, err := baseTLSNameServer(url, "DOTL")
+	if err != nil {
+		return nil, err
+	}
+
+	s.dial = func(ctx context.Context) (net.Conn, error) {
+		return internet.DialSystem(ctx, s.destination, nil)
+	}
+
+	return s, nil
+}
+
+func baseTLSNameServer(url *url.URL, prefix string) (*TLSNameServer, error) {
+	var err error
+	port := net.Port(853)
+	if url.Port() != "" {
+		port, err = net.PortFromString(url.Port())
+		if err != nil {
+			return nil, err
+		}
+	}
+	dest := net.TCPDestination(net.ParseAddress(url.Hostname()), port)
+
+	tlsConfig := (&tls.Config{}).GetTLSConfig(tls.WithNextProto("dot"))
+	tlsConfig.ServerName = url.Hostname()
+
+	s := &TLSNameServer{
+		destination: dest,
+		tlsConfig:   tlsConfig,
+		ips:         make(map[string]record),
+		pub:         pubsub.NewService(),
+		name:        prefix + "//" + dest.NetAddr(),
+	}
+	s.cleanup = &task.Periodic{
+		Interval: time.Minute,
+		Execute:  s.Cleanup,
+	}
+
+	return s, nil
+}
+
+// Name implements Server.
+func (s *TLSNameServer) Name() string {
+	return s.name
+}
+
+// Cleanup clears expired items from cache
+func (s *TLSNameServer) Cleanup() error {
+	now := time.Now()
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.ips) == 0 {
+		return newError("nothing to do. stopping...")
+	}
+
+	for domain, record := range s.ips {
+		if s.expired(record.A, now) {
+			record.A = nil
+		}
+		if s.expired(record.AAAA, now) {
+			record.AAAA = nil
+		}
+
+		if record.A == nil && record.AAAA == nil {
+			newError(s.name, " cleanup ", domain).AtDebug().WriteToLog()
+			delete(s.ips, domain)
+		} else {
+			s.ips[domain] = record
+		}
+	}
+
+	if len(s.ips) == 0 {
+		s.ips = make(map[string]record)
+	}
+
+	return nil
+}
+
+func (s *TLSNameServer) exportCache() []*CacheState_Record {
+	return s.exportRecords(&s.RWMutex, s.ips)
+}
+
+func (s *TLSNameServer) importCache(records []*CacheState_Record) {
+	if s.importRecords(&s.RWMutex, s.ips, records) > 0 {
+		common.Must(s.cleanup.Start())
+	}
+}
+
+func (s *TLSNameServer) listCache() []CacheEntry {
+	return append(listRecords(&s.RWMutex, s.ips), s.listRecordCache()...)
+}
+
+func (s *TLSNameServer) flushCache(domain string) int {
+	return flushRecords(&s.RWMutex, s.ips, domain) + s.flushRecordCache(domain)
+}
+
+func (s *TLSNameServer) updateIP(req *dnsRequest, ipRec *IPRecord) {
+	s.clampTTL(ipRec)
+	elapsed := time.Since(req.start)
+
+	s.Lock()
+	rec := s.ips[req.domain]
+	updated := false
+
+	switch req.reqType {
+	case dnsmessage.TypeA:
+		if s.isNewer(rec.A, ipRec) {
+			rec.A = ipRec
+			updated = true
+		}
+	case dnsmessage.TypeAAAA:
+		addr := make([]net.Address, 0)
+		for _, ip := range ipRec.IP {
+			if len(ip.IP()) == net.IPv6len {
+				addr = append(addr, ip)
+			}
+		}
+		ipRec.IP = addr
+		if s.isNewer(rec.AAAA, ipRec) {
+			rec.AAAA = ipRec
+			updated = true
+		}
+	}
+	newError(s.name, " got answer: ", req.domain, " ", req.reqType, " -> ", ipRec.IP, " ", elapsed).AtInfo().WriteToLog()
+
+	if updated {
+		s.ips[req.domain] = rec
+	}
+	switch req.reqType {
+	case dnsmessage.TypeA:
+		s.pub.Publish(req.domain+"4", nil)
+	case dnsmessage.TypeAAAA:
+		s.pub.Publish(req.domain+"6", nil)
+	}
+	s.Unlock()
+	common.Must(s.cleanup.Start())
+}
+
+func (s *TLSNameServer) newReqID() uint16 {
+	return uint16(atomic.AddUint32(&s.reqID, 1))
+}
+
+func (s *TLSNameServer) sendQuery(ctx context.Context, domain string, clientIP net.IP, option dns_feature.IPOption) {
+	newError(s.name, " querying DNS for: ", domain).AtDebug().WriteToLog(session.ExportIDToError(ctx))
+
+	reqs := buildReqMsgs(domain, option, s.newReqID, s.ednsOptions(ctx, clientIP))
+	key := queryKey(ctx, domain)
+
+	for _, req := range reqs {
+		req.domain = key
+		if s.validator != nil {
+			go s.queryValidated(ctx, req)
+			continue
+		}
+		go func(r *dnsRequest) {
+			b, err := dns.PackMessage(r.msg)
+			if err != nil {
+				newError("failed to pack dns query").Base(err).AtError().WriteToLog()
+				return
+			}
+			defer b.Release()
+
+			// Retry once on a new connection, in case the reused one has been closed by the server.
+			for attempt := 0; attempt < 2; attempt++ {
+				conn, err := s.getConn(ctx)
+				if err != nil {
+					newError("failed to dial namesever").Base(err).AtError().WriteToLog()
+					return
+				}
+				if err = conn.writeQuery(r, b.Bytes()); err == nil {
+					return
+				}
+				newError("failed to send query").Base(err).AtDebug().WriteToLog()
+			}
+		}(req)
+	}
+}
+
+// queryValidated sends the request and validates its answer before caching it.
+func (s *TLSNameServer) queryValidated(ctx context.Context, req *dnsRequest) {
+	resp, err := s.exchange(ctx, req)
+	if err != nil {
+		newError(s.name, " failed to query ", req.domain).Base(err).AtError().WriteToLog()
+		return
+	}
+	rec, err := parseResponse(resp)
+	if err != nil {
+		newError("failed to parse DNS over TLS response").Base(err).AtError().WriteToLog()
+		return
+	}
+	s.validate(ctx, s, req, resp, rec)
+	s.updateIP(req, rec)
+}
+
+// exchange sends the request on the reusable connection and waits for its raw
+// response.
+func (s *TLSNameServer) exchange(ctx context.Context, req *dnsRequest) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Second*5)
+		defer cancel()
+	}
+
+	b, err := dns.PackMessage(req.msg)
+	if err != nil {
+		return nil, newError("failed to pack dns query").Base(err)
+	}
+	defer b.Release()
+
+	response := make(chan []byte, 1)
+	req.response = response
+
+	var conn *tlsConn
+	// Retry once on a new connection, in case the reused one has been closed by the server.
+	for attempt := 0; attempt < 2; attempt++ {
+		conn, err = s.getConn(ctx)
+		if err != nil {
+			return nil, newError("failed to dial namesever").Base(err)
+		}
+		if err = conn.writeQuery(req, b.Bytes()); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, newError("failed to send query").Base(err)
+	}
+
+	select {
+	case resp := <-response:
+		if resp == nil {
+			return nil, newError(s.name, " connection closed before response")
+		}
+		return resp, nil
+	case <-ctx.Done():
+		conn.takeRequest(req.msg.ID)
+		return nil, ctx.Err()
+	}
+}
+
+// QueryRecords implements recordQuerier.
+func (s *TLSNameServer) QueryRecords(ctx context.Context, domain string, qType dnsmessage.Type, clientIP net.IP, disableCache bool) ([]dnsmessage.Resource, error) {
+	return s.queryRecords(ctx, s, &s.cacheOptions, &s.dnssecOptions, Fqdn(domain), qType, clientIP, disableCache)
+}
+
+// getConn returns the reusable connection to the server, and establishes a new one if there is none.
+func (s *TLSNameServer) getConn(ctx context.Context) (*tlsConn, error) {
+	s.connAccess.Lock()
+	defer s.connAccess.Unlock()
+
+	if s.conn != nil && !s.conn.isClosed() {
+		return s.conn, nil
+	}
+
+	// The connection outlives the query, so it must not be canceled with the query context.
+	dnsCtx := core.ToBackgroundDetachedContext(ctx)
+	if inbound := session.InboundFromContext(ctx); inbound != nil {
+		dnsCtx = session.ContextWithInbound(dnsCtx, inbound)
+	}
+	dnsCtx = session.ContextWithContent(dnsCtx, &session.Content{
+		Protocol:       "dns",
+		SkipDNSResolve: true,
+	})
+	dnsCtx, cancel := context.WithCancel(dnsCtx)
+
+	rawConn, err := s.dial(dnsCtx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	tlsClient := gotls.Client(rawConn, s.tlsConfig)
+	handshakeCtx, handshakeCancel := context.WithTimeout(ctx, time.Second*5)
+	defer handshakeCancel()
+	if err := tlsClient.HandshakeContext(handshakeCtx); err != nil {
+		rawConn.Close()
+		cancel()
+		return nil, newError("failed to complete TLS handshake with ", s.destination).Base(err)
+	}
+
+	conn := &tlsConn{
+		conn:     tlsClient,
+		requests: make(map[uint16]*dnsRequest),
+	}
+	conn.timer = signal.CancelAfterInactivity(dnsCtx, func() {
+		conn.close()
+		cancel()
+	}, tlsIdleTimeout)
+	go s.readResponses(conn)
+
+	s.conn = conn
+	return conn, nil
+}
+
+// readResponses reads pipelined responses from the connection until it is closed.
+func (s *TLSNameServer) readResponses(conn *tlsConn) {
+	var length [2]byte
+	for {
+		if _, err := io.ReadFull(conn.conn, length[:]); err != nil {
+			if !conn.isClosed() && err != io.EOF {
+				newError(s.name, " failed to read response length").Base(err).AtDebug().WriteToLog()
+			}
+			s.failRequests(conn, err)
+			return
+		}
+		payload := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn.conn, payload); err != nil {
+			newError(s.name, " failed to read response").Base(err).AtDebug().WriteToLog()
+			s.failRequests(conn, err)
+			return
+		}
+		conn.timer.Update()
+
+		rec, err := parseResponse(payload)
+		if err != nil {
+			newError("failed to parse DNS over TLS response").Base(err).AtError().WriteToLog()
+			continue
+		}
+		if req := conn.takeRequest(rec.ReqID); req != nil {
+			if req.response != nil {
+				req.response <- payload
+				continue
+			}
+			s.updateIP(req, rec)
+		}
+	}
+}
+
+// failRequests closes the connection and fails its pending requests, instead of
+// leaving them to time out.
+func (s *TLSNameServer) failRequests(conn *tlsConn, err error) {
+	err = newError(s.name, " connection closed before response").Base(err)
+	for _, req := range conn.closeAndTakeRequests() {
+		if req.response != nil {
+			req.response <- nil
+			continue
+		}
+		switch req.reqType {
+		case dnsmessage.TypeA:
+			s.pub.Publish(req.domain+"4", err)
+		case dnsmessage.TypeAAAA:
+			s.pub.Publish(req.domain+"6", err)
+		}
+	}
+}
+
+func (c *tlsConn) writeQuery(req *dnsRequest, query []byte) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+
+	c.requests[req.msg.ID] = req
+	if _, err := c.conn.Write(msg); err != nil {
+		delete(c.requests, req.msg.ID)
+		c.closeLocked()
+		return err
+	}
+	c.timer.Update()
+	return nil
+}
+
+func (c *tlsConn) takeRequest(id uint16) *dnsRequest {
+	c.Lock()
+	defer c.Unlock()
+
+	req := c.requests[id]
+	delete(c.requests, id)
+	return req
+}
+
+// closeAndTakeRequests closes the connection and returns all pending requests.
+// No request can be added afterwards.
+func (c *tlsConn) closeAndTakeRequests() []*dnsRequest {
+	c.Lock()
+	defer c.Unlock()
+
+	c.closeLocked()
+	reqs := make([]*dnsRequest, 0, len(c.requests))
+	for id, req := range c.requests {
+		reqs = append(reqs, req)
+		delete(c.requests, id)
+	}
+	return reqs
+}
+
+func (c *tlsConn) isClosed() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.closed
+}
+
+func (c *tlsConn) close() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.closeLocked()
+}
+
+func (c *tlsConn) closeLocked() {
+	if !c.closed {
+		c.closed = true
+		c.conn.Close()
+	}
+}
+
+func (s *TLSNameServer) findIPsForDomain(domain string, option dns_feature.IPOption) ([]net.IP, bool, error) {
+	s.RLock()
+	record, found := s.ips[domain]
+	s.RUnlock()
+
+	if !found {
+		return nil, false, errRecordNotFound
+	}
+
+	var ips []net.Address
+	var lastErr error
+	var refresh bool
+	if option.IPv4Enable {
+		a, r, err := s.getIPs(record.A)
+		refresh = refresh || r
+		if err != nil {
+			lastErr = err
+		}
+		ips = append(ips, a...)
+	}
+
+	if option.IPv6Enable {
+		aaaa, r, err := s.getIPs(record.AAAA)
+		refresh = refresh || r
+		if err != nil {
+			lastErr = err
+		}
+		ips = append(ips, aaaa...)
+	}
+
+	if len(ips) > 0 {
+		netIPs, err := toNetIP(ips)
+		return netIPs, refresh, err
+	}
+
+	if lastErr != nil {
+		return nil, false, lastErr
+	}
+
+	return nil, false, dns_feature.ErrEmptyResponse
+}
+
+// QueryIP implements Server.
+func (s *TLSNameServer) QueryIP(ctx context.Context, domain string, clientIP net.IP, option dns_feature.IPOption, disableCache bool) ([]net.IP, error) {
+	fqdn := Fqdn(domain)
+	key := queryKey(ctx, fqdn)
+
+	if disableCache {
+		newError("DNS cache is disabled. Querying IP for ", domain, " at ", s.name).AtDebug().WriteToLog()
+	} else {
+		ips, refresh, err := s.findIPsForDomain(key, option)
+		if err != errRecordNotFound {
+			if refresh {
+				go s.sendQuery(core.ToBackgroundDetachedContext(ctx), fqdn, clientIP, option)
+			}
+			markCacheHit(ctx)
+			newError(s.name, " cache HIT ", domain, " -> ", ips).Base(err).AtDebug().WriteToLog()
+			return ips, err
+		}
+	}
+
+	// ipv4 and ipv6 belong to different subscription groups
+	var sub4, sub6 *pubsub.Subscriber
+	if option.IPv4Enable {
+		sub4 = s.pub.Subscribe(key + "4")
+		defer sub4.Close()
+	}
+	if option.IPv6Enable {
+		sub6 = s.pub.Subscribe(key + "6")
+		defer sub6.Close()
+	}
+	// A request failed on a broken connection publishes its error instead of a record.
+	var failure error
+	wait := func(sub *pubsub.Subscriber) {
+		select {
+		case msg := <-sub.Wait():
+			if err, ok := msg.(error); ok {
+				failure = err
+			}
+		case <-ctx.Done():
+		}
+	}
+	done := make(chan interface{})
+	go func() {
+		if sub4 != nil {
+			wait(sub4)
+		}
+		if sub6 != nil {
+			wait(sub6)
+		}
+		close(done)
+	}()
+	s.sendQuery(ctx, fqdn, clientIP, option)
+
+	for {
+		ips, _, err := s.findIPsForDomain(key, option)
+		if err != errRecordNotFound {
+			return ips, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-done:
+			if failure != nil {
+				if ips, _, err := s.findIPsForDomain(key, option); err != errRecordNotFound {
+					return ips, err
+				}
+				return nil, failure
+			}
+		}
+	}
+}
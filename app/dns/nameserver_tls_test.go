@@ -0,0 +1,159 @@
+package dns
+
+import (
+	"context"
+	gotls "crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/protocol/tls/cert"
+	dns_feature "github.com/v2fly/v2ray-core/v4/features/dns"
+)
+
+type countingListener struct {
+	net.Listener
+	count int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.count, 1)
+	}
+	return conn, err
+}
+
+func TestTLSLocalNameServer(t *testing.T) {
+	ca := cert.MustGenerate(nil, cert.Authority(true), cert.KeyUsage(x509.KeyUsageCertSign))
+	serverCert := cert.MustGenerate(ca, cert.DNSNames("localhost"))
+	certPEM, keyPEM := serverCert.ToPEM()
+	keyPair, err := gotls.X509KeyPair(certPEM, keyPEM)
+	common.Must(err)
+	caPEM, _ := ca.ToPEM()
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+
+	rawListener, err := net.Listen("tcp", "127.0.0.1:0")
+	common.Must(err)
+	listener := &countingListener{Listener: gotls.NewListener(rawListener, &gotls.Config{Certificates: []gotls.Certificate{keyPair}})}
+	server := &dns.Server{
+		Listener: listener,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			ans := new(dns.Msg)
+			ans.SetReply(r)
+			switch r.Question[0].Qtype {
+			case dns.TypeA:
+				ans.Answer = append(ans.Answer, common.Must2(dns.NewRR(r.Question[0].Name+" IN A 10.0.0.1")).(dns.RR))
+			case dns.TypeTXT:
+				ans.Answer = append(ans.Answer, common.Must2(dns.NewRR(r.Question[0].Name+" IN TXT \"v=spf1 -all\"")).(dns.RR))
+			}
+			w.WriteMsg(ans)
+		}),
+	}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	u, err := url.Parse("tls+local://localhost:" + net.Port(listener.Addr().(*net.TCPAddr).Port).String())
+	common.Must(err)
+	s, err := NewTLSLocalNameServer(u)
+	common.Must(err)
+	s.tlsConfig.RootCAs = roots
+
+	var wg sync.WaitGroup
+	for _, domain := range []string{"v2fly.org", "example.com", "github.com"} {
+		wg.Add(1)
+		go func(domain string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			ips, err := s.QueryIP(ctx, domain, net.IP(nil), dns_feature.IPOption{
+				IPv4Enable: true,
+				IPv6Enable: true,
+			}, true)
+			if err != nil {
+				t.Error("failed to query ", domain, ": ", err)
+				return
+			}
+			if len(ips) != 1 || !ips[0].Equal(net.IP{10, 0, 0, 1}) {
+				t.Error("unexpected answer for ", domain, ": ", ips)
+			}
+		}(domain)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	records, err := s.QueryRecords(ctx, "v2fly.org", dnsmessage.TypeTXT, net.IP(nil), true)
+	if err != nil {
+		t.Fatal("failed to query TXT records: ", err)
+	}
+	if len(records) != 1 || records[0].Body.(*dnsmessage.TXTResource).TXT[0] != "v=spf1 -all" {
+		t.Error("unexpected TXT records: ", records)
+	}
+
+	if count := atomic.LoadInt32(&listener.count); count != 1 {
+		t.Error("expect queries pipelined in 1 connection, but got ", count)
+	}
+}
+
+func TestTLSNameServerConnectionClosed(t *testing.T) {
+	ca := cert.MustGenerate(nil, cert.Authority(true), cert.KeyUsage(x509.KeyUsageCertSign))
+	serverCert := cert.MustGenerate(ca, cert.DNSNames("localhost"))
+	certPEM, keyPEM := serverCert.ToPEM()
+	keyPair, err := gotls.X509KeyPair(certPEM, keyPEM)
+	common.Must(err)
+	caPEM, _ := ca.ToPEM()
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+
+	// The server reads a query and closes the connection without answering.
+	listener, err := gotls.Listen("tcp", "127.0.0.1:0", &gotls.Config{Certificates: []gotls.Certificate{keyPair}})
+	common.Must(err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := conn.Read(length[:]); err == nil {
+					conn.Read(make([]byte, 512))
+				}
+			}()
+		}
+	}()
+
+	u, err := url.Parse("tls+local://localhost:" + net.Port(listener.Addr().(*net.TCPAddr).Port).String())
+	common.Must(err)
+	s, err := NewTLSLocalNameServer(u)
+	common.Must(err)
+	s.tlsConfig.RootCAs = roots
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	start := time.Now()
+	if _, err := s.QueryIP(ctx, "v2fly.org", net.IP(nil), dns_feature.IPOption{
+		IPv4Enable: true,
+		IPv6Enable: true,
+	}, true); err == nil || err == context.DeadlineExceeded {
+		t.Error("expect connection error, but got ", err)
+	}
+	if _, err := s.QueryRecords(ctx, "v2fly.org", dnsmessage.TypeTXT, net.IP(nil), true); err == nil || err == context.DeadlineExceeded {
+		t.Error("expect connection error, but got ", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Error("expect pending queries to fail immediately, but took ", elapsed)
+	}
+}
@@ -28,14 +28,18 @@ import (
 // ClassicNameServer implemented traditional UDP DNS.
 type ClassicNameServer struct {
 	sync.RWMutex
-	name      string
-	address   net.Destination
-	ips       map[string]record
-	requests  map[uint16]dnsRequest
-	pub       *pubsub.Service
-	udpServer *udp.Dispatcher
-	cleanup   *task.Periodic
-	reqID     uint32
+	cacheOptions
+	recordCache
+	dnssecOptions
+	name       string
+	address    net.Destination
+	ips        map[string]record
+	requests   map[uint16]dnsRequest
+	pub        *pubsub.Service
+	udpServer  *udp.Dispatcher
+	dispatcher routing.Dispatcher
+	cleanup    *task.Periodic
+	reqID      uint32
 }
 
 // NewClassicNameServer creates udp server object for remote resolving.
@@ -46,11 +50,12 @@ func NewClassicNameServer(address net.Destination, dispatcher routing.Dispatcher
 	}
 
 	s := &ClassicNameServer{
-		address:  address,
-		ips:      make(map[string]record),
-		requests: make(map[uint16]dnsRequest),
-		pub:      pubsub.NewService(),
-		name:     strings.ToUpper(address.String()),
+		address:    address,
+		ips:        make(map[string]record),
+		requests:   make(map[uint16]dnsRequest),
+		pub:        pubsub.NewService(),
+		dispatcher: dispatcher,
+		name:       strings.ToUpper(address.String()),
 	}
 	s.cleanup = &task.Periodic{
 		Interval: time.Minute,
@@ -77,10 +82,10 @@ func (s *ClassicNameServer) Cleanup() error {
 	}
 
 	for domain, record := range s.ips {
-		if record.A != nil && record.A.Expire.Before(now) {
+		if s.expired(record.A, now) {
 			record.A = nil
 		}
-		if record.AAAA != nil && record.AAAA.Expire.Before(now) {
+		if s.expired(record.AAAA, now) {
 			record.AAAA = nil
 		}
 
@@ -108,6 +113,24 @@ func (s *ClassicNameServer) Cleanup() error {
 	return nil
 }
 
+func (s *ClassicNameServer) exportCache() []*CacheState_Record {
+	return s.exportRecords(&s.RWMutex, s.ips)
+}
+
+func (s *ClassicNameServer) importCache(records []*CacheState_Record) {
+	if s.importRecords(&s.RWMutex, s.ips, records) > 0 {
+		common.Must(s.cleanup.Start())
+	}
+}
+
+func (s *ClassicNameServer) listCache() []CacheEntry {
+	return append(listRecords(&s.RWMutex, s.ips), s.listRecordCache()...)
+}
+
+func (s *ClassicNameServer) flushCache(domain string) int {
+	return flushRecords(&s.RWMutex, s.ips, domain) + s.flushRecordCache(domain)
+}
+
 // HandleResponse handles udp response packet from remote DNS server.
 func (s *ClassicNameServer) HandleResponse(ctx context.Context, packet *udp_proto.Packet) {
 	ipRec, err := parseResponse(packet.Payload.Bytes())
@@ -129,6 +152,15 @@ func (s *ClassicNameServer) HandleResponse(ctx context.Context, packet *udp_prot
 		return
 	}
 
+	if req.response != nil {
+		req.response <- append([]byte(nil), packet.Payload.Bytes()...)
+		return
+	}
+
+	s.handleAnswer(&req, ipRec)
+}
+
+func (s *ClassicNameServer) handleAnswer(req *dnsRequest, ipRec *IPRecord) {
 	var rec record
 	switch req.reqType {
 	case dnsmessage.TypeA:
@@ -145,17 +177,20 @@ func (s *ClassicNameServer) HandleResponse(ctx context.Context, packet *udp_prot
 }
 
 func (s *ClassicNameServer) updateIP(domain string, newRec record) {
+	s.clampTTL(newRec.A)
+	s.clampTTL(newRec.AAAA)
+
 	s.Lock()
 
 	newError(s.name, " updating IP records for domain:", domain).AtDebug().WriteToLog()
 	rec := s.ips[domain]
 
 	updated := false
-	if isNewer(rec.A, newRec.A) {
+	if s.isNewer(rec.A, newRec.A) {
 		rec.A = newRec.A
 		updated = true
 	}
-	if isNewer(rec.AAAA, newRec.AAAA) {
+	if s.isNewer(rec.AAAA, newRec.AAAA) {
 		rec.AAAA = newRec.AAAA
 		updated = true
 	}
@@ -189,35 +224,104 @@ func (s *ClassicNameServer) addPendingRequest(req *dnsRequest) {
 func (s *ClassicNameServer) sendQuery(ctx context.Context, domain string, clientIP net.IP, option dns_feature.IPOption) {
 	newError(s.name, " querying DNS for: ", domain).AtDebug().WriteToLog(session.ExportIDToError(ctx))
 
-	reqs := buildReqMsgs(domain, option, s.newReqID, genEDNS0Options(clientIP))
+	reqs := buildReqMsgs(domain, option, s.newReqID, s.ednsOptions(ctx, clientIP))
+	key := queryKey(ctx, domain)
 
 	for _, req := range reqs {
-		s.addPendingRequest(req)
-		b, _ := dns.PackMessage(req.msg)
-		udpCtx := core.ToBackgroundDetachedContext(ctx)
-		if inbound := session.InboundFromContext(ctx); inbound != nil {
-			udpCtx = session.ContextWithInbound(udpCtx, inbound)
+		req.domain = key
+		if s.validator != nil {
+			go s.queryValidated(ctx, req)
+			continue
 		}
-		udpCtx = session.ContextWithContent(udpCtx, &session.Content{
-			Protocol: "dns",
-		})
-		s.udpServer.Dispatch(udpCtx, s.address, b)
+		s.dispatch(ctx, req)
+	}
+}
+
+// queryValidated sends the request and validates its answer before caching it.
+func (s *ClassicNameServer) queryValidated(ctx context.Context, req *dnsRequest) {
+	resp, err := s.exchange(ctx, req)
+	if err != nil {
+		newError(s.name, " failed to query ", req.domain).Base(err).AtError().WriteToLog()
+		return
 	}
+	ipRec, err := parseResponse(resp)
+	if err != nil {
+		newError(s.name, " fail to parse responded DNS udp").Base(err).AtError().WriteToLog()
+		return
+	}
+	s.validate(ctx, s, req, resp, ipRec)
+	s.handleAnswer(req, ipRec)
+}
+
+func (s *ClassicNameServer) dispatch(ctx context.Context, req *dnsRequest) {
+	s.addPendingRequest(req)
+	b, _ := dns.PackMessage(req.msg)
+	udpCtx := core.ToBackgroundDetachedContext(ctx)
+	if inbound := session.InboundFromContext(ctx); inbound != nil {
+		udpCtx = session.ContextWithInbound(udpCtx, inbound)
+	}
+	udpCtx = session.ContextWithContent(udpCtx, &session.Content{
+		Protocol: "dns",
+	})
+	s.udpServer.Dispatch(udpCtx, s.address, b)
+}
+
+// exchange sends the request and waits for its raw response.
+func (s *ClassicNameServer) exchange(ctx context.Context, req *dnsRequest) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Second*8)
+		defer cancel()
+	}
+
+	response := make(chan []byte, 1)
+	req.response = response
+	s.dispatch(ctx, req)
+	common.Must(s.cleanup.Start())
+
+	select {
+	case resp := <-response:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// exchangeTCP implements tcpExchanger. It sends the request to the same
+// server over TCP.
+func (s *ClassicNameServer) exchangeTCP(ctx context.Context, req *dnsRequest) ([]byte, error) {
+	return exchangeOverStream(ctx, func(ctx context.Context) (net.Conn, error) {
+		link, err := s.dispatcher.Dispatch(ctx, net.TCPDestination(s.address.Address, s.address.Port))
+		if err != nil {
+			return nil, err
+		}
+		return net.NewConnection(
+			net.ConnectionInputMulti(link.Writer),
+			net.ConnectionOutputMulti(link.Reader),
+		), nil
+	}, req)
 }
 
-func (s *ClassicNameServer) findIPsForDomain(domain string, option dns_feature.IPOption) ([]net.IP, error) {
+// QueryRecords implements recordQuerier.
+func (s *ClassicNameServer) QueryRecords(ctx context.Context, domain string, qType dnsmessage.Type, clientIP net.IP, disableCache bool) ([]dnsmessage.Resource, error) {
+	return s.queryRecords(ctx, s, &s.cacheOptions, &s.dnssecOptions, Fqdn(domain), qType, clientIP, disableCache)
+}
+
+func (s *ClassicNameServer) findIPsForDomain(domain string, option dns_feature.IPOption) ([]net.IP, bool, error) {
 	s.RLock()
 	record, found := s.ips[domain]
 	s.RUnlock()
 
 	if !found {
-		return nil, errRecordNotFound
+		return nil, false, errRecordNotFound
 	}
 
 	var ips []net.Address
 	var lastErr error
+	var refresh bool
 	if option.IPv4Enable {
-		a, err := record.A.getIPs()
+		a, r, err := s.getIPs(record.A)
+		refresh = refresh || r
 		if err != nil {
 			lastErr = err
 		}
@@ -225,7 +329,8 @@ func (s *ClassicNameServer) findIPsForDomain(domain string, option dns_feature.I
 	}
 
 	if option.IPv6Enable {
-		aaaa, err := record.AAAA.getIPs()
+		aaaa, r, err := s.getIPs(record.AAAA)
+		refresh = refresh || r
 		if err != nil {
 			lastErr = err
 		}
@@ -233,25 +338,31 @@ func (s *ClassicNameServer) findIPsForDomain(domain string, option dns_feature.I
 	}
 
 	if len(ips) > 0 {
-		return toNetIP(ips)
+		netIPs, err := toNetIP(ips)
+		return netIPs, refresh, err
 	}
 
 	if lastErr != nil {
-		return nil, lastErr
+		return nil, false, lastErr
 	}
 
-	return nil, dns_feature.ErrEmptyResponse
+	return nil, false, dns_feature.ErrEmptyResponse
 }
 
 // QueryIP implements Server.
 func (s *ClassicNameServer) QueryIP(ctx context.Context, domain string, clientIP net.IP, option dns_feature.IPOption, disableCache bool) ([]net.IP, error) {
 	fqdn := Fqdn(domain)
+	key := queryKey(ctx, fqdn)
 
 	if disableCache {
 		newError("DNS cache is disabled. Querying IP for ", domain, " at ", s.name).AtDebug().WriteToLog()
 	} else {
-		ips, err := s.findIPsForDomain(fqdn, option)
+		ips, refresh, err := s.findIPsForDomain(key, option)
 		if err != errRecordNotFound {
+			if refresh {
+				go s.sendQuery(core.ToBackgroundDetachedContext(ctx), fqdn, clientIP, option)
+			}
+			markCacheHit(ctx)
 			newError(s.name, " cache HIT ", domain, " -> ", ips).Base(err).AtDebug().WriteToLog()
 			return ips, err
 		}
@@ -260,11 +371,11 @@ func (s *ClassicNameServer) QueryIP(ctx context.Context, domain string, clientIP
 	// ipv4 and ipv6 belong to different subscription groups
 	var sub4, sub6 *pubsub.Subscriber
 	if option.IPv4Enable {
-		sub4 = s.pub.Subscribe(fqdn + "4")
+		sub4 = s.pub.Subscribe(key + "4")
 		defer sub4.Close()
 	}
 	if option.IPv6Enable {
-		sub6 = s.pub.Subscribe(fqdn + "6")
+		sub6 = s.pub.Subscribe(key + "6")
 		defer sub6.Close()
 	}
 	done := make(chan interface{})
@@ -286,7 +397,7 @@ func (s *ClassicNameServer) QueryIP(ctx context.Context, domain string, clientIP
 	s.sendQuery(ctx, fqdn, clientIP, option)
 
 	for {
-		ips, err := s.findIPsForDomain(fqdn, option)
+		ips, _, err := s.findIPsForDomain(key, option)
 		if err != errRecordNotFound {
 			return ips, err
 		}
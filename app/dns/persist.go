@@ -0,0 +1,176 @@
+//go:build !confonly
+// +build !confonly
+
+package dns
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/v2fly/v2ray-core/v4/app/persistentstorage"
+	"github.com/v2fly/v2ray-core/v4/common/errors"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/task"
+)
+
+// cachePersistInterval is how often the caches are saved, so that they
+// survive a crash too.
+const cachePersistInterval = time.Minute
+
+// cachePersister is implemented by name servers whose cache can be saved
+// across restarts.
+type cachePersister interface {
+	exportCache() []*CacheState_Record
+	importCache(records []*CacheState_Record)
+}
+
+func (o *cacheOptions) exportRecords(mu *sync.RWMutex, ips map[string]record) []*CacheState_Record {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	now := time.Now()
+	var records []*CacheState_Record
+	export := func(domain string, rec *IPRecord, ipv6 bool) {
+		if rec == nil || rec.RCode != dnsmessage.RCodeSuccess || len(rec.IP) == 0 || o.expired(rec, now) {
+			return
+		}
+		r := &CacheState_Record{
+			Domain: domain,
+			Ipv6:   ipv6,
+			Expire: rec.Expire.Unix(),
+			Ttl:    uint32(rec.ttl / time.Second),
+		}
+		for _, ip := range rec.IP {
+			r.Ip = append(r.Ip, ip.IP())
+		}
+		records = append(records, r)
+	}
+	for domain, rec := range ips {
+		export(domain, rec.A, false)
+		export(domain, rec.AAAA, true)
+	}
+	return records
+}
+
+// importRecords adds saved records that are still servable to ips, without
+// replacing records already in the cache. It returns the number of records
+// added.
+func (o *cacheOptions) importRecords(mu *sync.RWMutex, ips map[string]record, records []*CacheState_Record) int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	for _, r := range records {
+		rec := &IPRecord{
+			Expire: time.Unix(r.Expire, 0),
+			RCode:  dnsmessage.RCodeSuccess,
+			ttl:    time.Duration(r.Ttl) * time.Second,
+		}
+		for _, ip := range r.Ip {
+			if len(ip) == net.IPv4len || len(ip) == net.IPv6len {
+				rec.IP = append(rec.IP, net.IPAddress(ip))
+			}
+		}
+		if len(rec.IP) == 0 || o.expired(rec, now) {
+			continue
+		}
+
+		cached := ips[r.Domain]
+		if r.Ipv6 {
+			if cached.AAAA != nil {
+				continue
+			}
+			cached.AAAA = rec
+		} else {
+			if cached.A != nil {
+				continue
+			}
+			cached.A = rec
+		}
+		ips[r.Domain] = cached
+		count++
+	}
+	return count
+}
+
+func cacheStorageKey(client *Client) []byte {
+	return []byte("dns/cache/" + client.Name())
+}
+
+// loadCache restores the caches saved by saveCache.
+func (s *DNS) loadCache() {
+	storage := persistentstorage.FromContext(s.ctx)
+	if storage == nil {
+		newError("no persistent storage available, DNS cache will not be restored").AtWarning().WriteToLog()
+		return
+	}
+
+	for _, client := range s.clients {
+		persister, ok := client.server.(cachePersister)
+		if !ok {
+			continue
+		}
+		data, err := storage.Get(s.ctx, cacheStorageKey(client))
+		if persistentstorage.IsNotFound(data, err) {
+			newError("no saved cache for ", client.Name()).AtDebug().WriteToLog()
+			continue
+		}
+		if err != nil {
+			newError("failed to read saved cache for ", client.Name()).Base(err).AtWarning().WriteToLog()
+			continue
+		}
+		state := new(CacheState)
+		if err := proto.Unmarshal(data, state); err != nil {
+			newError("failed to parse saved cache for ", client.Name()).Base(err).AtWarning().WriteToLog()
+			continue
+		}
+		persister.importCache(state.Records)
+	}
+}
+
+// startPersisting saves the caches periodically, if a persistent storage
+// engine is available.
+func (s *DNS) startPersisting() error {
+	if persistentstorage.FromContext(s.ctx) == nil {
+		return nil
+	}
+	s.persistTask = &task.Periodic{
+		Interval: cachePersistInterval,
+		Execute: func() error {
+			if err := s.saveCache(); err != nil {
+				newError("failed to save DNS cache").Base(err).AtWarning().WriteToLog()
+			}
+			return nil
+		},
+	}
+	return s.persistTask.Start()
+}
+
+// saveCache saves the cache of each name server to the persistent storage.
+func (s *DNS) saveCache() error {
+	storage := persistentstorage.FromContext(s.ctx)
+	if storage == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, client := range s.clients {
+		persister, ok := client.server.(cachePersister)
+		if !ok {
+			continue
+		}
+		data, err := proto.Marshal(&CacheState{Records: persister.exportCache()})
+		if err != nil {
+			errs = append(errs, newError("failed to encode cache for ", client.Name()).Base(err))
+			continue
+		}
+		if err := storage.Put(s.ctx, cacheStorageKey(client), data); err != nil {
+			errs = append(errs, newError("failed to save cache for ", client.Name()).Base(err))
+		}
+	}
+	return errors.Combine(errs...)
+}
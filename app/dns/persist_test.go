@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/v2fly/v2ray-core/v4/common/net"
+)
+
+func TestCacheExportImport(t *testing.T) {
+	opts := &cacheOptions{staleTTL: time.Minute}
+	var mu sync.RWMutex
+	now := time.Now()
+	ips := map[string]record{
+		"v2fly.org.": {
+			A:    &IPRecord{IP: []net.Address{net.ParseAddress("1.2.3.4")}, Expire: now.Add(time.Hour), ttl: time.Hour},
+			AAAA: &IPRecord{IP: []net.Address{net.ParseAddress("2001::1")}, Expire: now.Add(-time.Second)},
+		},
+		"expired.v2fly.org.": {
+			A: &IPRecord{IP: []net.Address{net.ParseAddress("1.2.3.5")}, Expire: now.Add(-time.Hour)},
+		},
+		"failed.v2fly.org.": {
+			A: &IPRecord{Expire: now.Add(time.Hour), RCode: dnsmessage.RCodeServerFailure},
+		},
+	}
+
+	records := opts.exportRecords(&mu, ips)
+	if len(records) != 2 {
+		t.Fatal("expected 2 exported records, got ", len(records))
+	}
+
+	restored := map[string]record{
+		"v2fly.org.": {
+			AAAA: &IPRecord{IP: []net.Address{net.ParseAddress("2001::2")}, Expire: now.Add(time.Hour)},
+		},
+	}
+	if n := opts.importRecords(&mu, restored, records); n != 1 {
+		t.Fatal("expected 1 imported record, got ", n)
+	}
+
+	rec := restored["v2fly.org."]
+	if r := cmp.Diff(rec.A.IP, []net.Address{net.ParseAddress("1.2.3.4")}); r != "" {
+		t.Error(r)
+	}
+	if rec.A.ttl != time.Hour || rec.A.Expire.Unix() != now.Add(time.Hour).Unix() {
+		t.Error("unexpected restored record ", rec.A.ttl, rec.A.Expire)
+	}
+	if r := cmp.Diff(rec.AAAA.IP, []net.Address{net.ParseAddress("2001::2")}); r != "" {
+		t.Error("cached record should not be replaced: ", r)
+	}
+
+	strict := &cacheOptions{}
+	if n := strict.importRecords(&mu, map[string]record{}, records); n != 1 {
+		t.Error("expected stale record to be dropped without serve-stale, got ", n)
+	}
+}
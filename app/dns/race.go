@@ -0,0 +1,134 @@
+//go:build !confonly
+// +build !confonly
+
+package dns
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/v2fly/v2ray-core/v4/common/errors"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/features/dns"
+)
+
+// failedQueryLatency is the latency recorded for queries that failed or timed
+// out, so that a failing name server does not look fast.
+const failedQueryLatency = 4 * time.Second
+
+// latencyTracker keeps a moving average of the response time of a name
+// server.
+type latencyTracker struct {
+	access  sync.Mutex
+	average time.Duration
+}
+
+func (t *latencyTracker) recordLatency(d time.Duration) {
+	t.access.Lock()
+	defer t.access.Unlock()
+
+	if t.average == 0 {
+		t.average = d
+		return
+	}
+	t.average += (d - t.average) / 8
+}
+
+// observe records the latency of a query that was not answered from the
+// cache. Queries canceled by the caller, e.g. after another server won a
+// race, are not recorded.
+func (t *latencyTracker) observe(err error, d time.Duration) {
+	switch {
+	case errors.Cause(err) == context.Canceled:
+		return
+	case errors.Cause(err) == context.DeadlineExceeded || isServerFailure(err):
+		if d < failedQueryLatency {
+			d = failedQueryLatency
+		}
+	}
+	t.recordLatency(d)
+}
+
+// Latency returns the average response time of the name server, or zero if
+// it has not been queried yet.
+func (t *latencyTracker) Latency() time.Duration {
+	t.access.Lock()
+	defer t.access.Unlock()
+
+	return t.average
+}
+
+// groupClients splits the sorted clients into query stages. Clients sharing a
+// race group are moved into the stage of the first of them, the others are
+// queried on their own.
+func groupClients(clients []*Client) [][]*Client {
+	stages := make([][]*Client, 0, len(clients))
+	groupIdx := make(map[string]int)
+	for _, client := range clients {
+		if client.raceGroup == "" {
+			stages = append(stages, []*Client{client})
+			continue
+		}
+		if idx, found := groupIdx[client.raceGroup]; found {
+			stages[idx] = append(stages[idx], client)
+			continue
+		}
+		groupIdx[client.raceGroup] = len(stages)
+		stages = append(stages, []*Client{client})
+	}
+	return stages
+}
+
+func stageName(clients []*Client) string {
+	names := make([]string, 0, len(clients))
+	for _, client := range clients {
+		names = append(names, client.Name())
+	}
+	return strings.Join(names, "|")
+}
+
+func isRetryableError(err error) bool {
+	return err == context.Canceled || err == context.DeadlineExceeded || err == errExpectedIPNonMatch
+}
+
+// raceQueryIP queries all clients in parallel and returns the first answer
+// that passes the expected IPs of its client, with the client that gave it,
+// cancelling the other queries. If no client answers, the first error that is
+// not retryable is returned.
+func raceQueryIP(ctx context.Context, clients []*Client, domain string, option dns.IPOption, disableCache bool) ([]net.IP, *Client, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		client *Client
+		ips    []net.IP
+		err    error
+	}
+	results := make(chan result, len(clients))
+	start := time.Now()
+	for _, client := range clients {
+		go func(client *Client) {
+			ips, err := client.QueryIP(ctx, domain, option, disableCache)
+			results <- result{client, ips, err}
+		}(client)
+	}
+
+	var lastErr error
+	for range clients {
+		r := <-results
+		if len(r.ips) > 0 {
+			newError("server ", r.client.Name(), " won the race for domain ", domain, " in ", time.Since(start)).AtDebug().WriteToLog()
+			return r.ips, r.client, nil
+		}
+		if r.err == nil {
+			continue
+		}
+		newError("failed to lookup ip for domain ", domain, " at server ", r.client.Name()).Base(r.err).AtDebug().WriteToLog()
+		if lastErr == nil || isRetryableError(lastErr) {
+			lastErr = r.err
+		}
+	}
+	return nil, nil, lastErr
+}
@@ -0,0 +1,226 @@
+//go:build !confonly
+// +build !confonly
+
+package dns
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/v2fly/v2ray-core/v4/common/errors"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	dns_feature "github.com/v2fly/v2ray-core/v4/features/dns"
+)
+
+var errRecordTypeNotSupported = errors.New("record type not supported")
+
+// recordQuerier is implemented by name servers that can look up records of any
+// type.
+type recordQuerier interface {
+	QueryRecords(ctx context.Context, domain string, qType dnsmessage.Type, clientIP net.IP, disableCache bool) ([]dnsmessage.Resource, error)
+}
+
+// exchanger is implemented by name servers that can send a request and wait
+// for its raw response.
+type exchanger interface {
+	exchange(ctx context.Context, req *dnsRequest) ([]byte, error)
+}
+
+type recordKey struct {
+	domain string
+	qType  dnsmessage.Type
+}
+
+type recordEntry struct {
+	answers []dnsmessage.Resource
+	rcode   dnsmessage.RCode
+	expire  time.Time
+}
+
+// result returns the cached answers, with their TTL lowered to the remaining
+// lifetime of the entry.
+func (e *recordEntry) result() ([]dnsmessage.Resource, error) {
+	if e.rcode != dnsmessage.RCodeSuccess {
+		return nil, dns_feature.RCodeError(e.rcode)
+	}
+	if len(e.answers) == 0 {
+		return nil, dns_feature.ErrEmptyResponse
+	}
+	ttl := uint32(time.Until(e.expire) / time.Second)
+	answers := make([]dnsmessage.Resource, len(e.answers))
+	for i, answer := range e.answers {
+		if answer.Header.TTL > ttl {
+			answer.Header.TTL = ttl
+		}
+		answers[i] = answer
+	}
+	return answers, nil
+}
+
+const maxCachedRecords = 4096
+
+// recordCache caches the answers of queries for records other than A and
+// AAAA.
+type recordCache struct {
+	recordAccess sync.Mutex
+	records      map[recordKey]recordEntry
+}
+
+func (c *recordCache) getRecords(key recordKey) (recordEntry, bool) {
+	c.recordAccess.Lock()
+	defer c.recordAccess.Unlock()
+
+	entry, found := c.records[key]
+	if found && entry.expire.Before(time.Now()) {
+		delete(c.records, key)
+		return entry, false
+	}
+	return entry, found
+}
+
+func (c *recordCache) putRecords(key recordKey, entry recordEntry) {
+	c.recordAccess.Lock()
+	defer c.recordAccess.Unlock()
+
+	if c.records == nil {
+		c.records = make(map[recordKey]recordEntry)
+	}
+	if len(c.records) >= maxCachedRecords {
+		now := time.Now()
+		for k, e := range c.records {
+			if e.expire.Before(now) {
+				delete(c.records, k)
+			}
+		}
+		if len(c.records) >= maxCachedRecords {
+			c.records = make(map[recordKey]recordEntry)
+		}
+	}
+	c.records[key] = entry
+}
+
+// queryRecords looks up records of qType for domain through s, caching the
+// answers. Bogus answers are dropped if DNSSEC validation is enabled.
+func (c *recordCache) queryRecords(ctx context.Context, s dnssecServer, opts *cacheOptions, dnssec *dnssecOptions, domain string, qType dnsmessage.Type, clientIP net.IP, disableCache bool) ([]dnsmessage.Resource, error) {
+	key := recordKey{domain: queryKey(ctx, domain), qType: qType}
+	if !disableCache {
+		if entry, found := c.getRecords(key); found {
+			markCacheHit(ctx)
+			return entry.result()
+		}
+	}
+
+	req := buildRecordReqMsg(domain, qType, s.newReqID(), dnssec.ednsOptions(ctx, clientIP))
+	resp, err := s.exchange(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	entry, ttl, cacheable, err := parseRecordResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	if dnssec.validator != nil {
+		status, err := dnssec.validator.validate(ctx, s, resp)
+		if status == dnssecBogus {
+			return nil, newError("DNSSEC validation failed for ", domain, " ", qType, " at ", s.Name()).Base(err).AtWarning()
+		}
+		newError(s.Name(), " DNSSEC ", status, " answer: ", domain, " ", qType).AtDebug().WriteToLog()
+		entry.answers = withoutSignatures(entry.answers, qType)
+	}
+	if cacheable {
+		entry.expire = time.Now().Add(opts.clamp(ttl))
+		c.putRecords(key, entry)
+	}
+	return entry.result()
+}
+
+// withoutSignatures drops the RRSIG records that come with answers when the DO
+// bit is set.
+func withoutSignatures(answers []dnsmessage.Resource, qType dnsmessage.Type) []dnsmessage.Resource {
+	const typeRRSIG = dnsmessage.Type(46)
+	if qType == typeRRSIG {
+		return answers
+	}
+	filtered := answers[:0]
+	for _, answer := range answers {
+		if answer.Header.Type != typeRRSIG {
+			filtered = append(filtered, answer)
+		}
+	}
+	return filtered
+}
+
+func buildRecordReqMsg(domain string, qType dnsmessage.Type, reqID uint16, reqOpts *dnsmessage.Resource) *dnsRequest {
+	msg := new(dnsmessage.Message)
+	msg.Header.ID = reqID
+	msg.Header.RecursionDesired = true
+	msg.Questions = []dnsmessage.Question{{
+		Name:  dnsmessage.MustNewName(domain),
+		Type:  qType,
+		Class: dnsmessage.ClassINET,
+	}}
+	if reqOpts != nil {
+		msg.Additionals = append(msg.Additionals, *reqOpts)
+	}
+	return &dnsRequest{
+		reqType: qType,
+		domain:  domain,
+		start:   time.Now(),
+		msg:     msg,
+	}
+}
+
+// parseRecordResponse parses the answers of a response and returns them with
+// the TTL they may be cached for. Negative answers (NXDOMAIN and NODATA) are
+// cached for the TTL of the SOA record in the authority section (RFC 2308).
+// The returned bool is false for responses that must not be cached: failures
+// and negative answers without SOA record.
+func parseRecordResponse(payload []byte) (recordEntry, time.Duration, bool, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(payload)
+	if err != nil {
+		return recordEntry{}, 0, false, newError("failed to parse DNS response").Base(err).AtWarning()
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return recordEntry{}, 0, false, newError("failed to skip questions in DNS response").Base(err).AtWarning()
+	}
+	answers, err := parser.AllAnswers()
+	if err != nil {
+		return recordEntry{}, 0, false, newError("failed to parse answers in DNS response").Base(err).AtWarning()
+	}
+	entry := recordEntry{
+		answers: answers,
+		rcode:   header.RCode,
+	}
+
+	switch {
+	case header.RCode == dnsmessage.RCodeSuccess && len(answers) > 0:
+		ttl := answers[0].Header.TTL
+		for _, answer := range answers[1:] {
+			if answer.Header.TTL < ttl {
+				ttl = answer.Header.TTL
+			}
+		}
+		return entry, time.Duration(ttl) * time.Second, true, nil
+	case header.RCode == dnsmessage.RCodeSuccess, header.RCode == dnsmessage.RCodeNameError:
+		authorities, err := parser.AllAuthorities()
+		if err != nil {
+			return entry, 0, false, nil
+		}
+		for _, authority := range authorities {
+			if soa, ok := authority.Body.(*dnsmessage.SOAResource); ok {
+				ttl := authority.Header.TTL
+				if soa.MinTTL < ttl {
+					ttl = soa.MinTTL
+				}
+				return entry, time.Duration(ttl) * time.Second, true, nil
+			}
+		}
+		return entry, 0, false, nil
+	default:
+		return entry, 0, false, nil
+	}
+}
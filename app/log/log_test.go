@@ -2,9 +2,12 @@ package log_test
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
 
 	"github.com/v2fly/v2ray-core/v4/app/log"
 	"github.com/v2fly/v2ray-core/v4/common"
@@ -50,3 +53,56 @@ func TestCustomLogHandler(t *testing.T) {
 
 	common.Must(logger.Close())
 }
+
+func TestDNSLogHandler(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+
+	var loggedValue []string
+
+	mockHandler := mocks.NewLogHandler(mockCtl)
+	mockHandler.EXPECT().Handle(gomock.Any()).AnyTimes().DoAndReturn(func(msg clog.Message) {
+		loggedValue = append(loggedValue, msg.String())
+	})
+
+	log.RegisterHandlerCreator(log.LogType_Console, func(lt log.LogType, options log.HandlerCreatorOptions) (clog.Handler, error) {
+		return mockHandler, nil
+	})
+
+	logger, err := log.New(context.Background(), &log.Config{
+		Error: &log.LogSpecification{Type: log.LogType_None},
+		Dns:   &log.LogSpecification{Type: log.LogType_Console},
+	})
+	common.Must(err)
+
+	common.Must(logger.Start())
+
+	clog.Record(&clog.GeneralMessage{
+		Severity: clog.Severity_Error,
+		Content:  "test",
+	})
+	clog.Record(&clog.DNSMessage{
+		Server:  "UDP:8.8.8.8:53",
+		Domain:  "v2fly.org",
+		QType:   "A",
+		Answer:  []string{"127.0.0.1"},
+		Latency: 12 * time.Millisecond,
+	})
+	clog.Record(&clog.DNSMessage{
+		Server: "UDP:8.8.8.8:53",
+		Domain: "v2fly.org",
+		QType:  "AAAA",
+		Cached: true,
+		Error:  errors.New("empty response"),
+	})
+
+	expected := []string{
+		"UDP:8.8.8.8:53 v2fly.org A -> [127.0.0.1] 12ms",
+		"UDP:8.8.8.8:53 cache v2fly.org AAAA failed: empty response 0s",
+	}
+	if r := cmp.Diff(expected, loggedValue); r != "" {
+		t.Error(r)
+	}
+
+	common.Must(logger.Close())
+}
@@ -0,0 +1,135 @@
+// Package filesystemstorage implements a persistent storage engine that keeps
+// each value in a file of a directory.
+package filesystemstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/features/extension"
+)
+
+//go:generate go run github.com/v2fly/v2ray-core/v4/common/errors/errorgen
+
+// tempPrefix starts the names of the files being written. Hex encoded keys
+// never contain it.
+const tempPrefix = ".tmp-"
+
+// Storage is a persistent storage engine that keeps each value in a file
+// named after the hex encoded key.
+type Storage struct {
+	access sync.Mutex
+	path   string
+}
+
+// New creates a Storage keeping the files in the directory of config.
+func New(ctx context.Context, config *Config) (*Storage, error) {
+	if config.Path == "" {
+		return nil, newError("path of the persistent storage is not set")
+	}
+	return &Storage{path: config.Path}, nil
+}
+
+// Type implements common.HasType.
+func (*Storage) Type() interface{} {
+	return extension.PersistentStorageEngineType()
+}
+
+// Start implements common.Runnable.
+func (s *Storage) Start() error {
+	if err := os.MkdirAll(s.path, 0o700); err != nil {
+		return newError("failed to create directory ", s.path).Base(err)
+	}
+	return nil
+}
+
+// Close implements common.Closable.
+func (*Storage) Close() error {
+	return nil
+}
+
+// PersistentStorageEngine implements extension.PersistentStorageEngine.
+func (*Storage) PersistentStorageEngine() {}
+
+func (s *Storage) fileName(key []byte) string {
+	return filepath.Join(s.path, hex.EncodeToString(key))
+}
+
+// Put implements extension.PersistentStorageEngine. The value is written to a
+// temporary file first, so that a crash never leaves it half written.
+func (s *Storage) Put(_ context.Context, key []byte, value []byte) error {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	f, err := os.CreateTemp(s.path, tempPrefix)
+	if err != nil {
+		return newError("failed to create file").Base(err)
+	}
+	_, err = f.Write(value)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.fileName(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return newError("failed to write value").Base(err)
+	}
+	return nil
+}
+
+// Get implements extension.PersistentStorageEngine.
+func (s *Storage) Get(_ context.Context, key []byte) ([]byte, error) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	value, err := os.ReadFile(s.fileName(key))
+	if os.IsNotExist(err) {
+		return nil, extension.ErrPersistentStorageKeyNotFound
+	}
+	if err != nil {
+		return nil, newError("failed to read value").Base(err)
+	}
+	return value, nil
+}
+
+// List implements extension.PersistentStorageEngine.
+func (s *Storage) List(_ context.Context, keyPrefix []byte) ([][]byte, error) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, newError("failed to list directory ", s.path).Base(err)
+	}
+	var keys [][]byte
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), tempPrefix) {
+			continue
+		}
+		key, err := hex.DecodeString(entry.Name())
+		if err != nil {
+			continue
+		}
+		if bytes.HasPrefix(key, keyPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func init() {
+	common.Must(common.RegisterConfig((*Config)(nil), func(ctx context.Context, config interface{}) (interface{}, error) {
+		return New(ctx, config.(*Config))
+	}))
+}
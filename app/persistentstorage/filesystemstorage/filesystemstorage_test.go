@@ -0,0 +1,60 @@
+package filesystemstorage_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	. "github.com/v2fly/v2ray-core/v4/app/persistentstorage/filesystemstorage"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/features/extension"
+)
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	config := &Config{Path: t.TempDir() + "/storage"}
+
+	s, err := New(ctx, config)
+	common.Must(err)
+	common.Must(s.Start())
+
+	if _, err := s.Get(ctx, []byte("stats/counters")); err != extension.ErrPersistentStorageKeyNotFound {
+		t.Error("expected key not found, got ", err)
+	}
+
+	common.Must(s.Put(ctx, []byte("stats/counters"), []byte("1")))
+	common.Must(s.Put(ctx, []byte("dns/cache/a"), []byte("2")))
+	common.Must(s.Put(ctx, []byte("dns/cache/b"), []byte("3")))
+	common.Must(s.Put(ctx, []byte("dns/cache/a"), []byte("4")))
+	common.Must(s.Close())
+
+	// Values outlast the engine.
+	s, err = New(ctx, config)
+	common.Must(err)
+	common.Must(s.Start())
+
+	value, err := s.Get(ctx, []byte("dns/cache/a"))
+	common.Must(err)
+	if string(value) != "4" {
+		t.Error("expected the last value, got ", string(value))
+	}
+
+	keys, err := s.List(ctx, []byte("dns/"))
+	common.Must(err)
+	var names []string
+	for _, key := range keys {
+		names = append(names, string(key))
+	}
+	sort.Strings(names)
+	if r := cmp.Diff(names, []string{"dns/cache/a", "dns/cache/b"}); r != "" {
+		t.Error(r)
+	}
+}
+
+func TestStorageWithoutPath(t *testing.T) {
+	if _, err := New(context.Background(), &Config{}); err == nil {
+		t.Error("expected error for empty path")
+	}
+}
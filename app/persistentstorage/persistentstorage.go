@@ -0,0 +1,34 @@
+// Package persistentstorage contains the helpers of the features that keep
+// their state in the persistent storage engine.
+package persistentstorage
+
+import (
+	"context"
+
+	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/common/errors"
+	"github.com/v2fly/v2ray-core/v4/features/extension"
+)
+
+// FromContext returns the persistent storage engine of the V2Ray instance in
+// ctx, or nil if there is none.
+func FromContext(ctx context.Context) extension.PersistentStorageEngine {
+	if ctx == nil {
+		return nil
+	}
+	v := core.FromContext(ctx)
+	if v == nil {
+		return nil
+	}
+	storage, _ := v.GetFeature(extension.PersistentStorageEngineType()).(extension.PersistentStorageEngine)
+	return storage
+}
+
+// IsNotFound reports whether the value returned by Get means that nothing
+// was saved under the key, as opposed to a failure to read it.
+func IsNotFound(value []byte, err error) bool {
+	if err != nil {
+		return errors.Cause(err) == extension.ErrPersistentStorageKeyNotFound
+	}
+	return len(value) == 0
+}
@@ -73,6 +73,7 @@ func (p *Policy) ToCorePolicy() policy.Session {
 	if p.Stats != nil {
 		cp.Stats.UserUplink = p.Stats.UserUplink
 		cp.Stats.UserDownlink = p.Stats.UserDownlink
+		cp.Stats.UserOnline = p.Stats.UserOnline
 	}
 	if p.Buffer != nil {
 		cp.Buffer.PerConnection = p.Buffer.Connection
@@ -88,6 +89,12 @@ func (p *SystemPolicy) ToCorePolicy() policy.System {
 			InboundDownlink:  p.Stats.InboundDownlink,
 			OutboundUplink:   p.Stats.OutboundUplink,
 			OutboundDownlink: p.Stats.OutboundDownlink,
+			RuleHits:         p.Stats.RuleHits,
+			RuleUplink:       p.Stats.RuleUplink,
+			RuleDownlink:     p.Stats.RuleDownlink,
+			InboundLatency:   p.Stats.InboundLatency,
+			OutboundLatency:  p.Stats.OutboundLatency,
+			DNSServer:        p.Stats.DnsServer,
 		},
 	}
 }
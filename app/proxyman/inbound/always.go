@@ -65,6 +65,7 @@ func NewAlwaysOnInboundHandler(ctx context.Context, tag string, receiverConfig *
 	}
 
 	uplinkCounter, downlinkCounter := getStatCounter(core.MustFromContext(ctx), tag)
+	sessionMetrics := getSessionMetrics(core.MustFromContext(ctx), tag)
 
 	nl := p.Network()
 	pr := receiverConfig.PortRange
@@ -100,6 +101,7 @@ func NewAlwaysOnInboundHandler(ctx context.Context, tag string, receiverConfig *
 				sniffingConfig:  receiverConfig.GetEffectiveSniffingSettings(),
 				uplinkCounter:   uplinkCounter,
 				downlinkCounter: downlinkCounter,
+				sessionMetrics:  sessionMetrics,
 				ctx:             ctx,
 			}
 			h.workers = append(h.workers, worker)
@@ -121,6 +123,7 @@ func NewAlwaysOnInboundHandler(ctx context.Context, tag string, receiverConfig *
 					sniffingConfig:  receiverConfig.GetEffectiveSniffingSettings(),
 					uplinkCounter:   uplinkCounter,
 					downlinkCounter: downlinkCounter,
+					sessionMetrics:  sessionMetrics,
 					ctx:             ctx,
 				}
 				h.workers = append(h.workers, worker)
@@ -115,6 +115,7 @@ func (h *DynamicInboundHandler) refresh() error {
 	}
 
 	uplinkCounter, downlinkCounter := getStatCounter(h.v, h.tag)
+	sessionMetrics := getSessionMetrics(h.v, h.tag)
 
 	for i := uint32(0); i < concurrency; i++ {
 		port := h.allocatePort()
@@ -137,6 +138,7 @@ func (h *DynamicInboundHandler) refresh() error {
 				sniffingConfig:  h.receiverConfig.GetEffectiveSniffingSettings(),
 				uplinkCounter:   uplinkCounter,
 				downlinkCounter: downlinkCounter,
+				sessionMetrics:  sessionMetrics,
 				ctx:             h.ctx,
 			}
 			if err := worker.Start(); err != nil {
@@ -0,0 +1,83 @@
+package inbound
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/features/policy"
+	"github.com/v2fly/v2ray-core/v4/features/routing"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
+	"github.com/v2fly/v2ray-core/v4/transport"
+)
+
+// sessionMetrics are the stats of the stream sessions of an inbound: the
+// handshake latency in milliseconds, the session duration in seconds, and the
+// number of sessions in progress.
+type sessionMetrics struct {
+	handshake stats.Histogram
+	duration  stats.Histogram
+	active    stats.Gauge
+}
+
+// getSessionMetrics returns the session metrics of the tagged inbound, or nil
+// if they are disabled by the system policy, or the stats manager does not
+// support histograms and gauges.
+func getSessionMetrics(v *core.Instance, tag string) *sessionMetrics {
+	policyManager := v.GetFeature(policy.ManagerType()).(policy.Manager)
+	if len(tag) == 0 || !policyManager.ForSystem().Stats.InboundLatency {
+		return nil
+	}
+	statsManager := v.GetFeature(stats.ManagerType()).(stats.Manager)
+	prefix := "inbound>>>" + tag
+	handshake, err := stats.GetOrRegisterHistogram(statsManager, prefix+">>>latency>>>handshake", stats.LatencyBuckets)
+	if err != nil {
+		return nil
+	}
+	duration, err := stats.GetOrRegisterHistogram(statsManager, prefix+">>>session>>>duration", stats.DurationBuckets)
+	if err != nil {
+		return nil
+	}
+	active, err := stats.GetOrRegisterGauge(statsManager, prefix+">>>sessions")
+	if err != nil {
+		return nil
+	}
+	return &sessionMetrics{
+		handshake: handshake,
+		duration:  duration,
+		active:    active,
+	}
+}
+
+// track records the start of a session. It returns the dispatcher to handle
+// the session with, and the function to call once the session ends.
+func (m *sessionMetrics) track(dispatcher routing.Dispatcher) (routing.Dispatcher, func()) {
+	if m == nil {
+		return dispatcher, func() {}
+	}
+	start := time.Now()
+	m.active.Add(1)
+	return &handshakeDispatcher{Dispatcher: dispatcher, start: start, latency: m.handshake}, func() {
+		m.active.Add(-1)
+		stats.ObserveDuration(m.duration, time.Since(start), time.Second)
+	}
+}
+
+// handshakeDispatcher records the handshake latency of a session, the time
+// from accepting the connection to its first dispatch.
+type handshakeDispatcher struct {
+	routing.Dispatcher
+	start   time.Time
+	latency stats.Histogram
+	once    sync.Once
+}
+
+// Dispatch implements routing.Dispatcher.
+func (d *handshakeDispatcher) Dispatch(ctx context.Context, dest net.Destination) (*transport.Link, error) {
+	d.once.Do(func() {
+		stats.ObserveDuration(d.latency, time.Since(d.start), time.Millisecond)
+	})
+	return d.Dispatcher.Dispatch(ctx, dest)
+}
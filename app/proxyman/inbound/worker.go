@@ -41,6 +41,7 @@ type tcpWorker struct {
 	sniffingConfig  *proxyman.SniffingConfig
 	uplinkCounter   stats.Counter
 	downlinkCounter stats.Counter
+	sessionMetrics  *sessionMetrics
 
 	hub internet.Listener
 
@@ -97,7 +98,9 @@ func (w *tcpWorker) callback(conn internet.Connection) {
 			WriteCounter: w.downlinkCounter,
 		}
 	}
-	if err := w.proxy.Process(ctx, net.Network_TCP, conn, w.dispatcher); err != nil {
+	dispatcher, done := w.sessionMetrics.track(w.dispatcher)
+	defer done()
+	if err := w.proxy.Process(ctx, net.Network_TCP, conn, dispatcher); err != nil {
 		newError("connection ends").Base(err).WriteToLog(session.ExportIDToError(ctx))
 	}
 	cancel()
@@ -431,6 +434,7 @@ type dsWorker struct {
 	sniffingConfig  *proxyman.SniffingConfig
 	uplinkCounter   stats.Counter
 	downlinkCounter stats.Counter
+	sessionMetrics  *sessionMetrics
 
 	hub internet.Listener
 
@@ -461,7 +465,9 @@ func (w *dsWorker) callback(conn internet.Connection) {
 			WriteCounter: w.downlinkCounter,
 		}
 	}
-	if err := w.proxy.Process(ctx, net.Network_UNIX, conn, w.dispatcher); err != nil {
+	dispatcher, done := w.sessionMetrics.track(w.dispatcher)
+	defer done()
+	if err := w.proxy.Process(ctx, net.Network_UNIX, conn, dispatcher); err != nil {
 		newError("connection ends").Base(err).WriteToLog(session.ExportIDToError(ctx))
 	}
 	cancel()
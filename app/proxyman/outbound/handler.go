@@ -2,6 +2,7 @@ package outbound
 
 import (
 	"context"
+	"time"
 
 	core "github.com/v2fly/v2ray-core/v4"
 	"github.com/v2fly/v2ray-core/v4/app/proxyman"
@@ -45,6 +46,19 @@ func getStatCounter(v *core.Instance, tag string) (stats.Counter, stats.Counter)
 	return uplinkCounter, downlinkCounter
 }
 
+// getDialLatency returns the histogram of the dial latency of the tagged
+// outbound in milliseconds, or nil if it is disabled by the system policy, or
+// the stats manager does not support histograms.
+func getDialLatency(v *core.Instance, tag string) stats.Histogram {
+	policy := v.GetFeature(policy.ManagerType()).(policy.Manager)
+	if len(tag) == 0 || !policy.ForSystem().Stats.OutboundLatency {
+		return nil
+	}
+	statsManager := v.GetFeature(stats.ManagerType()).(stats.Manager)
+	h, _ := stats.GetOrRegisterHistogram(statsManager, "outbound>>>"+tag+">>>latency>>>dial", stats.LatencyBuckets)
+	return h
+}
+
 // Handler is an implements of outbound.Handler.
 type Handler struct {
 	tag             string
@@ -55,6 +69,7 @@ type Handler struct {
 	mux             *mux.ClientManager
 	uplinkCounter   stats.Counter
 	downlinkCounter stats.Counter
+	dialLatency     stats.Histogram
 }
 
 // NewHandler create a new Handler based on the given configuration.
@@ -66,6 +81,7 @@ func NewHandler(ctx context.Context, config *core.OutboundHandlerConfig) (outbou
 		outboundManager: v.GetFeature(outbound.ManagerType()).(outbound.Manager),
 		uplinkCounter:   uplinkCounter,
 		downlinkCounter: downlinkCounter,
+		dialLatency:     getDialLatency(v, config.Tag),
 	}
 
 	if config.SenderSettings != nil {
@@ -208,7 +224,11 @@ func (h *Handler) Dial(ctx context.Context, dest net.Destination) (internet.Conn
 		ctx = session.SetTransportLayerProxyTagToContext(ctx, tag)
 	}
 
+	start := time.Now()
 	conn, err := internet.Dial(ctx, dest, h.streamSettings)
+	if err == nil && h.dialLatency != nil {
+		stats.ObserveDuration(h.dialLatency, time.Since(start), time.Millisecond)
+	}
 	return h.getStatCouterConnection(conn), err
 }
 
@@ -14,6 +14,7 @@ import (
 	"github.com/v2fly/v2ray-core/v4/common/net"
 	"github.com/v2fly/v2ray-core/v4/common/serial"
 	"github.com/v2fly/v2ray-core/v4/features/outbound"
+	feature_stats "github.com/v2fly/v2ray-core/v4/features/stats"
 	"github.com/v2fly/v2ray-core/v4/proxy/freedom"
 	"github.com/v2fly/v2ray-core/v4/transport/internet"
 )
@@ -82,3 +83,32 @@ func TestOutboundWithStatCounter(t *testing.T) {
 		t.Errorf("Expected conn to be StatCouterConnection")
 	}
 }
+
+func TestOutboundDialLatency(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		config := &core.Config{
+			App: []*anypb.Any{
+				serial.ToTypedMessage(&stats.Config{}),
+				serial.ToTypedMessage(&policy.Config{
+					System: &policy.SystemPolicy{
+						Stats: &policy.SystemPolicy_Stats{
+							OutboundLatency: enabled,
+						},
+					},
+				}),
+			},
+		}
+
+		v, _ := core.New(config)
+		v.AddFeature((outbound.Manager)(new(Manager)))
+		ctx := toContext(context.Background(), v)
+		NewHandler(ctx, &core.OutboundHandlerConfig{
+			Tag:           "tag",
+			ProxySettings: serial.ToTypedMessage(&freedom.Config{}),
+		})
+		m := v.GetFeature(feature_stats.ManagerType()).(*stats.Manager)
+		if registered := m.GetHistogram("outbound>>>tag>>>latency>>>dial") != nil; registered != enabled {
+			t.Error("expected dial latency registered to be ", enabled, ", got ", registered)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package restful_api
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/app/observatory"
+	"github.com/v2fly/v2ray-core/v4/app/stats"
+	"github.com/v2fly/v2ray-core/v4/features/extension"
+	feature_stats "github.com/v2fly/v2ray-core/v4/features/stats"
+)
+
+// metricFamily is a metric of the Prometheus text format with all its
+// samples.
+type metricFamily struct {
+	name    string
+	typ     string
+	help    string
+	samples []metricSample
+}
+
+type metricSample struct {
+	suffix string   // of the name, for the series of histograms
+	labels []string // name and value pairs
+	value  float64
+}
+
+// metricSet collects the metric families of a scrape.
+type metricSet map[string]*metricFamily
+
+func (s metricSet) family(name string, typ string, help string) *metricFamily {
+	family, found := s[name]
+	if !found {
+		family = &metricFamily{name: name, typ: typ, help: help}
+		s[name] = family
+	}
+	return family
+}
+
+func (s metricSet) add(name string, typ string, help string, value float64, labels ...string) {
+	family := s.family(name, typ, help)
+	family.samples = append(family.samples, metricSample{labels: labels, value: value})
+}
+
+// write writes the metrics in the Prometheus text format, sorted by name.
+func (s metricSet) write(w io.Writer) error {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		family := s[name]
+		bw.WriteString("# HELP " + name + " " + family.help + "\n")
+		bw.WriteString("# TYPE " + name + " " + family.typ + "\n")
+		for _, sample := range family.samples {
+			bw.WriteString(name + sample.suffix)
+			if len(sample.labels) > 0 {
+				bw.WriteByte('{')
+				for i := 0; i+1 < len(sample.labels); i += 2 {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(sample.labels[i] + "=\"" + escapeLabelValue(sample.labels[i+1]) + "\"")
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatMetricValue(sample.value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatMetricValue(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// trafficLabels are the labels of the tags in the names of traffic counters.
+var trafficLabels = map[string]string{
+	"inbound":  "tag",
+	"outbound": "tag",
+	"user":     "user",
+	"rule":     "rule",
+}
+
+// addCounter adds the stats counter of the given name, with the labels
+// encoded in the name as in "inbound>>>tag>>>traffic>>>uplink". Counters that
+// are not known are exported with their name as a label.
+func (s metricSet) addCounter(name string, value int64) {
+	parts := strings.Split(name, ">>>")
+	switch {
+	case len(parts) == 4 && parts[2] == "traffic" && trafficLabels[parts[0]] != "":
+		s.add("v2ray_"+parts[0]+"_traffic_bytes_total", "counter", "Traffic of "+parts[0]+"s in bytes.", float64(value),
+			trafficLabels[parts[0]], parts[1], "direction", parts[3])
+		return
+	case len(parts) == 3 && parts[0] == "rule" && parts[2] == "hits":
+		s.add("v2ray_rule_hits_total", "counter", "Number of connections matched by routing rules.", float64(value),
+			"rule", parts[1])
+		return
+	case len(parts) == 3 && parts[0] == "dns":
+		s.add("v2ray_dns_"+parts[2]+"_total", "counter", "Number of "+strings.ReplaceAll(parts[2], "_", " ")+" of name servers.", float64(value),
+			"server", parts[1])
+		return
+	}
+	s.add("v2ray_stats_counter", "untyped", "Stats counters without a known layout.", float64(value), "name", name)
+}
+
+func (s metricSet) addCounters(manager feature_stats.Manager) {
+	m, ok := manager.(*stats.Manager)
+	if !ok {
+		return
+	}
+	m.VisitCounters(func(name string, c feature_stats.Counter) bool {
+		s.addCounter(name, c.Value())
+		return true
+	})
+}
+
+// addGauge adds the stats gauge of the given name, like addCounter.
+func (s metricSet) addGauge(name string, value int64) {
+	parts := strings.Split(name, ">>>")
+	switch {
+	case len(parts) == 3 && parts[0] == "inbound" && parts[2] == "sessions":
+		s.add("v2ray_inbound_sessions", "gauge", "Number of sessions in progress of inbounds.", float64(value),
+			"tag", parts[1])
+		return
+	case len(parts) == 3 && parts[0] == "dns" && parts[2] == "latency_avg_ms":
+		s.add("v2ray_dns_latency_average_milliseconds", "gauge", "Moving average latency of name servers in milliseconds, counting failures as timeouts.", float64(value),
+			"server", parts[1])
+		return
+	}
+	s.add("v2ray_stats_gauge", "gauge", "Stats gauges without a known layout.", float64(value), "name", name)
+}
+
+// histogramFamilies are the metrics of the known histograms, by the layout of
+// their names with the tag replaced by "*", and the label of the tag.
+var histogramFamilies = map[string]struct{ name, help, label string }{
+	"inbound>>>*>>>latency>>>handshake": {"v2ray_inbound_handshake_latency_milliseconds", "Handshake latency of inbounds in milliseconds.", "tag"},
+	"inbound>>>*>>>session>>>duration":  {"v2ray_inbound_session_duration_seconds", "Duration of sessions of inbounds in seconds.", "tag"},
+	"outbound>>>*>>>latency>>>dial":     {"v2ray_outbound_dial_latency_milliseconds", "Dial latency of outbounds in milliseconds.", "tag"},
+	"dns>>>*>>>latency":                 {"v2ray_dns_query_latency_milliseconds", "Latency of queries to name servers in milliseconds.", "server"},
+}
+
+// addHistogram adds the stats histogram of the given name, like addCounter.
+func (s metricSet) addHistogram(name string, snapshot *feature_stats.HistogramSnapshot) {
+	var family *metricFamily
+	var labels []string
+	parts := strings.Split(name, ">>>")
+	if len(parts) > 2 {
+		layout := parts[0] + ">>>*>>>" + strings.Join(parts[2:], ">>>")
+		if known, found := histogramFamilies[layout]; found {
+			family = s.family(known.name, "histogram", known.help)
+			labels = []string{known.label, parts[1]}
+		}
+	}
+	if family == nil {
+		family = s.family("v2ray_stats_histogram", "histogram", "Stats histograms without a known layout.")
+		labels = []string{"name", name}
+	}
+
+	bucket := func(le string, count uint64) metricSample {
+		return metricSample{suffix: "_bucket", labels: append(append([]string{}, labels...), "le", le), value: float64(count)}
+	}
+	for i, bound := range snapshot.Buckets {
+		family.samples = append(family.samples, bucket(formatMetricValue(bound), snapshot.Counts[i]))
+	}
+	family.samples = append(family.samples,
+		bucket("+Inf", snapshot.Count),
+		metricSample{suffix: "_sum", labels: labels, value: snapshot.Sum},
+		metricSample{suffix: "_count", labels: labels, value: float64(snapshot.Count)},
+	)
+}
+
+func (s metricSet) addGauges(manager feature_stats.Manager) {
+	m, ok := manager.(*stats.Manager)
+	if !ok {
+		return
+	}
+	m.VisitGauges(func(name string, g feature_stats.Gauge) bool {
+		s.addGauge(name, g.Value())
+		return true
+	})
+}
+
+func (s metricSet) addHistograms(manager feature_stats.Manager) {
+	m, ok := manager.(*stats.Manager)
+	if !ok {
+		return
+	}
+	m.VisitHistograms(func(name string, h feature_stats.Histogram) bool {
+		s.addHistogram(name, h.Snapshot())
+		return true
+	})
+}
+
+func (s metricSet) addObservation(result *observatory.ObservationResult) {
+	for _, status := range result.Status {
+		alive := 0.0
+		if status.Alive {
+			alive = 1
+		}
+		s.add("v2ray_observatory_alive", "gauge", "Whether outbounds are alive according to the observatory.", alive,
+			"outbound", status.OutboundTag)
+		s.add("v2ray_observatory_delay_milliseconds", "gauge", "Delay of outbounds measured by the observatory in milliseconds.", float64(status.Delay),
+			"outbound", status.OutboundTag)
+	}
+}
+
+func (s metricSet) addRuntime(startTime time.Time) {
+	var rtm runtime.MemStats
+	runtime.ReadMemStats(&rtm)
+
+	s.add("v2ray_uptime_seconds", "gauge", "Time since V2Ray started in seconds.", time.Since(startTime).Truncate(time.Second).Seconds())
+	s.add("v2ray_goroutines", "gauge", "Number of goroutines.", float64(runtime.NumGoroutine()))
+	s.add("v2ray_memstats_alloc_bytes", "gauge", "Bytes of allocated heap objects.", float64(rtm.Alloc))
+	s.add("v2ray_memstats_alloc_bytes_total", "counter", "Cumulative bytes allocated for heap objects.", float64(rtm.TotalAlloc))
+	s.add("v2ray_memstats_sys_bytes", "gauge", "Bytes of memory obtained from the OS.", float64(rtm.Sys))
+	s.add("v2ray_memstats_mallocs_total", "counter", "Cumulative count of heap objects allocated.", float64(rtm.Mallocs))
+	s.add("v2ray_memstats_frees_total", "counter", "Cumulative count of heap objects freed.", float64(rtm.Frees))
+	s.add("v2ray_memstats_live_objects", "gauge", "Number of live heap objects.", float64(rtm.Mallocs-rtm.Frees))
+	s.add("v2ray_memstats_gc_total", "counter", "Number of completed GC cycles.", float64(rtm.NumGC))
+	s.add("v2ray_memstats_gc_pause_seconds_total", "counter", "Cumulative time spent in GC pauses in seconds.", float64(rtm.PauseTotalNs)/1e9)
+}
+
+func (rs *restfulService) metrics(w http.ResponseWriter, r *http.Request) {
+	set := metricSet{}
+	set.addCounters(rs.stats)
+	set.addGauges(rs.stats)
+	set.addHistograms(rs.stats)
+	if instance := core.FromContext(rs.ctx); instance != nil {
+		if observer, ok := instance.GetFeature(extension.ObservatoryType()).(extension.Observatory); ok {
+			if result, err := observer.GetObservation(r.Context()); err == nil {
+				if result, ok := result.(*observatory.ObservationResult); ok {
+					set.addObservation(result)
+				}
+			}
+		}
+	}
+	set.addRuntime(rs.startTime)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := set.write(w); err != nil {
+		newError("failed to write metrics").Base(err).WriteToLog()
+	}
+}
@@ -0,0 +1,107 @@
+package restful_api
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/v2fly/v2ray-core/v4/app/observatory"
+	"github.com/v2fly/v2ray-core/v4/app/stats"
+	"github.com/v2fly/v2ray-core/v4/common"
+	feature_stats "github.com/v2fly/v2ray-core/v4/features/stats"
+)
+
+func TestMetricsCounters(t *testing.T) {
+	manager, err := stats.NewManager(context.Background(), &stats.Config{})
+	common.Must(err)
+	counters := map[string]int64{
+		"inbound>>>socks>>>traffic>>>uplink":      100,
+		"user>>>a@v2fly.org>>>traffic>>>downlink": 200,
+		"rule>>>direct>>>hits":                    3,
+		"dns>>>UDP:8.8.8.8:53>>>queries":          4,
+		"custom\"counter":                         5,
+	}
+	for name, value := range counters {
+		c, err := feature_stats.GetOrRegisterCounter(manager, name)
+		common.Must(err)
+		c.Set(value)
+	}
+
+	rs := &restfulService{stats: manager, startTime: time.Now(), ctx: context.Background()}
+	w := httptest.NewRecorder()
+	rs.metrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	for _, line := range []string{
+		"# TYPE v2ray_inbound_traffic_bytes_total counter",
+		`v2ray_inbound_traffic_bytes_total{tag="socks",direction="uplink"} 100`,
+		`v2ray_user_traffic_bytes_total{user="a@v2fly.org",direction="downlink"} 200`,
+		`v2ray_rule_hits_total{rule="direct"} 3`,
+		`v2ray_dns_queries_total{server="UDP:8.8.8.8:53"} 4`,
+		`v2ray_stats_counter{name="custom\"counter"} 5`,
+		"# TYPE v2ray_goroutines gauge",
+	} {
+		assert.Contains(t, strings.Split(body, "\n"), line)
+	}
+}
+
+func TestMetricsObservation(t *testing.T) {
+	set := metricSet{}
+	set.addObservation(&observatory.ObservationResult{
+		Status: []*observatory.OutboundStatus{
+			{OutboundTag: "proxy", Alive: true, Delay: 120},
+			{OutboundTag: "backup", Alive: false, Delay: 99999999},
+		},
+	})
+	b := new(strings.Builder)
+	common.Must(set.write(b))
+	assert.Equal(t, `# HELP v2ray_observatory_alive Whether outbounds are alive according to the observatory.
+# TYPE v2ray_observatory_alive gauge
+v2ray_observatory_alive{outbound="proxy"} 1
+v2ray_observatory_alive{outbound="backup"} 0
+# HELP v2ray_observatory_delay_milliseconds Delay of outbounds measured by the observatory in milliseconds.
+# TYPE v2ray_observatory_delay_milliseconds gauge
+v2ray_observatory_delay_milliseconds{outbound="proxy"} 120
+v2ray_observatory_delay_milliseconds{outbound="backup"} 99999999
+`, b.String())
+}
+
+func TestMetricsHistograms(t *testing.T) {
+	manager, err := stats.NewManager(context.Background(), &stats.Config{})
+	common.Must(err)
+	h, err := manager.RegisterHistogram("outbound>>>proxy>>>latency>>>dial", []float64{10, 100})
+	common.Must(err)
+	h.Observe(5)
+	h.Observe(50)
+	h.Observe(500)
+	g, err := manager.RegisterGauge("inbound>>>socks>>>sessions")
+	common.Must(err)
+	g.Set(3)
+	g, err = manager.RegisterGauge("dns>>>UDP:8.8.8.8:53>>>latency_avg_ms")
+	common.Must(err)
+	g.Set(25)
+
+	set := metricSet{}
+	set.addGauges(manager)
+	set.addHistograms(manager)
+	b := new(strings.Builder)
+	common.Must(set.write(b))
+	assert.Equal(t, `# HELP v2ray_dns_latency_average_milliseconds Moving average latency of name servers in milliseconds, counting failures as timeouts.
+# TYPE v2ray_dns_latency_average_milliseconds gauge
+v2ray_dns_latency_average_milliseconds{server="UDP:8.8.8.8:53"} 25
+# HELP v2ray_inbound_sessions Number of sessions in progress of inbounds.
+# TYPE v2ray_inbound_sessions gauge
+v2ray_inbound_sessions{tag="socks"} 3
+# HELP v2ray_outbound_dial_latency_milliseconds Dial latency of outbounds in milliseconds.
+# TYPE v2ray_outbound_dial_latency_milliseconds histogram
+v2ray_outbound_dial_latency_milliseconds_bucket{tag="proxy",le="10"} 1
+v2ray_outbound_dial_latency_milliseconds_bucket{tag="proxy",le="100"} 2
+v2ray_outbound_dial_latency_milliseconds_bucket{tag="proxy",le="+Inf"} 3
+v2ray_outbound_dial_latency_milliseconds_sum{tag="proxy"} 555
+v2ray_outbound_dial_latency_milliseconds_count{tag="proxy"} 3
+`, b.String())
+}
@@ -2,7 +2,9 @@ package restful_api
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -10,7 +12,9 @@ import (
 	"github.com/go-playground/validator/v10"
 
 	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/app/stats"
 	"github.com/v2fly/v2ray-core/v4/common/net"
+	feature_stats "github.com/v2fly/v2ray-core/v4/features/stats"
 	"github.com/v2fly/v2ray-core/v4/transport/internet"
 )
 
@@ -47,6 +51,108 @@ func (rs *restfulService) tagStats(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+type HistoryBound struct {
+	// Interval is the length of the buckets in seconds.
+	Interval int64 `json:"interval"`
+	// Start is the Unix time the first bucket starts at.
+	Start int64 `json:"start"`
+	// Uplink and Downlink are the byte rates per second in the buckets,
+	// oldest first.
+	Uplink   []float64 `json:"uplink"`
+	Downlink []float64 `json:"downlink"`
+}
+
+func (rs *restfulService) tagHistory(w http.ResponseWriter, r *http.Request) {
+	boundType := chi.URLParam(r, "bound_type")
+	tag := chi.URLParam(r, "tag")
+	interval := r.URL.Query().Get("interval")
+
+	var seconds int64
+	if interval != "" {
+		var err error
+		if seconds, err = strconv.ParseInt(interval, 10, 64); err != nil || seconds <= 0 {
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, render.M{})
+			return
+		}
+	}
+
+	if validate.Var(boundType, "required,oneof=inbounds outbounds users") != nil ||
+		validate.Var(tag, "required,min=1,max=255") != nil {
+		render.Status(r, http.StatusUnprocessableEntity)
+		render.JSON(w, r, render.M{})
+		return
+	}
+
+	manager, ok := rs.stats.(*stats.Manager)
+	if !ok {
+		render.Status(r, http.StatusNotImplemented)
+		render.JSON(w, r, render.M{})
+		return
+	}
+	bound := boundType[:len(boundType)-1]
+	histories := manager.GetCounterHistories(time.Duration(seconds)*time.Second,
+		bound+">>>"+tag+">>>traffic>>>uplink", bound+">>>"+tag+">>>traffic>>>downlink")
+	if histories == nil {
+		render.Status(r, http.StatusNotFound)
+		render.JSON(w, r, render.M{})
+		return
+	}
+	up, down := histories[0], histories[1]
+
+	render.JSON(w, r, &HistoryBound{
+		Interval: int64(up.Interval / time.Second),
+		Start:    up.Start.Unix(),
+		Uplink:   up.Rates(),
+		Downlink: down.Rates(),
+	})
+}
+
+type OnlineIP struct {
+	IP          string `json:"ip"`
+	Since       int64  `json:"since"`
+	Connections int    `json:"connections"`
+}
+
+type OnlineUser struct {
+	Email       string      `json:"email"`
+	Since       int64       `json:"since"`
+	Connections int         `json:"connections"`
+	IPs         []*OnlineIP `json:"ips"`
+}
+
+func (rs *restfulService) onlineUsers(w http.ResponseWriter, r *http.Request) {
+	tracker, ok := rs.stats.(feature_stats.OnlineTracker)
+	if !ok {
+		render.Status(r, http.StatusNotImplemented)
+		render.JSON(w, r, render.M{})
+		return
+	}
+
+	email := r.URL.Query().Get("user")
+	users := make([]*OnlineUser, 0)
+	for _, u := range tracker.GetOnlineUsers() {
+		if email != "" && u.Email != email {
+			continue
+		}
+		user := &OnlineUser{
+			Email:       u.Email,
+			Since:       u.Since.Unix(),
+			Connections: u.Connections,
+		}
+		for _, ip := range u.IPs {
+			user.IPs = append(user.IPs, &OnlineIP{
+				IP:          ip.IP.String(),
+				Since:       ip.Since.Unix(),
+				Connections: ip.Connections,
+			})
+		}
+		users = append(users, user)
+	}
+
+	render.JSON(w, r, render.M{"users": users})
+}
+
 func (rs *restfulService) version(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, render.M{"version": core.Version()})
 }
@@ -75,8 +181,23 @@ func (rs *restfulService) start() error {
 	validate = validator.New()
 	r.Route("/v1", func(r chi.Router) {
 		r.Get("/{bound_type}/{tag}/stats", rs.tagStats)
+		r.Get("/{bound_type}/{tag}/history", rs.tagHistory)
+		r.Group(func(r chi.Router) {
+			if rs.config.AuthToken != "" {
+				r.Use(rs.TokenAuthMiddleware)
+			}
+			r.Get("/users/online", rs.onlineUsers)
+		})
 	})
 	r.Get("/version", rs.version)
+	if rs.config.EnableMetrics {
+		r.Group(func(r chi.Router) {
+			if rs.config.AuthToken != "" {
+				r.Use(rs.TokenAuthMiddleware)
+			}
+			r.Get("/metrics", rs.metrics)
+		})
+	}
 
 	var listener net.Listener
 	var err error
@@ -0,0 +1,46 @@
+package restful_api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+
+	"github.com/v2fly/v2ray-core/v4/app/stats"
+	"github.com/v2fly/v2ray-core/v4/common"
+)
+
+func TestTagHistoryInterval(t *testing.T) {
+	manager, err := stats.NewManager(context.Background(), &stats.Config{
+		History: &stats.HistoryConfig{},
+	})
+	common.Must(err)
+	_, err = manager.RegisterCounter("inbound>>>socks>>>traffic>>>uplink")
+	common.Must(err)
+	_, err = manager.RegisterCounter("inbound>>>socks>>>traffic>>>downlink")
+	common.Must(err)
+
+	validate = validator.New()
+	rs := &restfulService{stats: manager, ctx: context.Background()}
+	r := chi.NewRouter()
+	r.Get("/v1/{bound_type}/{tag}/history", rs.tagHistory)
+
+	for interval, status := range map[string]int{
+		"":    http.StatusOK,
+		"60":  http.StatusOK,
+		"0":   http.StatusUnprocessableEntity,
+		"-60": http.StatusUnprocessableEntity,
+		"1.5": http.StatusUnprocessableEntity,
+		"abc": http.StatusUnprocessableEntity,
+		"7":   http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/inbounds/socks/history?interval="+interval, nil))
+		if w.Code != status {
+			t.Error("interval ", interval, ": expected status ", status, ", got ", w.Code)
+		}
+	}
+}
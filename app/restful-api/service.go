@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 	"sync"
+	"time"
 
 	core "github.com/v2fly/v2ray-core/v4"
 	"github.com/v2fly/v2ray-core/v4/features"
@@ -17,7 +18,8 @@ type restfulService struct {
 	config   *Config
 	access   sync.Mutex
 
-	stats feature_stats.Manager
+	stats     feature_stats.Manager
+	startTime time.Time
 
 	ctx context.Context
 }
@@ -49,6 +51,7 @@ func (rs *restfulService) init(config *Config, stats feature_stats.Manager) {
 func newRestfulService(ctx context.Context, config *Config) (features.Feature, error) {
 	r := new(restfulService)
 	r.ctx = ctx
+	r.startTime = time.Now()
 	if err := core.RequireFeatures(ctx, func(stats feature_stats.Manager) {
 		r.init(config, stats)
 	}); err != nil {
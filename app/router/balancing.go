@@ -6,14 +6,23 @@ package router
 import (
 	"context"
 
+	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/app/observatory"
+	"github.com/v2fly/v2ray-core/v4/features"
 	"github.com/v2fly/v2ray-core/v4/features/extension"
 	"github.com/v2fly/v2ray-core/v4/features/outbound"
+	"github.com/v2fly/v2ray-core/v4/features/routing"
 )
 
 type BalancingStrategy interface {
 	PickOutbound([]string) string
 }
 
+// ContextualBalancingStrategy is a BalancingStrategy that picks outbounds according to the routing context.
+type ContextualBalancingStrategy interface {
+	PickOutboundWithContext(ctx routing.Context, candidates []string) string
+}
+
 type BalancingPrincipleTarget interface {
 	GetPrincipleTarget([]string) []string
 }
@@ -29,6 +38,11 @@ type Balancer struct {
 
 // PickOutbound picks the tag of a outbound
 func (b *Balancer) PickOutbound() (string, error) {
+	return b.PickOutboundWithContext(nil)
+}
+
+// PickOutboundWithContext picks the tag of a outbound for the routing context
+func (b *Balancer) PickOutboundWithContext(ctx routing.Context) (string, error) {
 	candidates, err := b.SelectOutbounds()
 	if err != nil {
 		if b.fallbackTag != "" {
@@ -40,6 +54,8 @@ func (b *Balancer) PickOutbound() (string, error) {
 	var tag string
 	if o := b.override.Get(); o != "" {
 		tag = o
+	} else if s, ok := b.strategy.(ContextualBalancingStrategy); ok && ctx != nil {
+		tag = s.PickOutboundWithContext(ctx, candidates)
 	} else {
 		tag = b.strategy.PickOutbound(candidates)
 	}
@@ -72,7 +88,7 @@ func (b *Balancer) SelectOutbounds() ([]string, error) {
 
 // GetPrincipleTarget implements routing.BalancerPrincipleTarget
 func (r *Router) GetPrincipleTarget(tag string) ([]string, error) {
-	if b, ok := r.balancers[tag]; ok {
+	if b, ok := r.getBalancer(tag); ok {
 		if s, ok := b.strategy.(BalancingPrincipleTarget); ok {
 			candidates, err := b.SelectOutbounds()
 			if err != nil {
@@ -87,7 +103,7 @@ func (r *Router) GetPrincipleTarget(tag string) ([]string, error) {
 
 // SetOverrideTarget implements routing.BalancerOverrider
 func (r *Router) SetOverrideTarget(tag, target string) error {
-	if b, ok := r.balancers[tag]; ok {
+	if b, ok := r.getBalancer(tag); ok {
 		b.override.Put(target)
 		return nil
 	}
@@ -96,8 +112,77 @@ func (r *Router) SetOverrideTarget(tag, target string) error {
 
 // GetOverrideTarget implements routing.BalancerOverrider
 func (r *Router) GetOverrideTarget(tag string) (string, error) {
-	if b, ok := r.balancers[tag]; ok {
+	if b, ok := r.getBalancer(tag); ok {
 		return b.override.Get(), nil
 	}
 	return "", newError("cannot find tag")
 }
+
+func (r *Router) getBalancer(tag string) (*Balancer, bool) {
+	r.access.RLock()
+	defer r.access.RUnlock()
+	b, ok := r.balancers[tag]
+	return b, ok
+}
+
+// aliveOutbounds filters away outbounds reported dead by the observatory. Outbounds not
+// observed yet are considered alive, and all candidates are returned without observatory.
+func aliveOutbounds(ctx context.Context, observerTag string, candidates []string) []string {
+	observer := getObservatory(ctx, observerTag)
+	if observer == nil {
+		return candidates
+	}
+	observeReport, err := observer.GetObservation(ctx)
+	if err != nil {
+		newError("cannot get observe report").Base(err).WriteToLog()
+		return candidates
+	}
+	result, ok := observeReport.(*observatory.ObservationResult)
+	if !ok {
+		return candidates
+	}
+	dead := make(map[string]bool)
+	for _, status := range result.Status {
+		if !status.Alive {
+			dead[status.OutboundTag] = true
+		}
+	}
+	if len(dead) == 0 {
+		return candidates
+	}
+	alive := make([]string, 0, len(candidates))
+	for _, tag := range candidates {
+		if !dead[tag] {
+			alive = append(alive, tag)
+		}
+	}
+	return alive
+}
+
+// getObservatory returns the observatory with the tag, or nil if it does not exist.
+func getObservatory(ctx context.Context, observerTag string) extension.Observatory {
+	if ctx == nil {
+		return nil
+	}
+	instance := core.FromContext(ctx)
+	if instance == nil {
+		return nil
+	}
+	feature := instance.GetFeature(extension.ObservatoryType())
+	if feature == nil {
+		return nil
+	}
+	if len(observerTag) > 0 {
+		tagged, ok := feature.(features.TaggedFeatures)
+		if !ok {
+			return nil
+		}
+		if feature, err := tagged.GetFeaturesByTag(observerTag); err == nil {
+			observer, _ := feature.(extension.Observatory)
+			return observer
+		}
+		return nil
+	}
+	observer, _ := feature.(extension.Observatory)
+	return observer
+}
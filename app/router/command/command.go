@@ -9,6 +9,7 @@ import (
 	"google.golang.org/grpc"
 
 	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/app/router"
 	"github.com/v2fly/v2ray-core/v4/common"
 	"github.com/v2fly/v2ray-core/v4/features/routing"
 	"github.com/v2fly/v2ray-core/v4/features/stats"
@@ -55,6 +56,46 @@ func (s *routingServer) OverrideBalancerTarget(ctx context.Context, request *Ove
 	return nil, newError("unsupported router implementation")
 }
 
+// ruleManager is implemented by routers supporting rule management at runtime.
+type ruleManager interface {
+	AddRule(rule *router.RoutingRule, prepend bool) error
+	RemoveRule(ruleTag string) error
+	ListRules() ([]*router.RoutingRule, []*router.BalancingRule, []*router.RuleSet)
+	ReplaceRules(rules []*router.RoutingRule, balancingRules []*router.BalancingRule, ruleSets []*router.RuleSet) error
+}
+
+func (s *routingServer) AddRule(ctx context.Context, request *AddRuleRequest) (*AddRuleResponse, error) {
+	if rm, ok := s.router.(ruleManager); ok {
+		if request.Rule == nil {
+			return nil, newError("Invalid rule.")
+		}
+		return &AddRuleResponse{}, rm.AddRule(request.Rule, request.Prepend)
+	}
+	return nil, newError("unsupported router implementation")
+}
+
+func (s *routingServer) RemoveRule(ctx context.Context, request *RemoveRuleRequest) (*RemoveRuleResponse, error) {
+	if rm, ok := s.router.(ruleManager); ok {
+		return &RemoveRuleResponse{}, rm.RemoveRule(request.RuleTag)
+	}
+	return nil, newError("unsupported router implementation")
+}
+
+func (s *routingServer) ListRules(ctx context.Context, request *ListRulesRequest) (*ListRulesResponse, error) {
+	if rm, ok := s.router.(ruleManager); ok {
+		rules, balancingRules, ruleSets := rm.ListRules()
+		return &ListRulesResponse{Rule: rules, BalancingRule: balancingRules, RuleSet: ruleSets}, nil
+	}
+	return nil, newError("unsupported router implementation")
+}
+
+func (s *routingServer) ReplaceRules(ctx context.Context, request *ReplaceRulesRequest) (*ReplaceRulesResponse, error) {
+	if rm, ok := s.router.(ruleManager); ok {
+		return &ReplaceRulesResponse{}, rm.ReplaceRules(request.Rule, request.BalancingRule, request.RuleSet)
+	}
+	return nil, newError("unsupported router implementation")
+}
+
 // NewRoutingServer creates a statistics service with statistics manager.
 func NewRoutingServer(router routing.Router, routingStats stats.Channel) RoutingServiceServer {
 	return &routingServer{
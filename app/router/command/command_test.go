@@ -219,10 +219,12 @@ func TestSerivceTestRoute(t *testing.T) {
 			{
 				InboundTag: []string{"in"},
 				TargetTag:  &router.RoutingRule_Tag{Tag: "out"},
+				RuleTag:    "inbound",
 			},
 			{
 				Protocol:  []string{"bittorrent"},
 				TargetTag: &router.RoutingRule_Tag{Tag: "blocked"},
+				RuleTag:   "bittorrent",
 			},
 			{
 				PortList:  &net.PortList{Range: []*net.PortRange{{From: 8080, To: 8080}}},
@@ -276,13 +278,13 @@ func TestSerivceTestRoute(t *testing.T) {
 		client := NewRoutingServiceClient(conn)
 
 		testCases := []*RoutingContext{
-			{InboundTag: "in", OutboundTag: "out"},
-			{TargetIPs: [][]byte{{1, 2, 3, 4}}, TargetPort: 8080, OutboundTag: "out"},
-			{TargetDomain: "example.com", TargetPort: 443, OutboundTag: "out"},
-			{SourcePort: 9999, TargetPort: 9999, OutboundTag: "out"},
-			{Network: net.Network_UDP, Protocol: "bittorrent", OutboundTag: "blocked"},
-			{User: "example@v2fly.org", OutboundTag: "out"},
-			{SourceIPs: [][]byte{{127, 0, 0, 1}}, Attributes: map[string]string{"attr": "value"}, OutboundTag: "out"},
+			{InboundTag: "in", OutboundTag: "out", RuleTag: "inbound", MatchedConditions: []string{"inbound:in"}},
+			{TargetIPs: [][]byte{{1, 2, 3, 4}}, TargetPort: 8080, OutboundTag: "out", MatchedConditions: []string{"port:8080"}},
+			{TargetDomain: "example.com", TargetPort: 443, OutboundTag: "out", MatchedConditions: []string{"domain:com"}},
+			{SourcePort: 9999, TargetPort: 9999, OutboundTag: "out", MatchedConditions: []string{"source_port:9999"}},
+			{Network: net.Network_UDP, Protocol: "bittorrent", OutboundTag: "blocked", RuleTag: "bittorrent", MatchedConditions: []string{"protocol:bittorrent"}},
+			{User: "example@v2fly.org", OutboundTag: "out", MatchedConditions: []string{"user:example@v2fly.org"}},
+			{SourceIPs: [][]byte{{127, 0, 0, 1}}, Attributes: map[string]string{"attr": "value"}, OutboundTag: "out", MatchedConditions: []string{"source_geoip:private (127.0.0.1)"}},
 		}
 
 		// Test simple TestRoute
@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/platform/process"
 	"github.com/v2fly/v2ray-core/v4/features/routing"
 )
 
@@ -35,6 +36,12 @@ func (c routingContext) GetSkipDNSResolve() bool {
 	return false
 }
 
+// GetSourceProcess is a mock implementation here to match the interface,
+// source process is not carried by protobuf RoutingContext.
+func (c routingContext) GetSourceProcess() *process.Info {
+	return nil
+}
+
 // AsRoutingContext converts a protobuf RoutingContext into an implementation of routing.Context.
 func AsRoutingContext(r *RoutingContext) routing.Context {
 	return routingContext{r}
@@ -46,18 +53,20 @@ func AsRoutingRoute(r *RoutingContext) routing.Route {
 }
 
 var fieldMap = map[string]func(*RoutingContext, routing.Route){
-	"inbound":        func(s *RoutingContext, r routing.Route) { s.InboundTag = r.GetInboundTag() },
-	"network":        func(s *RoutingContext, r routing.Route) { s.Network = r.GetNetwork() },
-	"ip_source":      func(s *RoutingContext, r routing.Route) { s.SourceIPs = mapIPsToBytes(r.GetSourceIPs()) },
-	"ip_target":      func(s *RoutingContext, r routing.Route) { s.TargetIPs = mapIPsToBytes(r.GetTargetIPs()) },
-	"port_source":    func(s *RoutingContext, r routing.Route) { s.SourcePort = uint32(r.GetSourcePort()) },
-	"port_target":    func(s *RoutingContext, r routing.Route) { s.TargetPort = uint32(r.GetTargetPort()) },
-	"domain":         func(s *RoutingContext, r routing.Route) { s.TargetDomain = r.GetTargetDomain() },
-	"protocol":       func(s *RoutingContext, r routing.Route) { s.Protocol = r.GetProtocol() },
-	"user":           func(s *RoutingContext, r routing.Route) { s.User = r.GetUser() },
-	"attributes":     func(s *RoutingContext, r routing.Route) { s.Attributes = r.GetAttributes() },
-	"outbound_group": func(s *RoutingContext, r routing.Route) { s.OutboundGroupTags = r.GetOutboundGroupTags() },
-	"outbound":       func(s *RoutingContext, r routing.Route) { s.OutboundTag = r.GetOutboundTag() },
+	"inbound":         func(s *RoutingContext, r routing.Route) { s.InboundTag = r.GetInboundTag() },
+	"network":         func(s *RoutingContext, r routing.Route) { s.Network = r.GetNetwork() },
+	"ip_source":       func(s *RoutingContext, r routing.Route) { s.SourceIPs = mapIPsToBytes(r.GetSourceIPs()) },
+	"ip_target":       func(s *RoutingContext, r routing.Route) { s.TargetIPs = mapIPsToBytes(r.GetTargetIPs()) },
+	"port_source":     func(s *RoutingContext, r routing.Route) { s.SourcePort = uint32(r.GetSourcePort()) },
+	"port_target":     func(s *RoutingContext, r routing.Route) { s.TargetPort = uint32(r.GetTargetPort()) },
+	"domain":          func(s *RoutingContext, r routing.Route) { s.TargetDomain = r.GetTargetDomain() },
+	"protocol":        func(s *RoutingContext, r routing.Route) { s.Protocol = r.GetProtocol() },
+	"user":            func(s *RoutingContext, r routing.Route) { s.User = r.GetUser() },
+	"attributes":      func(s *RoutingContext, r routing.Route) { s.Attributes = r.GetAttributes() },
+	"outbound_group":  func(s *RoutingContext, r routing.Route) { s.OutboundGroupTags = r.GetOutboundGroupTags() },
+	"outbound":        func(s *RoutingContext, r routing.Route) { s.OutboundTag = r.GetOutboundTag() },
+	"rule_tag":        func(s *RoutingContext, r routing.Route) { s.RuleTag = r.GetRuleTag() },
+	"rule_conditions": func(s *RoutingContext, r routing.Route) { s.MatchedConditions = r.GetMatchedConditions() },
 }
 
 // AsProtobufMessage takes selectors of fields and returns a function to convert routing.Route to protobuf RoutingContext.
@@ -1,13 +1,16 @@
 package router
 
 import (
+	"strconv"
 	"strings"
+	"time"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/syntax"
 
 	"github.com/v2fly/v2ray-core/v4/app/router/routercommon"
 	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/platform/process"
 	"github.com/v2fly/v2ray-core/v4/common/strmatcher"
 	"github.com/v2fly/v2ray-core/v4/features/routing"
 )
@@ -16,6 +19,12 @@ type Condition interface {
 	Apply(ctx routing.Context) bool
 }
 
+// MatchDescriber is implemented by conditions that can describe which part of
+// them matches the given routing context.
+type MatchDescriber interface {
+	DescribeMatch(ctx routing.Context) string
+}
+
 type ConditionChan []Condition
 
 func NewConditionChan() *ConditionChan {
@@ -42,6 +51,19 @@ func (v *ConditionChan) Len() int {
 	return len(*v)
 }
 
+// DescribeMatches describes all conditions registered in this chan that match the given routing context.
+func (v *ConditionChan) DescribeMatches(ctx routing.Context) []string {
+	descriptions := make([]string, 0, len(*v))
+	for _, cond := range *v {
+		if describer, ok := cond.(MatchDescriber); ok {
+			if description := describer.DescribeMatch(ctx); len(description) > 0 {
+				descriptions = append(descriptions, description)
+			}
+		}
+	}
+	return descriptions
+}
+
 var matcherTypeMap = map[routercommon.Domain_Type]strmatcher.Type{
 	routercommon.Domain_Plain:      strmatcher.Substr,
 	routercommon.Domain_Regex:      strmatcher.Regex,
@@ -64,7 +86,8 @@ func domainToMatcher(domain *routercommon.Domain) (strmatcher.Matcher, error) {
 }
 
 type DomainMatcher struct {
-	matcher strmatcher.IndexMatcher
+	matcher  strmatcher.IndexMatcher
+	matchers []strmatcher.Matcher
 }
 
 func NewDomainMatcher(matcherType string, domains []*routercommon.Domain) (*DomainMatcher, error) {
@@ -77,17 +100,19 @@ func NewDomainMatcher(matcherType string, domains []*routercommon.Domain) (*Doma
 	default:
 		indexMatcher = strmatcher.NewLinearIndexMatcher()
 	}
+	matchers := make([]strmatcher.Matcher, 0, len(domains))
 	for _, domain := range domains {
 		matcher, err := domainToMatcher(domain)
 		if err != nil {
 			return nil, err
 		}
 		indexMatcher.Add(matcher)
+		matchers = append(matchers, matcher)
 	}
 	if err := indexMatcher.Build(); err != nil {
 		return nil, err
 	}
-	return &DomainMatcher{matcher: indexMatcher}, nil
+	return &DomainMatcher{matcher: indexMatcher, matchers: matchers}, nil
 }
 
 func (m *DomainMatcher) Match(domain string) bool {
@@ -103,6 +128,20 @@ func (m *DomainMatcher) Apply(ctx routing.Context) bool {
 	return m.Match(domain)
 }
 
+// DescribeMatch implements MatchDescriber.
+func (m *DomainMatcher) DescribeMatch(ctx routing.Context) string {
+	domain := ctx.GetTargetDomain()
+	if len(domain) == 0 {
+		return ""
+	}
+	indices := m.matcher.Match(domain)
+	if len(indices) == 0 {
+		return ""
+	}
+	// Index of matcher starts from 1
+	return m.matchers[indices[0]-1].String()
+}
+
 type MultiGeoIPMatcher struct {
 	matchers []*GeoIPMatcher
 	onSource bool
@@ -144,6 +183,26 @@ func (m *MultiGeoIPMatcher) Apply(ctx routing.Context) bool {
 	return false
 }
 
+// DescribeMatch implements MatchDescriber.
+func (m *MultiGeoIPMatcher) DescribeMatch(ctx routing.Context) string {
+	var ips []net.IP
+	prefix := ""
+	if m.onSource {
+		ips = ctx.GetSourceIPs()
+		prefix = "source_"
+	} else {
+		ips = ctx.GetTargetIPs()
+	}
+	for _, ip := range ips {
+		for _, matcher := range m.matchers {
+			if matcher.Match(ip) {
+				return prefix + matcher.String() + " (" + ip.String() + ")"
+			}
+		}
+	}
+	return ""
+}
+
 type PortMatcher struct {
 	port     net.MemoryPortList
 	onSource bool
@@ -165,6 +224,14 @@ func (v *PortMatcher) Apply(ctx routing.Context) bool {
 	return v.port.Contains(ctx.GetTargetPort())
 }
 
+// DescribeMatch implements MatchDescriber.
+func (v *PortMatcher) DescribeMatch(ctx routing.Context) string {
+	if v.onSource {
+		return "source_port:" + ctx.GetSourcePort().String()
+	}
+	return "port:" + ctx.GetTargetPort().String()
+}
+
 type NetworkMatcher struct {
 	list [8]bool
 }
@@ -182,6 +249,11 @@ func (v NetworkMatcher) Apply(ctx routing.Context) bool {
 	return v.list[int(ctx.GetNetwork())]
 }
 
+// DescribeMatch implements MatchDescriber.
+func (v NetworkMatcher) DescribeMatch(ctx routing.Context) string {
+	return "network:" + ctx.GetNetwork().SystemString()
+}
+
 type UserMatcher struct {
 	user []string
 }
@@ -212,6 +284,11 @@ func (v *UserMatcher) Apply(ctx routing.Context) bool {
 	return false
 }
 
+// DescribeMatch implements MatchDescriber.
+func (v *UserMatcher) DescribeMatch(ctx routing.Context) string {
+	return "user:" + ctx.GetUser()
+}
+
 type InboundTagMatcher struct {
 	tags []string
 }
@@ -242,6 +319,11 @@ func (v *InboundTagMatcher) Apply(ctx routing.Context) bool {
 	return false
 }
 
+// DescribeMatch implements MatchDescriber.
+func (v *InboundTagMatcher) DescribeMatch(ctx routing.Context) string {
+	return "inbound:" + ctx.GetInboundTag()
+}
+
 type ProtocolMatcher struct {
 	protocols []string
 }
@@ -274,8 +356,14 @@ func (m *ProtocolMatcher) Apply(ctx routing.Context) bool {
 	return false
 }
 
+// DescribeMatch implements MatchDescriber.
+func (m *ProtocolMatcher) DescribeMatch(ctx routing.Context) string {
+	return "protocol:" + ctx.GetProtocol()
+}
+
 type AttributeMatcher struct {
-	program *starlark.Program
+	program     *starlark.Program
+	usesProcess bool
 }
 
 func NewAttributeMatcher(code string) (*AttributeMatcher, error) {
@@ -283,19 +371,27 @@ func NewAttributeMatcher(code string) (*AttributeMatcher, error) {
 	if err != nil {
 		return nil, newError("attr rule").Base(err)
 	}
+	matcher := new(AttributeMatcher)
 	p, err := starlark.FileProgram(starFile, func(name string) bool {
+		if name == "process" {
+			matcher.usesProcess = true
+			return true
+		}
 		return name == "attrs"
 	})
 	if err != nil {
 		return nil, err
 	}
-	return &AttributeMatcher{
-		program: p,
-	}, nil
+	matcher.program = p
+	return matcher, nil
 }
 
 // Match implements attributes matching.
 func (m *AttributeMatcher) Match(attrs map[string]string) bool {
+	return m.match(attrs, nil)
+}
+
+func (m *AttributeMatcher) match(attrs map[string]string, proc *process.Info) bool {
 	attrsDict := new(starlark.Dict)
 	for key, value := range attrs {
 		attrsDict.SetKey(starlark.String(key), starlark.String(value))
@@ -303,6 +399,16 @@ func (m *AttributeMatcher) Match(attrs map[string]string) bool {
 
 	predefined := make(starlark.StringDict)
 	predefined["attrs"] = attrsDict
+	if m.usesProcess {
+		procDict := new(starlark.Dict)
+		if proc != nil {
+			procDict.SetKey(starlark.String("pid"), starlark.MakeInt(proc.PID))
+			procDict.SetKey(starlark.String("uid"), starlark.MakeUint(uint(proc.UID)))
+			procDict.SetKey(starlark.String("name"), starlark.String(proc.Name))
+			procDict.SetKey(starlark.String("path"), starlark.String(proc.Path))
+		}
+		predefined["process"] = procDict
+	}
 
 	thread := &starlark.Thread{
 		Name: "matcher",
@@ -318,8 +424,163 @@ func (m *AttributeMatcher) Match(attrs map[string]string) bool {
 // Apply implements Condition.
 func (m *AttributeMatcher) Apply(ctx routing.Context) bool {
 	attributes := ctx.GetAttributes()
-	if attributes == nil {
+	var proc *process.Info
+	if m.usesProcess {
+		proc = ctx.GetSourceProcess()
+	}
+	if attributes == nil && proc == nil {
 		return false
 	}
-	return m.Match(attributes)
+	return m.match(attributes, proc)
+}
+
+// DescribeMatch implements MatchDescriber.
+func (m *AttributeMatcher) DescribeMatch(ctx routing.Context) string {
+	return "attrs"
+}
+
+type ProcessMatcher struct {
+	names map[string]bool
+	paths map[string]bool
+	uids  map[uint32]bool
+}
+
+// NewProcessMatcher creates a new matcher that matches the local process the connection was from.
+func NewProcessMatcher(names []string, paths []string, uids []uint32) *ProcessMatcher {
+	matcher := &ProcessMatcher{
+		names: make(map[string]bool, len(names)),
+		paths: make(map[string]bool, len(paths)),
+		uids:  make(map[uint32]bool, len(uids)),
+	}
+	for _, name := range names {
+		matcher.names[name] = true
+	}
+	for _, path := range paths {
+		matcher.paths[path] = true
+	}
+	for _, uid := range uids {
+		matcher.uids[uid] = true
+	}
+	return matcher
+}
+
+// Match checks whether the given process matches any of the names, paths or user IDs.
+func (m *ProcessMatcher) Match(proc *process.Info) bool {
+	return len(m.describe(proc)) > 0
+}
+
+func (m *ProcessMatcher) describe(proc *process.Info) string {
+	if proc == nil {
+		return ""
+	}
+	switch {
+	case len(proc.Name) > 0 && m.names[proc.Name]:
+		return "process:" + proc.Name
+	case len(proc.Path) > 0 && m.paths[proc.Path]:
+		return "process:" + proc.Path
+	case m.uids[proc.UID]:
+		return "process_uid:" + strconv.FormatUint(uint64(proc.UID), 10)
+	}
+	return ""
+}
+
+// Apply implements Condition.
+func (m *ProcessMatcher) Apply(ctx routing.Context) bool {
+	return m.Match(ctx.GetSourceProcess())
+}
+
+// DescribeMatch implements MatchDescriber.
+func (m *ProcessMatcher) DescribeMatch(ctx routing.Context) string {
+	return m.describe(ctx.GetSourceProcess())
+}
+
+type timeWindow struct {
+	weekdays [7]bool
+	start    int
+	end      int
+	location *time.Location
+}
+
+func (w *timeWindow) match(t time.Time) bool {
+	t = t.In(w.location)
+	minute := t.Hour()*60 + t.Minute()
+	weekday := int(t.Weekday())
+	switch {
+	case w.start == w.end:
+		return w.weekdays[weekday]
+	case w.start < w.end:
+		return w.weekdays[weekday] && minute >= w.start && minute < w.end
+	default:
+		// The window spans midnight, so it may have begun on the day before.
+		return w.weekdays[weekday] && minute >= w.start || w.weekdays[(weekday+6)%7] && minute < w.end
+	}
+}
+
+type TimeMatcher struct {
+	windows []*timeWindow
+}
+
+// NewTimeMatcher creates a new matcher that matches the current time against a list of time windows.
+func NewTimeMatcher(windows []*TimeWindow) (*TimeMatcher, error) {
+	matcher := &TimeMatcher{
+		windows: make([]*timeWindow, 0, len(windows)),
+	}
+	for _, window := range windows {
+		w := &timeWindow{
+			start:    int(window.Start),
+			end:      int(window.End),
+			location: time.Local,
+		}
+		if w.start > 24*60 || w.end > 24*60 {
+			return nil, newError("time of day out of range in time window")
+		}
+		if len(window.Weekday) == 0 {
+			for i := range w.weekdays {
+				w.weekdays[i] = true
+			}
+		}
+		for _, day := range window.Weekday {
+			if day > 6 {
+				return nil, newError("invalid day of week: ", day)
+			}
+			w.weekdays[day] = true
+		}
+		if len(window.TimeZone) > 0 {
+			location, err := time.LoadLocation(window.TimeZone)
+			if err != nil {
+				return nil, newError("failed to load time zone ", window.TimeZone).Base(err)
+			}
+			w.location = location
+		}
+		matcher.windows = append(matcher.windows, w)
+	}
+	return matcher, nil
+}
+
+// Match checks whether the given time is in any of the time windows.
+func (m *TimeMatcher) Match(t time.Time) bool {
+	return m.matchWindow(t) != nil
+}
+
+func (m *TimeMatcher) matchWindow(t time.Time) *timeWindow {
+	for _, w := range m.windows {
+		if w.match(t) {
+			return w
+		}
+	}
+	return nil
+}
+
+// Apply implements Condition.
+func (m *TimeMatcher) Apply(ctx routing.Context) bool {
+	return m.Match(time.Now())
+}
+
+// DescribeMatch implements MatchDescriber.
+func (m *TimeMatcher) DescribeMatch(ctx routing.Context) string {
+	now := time.Now()
+	if w := m.matchWindow(now); w != nil {
+		return "time:" + now.In(w.location).Format("Mon 15:04 MST")
+	}
+	return ""
 }
@@ -1,6 +1,8 @@
 package router
 
 import (
+	"strings"
+
 	"inet.af/netaddr"
 
 	"github.com/v2fly/v2ray-core/v4/app/router/routercommon"
@@ -78,6 +80,22 @@ func (m *GeoIPMatcher) Match(ip net.IP) bool {
 	return isMatched
 }
 
+// String returns the GeoIP reference of the matcher, e.g. "geoip:cn" or "geoip:!cn".
+// Custom CIDR lists without country code are represented as "cidr".
+func (m *GeoIPMatcher) String() string {
+	if m.countryCode == "" {
+		return "cidr"
+	}
+	prefix, code := "geoip:", strings.ToLower(m.countryCode)
+	if asn := strings.TrimPrefix(m.countryCode, "AS"); len(asn) > 0 && len(asn) < len(m.countryCode) && strings.Trim(asn, "0123456789") == "" {
+		prefix, code = "asn:", asn
+	}
+	if m.reverseMatch {
+		code = "!" + code
+	}
+	return prefix + code
+}
+
 // GeoIPMatcherContainer is a container for GeoIPMatchers. It keeps unique copies of GeoIPMatcher by country code.
 type GeoIPMatcherContainer struct {
 	matchers []*GeoIPMatcher
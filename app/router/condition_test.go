@@ -1,13 +1,16 @@
 package router_test
 
 import (
+	"context"
 	"errors"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 
@@ -16,6 +19,7 @@ import (
 	"github.com/v2fly/v2ray-core/v4/common"
 	"github.com/v2fly/v2ray-core/v4/common/net"
 	"github.com/v2fly/v2ray-core/v4/common/platform/filesystem"
+	"github.com/v2fly/v2ray-core/v4/common/platform/process"
 	"github.com/v2fly/v2ray-core/v4/common/protocol"
 	"github.com/v2fly/v2ray-core/v4/common/protocol/http"
 	"github.com/v2fly/v2ray-core/v4/common/session"
@@ -552,3 +556,131 @@ func BenchmarkMultiGeoIPMatcher(b *testing.B) {
 		_ = matcher.Apply(ctx)
 	}
 }
+
+func TestTimeMatcher(t *testing.T) {
+	cases := []struct {
+		window string
+		time   time.Time
+		output bool
+	}{
+		{
+			window: "Mon-Fri 09:00-18:00 UTC",
+			time:   time.Date(2021, time.June, 7, 9, 0, 0, 0, time.UTC), // Monday
+			output: true,
+		},
+		{
+			window: "Mon-Fri 09:00-18:00 UTC",
+			time:   time.Date(2021, time.June, 7, 18, 0, 0, 0, time.UTC),
+			output: false,
+		},
+		{
+			window: "Mon-Fri 09:00-18:00 UTC",
+			time:   time.Date(2021, time.June, 6, 12, 0, 0, 0, time.UTC), // Sunday
+			output: false,
+		},
+		{
+			window: "Mon-Fri 09:00-18:00 UTC",
+			time:   time.Date(2021, time.June, 7, 8, 30, 0, 0, time.FixedZone("UTC-1", -3600)),
+			output: true,
+		},
+		{
+			window: "Fri 22:00-06:00 UTC",
+			time:   time.Date(2021, time.June, 12, 5, 59, 0, 0, time.UTC), // Saturday
+			output: true,
+		},
+		{
+			window: "Fri 22:00-06:00 UTC",
+			time:   time.Date(2021, time.June, 11, 5, 59, 0, 0, time.UTC), // Friday
+			output: false,
+		},
+		{
+			window: "Sat,Sun",
+			time:   time.Date(2021, time.June, 6, 23, 59, 0, 0, time.Local),
+			output: true,
+		},
+		{
+			window: "Fri-Mon 10:00-11:00",
+			time:   time.Date(2021, time.June, 6, 10, 30, 0, 0, time.Local),
+			output: true,
+		},
+	}
+
+	for _, test := range cases {
+		window, err := router.ParseTimeWindow(test.window)
+		common.Must(err)
+		matcher, err := router.NewTimeMatcher([]*router.TimeWindow{window})
+		common.Must(err)
+		if r := matcher.Match(test.time); r != test.output {
+			t.Error("for window ", test.window, " and time ", test.time, ", expect ", test.output, " but got ", r)
+		}
+	}
+
+	for _, invalid := range []string{"", "Mon-Foo", "25:00-26:00", "09:00", "Mon 09:00-18:00 Nowhere/Special"} {
+		if _, err := router.ParseTimeWindow(invalid); err == nil {
+			t.Error("expect error for invalid time window ", invalid)
+		}
+	}
+}
+
+func TestProcessMatcher(t *testing.T) {
+	matcher := router.NewProcessMatcher([]string{"firefox"}, []string{"/opt/idea/bin/idea"}, []uint32{1000})
+	cases := []struct {
+		process *process.Info
+		output  bool
+	}{
+		{
+			process: &process.Info{PID: 1, UID: 0, Name: "firefox", Path: "/usr/lib/firefox/firefox"},
+			output:  true,
+		},
+		{
+			process: &process.Info{PID: 2, UID: 0, Name: "java", Path: "/opt/idea/bin/idea"},
+			output:  true,
+		},
+		{
+			process: &process.Info{PID: 3, UID: 1000, Name: "curl", Path: "/usr/bin/curl"},
+			output:  true,
+		},
+		{
+			process: &process.Info{PID: 4, UID: 0, Name: "curl", Path: "/usr/bin/curl"},
+			output:  false,
+		},
+		{
+			process: nil,
+			output:  false,
+		},
+	}
+	for _, test := range cases {
+		if r := matcher.Match(test.process); r != test.output {
+			t.Error("for process ", test.process, ", expect ", test.output, " but got ", r)
+		}
+	}
+}
+
+func TestProcessAttributeMatcher(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process lookup is only supported on Linux")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	common.Must(err)
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	common.Must(err)
+	defer conn.Close()
+
+	ctx := routing_session.AsRoutingContext(session.ContextWithOutbound(
+		session.ContextWithInbound(context.Background(), &session.Inbound{Source: net.DestinationFromAddr(conn.LocalAddr())}),
+		&session.Outbound{Target: net.TCPDestination(net.DomainAddress("v2fly.org"), 443)},
+	))
+
+	matcher, err := router.NewAttributeMatcher("process['pid'] == " + strconv.Itoa(os.Getpid()))
+	common.Must(err)
+	if !matcher.Apply(ctx) {
+		t.Error("expect attribute matcher to match the current process")
+	}
+
+	uidMatcher := router.NewProcessMatcher(nil, nil, []uint32{uint32(os.Getuid())})
+	if !uidMatcher.Apply(ctx) {
+		t.Error("expect process matcher to match the current user")
+	}
+}
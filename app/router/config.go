@@ -14,18 +14,27 @@ import (
 	"github.com/v2fly/v2ray-core/v4/common/serial"
 	"github.com/v2fly/v2ray-core/v4/features/outbound"
 	"github.com/v2fly/v2ray-core/v4/features/routing"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
 	"github.com/v2fly/v2ray-core/v4/infra/conf/v5cfg"
 )
 
 type Rule struct {
 	Tag       string
+	RuleTag   string
 	Balancer  *Balancer
 	Condition Condition
+
+	config *RoutingRule
+	hits   stats.Counter
 }
 
 func (r *Rule) GetTag() (string, error) {
+	return r.getTag(nil)
+}
+
+func (r *Rule) getTag(ctx routing.Context) (string, error) {
 	if r.Balancer != nil {
-		return r.Balancer.PickOutbound()
+		return r.Balancer.PickOutboundWithContext(ctx)
 	}
 	return r.Tag, nil
 }
@@ -35,7 +44,24 @@ func (r *Rule) Apply(ctx routing.Context) bool {
 	return r.Condition.Apply(ctx)
 }
 
+// DescribeMatches describes the conditions of the rule matching current routing context.
+func (r *Rule) DescribeMatches(ctx routing.Context) []string {
+	switch cond := r.Condition.(type) {
+	case *ConditionChan:
+		return cond.DescribeMatches(ctx)
+	case MatchDescriber:
+		if description := cond.DescribeMatch(ctx); len(description) > 0 {
+			return []string{description}
+		}
+	}
+	return nil
+}
+
 func (rr *RoutingRule) BuildCondition() (Condition, error) {
+	return rr.buildCondition(nil)
+}
+
+func (rr *RoutingRule) buildCondition(ruleSets map[string]*ruleSetProvider) (Condition, error) {
 	conds := NewConditionChan()
 
 	if len(rr.Domain) > 0 {
@@ -110,6 +136,30 @@ func (rr *RoutingRule) BuildCondition() (Condition, error) {
 		conds.Add(cond)
 	}
 
+	if len(rr.ProcessName) > 0 || len(rr.ProcessPath) > 0 || len(rr.ProcessUid) > 0 {
+		conds.Add(NewProcessMatcher(rr.ProcessName, rr.ProcessPath, rr.ProcessUid))
+	}
+
+	if len(rr.RuleSet) > 0 {
+		cond := &RuleSetMatcher{tags: rr.RuleSet}
+		for _, tag := range rr.RuleSet {
+			provider, found := ruleSets[tag]
+			if !found {
+				return nil, newError("rule set ", tag, " not found")
+			}
+			cond.providers = append(cond.providers, provider)
+		}
+		conds.Add(cond)
+	}
+
+	if len(rr.TimeWindow) > 0 {
+		cond, err := NewTimeMatcher(rr.TimeWindow)
+		if err != nil {
+			return nil, newError("failed to build time condition").Base(err)
+		}
+		conds.Add(cond)
+	}
+
 	if conds.Len() == 0 {
 		return nil, newError("this rule has no effective fields").AtWarning()
 	}
@@ -149,6 +199,52 @@ func (br *BalancingRule) Build(ohm outbound.Manager, dispatcher routing.Dispatch
 			ohm:       ohm, fallbackTag: br.FallbackTag,
 			strategy: leastLoadStrategy,
 		}, nil
+	case "weightedroundrobin":
+		i, err := serial.GetInstanceOf(br.StrategySettings)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := i.(*StrategyWeightedRoundRobinConfig)
+		if !ok {
+			return nil, newError("not a StrategyWeightedRoundRobinConfig").AtError()
+		}
+		return &Balancer{
+			selectors: br.OutboundSelector,
+			ohm:       ohm, fallbackTag: br.FallbackTag,
+			strategy: NewWeightedRoundRobinStrategy(s),
+		}, nil
+	case "priority":
+		i, err := serial.GetInstanceOf(br.StrategySettings)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := i.(*StrategyPriorityConfig)
+		if !ok {
+			return nil, newError("not a StrategyPriorityConfig").AtError()
+		}
+		return &Balancer{
+			selectors: br.OutboundSelector,
+			ohm:       ohm, fallbackTag: br.FallbackTag,
+			strategy: NewPriorityStrategy(s, br.OutboundSelector),
+		}, nil
+	case "consistenthash":
+		i, err := serial.GetInstanceOf(br.StrategySettings)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := i.(*StrategyConsistentHashConfig)
+		if !ok {
+			return nil, newError("not a StrategyConsistentHashConfig").AtError()
+		}
+		consistentHashStrategy, err := NewConsistentHashStrategy(s)
+		if err != nil {
+			return nil, err
+		}
+		return &Balancer{
+			selectors: br.OutboundSelector,
+			ohm:       ohm, fallbackTag: br.FallbackTag,
+			strategy: consistentHashStrategy,
+		}, nil
 	case "random":
 		fallthrough
 	case "":
@@ -4,25 +4,41 @@ package router
 
 import (
 	"context"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
 
 	core "github.com/v2fly/v2ray-core/v4"
 	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/errors"
 	"github.com/v2fly/v2ray-core/v4/common/net"
 	"github.com/v2fly/v2ray-core/v4/common/platform"
 	"github.com/v2fly/v2ray-core/v4/features/dns"
 	"github.com/v2fly/v2ray-core/v4/features/outbound"
+	"github.com/v2fly/v2ray-core/v4/features/policy"
 	"github.com/v2fly/v2ray-core/v4/features/routing"
 	routing_dns "github.com/v2fly/v2ray-core/v4/features/routing/dns"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
 	"github.com/v2fly/v2ray-core/v4/infra/conf/cfgcommon"
 	"github.com/v2fly/v2ray-core/v4/infra/conf/geodata"
 )
 
 // Router is an implementation of routing.Router.
 type Router struct {
+	access         sync.RWMutex
 	domainStrategy DomainStrategy
 	rules          []*Rule
 	balancers      map[string]*Balancer
+	balancingRules []*BalancingRule
+	ruleSets       map[string]*ruleSetProvider
+	ruleSetConfigs []*RuleSet
 	dns            dns.Client
+
+	ctx         context.Context
+	ohm         outbound.Manager
+	dispatcher  routing.Dispatcher
+	stats       stats.Manager
+	statsPolicy policy.SystemStats
 }
 
 // Route is an implementation of routing.Route.
@@ -30,45 +46,116 @@ type Route struct {
 	routing.Context
 	outboundGroupTags []string
 	outboundTag       string
+	rule              *Rule
 }
 
 // Init initializes the Router.
 func (r *Router) Init(ctx context.Context, config *Config, d dns.Client, ohm outbound.Manager, dispatcher routing.Dispatcher) error {
 	r.domainStrategy = config.DomainStrategy
 	r.dns = d
+	r.ctx = ctx
+	r.ohm = ohm
+	r.dispatcher = dispatcher
 
-	r.balancers = make(map[string]*Balancer, len(config.BalancingRule))
-	for _, rule := range config.BalancingRule {
-		balancer, err := rule.Build(ohm, dispatcher)
+	ruleSets, err := r.buildRuleSets(config.RuleSet)
+	if err != nil {
+		return err
+	}
+	balancers, err := r.buildBalancers(config.BalancingRule)
+	if err != nil {
+		return err
+	}
+	rules, err := r.buildRules(config.Rule, balancers, ruleSets)
+	if err != nil {
+		return err
+	}
+	r.ruleSets = ruleSets
+	r.ruleSetConfigs = config.RuleSet
+	r.balancers = balancers
+	r.balancingRules = config.BalancingRule
+	r.rules = rules
+
+	return nil
+}
+
+// buildRuleSets creates providers for the given rule sets. Providers of the
+// router with the same configuration are reused instead of loading the files again.
+func (r *Router) buildRuleSets(ruleSets []*RuleSet) (map[string]*ruleSetProvider, error) {
+	r.access.RLock()
+	current := r.ruleSets
+	r.access.RUnlock()
+
+	providers := make(map[string]*ruleSetProvider, len(ruleSets))
+	for _, ruleSet := range ruleSets {
+		if _, found := providers[ruleSet.Tag]; found {
+			return nil, newError("duplicate rule set tag ", ruleSet.Tag)
+		}
+		if provider, found := current[ruleSet.Tag]; found && proto.Equal(provider.config, ruleSet) {
+			providers[ruleSet.Tag] = provider
+			continue
+		}
+		provider, err := newRuleSetProvider(ruleSet)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		balancer.InjectContext(ctx)
-		r.balancers[rule.Tag] = balancer
+		providers[ruleSet.Tag] = provider
 	}
+	return providers, nil
+}
 
-	r.rules = make([]*Rule, 0, len(config.Rule))
-	for _, rule := range config.Rule {
-		cond, err := rule.BuildCondition()
+func (r *Router) buildBalancers(balancingRules []*BalancingRule) (map[string]*Balancer, error) {
+	balancers := make(map[string]*Balancer, len(balancingRules))
+	for _, rule := range balancingRules {
+		balancer, err := rule.Build(r.ohm, r.dispatcher)
 		if err != nil {
-			return err
-		}
-		rr := &Rule{
-			Condition: cond,
-			Tag:       rule.GetTag(),
+			return nil, err
 		}
-		btag := rule.GetBalancingTag()
-		if len(btag) > 0 {
-			brule, found := r.balancers[btag]
-			if !found {
-				return newError("balancer ", btag, " not found")
+		balancer.InjectContext(r.ctx)
+		balancers[rule.Tag] = balancer
+	}
+	return balancers, nil
+}
+
+func (r *Router) buildRules(routingRules []*RoutingRule, balancers map[string]*Balancer, ruleSets map[string]*ruleSetProvider) ([]*Rule, error) {
+	rules := make([]*Rule, 0, len(routingRules))
+	ruleTags := make(map[string]bool)
+	for _, rule := range routingRules {
+		if ruleTag := rule.GetRuleTag(); len(ruleTag) > 0 {
+			if ruleTags[ruleTag] {
+				return nil, newError("duplicate rule tag ", ruleTag)
 			}
-			rr.Balancer = brule
+			ruleTags[ruleTag] = true
 		}
-		r.rules = append(r.rules, rr)
+		rr, err := buildRule(rule, balancers, ruleSets)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rr)
 	}
+	r.registerRuleCounters(rules)
+	return rules, nil
+}
 
-	return nil
+func buildRule(rule *RoutingRule, balancers map[string]*Balancer, ruleSets map[string]*ruleSetProvider) (*Rule, error) {
+	cond, err := rule.buildCondition(ruleSets)
+	if err != nil {
+		return nil, err
+	}
+	rr := &Rule{
+		Condition: cond,
+		Tag:       rule.GetTag(),
+		RuleTag:   rule.GetRuleTag(),
+		config:    rule,
+	}
+	btag := rule.GetBalancingTag()
+	if len(btag) > 0 {
+		brule, found := balancers[btag]
+		if !found {
+			return nil, newError("balancer ", btag, " not found")
+		}
+		rr.Balancer = brule
+	}
+	return rr, nil
 }
 
 // PickRoute implements routing.Router.
@@ -77,11 +164,14 @@ func (r *Router) PickRoute(ctx routing.Context) (routing.Route, error) {
 	if err != nil {
 		return nil, err
 	}
-	tag, err := rule.GetTag()
+	if rule.hits != nil {
+		rule.hits.Add(1)
+	}
+	tag, err := rule.getTag(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &Route{Context: ctx, outboundTag: tag}, nil
+	return &Route{Context: ctx, outboundTag: tag, rule: rule}, nil
 }
 
 func (r *Router) pickRouteInternal(ctx routing.Context) (*Rule, routing.Context, error) {
@@ -94,7 +184,11 @@ func (r *Router) pickRouteInternal(ctx routing.Context) (*Rule, routing.Context,
 		ctx = routing_dns.ContextWithDNSClient(ctx, r.dns)
 	}
 
-	for _, rule := range r.rules {
+	r.access.RLock()
+	rules := r.rules
+	r.access.RUnlock()
+
+	for _, rule := range rules {
 		if rule.Apply(ctx) {
 			return rule, ctx, nil
 		}
@@ -107,7 +201,7 @@ func (r *Router) pickRouteInternal(ctx routing.Context) (*Rule, routing.Context,
 	ctx = routing_dns.ContextWithDNSClient(ctx, r.dns)
 
 	// Try applying rules again if we have IPs.
-	for _, rule := range r.rules {
+	for _, rule := range rules {
 		if rule.Apply(ctx) {
 			return rule, ctx, nil
 		}
@@ -118,11 +212,31 @@ func (r *Router) pickRouteInternal(ctx routing.Context) (*Rule, routing.Context,
 
 // Start implements common.Runnable.
 func (r *Router) Start() error {
+	r.access.RLock()
+	defer r.access.RUnlock()
+
+	for _, ruleSet := range r.ruleSets {
+		if err := ruleSet.Start(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // Close implements common.Closable.
 func (r *Router) Close() error {
+	r.access.RLock()
+	defer r.access.RUnlock()
+
+	var errs []error
+	for _, ruleSet := range r.ruleSets {
+		if err := ruleSet.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Combine(errs...)
+	}
 	return nil
 }
 
@@ -141,10 +255,30 @@ func (r *Route) GetOutboundTag() string {
 	return r.outboundTag
 }
 
+// GetRuleTag implements routing.Route.
+func (r *Route) GetRuleTag() string {
+	if r.rule == nil {
+		return ""
+	}
+	return r.rule.RuleTag
+}
+
+// GetMatchedConditions implements routing.Route.
+func (r *Route) GetMatchedConditions() []string {
+	if r.rule == nil {
+		return nil
+	}
+	return r.rule.DescribeMatches(r.Context)
+}
+
 func init() {
 	common.Must(common.RegisterConfig((*Config)(nil), func(ctx context.Context, config interface{}) (interface{}, error) {
 		r := new(Router)
-		if err := core.RequireFeatures(ctx, func(d dns.Client, ohm outbound.Manager, dispatcher routing.Dispatcher) error {
+		if err := core.RequireFeatures(ctx, func(d dns.Client, ohm outbound.Manager, dispatcher routing.Dispatcher, pm policy.Manager, sm stats.Manager) error {
+			if p := pm.ForSystem().Stats; p.RuleHits || p.RuleUplink || p.RuleDownlink {
+				r.stats = sm
+				r.statsPolicy = p
+			}
 			return r.Init(ctx, config.(*Config), d, ohm, dispatcher)
 		}); err != nil {
 			return nil, err
@@ -224,7 +358,7 @@ func init() {
 					rule.Domain = append(rule.Domain, geo.Domain...)
 				}
 			}
-			{
+			if v.PortList != "" {
 				portList := &cfgcommon.PortList{}
 				err := portList.UnmarshalText(v.PortList)
 				if err != nil {
@@ -232,7 +366,7 @@ func init() {
 				}
 				rule.PortList = portList.Build()
 			}
-			{
+			if v.SourcePortList != "" {
 				portList := &cfgcommon.PortList{}
 				err := portList.UnmarshalText(v.SourcePortList)
 				if err != nil {
@@ -240,19 +374,46 @@ func init() {
 				}
 				rule.SourcePortList = portList.Build()
 			}
-			rule.Domain = v.Domain
-			rule.Networks = net.ParseNetworks(v.Networks)
+			rule.Domain = append(rule.Domain, v.Domain...)
+			if v.Networks != "" {
+				rule.Networks = net.ParseNetworks(v.Networks)
+			}
 			rule.Protocol = v.Protocol
 			rule.Attributes = v.Attributes
 			rule.UserEmail = v.UserEmail
 			rule.InboundTag = v.InboundTag
 			rule.DomainMatcher = v.DomainMatcher
+			rule.RuleTag = v.RuleTag
+			rule.ProcessName = v.ProcessName
+			rule.ProcessPath = v.ProcessPath
+			rule.ProcessUid = v.ProcessUid
+			rule.RuleSet = v.RuleSet
+
+			for _, window := range v.TimeWindow {
+				timeWindow, err := ParseTimeWindow(window)
+				if err != nil {
+					return nil, err
+				}
+				rule.TimeWindow = append(rule.TimeWindow, timeWindow)
+			}
+
+			switch target := v.TargetTag.(type) {
+			case *SimplifiedRoutingRule_Tag:
+				rule.TargetTag = &RoutingRule_Tag{Tag: target.Tag}
+			case *SimplifiedRoutingRule_BalancingTag:
+				rule.TargetTag = &RoutingRule_BalancingTag{BalancingTag: target.BalancingTag}
+			}
+
+			// Without this, rules of the simplified config never reach the
+			// router and routing results could not report them by rule tag.
+			routingRules = append(routingRules, rule)
 		}
 
 		fullConfig := &Config{
 			DomainStrategy: simplifiedConfig.DomainStrategy,
 			Rule:           routingRules,
 			BalancingRule:  simplifiedConfig.BalancingRule,
+			RuleSet:        simplifiedConfig.RuleSet,
 		}
 		return common.CreateObject(ctx, fullConfig)
 	}))
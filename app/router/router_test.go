@@ -5,14 +5,25 @@ import (
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	"google.golang.org/protobuf/types/known/anypb"
 
+	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/app/dispatcher"
+	"github.com/v2fly/v2ray-core/v4/app/policy"
+	"github.com/v2fly/v2ray-core/v4/app/proxyman"
+	_ "github.com/v2fly/v2ray-core/v4/app/proxyman/outbound"
 	. "github.com/v2fly/v2ray-core/v4/app/router"
 	"github.com/v2fly/v2ray-core/v4/app/router/routercommon"
+	"github.com/v2fly/v2ray-core/v4/app/stats"
 	"github.com/v2fly/v2ray-core/v4/common"
 	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/serial"
 	"github.com/v2fly/v2ray-core/v4/common/session"
 	"github.com/v2fly/v2ray-core/v4/features/outbound"
+	"github.com/v2fly/v2ray-core/v4/features/routing"
 	routing_session "github.com/v2fly/v2ray-core/v4/features/routing/session"
+	feature_stats "github.com/v2fly/v2ray-core/v4/features/stats"
+	_ "github.com/v2fly/v2ray-core/v4/infra/conf/geodata/standard"
 	"github.com/v2fly/v2ray-core/v4/testing/mocks"
 )
 
@@ -247,3 +258,192 @@ func TestIPIfNonMatchIP(t *testing.T) {
 		t.Error("expect tag 'test', bug actually ", tag)
 	}
 }
+
+func TestRuleManagement(t *testing.T) {
+	config := &Config{
+		Rule: []*RoutingRule{
+			{
+				TargetTag: &RoutingRule_Tag{
+					Tag: "test",
+				},
+				Networks: []net.Network{net.Network_TCP},
+				RuleTag:  "tcp",
+			},
+		},
+	}
+
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+
+	mockDNS := mocks.NewDNSClient(mockCtl)
+
+	r := new(Router)
+	common.Must(r.Init(context.TODO(), config, mockDNS, nil, nil))
+
+	pickTag := func() string {
+		ctx := session.ContextWithOutbound(context.Background(), &session.Outbound{Target: net.TCPDestination(net.DomainAddress("v2fly.org"), 80)})
+		route, err := r.PickRoute(routing_session.AsRoutingContext(ctx))
+		if err != nil {
+			return ""
+		}
+		return route.GetOutboundTag()
+	}
+
+	common.Must(r.AddRule(&RoutingRule{
+		TargetTag: &RoutingRule_Tag{
+			Tag: "direct",
+		},
+		Domain:  []*routercommon.Domain{{Type: routercommon.Domain_RootDomain, Value: "v2fly.org"}},
+		RuleTag: "v2fly",
+	}, true))
+	if tag := pickTag(); tag != "direct" {
+		t.Error("expect tag 'direct', but actually ", tag)
+	}
+	if err := r.AddRule(&RoutingRule{
+		TargetTag: &RoutingRule_Tag{Tag: "test"},
+		Networks:  []net.Network{net.Network_UDP},
+		RuleTag:   "v2fly",
+	}, false); err == nil {
+		t.Error("expect error when adding duplicate rule tag")
+	}
+
+	common.Must(r.RemoveRule("v2fly"))
+	if tag := pickTag(); tag != "test" {
+		t.Error("expect tag 'test', but actually ", tag)
+	}
+	if err := r.RemoveRule("v2fly"); err == nil {
+		t.Error("expect error when removing non-existent rule")
+	}
+
+	common.Must(r.ReplaceRules([]*RoutingRule{
+		{
+			TargetTag: &RoutingRule_Tag{
+				Tag: "udp",
+			},
+			Networks: []net.Network{net.Network_UDP},
+			RuleTag:  "udp",
+		},
+	}, nil, nil))
+	if tag := pickTag(); tag != "" {
+		t.Error("expect no route, but actually ", tag)
+	}
+	rules, balancingRules, _ := r.ListRules()
+	if len(rules) != 1 || rules[0].RuleTag != "udp" || len(balancingRules) != 0 {
+		t.Error("unexpected rules after replacing: ", rules, balancingRules)
+	}
+}
+
+func TestRuleHitsCounter(t *testing.T) {
+	config := &core.Config{
+		App: []*anypb.Any{
+			serial.ToTypedMessage(&Config{
+				Rule: []*RoutingRule{
+					{
+						TargetTag: &RoutingRule_Tag{
+							Tag: "test",
+						},
+						Networks: []net.Network{net.Network_TCP},
+						RuleTag:  "tcp",
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(&stats.Config{}),
+			serial.ToTypedMessage(&policy.Config{
+				System: &policy.SystemPolicy{
+					Stats: &policy.SystemPolicy_Stats{
+						RuleHits:     true,
+						RuleDownlink: true,
+					},
+				},
+			}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	r := v.GetFeature(routing.RouterType()).(*Router)
+	sm := v.GetFeature(feature_stats.ManagerType()).(feature_stats.Manager)
+
+	ctx := session.ContextWithOutbound(context.Background(), &session.Outbound{Target: net.TCPDestination(net.DomainAddress("v2fly.org"), 80)})
+	for i := 0; i < 3; i++ {
+		if _, err := r.PickRoute(routing_session.AsRoutingContext(ctx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counter := sm.GetCounter(RuleHitsCounterName("tcp"))
+	if counter == nil {
+		t.Fatal("counter for rule 'tcp' is not registered")
+	}
+	if v := counter.Value(); v != 3 {
+		t.Error("expect 3 hits, but actually ", v)
+	}
+
+	if sm.GetCounter(RuleDownlinkCounterName("tcp")) == nil {
+		t.Error("downlink counter for rule 'tcp' is not registered")
+	}
+	if sm.GetCounter(RuleUplinkCounterName("tcp")) != nil {
+		t.Error("uplink counter for rule 'tcp' is registered while disabled")
+	}
+
+	common.Must(r.RemoveRule("tcp"))
+	if sm.GetCounter(RuleHitsCounterName("tcp")) != nil {
+		t.Error("expect counter for rule 'tcp' to be unregistered")
+	}
+	if sm.GetCounter(RuleDownlinkCounterName("tcp")) != nil {
+		t.Error("expect downlink counter for rule 'tcp' to be unregistered")
+	}
+}
+
+func TestSimplifiedConfig(t *testing.T) {
+	config := &core.Config{
+		App: []*anypb.Any{
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(&SimplifiedConfig{
+				Rule: []*SimplifiedRoutingRule{
+					{
+						TargetTag: &SimplifiedRoutingRule_Tag{
+							Tag: "test",
+						},
+						Domain:  []*routercommon.Domain{{Type: routercommon.Domain_RootDomain, Value: "v2fly.org"}},
+						RuleTag: "v2fly",
+					},
+					{
+						TargetTag: &SimplifiedRoutingRule_BalancingTag{
+							BalancingTag: "balance",
+						},
+						Networks: "udp",
+					},
+				},
+				BalancingRule: []*BalancingRule{
+					{
+						Tag:              "balance",
+						OutboundSelector: []string{"test"},
+					},
+				},
+			}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+	r := v.GetFeature(routing.RouterType()).(routing.Router)
+
+	ctx := session.ContextWithOutbound(context.Background(), &session.Outbound{Target: net.TCPDestination(net.DomainAddress("www.v2fly.org"), 80)})
+	route, err := r.PickRoute(routing_session.AsRoutingContext(ctx))
+	common.Must(err)
+	if tag := route.GetOutboundTag(); tag != "test" {
+		t.Error("expect tag 'test', but actually ", tag)
+	}
+	if tag := route.GetRuleTag(); tag != "v2fly" {
+		t.Error("expect rule tag 'v2fly', but actually ", tag)
+	}
+
+	ctx = session.ContextWithOutbound(context.Background(), &session.Outbound{Target: net.TCPDestination(net.DomainAddress("www.example.com"), 80)})
+	if _, err := r.PickRoute(routing_session.AsRoutingContext(ctx)); err == nil {
+		t.Error("expect no route for unmatched domain")
+	}
+}
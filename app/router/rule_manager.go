@@ -0,0 +1,139 @@
+package router
+
+// AddRule adds a routing rule to the router. The rule is inserted before all
+// existing rules if prepend is set, otherwise it is appended after them.
+func (r *Router) AddRule(config *RoutingRule, prepend bool) error {
+	r.access.Lock()
+	defer r.access.Unlock()
+
+	if ruleTag := config.GetRuleTag(); len(ruleTag) > 0 && r.findRule(ruleTag) >= 0 {
+		return newError("rule tag ", ruleTag, " already exists")
+	}
+	rule, err := buildRule(config, r.balancers, r.ruleSets)
+	if err != nil {
+		return newError("failed to build rule").Base(err)
+	}
+	r.registerRuleCounters([]*Rule{rule})
+
+	rules := make([]*Rule, 0, len(r.rules)+1)
+	if prepend {
+		rules = append(rules, rule)
+		rules = append(rules, r.rules...)
+	} else {
+		rules = append(rules, r.rules...)
+		rules = append(rules, rule)
+	}
+	r.rules = rules
+	return nil
+}
+
+// RemoveRule removes the routing rule with the given rule tag from the router.
+func (r *Router) RemoveRule(ruleTag string) error {
+	if len(ruleTag) == 0 {
+		return newError("empty rule tag")
+	}
+
+	r.access.Lock()
+	defer r.access.Unlock()
+
+	idx := r.findRule(ruleTag)
+	if idx < 0 {
+		return newError("rule ", ruleTag, " not found")
+	}
+	rules := make([]*Rule, 0, len(r.rules)-1)
+	rules = append(rules, r.rules[:idx]...)
+	rules = append(rules, r.rules[idx+1:]...)
+	r.unregisterRuleCounters(r.rules[idx:idx+1], rules)
+	r.rules = rules
+	return nil
+}
+
+// ListRules returns the configurations of routing rules, balancers and rule sets currently in use.
+func (r *Router) ListRules() ([]*RoutingRule, []*BalancingRule, []*RuleSet) {
+	r.access.RLock()
+	defer r.access.RUnlock()
+
+	rules := make([]*RoutingRule, 0, len(r.rules))
+	for _, rule := range r.rules {
+		rules = append(rules, rule.config)
+	}
+	balancingRules := make([]*BalancingRule, len(r.balancingRules))
+	copy(balancingRules, r.balancingRules)
+	ruleSets := make([]*RuleSet, len(r.ruleSetConfigs))
+	copy(ruleSets, r.ruleSetConfigs)
+	return rules, balancingRules, ruleSets
+}
+
+// ReplaceRules atomically replaces all routing rules, balancers and rule sets of the router.
+// Override targets of balancers are kept if a balancer with the same tag still exists, and
+// rule sets with unchanged configuration keep their loaded content.
+func (r *Router) ReplaceRules(routingRules []*RoutingRule, balancingRules []*BalancingRule, ruleSetConfigs []*RuleSet) error {
+	ruleSets, err := r.buildRuleSets(ruleSetConfigs)
+	if err != nil {
+		return newError("failed to build rule sets").Base(err)
+	}
+	balancers, err := r.buildBalancers(balancingRules)
+	if err != nil {
+		r.closeNewRuleSets(ruleSets)
+		return newError("failed to build balancers").Base(err)
+	}
+	rules, err := r.buildRules(routingRules, balancers, ruleSets)
+	if err != nil {
+		r.closeNewRuleSets(ruleSets)
+		return newError("failed to build rules").Base(err)
+	}
+	for _, ruleSet := range ruleSets {
+		if err := ruleSet.Start(); err != nil {
+			r.closeNewRuleSets(ruleSets)
+			r.access.RLock()
+			r.unregisterRuleCounters(rules, r.rules)
+			r.access.RUnlock()
+			return newError("failed to start rule set ", ruleSet.config.Tag).Base(err)
+		}
+	}
+
+	r.access.Lock()
+	for tag, balancer := range balancers {
+		if old, found := r.balancers[tag]; found {
+			if target := old.override.Get(); target != "" {
+				balancer.override.Put(target)
+			}
+		}
+	}
+	r.unregisterRuleCounters(r.rules, rules)
+	oldRuleSets := r.ruleSets
+	r.ruleSets = ruleSets
+	r.ruleSetConfigs = ruleSetConfigs
+	r.balancers = balancers
+	r.balancingRules = balancingRules
+	r.rules = rules
+	r.access.Unlock()
+
+	for tag, ruleSet := range oldRuleSets {
+		if ruleSets[tag] != ruleSet {
+			ruleSet.Close()
+		}
+	}
+	return nil
+}
+
+// closeNewRuleSets closes the rule sets built by buildRuleSets that are not in
+// use by the router.
+func (r *Router) closeNewRuleSets(ruleSets map[string]*ruleSetProvider) {
+	r.access.RLock()
+	defer r.access.RUnlock()
+	for tag, ruleSet := range ruleSets {
+		if r.ruleSets[tag] != ruleSet {
+			ruleSet.Close()
+		}
+	}
+}
+
+func (r *Router) findRule(ruleTag string) int {
+	for idx, rule := range r.rules {
+		if rule.RuleTag == ruleTag {
+			return idx
+		}
+	}
+	return -1
+}
@@ -0,0 +1,81 @@
+package router
+
+import (
+	"github.com/v2fly/v2ray-core/v4/features/policy"
+	"github.com/v2fly/v2ray-core/v4/features/routing"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
+)
+
+// RuleHitsCounterName returns the name of the stats counter for hits of the routing rule with the given tag.
+func RuleHitsCounterName(ruleTag string) string {
+	return routing.RuleHitsCounterName(ruleTag)
+}
+
+// RuleUplinkCounterName returns the name of the stats counter for uplink traffic routed by the rule with the given tag.
+func RuleUplinkCounterName(ruleTag string) string {
+	return routing.RuleUplinkCounterName(ruleTag)
+}
+
+// RuleDownlinkCounterName returns the name of the stats counter for downlink traffic routed by the rule with the given tag.
+func RuleDownlinkCounterName(ruleTag string) string {
+	return routing.RuleDownlinkCounterName(ruleTag)
+}
+
+// ruleCounterNames returns the names of the counters of a tagged rule enabled by the stats policy.
+func ruleCounterNames(p policy.SystemStats, ruleTag string) []string {
+	var names []string
+	if p.RuleHits {
+		names = append(names, RuleHitsCounterName(ruleTag))
+	}
+	if p.RuleUplink {
+		names = append(names, RuleUplinkCounterName(ruleTag))
+	}
+	if p.RuleDownlink {
+		names = append(names, RuleDownlinkCounterName(ruleTag))
+	}
+	return names
+}
+
+// registerRuleCounters registers the counters of the tagged rules enabled by the stats policy,
+// and attaches hit counters to the rules. Traffic counters are looked up by the dispatcher.
+func (r *Router) registerRuleCounters(rules []*Rule) {
+	if r.stats == nil {
+		return
+	}
+	for _, rule := range rules {
+		if len(rule.RuleTag) == 0 {
+			continue
+		}
+		for _, name := range ruleCounterNames(r.statsPolicy, rule.RuleTag) {
+			c, err := stats.GetOrRegisterCounter(r.stats, name)
+			if err != nil {
+				newError("failed to register counter ", name).Base(err).AtWarning().WriteToLog()
+				continue
+			}
+			if name == RuleHitsCounterName(rule.RuleTag) {
+				rule.hits = c
+			}
+		}
+	}
+}
+
+// unregisterRuleCounters removes counters of the rules that are not in use anymore.
+func (r *Router) unregisterRuleCounters(removed []*Rule, remaining []*Rule) {
+	if r.stats == nil {
+		return
+	}
+	inUse := make(map[string]bool, len(remaining))
+	for _, rule := range remaining {
+		inUse[rule.RuleTag] = true
+	}
+	for _, rule := range removed {
+		if len(rule.RuleTag) == 0 || inUse[rule.RuleTag] {
+			continue
+		}
+		for _, name := range ruleCounterNames(r.statsPolicy, rule.RuleTag) {
+			if err := r.stats.UnregisterCounter(name); err != nil {
+				newError("failed to unregister counter ", name).Base(err).AtWarning().WriteToLog()
+			}
+		}
+	}
+}
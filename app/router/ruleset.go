@@ -0,0 +1,302 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/v2fly/v2ray-core/v4/app/router/routercommon"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/platform"
+	"github.com/v2fly/v2ray-core/v4/common/platform/filesystem"
+	"github.com/v2fly/v2ray-core/v4/common/task"
+	"github.com/v2fly/v2ray-core/v4/features/routing"
+)
+
+const defaultRuleSetReloadInterval = 5 * time.Second
+
+// ParseRuleSetText parses rule set content in text format. Each line contains one entry of:
+//   - a domain rule, in the same form as in routing rules: "domain:", "full:", "regexp:" or "keyword:"
+//     followed by the value. A domain without type prefix is treated as "domain:".
+//   - an IP address or CIDR, e.g. "10.0.0.0/8".
+//   - a port or port range prefixed with "port:", e.g. "port:53" or "port:1000-2000".
+//
+// Empty lines and lines starting with "#" are ignored.
+func ParseRuleSetText(content []byte) (*RuleSetContent, error) {
+	ruleSet := new(RuleSetContent)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := ruleSet.addEntry(line); err != nil {
+			return nil, newError("invalid entry in line ", lineNum).Base(err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ruleSet, nil
+}
+
+func (c *RuleSetContent) addEntry(entry string) error {
+	if strings.HasPrefix(entry, "port:") {
+		portRange, err := parsePortRange(entry[5:])
+		if err != nil {
+			return err
+		}
+		if c.PortList == nil {
+			c.PortList = new(net.PortList)
+		}
+		c.PortList.Range = append(c.PortList.Range, portRange)
+		return nil
+	}
+
+	if cidr, ok := parseCIDR(entry); ok {
+		c.Cidr = append(c.Cidr, cidr)
+		return nil
+	}
+
+	domain := new(routercommon.Domain)
+	switch {
+	case strings.HasPrefix(entry, "domain:"):
+		domain.Type, domain.Value = routercommon.Domain_RootDomain, entry[7:]
+	case strings.HasPrefix(entry, "full:"):
+		domain.Type, domain.Value = routercommon.Domain_Full, entry[5:]
+	case strings.HasPrefix(entry, "regexp:"):
+		domain.Type, domain.Value = routercommon.Domain_Regex, entry[7:]
+	case strings.HasPrefix(entry, "keyword:"):
+		domain.Type, domain.Value = routercommon.Domain_Plain, entry[8:]
+	default:
+		domain.Type, domain.Value = routercommon.Domain_RootDomain, entry
+	}
+	if len(domain.Value) == 0 {
+		return newError("empty domain: ", entry)
+	}
+	c.Domain = append(c.Domain, domain)
+	return nil
+}
+
+func parsePortRange(s string) (*net.PortRange, error) {
+	bounds := strings.SplitN(s, "-", 2)
+	from, err := strconv.ParseUint(strings.TrimSpace(bounds[0]), 10, 16)
+	if err != nil {
+		return nil, newError("invalid port: ", s).Base(err)
+	}
+	to := from
+	if len(bounds) == 2 {
+		if to, err = strconv.ParseUint(strings.TrimSpace(bounds[1]), 10, 16); err != nil {
+			return nil, newError("invalid port: ", s).Base(err)
+		}
+	}
+	return &net.PortRange{From: uint32(from), To: uint32(to)}, nil
+}
+
+func parseCIDR(s string) (*routercommon.CIDR, bool) {
+	ipStr, prefixStr := s, ""
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		ipStr, prefixStr = s[:i], s[i+1:]
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return nil, false
+	}
+	if ip4 := ip.To4(); ip4 != nil && !strings.Contains(ipStr, ":") {
+		ip = ip4
+	}
+	prefix := uint64(len(ip) * 8)
+	if len(prefixStr) > 0 {
+		var err error
+		if prefix, err = strconv.ParseUint(prefixStr, 10, 8); err != nil || prefix > uint64(len(ip)*8) {
+			return nil, false
+		}
+	}
+	return &routercommon.CIDR{Ip: []byte(ip), Prefix: uint32(prefix)}, true
+}
+
+// ruleSetMatcher is the compiled content of a rule set.
+type ruleSetMatcher struct {
+	domain *DomainMatcher
+	ip     *GeoIPMatcher
+	port   net.MemoryPortList
+}
+
+func newRuleSetMatcher(content *RuleSetContent) (*ruleSetMatcher, error) {
+	m := new(ruleSetMatcher)
+	if len(content.Domain) > 0 {
+		domain, err := NewDomainMatcher("mph", content.Domain)
+		if err != nil {
+			return nil, err
+		}
+		m.domain = domain
+	}
+	if len(content.Cidr) > 0 {
+		m.ip = new(GeoIPMatcher)
+		if err := m.ip.Init(content.Cidr); err != nil {
+			return nil, err
+		}
+	}
+	if content.PortList != nil {
+		m.port = net.PortListFromProto(content.PortList)
+	}
+	return m, nil
+}
+
+func (m *ruleSetMatcher) describe(ctx routing.Context) string {
+	if m.domain != nil {
+		if domain := ctx.GetTargetDomain(); len(domain) > 0 && m.domain.Match(domain) {
+			return domain
+		}
+	}
+	if m.ip != nil {
+		for _, ip := range ctx.GetTargetIPs() {
+			if m.ip.Match(ip) {
+				return ip.String()
+			}
+		}
+	}
+	if len(m.port) > 0 {
+		if port := ctx.GetTargetPort(); m.port.Contains(port) {
+			return "port " + port.String()
+		}
+	}
+	return ""
+}
+
+// ruleSetProvider provides the content of a rule set file, and reloads it when the file changes.
+// Relative paths are resolved against the asset location.
+type ruleSetProvider struct {
+	config *RuleSet
+	path   string
+
+	access  sync.RWMutex
+	matcher *ruleSetMatcher
+	modTime time.Time
+	size    int64
+	reload  *task.Periodic
+}
+
+func newRuleSetProvider(config *RuleSet) (*ruleSetProvider, error) {
+	if len(config.Tag) == 0 {
+		return nil, newError("rule set tag is empty")
+	}
+	path := config.Path
+	if !filepath.IsAbs(path) {
+		path = platform.GetAssetLocation(path)
+	}
+	p := &ruleSetProvider{
+		config: config,
+		path:   path,
+	}
+	if _, err := p.load(); err != nil {
+		return nil, newError("failed to load rule set ", config.Tag).Base(err)
+	}
+	interval := defaultRuleSetReloadInterval
+	if config.ReloadInterval > 0 {
+		interval = time.Duration(config.ReloadInterval) * time.Second
+	}
+	p.reload = &task.Periodic{
+		Interval: interval,
+		Execute:  p.checkReload,
+	}
+	return p, nil
+}
+
+// load reads and compiles the rule set file if it has been modified since last load.
+func (p *ruleSetProvider) load() (bool, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return false, err
+	}
+	p.access.RLock()
+	unchanged := p.matcher != nil && info.ModTime().Equal(p.modTime) && info.Size() == p.size
+	p.access.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := filesystem.ReadFile(p.path)
+	if err != nil {
+		return false, err
+	}
+	var content *RuleSetContent
+	switch p.config.Format {
+	case RuleSet_Protobuf:
+		content = new(RuleSetContent)
+		err = proto.Unmarshal(data, content)
+	default:
+		content, err = ParseRuleSetText(data)
+	}
+	if err != nil {
+		return false, err
+	}
+	matcher, err := newRuleSetMatcher(content)
+	if err != nil {
+		return false, err
+	}
+
+	p.access.Lock()
+	p.matcher = matcher
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+	p.access.Unlock()
+	return true, nil
+}
+
+func (p *ruleSetProvider) checkReload() error {
+	reloaded, err := p.load()
+	if err != nil {
+		// Keep using the previous content until the file is fixed.
+		newError("failed to reload rule set ", p.config.Tag).Base(err).AtWarning().WriteToLog()
+		return nil
+	}
+	if reloaded {
+		newError("rule set ", p.config.Tag, " reloaded").AtInfo().WriteToLog()
+	}
+	return nil
+}
+
+func (p *ruleSetProvider) getMatcher() *ruleSetMatcher {
+	p.access.RLock()
+	defer p.access.RUnlock()
+	return p.matcher
+}
+
+// Start implements common.Runnable.
+func (p *ruleSetProvider) Start() error {
+	return p.reload.Start()
+}
+
+// Close implements common.Closable.
+func (p *ruleSetProvider) Close() error {
+	return p.reload.Close()
+}
+
+// RuleSetMatcher matches routing contexts against the current content of rule sets.
+type RuleSetMatcher struct {
+	tags      []string
+	providers []*ruleSetProvider
+}
+
+// Apply implements Condition.
+func (m *RuleSetMatcher) Apply(ctx routing.Context) bool {
+	return len(m.DescribeMatch(ctx)) > 0
+}
+
+// DescribeMatch implements MatchDescriber.
+func (m *RuleSetMatcher) DescribeMatch(ctx routing.Context) string {
+	for i, provider := range m.providers {
+		if description := provider.getMatcher().describe(ctx); len(description) > 0 {
+			return "rule_set:" + m.tags[i] + " (" + description + ")"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,198 @@
+package router_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+
+	. "github.com/v2fly/v2ray-core/v4/app/router"
+	"github.com/v2fly/v2ray-core/v4/app/router/routercommon"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/session"
+	routing_session "github.com/v2fly/v2ray-core/v4/features/routing/session"
+	"github.com/v2fly/v2ray-core/v4/testing/mocks"
+)
+
+func TestParseRuleSetText(t *testing.T) {
+	content, err := ParseRuleSetText([]byte(`
+# comment
+v2fly.org
+full:www.example.com
+regexp:^ads\d+\.
+keyword:tracker
+10.0.0.0/8
+::1
+port:53
+port:1000-2000
+`))
+	common.Must(err)
+
+	if len(content.Domain) != 4 {
+		t.Fatal("expect 4 domains, but got ", content.Domain)
+	}
+	expectedTypes := []routercommon.Domain_Type{routercommon.Domain_RootDomain, routercommon.Domain_Full, routercommon.Domain_Regex, routercommon.Domain_Plain}
+	for i, domain := range content.Domain {
+		if domain.Type != expectedTypes[i] {
+			t.Error("expect domain type ", expectedTypes[i], ", but got ", domain.Type)
+		}
+	}
+	if len(content.Cidr) != 2 || content.Cidr[0].Prefix != 8 || len(content.Cidr[0].Ip) != 4 || content.Cidr[1].Prefix != 128 {
+		t.Error("unexpected CIDRs: ", content.Cidr)
+	}
+	if len(content.PortList.Range) != 2 || content.PortList.Range[1].From != 1000 || content.PortList.Range[1].To != 2000 {
+		t.Error("unexpected ports: ", content.PortList)
+	}
+
+	if _, err := ParseRuleSetText([]byte("port:abc")); err == nil {
+		t.Error("expect error for invalid port")
+	}
+}
+
+func TestRuleSetReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	common.Must(os.WriteFile(path, []byte("v2fly.org\n"), 0o644))
+
+	config := &Config{
+		Rule: []*RoutingRule{
+			{
+				TargetTag: &RoutingRule_Tag{
+					Tag: "test",
+				},
+				RuleSet: []string{"rules"},
+			},
+		},
+		RuleSet: []*RuleSet{
+			{
+				Tag:            "rules",
+				Path:           path,
+				ReloadInterval: 1,
+			},
+		},
+	}
+
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+
+	mockDNS := mocks.NewDNSClient(mockCtl)
+	mockOhm := mocks.NewOutboundManager(mockCtl)
+	mockHs := mocks.NewOutboundHandlerSelector(mockCtl)
+
+	r := new(Router)
+	common.Must(r.Init(context.TODO(), config, mockDNS, &mockOutboundManager{
+		Manager:         mockOhm,
+		HandlerSelector: mockHs,
+	}, nil))
+	common.Must(r.Start())
+	defer r.Close()
+
+	pick := func(domain string) string {
+		ctx := session.ContextWithOutbound(context.Background(), &session.Outbound{Target: net.TCPDestination(net.DomainAddress(domain), 80)})
+		route, err := r.PickRoute(routing_session.AsRoutingContext(ctx))
+		if err != nil {
+			return ""
+		}
+		return route.GetOutboundTag()
+	}
+
+	if tag := pick("www.v2fly.org"); tag != "test" {
+		t.Fatal("expect tag 'test', but actually ", tag)
+	}
+	if tag := pick("example.com"); tag != "" {
+		t.Fatal("expect no route, but actually ", tag)
+	}
+
+	common.Must(os.WriteFile(path, []byte("example.com\n"), 0o644))
+	modTime := time.Now().Add(time.Minute)
+	common.Must(os.Chtimes(path, modTime, modTime))
+
+	for deadline := time.Now().Add(5 * time.Second); pick("example.com") != "test"; {
+		if time.Now().After(deadline) {
+			t.Fatal("rule set not reloaded")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if tag := pick("www.v2fly.org"); tag != "" {
+		t.Error("expect no route after reload, but actually ", tag)
+	}
+
+	if err := new(Router).Init(context.TODO(), &Config{
+		Rule: []*RoutingRule{
+			{
+				TargetTag: &RoutingRule_Tag{Tag: "test"},
+				RuleSet:   []string{"unknown"},
+			},
+		},
+	}, mockDNS, nil, nil); err == nil {
+		t.Error("expect error for unknown rule set")
+	}
+}
+
+func TestRuleSetReplace(t *testing.T) {
+	dir := t.TempDir()
+	v2flyPath := filepath.Join(dir, "v2fly.txt")
+	common.Must(os.WriteFile(v2flyPath, []byte("v2fly.org\n"), 0o644))
+	examplePath := filepath.Join(dir, "example.txt")
+	common.Must(os.WriteFile(examplePath, []byte("example.com\n"), 0o644))
+
+	config := &Config{
+		Rule: []*RoutingRule{
+			{
+				TargetTag: &RoutingRule_Tag{Tag: "test"},
+				RuleSet:   []string{"v2fly"},
+			},
+		},
+		RuleSet: []*RuleSet{{Tag: "v2fly", Path: v2flyPath}},
+	}
+
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+
+	r := new(Router)
+	common.Must(r.Init(context.TODO(), config, mocks.NewDNSClient(mockCtl), nil, nil))
+	common.Must(r.Start())
+	defer r.Close()
+
+	pick := func(domain string) string {
+		ctx := session.ContextWithOutbound(context.Background(), &session.Outbound{Target: net.TCPDestination(net.DomainAddress(domain), 80)})
+		route, err := r.PickRoute(routing_session.AsRoutingContext(ctx))
+		if err != nil {
+			return ""
+		}
+		return route.GetOutboundTag()
+	}
+
+	if err := r.ReplaceRules([]*RoutingRule{
+		{
+			TargetTag: &RoutingRule_Tag{Tag: "test"},
+			RuleSet:   []string{"example"},
+		},
+	}, nil, nil); err == nil {
+		t.Error("expect error for rule referring to a removed rule set")
+	}
+
+	common.Must(r.ReplaceRules([]*RoutingRule{
+		{
+			TargetTag: &RoutingRule_Tag{Tag: "test"},
+			RuleSet:   []string{"v2fly", "example"},
+		},
+	}, nil, []*RuleSet{
+		{Tag: "v2fly", Path: v2flyPath},
+		{Tag: "example", Path: examplePath},
+	}))
+	if tag := pick("example.com"); tag != "test" {
+		t.Error("expect tag 'test', but actually ", tag)
+	}
+	if tag := pick("www.v2fly.org"); tag != "test" {
+		t.Error("expect tag 'test', but actually ", tag)
+	}
+
+	_, _, ruleSets := r.ListRules()
+	if len(ruleSets) != 2 || ruleSets[0].Tag != "v2fly" || ruleSets[1].Tag != "example" {
+		t.Error("unexpected rule sets: ", ruleSets)
+	}
+}
@@ -0,0 +1,162 @@
+//go:build !confonly
+// +build !confonly
+
+package router
+
+import (
+	"context"
+	"hash/fnv"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/features/routing"
+)
+
+// ConsistentHashStrategy represents a balancing strategy that picks the same outbound for the same
+// hash key, e.g. source IP, as long as the outbound is alive. It uses rendezvous hashing, so only
+// keys on a dead outbound are moved to other outbounds.
+type ConsistentHashStrategy struct {
+	ctx    context.Context
+	config *StrategyConsistentHashConfig
+
+	expression *starlark.Program
+}
+
+// NewConsistentHashStrategy creates a new ConsistentHashStrategy with settings
+func NewConsistentHashStrategy(config *StrategyConsistentHashConfig) (*ConsistentHashStrategy, error) {
+	s := &ConsistentHashStrategy{config: config}
+	if config.Key == StrategyConsistentHashConfig_Expression {
+		if len(config.Expression) == 0 {
+			return nil, newError("empty hash key expression")
+		}
+		starFile, err := syntax.Parse("hash.star", "key=("+config.Expression+")", 0)
+		if err != nil {
+			return nil, newError("invalid hash key expression").Base(err)
+		}
+		p, err := starlark.FileProgram(starFile, func(name string) bool {
+			switch name {
+			case "attrs", "source_ip", "domain", "user", "inbound_tag":
+				return true
+			default:
+				return false
+			}
+		})
+		if err != nil {
+			return nil, newError("invalid hash key expression").Base(err)
+		}
+		s.expression = p
+	}
+	return s, nil
+}
+
+func (s *ConsistentHashStrategy) InjectContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+func (s *ConsistentHashStrategy) GetPrincipleTarget(candidates []string) []string {
+	return aliveOutbounds(s.ctx, s.config.ObserverTag, candidates)
+}
+
+func (s *ConsistentHashStrategy) PickOutbound(candidates []string) string {
+	return s.PickOutboundWithContext(nil, candidates)
+}
+
+// PickOutboundWithContext implements ContextualBalancingStrategy.
+func (s *ConsistentHashStrategy) PickOutboundWithContext(ctx routing.Context, candidates []string) string {
+	key := hash64(s.hashKey(ctx))
+	var selected string
+	var maxScore uint64
+	for _, tag := range aliveOutbounds(s.ctx, s.config.ObserverTag, candidates) {
+		if score := mix64(key ^ hash64(tag)); len(selected) == 0 || score > maxScore {
+			selected, maxScore = tag, score
+		}
+	}
+	// goes to fallbackTag if empty
+	return selected
+}
+
+func (s *ConsistentHashStrategy) hashKey(ctx routing.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	switch s.config.Key {
+	case StrategyConsistentHashConfig_Domain:
+		if domain := ctx.GetTargetDomain(); len(domain) > 0 {
+			return domain
+		}
+		if ips := ctx.GetTargetIPs(); len(ips) > 0 {
+			return ips[0].String()
+		}
+	case StrategyConsistentHashConfig_User:
+		return ctx.GetUser()
+	case StrategyConsistentHashConfig_Expression:
+		return s.evalExpression(ctx)
+	default:
+		if ips := ctx.GetSourceIPs(); len(ips) > 0 {
+			return ips[0].String()
+		}
+	}
+	return ""
+}
+
+func (s *ConsistentHashStrategy) evalExpression(ctx routing.Context) string {
+	attrsDict := new(starlark.Dict)
+	for key, value := range ctx.GetAttributes() {
+		attrsDict.SetKey(starlark.String(key), starlark.String(value))
+	}
+	var sourceIP, domain string
+	if ips := ctx.GetSourceIPs(); len(ips) > 0 {
+		sourceIP = ips[0].String()
+	}
+	if domain = ctx.GetTargetDomain(); len(domain) == 0 {
+		if ips := ctx.GetTargetIPs(); len(ips) > 0 {
+			domain = ips[0].String()
+		}
+	}
+	predefined := starlark.StringDict{
+		"attrs":       attrsDict,
+		"source_ip":   starlark.String(sourceIP),
+		"domain":      starlark.String(domain),
+		"user":        starlark.String(ctx.GetUser()),
+		"inbound_tag": starlark.String(ctx.GetInboundTag()),
+	}
+
+	thread := &starlark.Thread{
+		Name: "balancer",
+	}
+	results, err := s.expression.Init(thread, predefined)
+	if err != nil {
+		newError("hash key expression").Base(err).WriteToLog()
+		return ""
+	}
+	switch key := results["key"].(type) {
+	case nil:
+		return ""
+	case starlark.String:
+		return key.GoString()
+	default:
+		return key.String()
+	}
+}
+
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mix64 is the finalizer of SplitMix64, which scrambles the bits of combined hashes.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func init() {
+	common.Must(common.RegisterConfig((*StrategyConsistentHashConfig)(nil), nil))
+}
@@ -0,0 +1,87 @@
+package router_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	. "github.com/v2fly/v2ray-core/v4/app/router"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/protocol"
+	"github.com/v2fly/v2ray-core/v4/common/session"
+	"github.com/v2fly/v2ray-core/v4/features/routing"
+	routing_session "github.com/v2fly/v2ray-core/v4/features/routing/session"
+)
+
+func sourceContext(i int) routing.Context {
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{
+		Source: net.TCPDestination(net.IPAddress([]byte{10, 0, byte(i >> 8), byte(i)}), 1234),
+		User:   &protocol.MemoryUser{Email: "user" + strconv.Itoa(i%10)},
+	})
+	return routing_session.AsRoutingContext(ctx)
+}
+
+func TestConsistentHashStrategy(t *testing.T) {
+	s, err := NewConsistentHashStrategy(&StrategyConsistentHashConfig{})
+	common.Must(err)
+
+	candidates := []string{"a", "b", "c", "d"}
+	picked := make(map[int]string)
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		tag := s.PickOutboundWithContext(sourceContext(i), candidates)
+		if again := s.PickOutboundWithContext(sourceContext(i), candidates); again != tag {
+			t.Fatal("expect the same outbound for the same source, but got ", tag, " and ", again)
+		}
+		picked[i] = tag
+		counts[tag]++
+	}
+	for _, tag := range candidates {
+		if counts[tag] < 150 {
+			t.Error("unbalanced distribution: ", counts)
+		}
+	}
+
+	// Only sources on the removed outbound should be moved.
+	remaining := []string{"a", "b", "d"}
+	for i := 0; i < 1000; i++ {
+		tag := s.PickOutboundWithContext(sourceContext(i), remaining)
+		if picked[i] != "c" && tag != picked[i] {
+			t.Error("source ", i, " moved from ", picked[i], " to ", tag)
+		}
+		if tag == "c" {
+			t.Error("picked removed outbound")
+		}
+	}
+
+	if tag := s.PickOutboundWithContext(sourceContext(0), nil); tag != "" {
+		t.Error("expect empty tag without candidates, but got ", tag)
+	}
+}
+
+func TestConsistentHashStrategyExpression(t *testing.T) {
+	if _, err := NewConsistentHashStrategy(&StrategyConsistentHashConfig{
+		Key: StrategyConsistentHashConfig_Expression,
+	}); err == nil {
+		t.Error("expect error for empty expression")
+	}
+
+	s, err := NewConsistentHashStrategy(&StrategyConsistentHashConfig{
+		Key:        StrategyConsistentHashConfig_Expression,
+		Expression: "user",
+	})
+	common.Must(err)
+	users, err := NewConsistentHashStrategy(&StrategyConsistentHashConfig{
+		Key: StrategyConsistentHashConfig_User,
+	})
+	common.Must(err)
+
+	candidates := []string{"a", "b", "c", "d"}
+	for i := 0; i < 100; i++ {
+		// Sources of the same user are sent to the same outbound.
+		if expected, tag := users.PickOutboundWithContext(sourceContext(i%10), candidates), s.PickOutboundWithContext(sourceContext(i), candidates); tag != expected {
+			t.Error("expect ", expected, " for user ", i%10, ", but got ", tag)
+		}
+	}
+}
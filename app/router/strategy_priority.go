@@ -0,0 +1,75 @@
+//go:build !confonly
+// +build !confonly
+
+package router
+
+import (
+	"context"
+	"strings"
+
+	"github.com/v2fly/v2ray-core/v4/common"
+)
+
+// PriorityStrategy represents a failover balancing strategy, which always picks the first
+// alive outbound in the order of priority.
+type PriorityStrategy struct {
+	ctx      context.Context
+	settings *StrategyPriorityConfig
+	priority []string
+}
+
+// NewPriorityStrategy creates a new PriorityStrategy with settings. The selectors are used as
+// the priority list if it is not specified in settings.
+func NewPriorityStrategy(settings *StrategyPriorityConfig, selectors []string) *PriorityStrategy {
+	priority := settings.Priority
+	if len(priority) == 0 {
+		priority = selectors
+	}
+	return &PriorityStrategy{
+		settings: settings,
+		priority: priority,
+	}
+}
+
+func (s *PriorityStrategy) InjectContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+func (s *PriorityStrategy) GetPrincipleTarget(candidates []string) []string {
+	if tag := s.PickOutbound(candidates); len(tag) > 0 {
+		return []string{tag}
+	}
+	return nil
+}
+
+func (s *PriorityStrategy) PickOutbound(candidates []string) string {
+	alive := aliveOutbounds(s.ctx, s.settings.ObserverTag, candidates)
+	var selected string
+	selectedRank := len(s.priority)
+	for _, tag := range alive {
+		rank := s.rank(tag)
+		if rank == len(s.priority) {
+			continue
+		}
+		// Ties of the same prefix are broken by tag.
+		if len(selected) == 0 || rank < selectedRank || rank == selectedRank && tag < selected {
+			selected, selectedRank = tag, rank
+		}
+	}
+	// goes to fallbackTag if empty
+	return selected
+}
+
+// rank returns the index of the first priority entry the tag matches, either exactly or by prefix.
+func (s *PriorityStrategy) rank(tag string) int {
+	for i, entry := range s.priority {
+		if strings.HasPrefix(tag, entry) {
+			return i
+		}
+	}
+	return len(s.priority)
+}
+
+func init() {
+	common.Must(common.RegisterConfig((*StrategyPriorityConfig)(nil), nil))
+}
@@ -0,0 +1,132 @@
+package router_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/app/dispatcher"
+	"github.com/v2fly/v2ray-core/v4/app/observatory"
+	"github.com/v2fly/v2ray-core/v4/app/proxyman"
+	. "github.com/v2fly/v2ray-core/v4/app/router"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/serial"
+	"github.com/v2fly/v2ray-core/v4/common/session"
+	"github.com/v2fly/v2ray-core/v4/features/extension"
+	"github.com/v2fly/v2ray-core/v4/features/routing"
+	routing_session "github.com/v2fly/v2ray-core/v4/features/routing/session"
+	"github.com/v2fly/v2ray-core/v4/proxy/blackhole"
+)
+
+type fakeObservatory struct {
+	result *observatory.ObservationResult
+}
+
+func (o *fakeObservatory) Type() interface{} {
+	return extension.ObservatoryType()
+}
+
+func (o *fakeObservatory) Start() error {
+	return nil
+}
+
+func (o *fakeObservatory) Close() error {
+	return nil
+}
+
+func (o *fakeObservatory) GetObservation(ctx context.Context) (proto.Message, error) {
+	return o.result, nil
+}
+
+func TestPriorityStrategy(t *testing.T) {
+	s := NewPriorityStrategy(&StrategyPriorityConfig{}, []string{"primary", "backup"})
+	if tag := s.PickOutbound([]string{"backup-1", "backup-0", "other", "primary"}); tag != "primary" {
+		t.Error("expect primary, but got ", tag)
+	}
+	if tag := s.PickOutbound([]string{"backup-1", "other", "backup-0"}); tag != "backup-0" {
+		t.Error("expect backup-0, but got ", tag)
+	}
+	if tag := s.PickOutbound([]string{"other"}); tag != "" {
+		t.Error("expect empty tag, but got ", tag)
+	}
+}
+
+func TestPriorityFailover(t *testing.T) {
+	config := &core.Config{
+		App: []*anypb.Any{
+			serial.ToTypedMessage(&Config{
+				Rule: []*RoutingRule{
+					{
+						TargetTag: &RoutingRule_BalancingTag{
+							BalancingTag: "failover",
+						},
+						Networks: []net.Network{net.Network_TCP},
+					},
+				},
+				BalancingRule: []*BalancingRule{
+					{
+						Tag:              "failover",
+						OutboundSelector: []string{"backup", "primary"},
+						Strategy:         "priority",
+						StrategySettings: serial.ToTypedMessage(&StrategyPriorityConfig{
+							Priority: []string{"primary", "backup"},
+						}),
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				Tag:           "primary",
+				ProxySettings: serial.ToTypedMessage(&blackhole.Config{}),
+			},
+			{
+				Tag:           "backup",
+				ProxySettings: serial.ToTypedMessage(&blackhole.Config{}),
+			},
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+	observer := &fakeObservatory{result: &observatory.ObservationResult{
+		Status: []*observatory.OutboundStatus{
+			{OutboundTag: "primary", Alive: true},
+			{OutboundTag: "backup", Alive: true},
+		},
+	}}
+	common.Must(v.AddFeature(observer))
+
+	r := v.GetFeature(routing.RouterType()).(*Router)
+	pick := func() string {
+		ctx := session.ContextWithOutbound(context.Background(), &session.Outbound{Target: net.TCPDestination(net.DomainAddress("v2fly.org"), 80)})
+		route, err := r.PickRoute(routing_session.AsRoutingContext(ctx))
+		common.Must(err)
+		return route.GetOutboundTag()
+	}
+
+	if tag := pick(); tag != "primary" {
+		t.Error("expect primary, but got ", tag)
+	}
+
+	observer.result.Status[0].Alive = false
+	if tag := pick(); tag != "backup" {
+		t.Error("expect failover to backup, but got ", tag)
+	}
+	targets, err := r.GetPrincipleTarget("failover")
+	common.Must(err)
+	if len(targets) != 1 || targets[0] != "backup" {
+		t.Error("expect principle target backup, but got ", targets)
+	}
+
+	observer.result.Status[0].Alive = true
+	if tag := pick(); tag != "primary" {
+		t.Error("expect primary after recovery, but got ", tag)
+	}
+}
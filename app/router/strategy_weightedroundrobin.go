@@ -0,0 +1,79 @@
+//go:build !confonly
+// +build !confonly
+
+package router
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/v2fly/v2ray-core/v4/common"
+)
+
+// WeightedRoundRobinStrategy represents a smooth weighted round-robin balancing strategy
+type WeightedRoundRobinStrategy struct {
+	ctx      context.Context
+	settings *StrategyWeightedRoundRobinConfig
+
+	access  sync.Mutex
+	weights *WeightManager
+	current map[string]float64
+}
+
+// NewWeightedRoundRobinStrategy creates a new WeightedRoundRobinStrategy with settings
+func NewWeightedRoundRobinStrategy(settings *StrategyWeightedRoundRobinConfig) *WeightedRoundRobinStrategy {
+	return &WeightedRoundRobinStrategy{
+		settings: settings,
+		weights: NewWeightManager(
+			settings.Weights, 1,
+			func(value, weight float64) float64 {
+				return value * weight
+			},
+		),
+		current: make(map[string]float64),
+	}
+}
+
+func (s *WeightedRoundRobinStrategy) InjectContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+func (s *WeightedRoundRobinStrategy) GetPrincipleTarget(candidates []string) []string {
+	return aliveOutbounds(s.ctx, s.settings.ObserverTag, candidates)
+}
+
+func (s *WeightedRoundRobinStrategy) PickOutbound(candidates []string) string {
+	alive := append([]string(nil), aliveOutbounds(s.ctx, s.settings.ObserverTag, candidates)...)
+	// Candidates come in random order, sort them to be fair on ties.
+	sort.Strings(alive)
+
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	var selected string
+	var total float64
+	current := make(map[string]float64, len(alive))
+	for _, tag := range alive {
+		weight := s.weights.Get(tag)
+		if weight <= 0 {
+			continue
+		}
+		current[tag] = s.current[tag] + weight
+		total += weight
+		if len(selected) == 0 || current[tag] > current[selected] {
+			selected = tag
+		}
+	}
+	if len(selected) > 0 {
+		current[selected] -= total
+	}
+	// Outbounds no longer in candidates are dropped, and start over when they are back.
+	s.current = current
+	// goes to fallbackTag if empty
+	return selected
+}
+
+func init() {
+	common.Must(common.RegisterConfig((*StrategyWeightedRoundRobinConfig)(nil), nil))
+}
@@ -0,0 +1,42 @@
+package router_test
+
+import (
+	"testing"
+
+	. "github.com/v2fly/v2ray-core/v4/app/router"
+)
+
+func TestWeightedRoundRobinStrategy(t *testing.T) {
+	s := NewWeightedRoundRobinStrategy(&StrategyWeightedRoundRobinConfig{
+		Weights: []*StrategyWeight{
+			{Match: "a", Value: 3},
+			{Match: "b", Value: 2},
+		},
+	})
+
+	candidates := []string{"c", "b", "a"}
+	var sequence []string
+	counts := make(map[string]int)
+	for i := 0; i < 12; i++ {
+		tag := s.PickOutbound(candidates)
+		sequence = append(sequence, tag)
+		counts[tag]++
+	}
+	if counts["a"] != 6 || counts["b"] != 4 || counts["c"] != 2 {
+		t.Error("unexpected distribution: ", counts)
+	}
+	// Smooth round-robin never picks the same outbound more than twice in a row with these weights.
+	for i := 2; i < len(sequence); i++ {
+		if sequence[i] == sequence[i-1] && sequence[i] == sequence[i-2] {
+			t.Error("unsmooth sequence: ", sequence)
+			break
+		}
+	}
+
+	if tag := s.PickOutbound([]string{"b"}); tag != "b" {
+		t.Error("expect b, but got ", tag)
+	}
+	if tag := s.PickOutbound(nil); tag != "" {
+		t.Error("expect empty tag without candidates, but got ", tag)
+	}
+}
@@ -0,0 +1,141 @@
+package router
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdayNames = map[string]uint32{
+	"sun": 0,
+	"mon": 1,
+	"tue": 2,
+	"wed": 3,
+	"thu": 4,
+	"fri": 5,
+	"sat": 6,
+}
+
+// ParseTimeWindow parses a time window in the form of "[days] [HH:MM-HH:MM] [time zone]",
+// e.g. "Mon-Fri 09:00-18:00 Europe/Berlin", "Sat,Sun" or "22:00-06:00 UTC".
+// All parts are optional, but at least one of them must be present.
+func ParseTimeWindow(s string) (*TimeWindow, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, newError("empty time window")
+	}
+
+	window := new(TimeWindow)
+	var hasDays, hasTime bool
+	for _, field := range fields {
+		switch {
+		case !hasTime && strings.Contains(field, ":"):
+			start, end, err := parseTimeRange(field)
+			if err != nil {
+				return nil, newError("invalid time range in time window: ", s).Base(err)
+			}
+			window.Start, window.End = start, end
+			hasTime = true
+		case !hasDays && !hasTime && len(window.TimeZone) == 0 && isWeekdayList(field):
+			days, err := parseWeekdays(field)
+			if err != nil {
+				return nil, newError("invalid days in time window: ", s).Base(err)
+			}
+			window.Weekday = days
+			hasDays = true
+		case len(window.TimeZone) == 0:
+			if _, err := time.LoadLocation(field); err != nil {
+				return nil, newError("invalid time zone in time window: ", s).Base(err)
+			}
+			window.TimeZone = field
+		default:
+			return nil, newError("unexpected field ", field, " in time window: ", s)
+		}
+	}
+	return window, nil
+}
+
+func isWeekdayList(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == ',' || r == '-') {
+			return false
+		}
+	}
+	names := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '-' })
+	if len(names) == 0 {
+		return false
+	}
+	_, err := parseWeekday(names[0])
+	return err == nil
+}
+
+func parseWeekday(s string) (uint32, error) {
+	if len(s) < 3 {
+		return 0, newError("unknown day of week: ", s)
+	}
+	day, found := weekdayNames[strings.ToLower(s[:3])]
+	if !found {
+		return 0, newError("unknown day of week: ", s)
+	}
+	return day, nil
+}
+
+func parseWeekdays(s string) ([]uint32, error) {
+	var days []uint32
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		from, err := parseWeekday(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = parseWeekday(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+		// Ranges may wrap around the end of week, e.g. Fri-Mon.
+		for day := from; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseTimeOfDay(s string) (uint32, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, newError("invalid time of day: ", s)
+	}
+	hour, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, newError("invalid time of day: ", s).Base(err)
+	}
+	minute, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, newError("invalid time of day: ", s).Base(err)
+	}
+	if minute >= 60 || hour > 24 || hour == 24 && minute != 0 {
+		return 0, newError("time of day out of range: ", s)
+	}
+	return uint32(hour*60 + minute), nil
+}
+
+func parseTimeRange(s string) (uint32, uint32, error) {
+	bounds := strings.SplitN(s, "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, newError("time range must be in the form of HH:MM-HH:MM: ", s)
+	}
+	start, err := parseTimeOfDay(bounds[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseTimeOfDay(bounds[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
@@ -48,22 +48,34 @@ func (s *statsServer) GetStats(ctx context.Context, request *GetStatsRequest) (*
 	}, nil
 }
 
-func (s *statsServer) QueryStats(ctx context.Context, request *QueryStatsRequest) (*QueryStatsResponse, error) {
+// matcher returns the function telling whether a name matches the patterns
+// of the request.
+func (r *QueryStatsRequest) matcher() (func(string) bool, error) {
 	mgroup := &strmatcher.LinearIndexMatcher{}
-	if request.Pattern != "" {
-		request.Patterns = append(request.Patterns, request.Pattern)
+	if r.Pattern != "" {
+		r.Patterns = append(r.Patterns, r.Pattern)
 	}
 	t := strmatcher.Substr
-	if request.Regexp {
+	if r.Regexp {
 		t = strmatcher.Regex
 	}
-	for _, p := range request.Patterns {
+	for _, p := range r.Patterns {
 		m, err := t.New(p)
 		if err != nil {
 			return nil, err
 		}
 		mgroup.Add(m)
 	}
+	return func(name string) bool {
+		return mgroup.Size() == 0 || len(mgroup.Match(name)) > 0
+	}, nil
+}
+
+func (s *statsServer) QueryStats(ctx context.Context, request *QueryStatsRequest) (*QueryStatsResponse, error) {
+	match, err := request.matcher()
+	if err != nil {
+		return nil, err
+	}
 
 	response := &QueryStatsResponse{}
 
@@ -73,7 +85,7 @@ func (s *statsServer) QueryStats(ctx context.Context, request *QueryStatsRequest
 	}
 
 	manager.VisitCounters(func(name string, c feature_stats.Counter) bool {
-		if mgroup.Size() == 0 || len(mgroup.Match(name)) > 0 {
+		if match(name) {
 			var value int64
 			if request.Reset_ {
 				value = c.Set(0)
@@ -91,6 +103,119 @@ func (s *statsServer) QueryStats(ctx context.Context, request *QueryStatsRequest
 	return response, nil
 }
 
+func (s *statsServer) QueryGauges(ctx context.Context, request *QueryStatsRequest) (*QueryStatsResponse, error) {
+	match, err := request.matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	manager, ok := s.stats.(*stats.Manager)
+	if !ok {
+		return nil, newError("QueryGauges only works its own stats.Manager.")
+	}
+
+	response := &QueryStatsResponse{}
+	manager.VisitGauges(func(name string, g feature_stats.Gauge) bool {
+		if match(name) {
+			response.Stat = append(response.Stat, &Stat{
+				Name:  name,
+				Value: g.Value(),
+			})
+		}
+		return true
+	})
+
+	return response, nil
+}
+
+func (s *statsServer) QueryHistograms(ctx context.Context, request *QueryStatsRequest) (*QueryHistogramsResponse, error) {
+	match, err := request.matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	manager, ok := s.stats.(*stats.Manager)
+	if !ok {
+		return nil, newError("QueryHistograms only works its own stats.Manager.")
+	}
+
+	response := &QueryHistogramsResponse{}
+	manager.VisitHistograms(func(name string, h feature_stats.Histogram) bool {
+		if !match(name) {
+			return true
+		}
+		var snapshot *feature_stats.HistogramSnapshot
+		if request.Reset_ {
+			snapshot = h.Reset()
+		} else {
+			snapshot = h.Snapshot()
+		}
+		histogram := &Histogram{
+			Name:  name,
+			Count: snapshot.Count,
+			Sum:   snapshot.Sum,
+		}
+		for i, bound := range snapshot.Buckets {
+			histogram.Bucket = append(histogram.Bucket, &HistogramBucket{
+				UpperBound: bound,
+				Count:      snapshot.Counts[i],
+			})
+		}
+		response.Histogram = append(response.Histogram, histogram)
+		return true
+	})
+
+	return response, nil
+}
+
+func (s *statsServer) QueryStatsHistory(ctx context.Context, request *QueryStatsHistoryRequest) (*QueryStatsHistoryResponse, error) {
+	match, err := (&QueryStatsRequest{Patterns: request.Patterns, Regexp: request.Regexp}).matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	manager, ok := s.stats.(*stats.Manager)
+	if !ok {
+		return nil, newError("QueryStatsHistory only works its own stats.Manager.")
+	}
+	intervals := manager.HistoryIntervals()
+	if len(intervals) == 0 {
+		return nil, newError("stats history is not enabled")
+	}
+	interval := time.Duration(request.Interval) * time.Second
+	if interval == 0 {
+		interval = intervals[0]
+	}
+	if !hasInterval(intervals, interval) {
+		return nil, newError("stats history is not kept in buckets of ", interval, ", available: ", intervals)
+	}
+
+	response := &QueryStatsHistoryResponse{}
+	manager.VisitCounterHistories(interval, func(h *stats.CounterHistory) bool {
+		if match(h.Name) {
+			response.History = append(response.History, &StatsHistory{
+				Name:     h.Name,
+				Interval: uint32(h.Interval / time.Second),
+				Start:    h.Start.Unix(),
+				Value:    h.Values,
+				Rate:     h.Rates(),
+			})
+		}
+		return true
+	})
+
+	return response, nil
+}
+
+func hasInterval(intervals []time.Duration, interval time.Duration) bool {
+	for _, i := range intervals {
+		if i == interval {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *statsServer) GetSysStats(ctx context.Context, request *SysStatsRequest) (*SysStatsResponse, error) {
 	var rtm runtime.MemStats
 	runtime.ReadMemStats(&rtm)
@@ -113,6 +238,33 @@ func (s *statsServer) GetSysStats(ctx context.Context, request *SysStatsRequest)
 	return response, nil
 }
 
+func (s *statsServer) GetOnlineUsers(ctx context.Context, request *GetOnlineUsersRequest) (*GetOnlineUsersResponse, error) {
+	tracker, ok := s.stats.(feature_stats.OnlineTracker)
+	if !ok {
+		return nil, newError("online users are not tracked by the stats manager")
+	}
+	response := &GetOnlineUsersResponse{}
+	for _, u := range tracker.GetOnlineUsers() {
+		if request.Email != "" && u.Email != request.Email {
+			continue
+		}
+		user := &OnlineUser{
+			Email:       u.Email,
+			Since:       u.Since.Unix(),
+			Connections: int64(u.Connections),
+		}
+		for _, ip := range u.IPs {
+			user.Ip = append(user.Ip, &OnlineIP{
+				Ip:          ip.IP.String(),
+				Since:       ip.Since.Unix(),
+				Connections: int64(ip.Connections),
+			})
+		}
+		response.User = append(response.User, user)
+	}
+	return response, nil
+}
+
 func (s *statsServer) mustEmbedUnimplementedStatsServiceServer() {}
 
 type service struct {
@@ -10,6 +10,7 @@ import (
 	"github.com/v2fly/v2ray-core/v4/app/stats"
 	. "github.com/v2fly/v2ray-core/v4/app/stats/command"
 	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
 )
 
 func TestGetStats(t *testing.T) {
@@ -90,3 +91,104 @@ func TestQueryStats(t *testing.T) {
 		t.Error(r)
 	}
 }
+
+func TestGetOnlineUsers(t *testing.T) {
+	m, err := stats.NewManager(context.Background(), &stats.Config{})
+	common.Must(err)
+
+	done := m.AddOnlineSession("love@v2fly.org", net.ParseIP("1.2.3.4"))
+	defer done()
+	m.AddOnlineSession("test@v2fly.org", net.ParseIP("5.6.7.8"))
+
+	s := NewStatsServer(m)
+	resp, err := s.GetOnlineUsers(context.Background(), &GetOnlineUsersRequest{Email: "love@v2fly.org"})
+	common.Must(err)
+	if len(resp.User) != 1 {
+		t.Fatal("expected 1 online user, got ", resp.User)
+	}
+	if u := resp.User[0]; u.Email != "love@v2fly.org" || u.Connections != 1 || len(u.Ip) != 1 || u.Ip[0].Ip != "1.2.3.4" {
+		t.Error("unexpected online user ", u)
+	}
+
+	resp, err = s.GetOnlineUsers(context.Background(), &GetOnlineUsersRequest{})
+	common.Must(err)
+	if len(resp.User) != 2 {
+		t.Error("expected 2 online users, got ", resp.User)
+	}
+}
+
+func TestQueryHistograms(t *testing.T) {
+	m, err := stats.NewManager(context.Background(), &stats.Config{})
+	common.Must(err)
+
+	h, err := m.RegisterHistogram("outbound>>>proxy>>>latency>>>dial", []float64{10, 100})
+	common.Must(err)
+	h.Observe(5)
+	h.Observe(50)
+	_, err = m.RegisterHistogram("dns>>>local>>>latency", []float64{10})
+	common.Must(err)
+	g, err := m.RegisterGauge("inbound>>>socks>>>sessions")
+	common.Must(err)
+	g.Set(2)
+
+	s := NewStatsServer(m)
+	resp, err := s.QueryHistograms(context.Background(), &QueryStatsRequest{
+		Patterns: []string{"outbound>>>"},
+		Reset_:   true,
+	})
+	common.Must(err)
+	if r := cmp.Diff(resp.Histogram, []*Histogram{
+		{
+			Name: "outbound>>>proxy>>>latency>>>dial",
+			Bucket: []*HistogramBucket{
+				{UpperBound: 10, Count: 1},
+				{UpperBound: 100, Count: 2},
+			},
+			Count: 2,
+			Sum:   55,
+		},
+	}, cmpopts.IgnoreUnexported(Histogram{}, HistogramBucket{})); r != "" {
+		t.Error(r)
+	}
+	if c := h.Snapshot().Count; c != 0 {
+		t.Error("expected histogram to be reset, got ", c)
+	}
+
+	gauges, err := s.QueryGauges(context.Background(), &QueryStatsRequest{Pattern: "sessions"})
+	common.Must(err)
+	if r := cmp.Diff(gauges.Stat, []*Stat{
+		{Name: "inbound>>>socks>>>sessions", Value: 2},
+	}, cmpopts.IgnoreUnexported(Stat{})); r != "" {
+		t.Error(r)
+	}
+}
+
+func TestQueryStatsHistory(t *testing.T) {
+	m, err := stats.NewManager(context.Background(), &stats.Config{
+		History: &stats.HistoryConfig{},
+	})
+	common.Must(err)
+	c, err := m.RegisterCounter("user>>>love@v2fly.org>>>traffic>>>uplink")
+	common.Must(err)
+	c.Add(120)
+	_, err = m.RegisterCounter("inbound>>>socks>>>traffic>>>uplink")
+	common.Must(err)
+
+	s := NewStatsServer(m)
+	resp, err := s.QueryStatsHistory(context.Background(), &QueryStatsHistoryRequest{
+		Patterns: []string{"user>>>"},
+		Interval: 60,
+	})
+	common.Must(err)
+	if len(resp.History) != 1 || resp.History[0].Name != "user>>>love@v2fly.org>>>traffic>>>uplink" ||
+		resp.History[0].Interval != 60 || len(resp.History[0].Value) != 1 {
+		t.Fatal("unexpected history ", resp.History)
+	}
+	if h := resp.History[0]; len(h.Rate) != len(h.Value) || h.Rate[0] != float64(h.Value[0])/60 {
+		t.Error("expected rates of the values, got ", h.Rate)
+	}
+
+	if _, err := s.QueryStatsHistory(context.Background(), &QueryStatsHistoryRequest{Interval: 7}); err == nil {
+		t.Error("expected error for an interval not kept")
+	}
+}
@@ -0,0 +1,23 @@
+package stats
+
+import "sync/atomic"
+
+// Gauge is an implementation of stats.Gauge.
+type Gauge struct {
+	value int64
+}
+
+// Value implements stats.Gauge.
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+// Set implements stats.Gauge.
+func (g *Gauge) Set(newValue int64) int64 {
+	return atomic.SwapInt64(&g.value, newValue)
+}
+
+// Add implements stats.Gauge.
+func (g *Gauge) Add(delta int64) int64 {
+	return atomic.AddInt64(&g.value, delta)
+}
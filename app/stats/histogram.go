@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/v2fly/v2ray-core/v4/features/stats"
+)
+
+// Histogram is an implementation of stats.Histogram.
+type Histogram struct {
+	access  sync.Mutex
+	buckets []float64
+	counts  []uint64 // per bucket, and values above the last bound at the end
+	sum     float64
+}
+
+// NewHistogram creates a histogram with the upper bounds of its buckets.
+func NewHistogram(buckets []float64) *Histogram {
+	bounds := make([]float64, len(buckets))
+	copy(bounds, buckets)
+	sort.Float64s(bounds)
+	return &Histogram{
+		buckets: bounds,
+		counts:  make([]uint64, len(bounds)+1),
+	}
+}
+
+// Buckets implements stats.Histogram.
+func (h *Histogram) Buckets() []float64 {
+	return h.buckets
+}
+
+// Observe implements stats.Histogram.
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.buckets, value)
+
+	h.access.Lock()
+	defer h.access.Unlock()
+
+	h.counts[i]++
+	h.sum += value
+}
+
+func (h *Histogram) snapshot() *stats.HistogramSnapshot {
+	s := &stats.HistogramSnapshot{
+		Buckets: h.buckets,
+		Counts:  make([]uint64, len(h.buckets)),
+		Sum:     h.sum,
+	}
+	for i, count := range h.counts {
+		s.Count += count
+		if i < len(s.Counts) {
+			s.Counts[i] = s.Count
+		}
+	}
+	return s
+}
+
+// Snapshot implements stats.Histogram.
+func (h *Histogram) Snapshot() *stats.HistogramSnapshot {
+	h.access.Lock()
+	defer h.access.Unlock()
+
+	return h.snapshot()
+}
+
+// Reset implements stats.Histogram.
+func (h *Histogram) Reset() *stats.HistogramSnapshot {
+	h.access.Lock()
+	defer h.access.Unlock()
+
+	s := h.snapshot()
+	h.counts = make([]uint64, len(h.buckets)+1)
+	h.sum = 0
+	return s
+}
@@ -0,0 +1,66 @@
+package stats_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/v2fly/v2ray-core/v4/app/stats"
+	"github.com/v2fly/v2ray-core/v4/common"
+)
+
+func TestStatsHistogram(t *testing.T) {
+	h := NewHistogram([]float64{100, 10, 1000})
+	for _, v := range []float64{1, 10, 11, 150, 5000} {
+		h.Observe(v)
+	}
+
+	s := h.Snapshot()
+	if s.Count != 5 || s.Sum != 5172 {
+		t.Error("unexpected count and sum ", s.Count, " ", s.Sum)
+	}
+	for i, want := range []uint64{2, 3, 4} {
+		if s.Counts[i] != want {
+			t.Error("bucket ", s.Buckets[i], ": expected ", want, ", got ", s.Counts[i])
+		}
+	}
+	if q := s.Quantile(0.5); q <= 10 || q > 100 {
+		t.Error("unexpected median ", q)
+	}
+	if q := s.Quantile(1); q != 1000 {
+		t.Error("expected quantile above the last bucket to be its bound, got ", q)
+	}
+
+	if s := h.Reset(); s.Count != 5 {
+		t.Error("expected reset to return the values before, got ", s.Count)
+	}
+	if s := h.Snapshot(); s.Count != 0 || s.Sum != 0 || s.Counts[2] != 0 {
+		t.Error("expected empty histogram after reset, got ", s)
+	}
+}
+
+func TestStatsHistogramBuckets(t *testing.T) {
+	m, err := NewManager(context.Background(), &Config{
+		Histogram: []*HistogramConfig{
+			{Pattern: []string{">>>latency>>>dial"}, Bucket: []float64{50, 500}},
+		},
+	})
+	common.Must(err)
+
+	h, err := m.RegisterHistogram("outbound>>>proxy>>>latency>>>dial", []float64{1, 2, 3})
+	common.Must(err)
+	if b := h.Buckets(); len(b) != 2 || b[0] != 50 || b[1] != 500 {
+		t.Error("expected configured buckets, got ", b)
+	}
+	h, err = m.RegisterHistogram("dns>>>local>>>latency", []float64{1, 2, 3})
+	common.Must(err)
+	if b := h.Buckets(); len(b) != 3 {
+		t.Error("expected registered buckets, got ", b)
+	}
+	if _, err := m.RegisterHistogram("dns>>>local>>>latency", nil); err == nil {
+		t.Error("expected error registering histogram twice")
+	}
+	common.Must(m.UnregisterHistogram("dns>>>local>>>latency"))
+	if m.GetHistogram("dns>>>local>>>latency") != nil {
+		t.Error("expected histogram to be unregistered")
+	}
+}
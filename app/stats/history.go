@@ -0,0 +1,284 @@
+package stats
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/v2fly/v2ray-core/v4/common/task"
+)
+
+// CounterHistory is the history of a counter in buckets of an interval.
+type CounterHistory struct {
+	Name     string
+	Interval time.Duration
+	// Start is the start of the first bucket.
+	Start time.Time
+	// Values are the increments of the counter in the buckets, oldest first.
+	// The last bucket is in progress.
+	Values []int64
+}
+
+// Rates returns the increments of the counter per second in the buckets.
+func (h *CounterHistory) Rates() []float64 {
+	rates := make([]float64, len(h.Values))
+	for i, v := range h.Values {
+		rates[i] = float64(v) / h.Interval.Seconds()
+	}
+	return rates
+}
+
+// historyRing keeps the increments of a counter in the buckets of an
+// interval, up to a fixed number of them.
+type historyRing struct {
+	interval time.Duration
+	values   []int64
+	head     int       // index of the bucket in progress
+	start    time.Time // start of the bucket in progress
+	filled   int       // number of buckets since the history started
+}
+
+func newHistoryRing(interval time.Duration, length int) *historyRing {
+	return &historyRing{
+		interval: interval,
+		values:   make([]int64, length),
+	}
+}
+
+// advance moves the bucket in progress to the one containing now.
+func (r *historyRing) advance(now time.Time) {
+	start := now.Truncate(r.interval)
+	if r.filled == 0 {
+		r.start, r.filled = start, 1
+		return
+	}
+	steps := int(start.Sub(r.start) / r.interval)
+	if steps <= 0 {
+		return
+	}
+	if steps > len(r.values) {
+		steps = len(r.values)
+	}
+	for i := 0; i < steps; i++ {
+		r.head = (r.head + 1) % len(r.values)
+		r.values[r.head] = 0
+	}
+	r.filled += steps
+	if r.filled > len(r.values) {
+		r.filled = len(r.values)
+	}
+	r.start = start
+}
+
+func (r *historyRing) add(now time.Time, delta int64) {
+	r.advance(now)
+	r.values[r.head] += delta
+}
+
+func (r *historyRing) snapshot(name string, now time.Time) *CounterHistory {
+	r.advance(now)
+	h := &CounterHistory{
+		Name:     name,
+		Interval: r.interval,
+		Start:    r.start.Add(-time.Duration(r.filled-1) * r.interval),
+		Values:   make([]int64, r.filled),
+	}
+	for i := range h.Values {
+		h.Values[i] = r.values[(r.head-r.filled+1+i+len(r.values))%len(r.values)]
+	}
+	return h
+}
+
+// counterHistory is the history of a counter in all the resolutions.
+type counterHistory struct {
+	counter *Counter
+	last    int64
+	rings   []*historyRing
+}
+
+// history keeps the history of the counters matching its config, by
+// sampling them every second.
+type history struct {
+	access      sync.Mutex
+	patterns    []string
+	resolutions []*HistoryResolution
+	counters    map[string]*counterHistory
+	task        *task.Periodic
+}
+
+var defaultHistoryResolutions = []*HistoryResolution{
+	{Interval: 1, Duration: 300},
+	{Interval: 60, Duration: 86400},
+}
+
+func newHistory(config *HistoryConfig) (*history, error) {
+	h := &history{
+		patterns: config.Pattern,
+		counters: make(map[string]*counterHistory),
+	}
+	if len(h.patterns) == 0 {
+		h.patterns = []string{">>>traffic>>>"}
+	}
+	resolutions := config.Resolution
+	if len(resolutions) == 0 {
+		resolutions = defaultHistoryResolutions
+	}
+	// The resolutions are sorted below, without touching the config.
+	h.resolutions = append([]*HistoryResolution(nil), resolutions...)
+	for _, r := range h.resolutions {
+		if r.Interval == 0 || r.Duration < r.Interval {
+			return nil, newError("invalid history resolution of ", r.Duration, "s in ", r.Interval, "s")
+		}
+	}
+	sort.Slice(h.resolutions, func(i, j int) bool { return h.resolutions[i].Interval < h.resolutions[j].Interval })
+	h.task = &task.Periodic{
+		Interval: time.Second,
+		Execute: func() error {
+			h.record(time.Now())
+			return nil
+		},
+	}
+	return h, nil
+}
+
+func (h *history) matches(name string) bool {
+	for _, pattern := range h.patterns {
+		if strings.Contains(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// add starts keeping the history of the counter, if it matches.
+func (h *history) add(name string, c *Counter) {
+	if !h.matches(name) {
+		return
+	}
+
+	h.access.Lock()
+	defer h.access.Unlock()
+
+	ch := &counterHistory{counter: c, last: c.Value()}
+	for _, r := range h.resolutions {
+		ch.rings = append(ch.rings, newHistoryRing(time.Duration(r.Interval)*time.Second, int(r.Duration/r.Interval)))
+	}
+	h.counters[name] = ch
+}
+
+func (h *history) remove(name string) {
+	h.access.Lock()
+	defer h.access.Unlock()
+
+	delete(h.counters, name)
+}
+
+// record adds the increments of the counters since the last record to the
+// buckets containing now.
+func (h *history) record(now time.Time) {
+	h.access.Lock()
+	defer h.access.Unlock()
+
+	for _, ch := range h.counters {
+		value := ch.counter.Value()
+		delta := value - ch.last
+		if delta < 0 {
+			// The counter was reset.
+			delta = value
+		}
+		ch.last = value
+		for _, r := range ch.rings {
+			r.add(now, delta)
+		}
+	}
+}
+
+// ring returns the index of the resolution of the interval, the finest if
+// interval is 0, or -1 if the interval is not kept.
+func (h *history) ring(interval time.Duration) int {
+	if interval == 0 {
+		return 0
+	}
+	for i, r := range h.resolutions {
+		if time.Duration(r.Interval)*time.Second == interval {
+			return i
+		}
+	}
+	return -1
+}
+
+// HistoryIntervals returns the intervals of the buckets of the kept history,
+// finest first.
+func (m *Manager) HistoryIntervals() []time.Duration {
+	if m.history == nil {
+		return nil
+	}
+	intervals := make([]time.Duration, 0, len(m.history.resolutions))
+	for _, r := range m.history.resolutions {
+		intervals = append(intervals, time.Duration(r.Interval)*time.Second)
+	}
+	return intervals
+}
+
+// GetCounterHistory returns the history of the named counter in buckets of
+// the interval, or of the finest interval if it is 0. It returns nil if the
+// history is not kept.
+func (m *Manager) GetCounterHistory(name string, interval time.Duration) *CounterHistory {
+	histories := m.GetCounterHistories(interval, name)
+	if histories == nil {
+		return nil
+	}
+	return histories[0]
+}
+
+// GetCounterHistories is like GetCounterHistory for several counters, whose
+// histories are all taken at the same instant. It returns nil if the history
+// of any of them is not kept.
+func (m *Manager) GetCounterHistories(interval time.Duration, names ...string) []*CounterHistory {
+	if m.history == nil {
+		return nil
+	}
+	h := m.history
+	i := h.ring(interval)
+	if i < 0 {
+		return nil
+	}
+
+	h.access.Lock()
+	defer h.access.Unlock()
+
+	now := time.Now()
+	histories := make([]*CounterHistory, 0, len(names))
+	for _, name := range names {
+		ch, found := h.counters[name]
+		if !found {
+			return nil
+		}
+		histories = append(histories, ch.rings[i].snapshot(name, now))
+	}
+	return histories
+}
+
+// VisitCounterHistories calls visitor function on the history of all counters
+// whose history is kept, in buckets of the interval as in GetCounterHistory.
+func (m *Manager) VisitCounterHistories(interval time.Duration, visitor func(*CounterHistory) bool) {
+	if m.history == nil {
+		return
+	}
+	h := m.history
+	i := h.ring(interval)
+	if i < 0 {
+		return
+	}
+
+	h.access.Lock()
+	defer h.access.Unlock()
+
+	now := time.Now()
+	for name, ch := range h.counters {
+		if !visitor(ch.rings[i].snapshot(name, now)) {
+			break
+		}
+	}
+}
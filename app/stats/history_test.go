@@ -0,0 +1,85 @@
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/v2fly/v2ray-core/v4/common"
+)
+
+func TestCounterHistory(t *testing.T) {
+	config := &Config{
+		History: &HistoryConfig{
+			Resolution: []*HistoryResolution{
+				{Interval: 60, Duration: 180},
+				{Interval: 1, Duration: 3},
+			},
+		},
+	}
+	m, err := NewManager(context.Background(), config)
+	common.Must(err)
+	if config.History.Resolution[0].Interval != 60 {
+		t.Error("expected the resolutions of the config to be left in order")
+	}
+
+	c, err := m.RegisterCounter("inbound>>>socks>>>traffic>>>uplink")
+	common.Must(err)
+	if _, err := m.RegisterCounter("dns>>>local>>>queries"); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1000, 0)
+	for i, value := range []int64{10, 30, 30, 100, 5} {
+		c.Set(value)
+		m.history.record(now.Add(time.Duration(i) * time.Second))
+	}
+
+	h := m.history.counters["inbound>>>socks>>>traffic>>>uplink"].rings[0].snapshot("", now.Add(4*time.Second))
+	if h.Start != time.Unix(1002, 0) || h.Interval != time.Second {
+		t.Error("unexpected history start ", h.Start, " and interval ", h.Interval)
+	}
+	// The counter was reset before the last record.
+	if r := cmp.Diff(h.Values, []int64{0, 70, 5}); r != "" {
+		t.Error(r)
+	}
+	if r := cmp.Diff(h.Rates(), []float64{0, 70, 5}); r != "" {
+		t.Error(r)
+	}
+
+	h = m.history.counters["inbound>>>socks>>>traffic>>>uplink"].rings[1].snapshot("", now.Add(125*time.Second))
+	if h.Start != time.Unix(960, 0) || len(h.Values) != 3 || h.Values[0] != 105 || h.Values[2] != 0 {
+		t.Error("unexpected minute history ", h)
+	}
+
+	if _, found := m.history.counters["dns>>>local>>>queries"]; found {
+		t.Error("expected no history of counters not matching")
+	}
+	if r := cmp.Diff(m.HistoryIntervals(), []time.Duration{time.Second, time.Minute}); r != "" {
+		t.Error(r)
+	}
+	if m.GetCounterHistory("inbound>>>socks>>>traffic>>>uplink", time.Hour) != nil {
+		t.Error("expected no history in buckets of an hour")
+	}
+	if m.GetCounterHistories(0, "inbound>>>socks>>>traffic>>>uplink", "dns>>>local>>>queries") != nil {
+		t.Error("expected no histories if one of them is not kept")
+	}
+
+	common.Must(m.UnregisterCounter("inbound>>>socks>>>traffic>>>uplink"))
+	if m.GetCounterHistory("inbound>>>socks>>>traffic>>>uplink", 0) != nil {
+		t.Error("expected no history of unregistered counter")
+	}
+}
+
+func TestCounterHistoryInvalidResolution(t *testing.T) {
+	_, err := NewManager(context.Background(), &Config{
+		History: &HistoryConfig{
+			Resolution: []*HistoryResolution{{Interval: 60, Duration: 30}},
+		},
+	})
+	if err == nil {
+		t.Error("expected error for a duration shorter than the interval")
+	}
+}
@@ -0,0 +1,104 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
+)
+
+// onlineSessions are the active sessions of a user from a source IP, by start
+// time.
+type onlineSessions struct {
+	ip     net.IP
+	starts map[uint64]time.Time
+}
+
+func (s *onlineSessions) since() time.Time {
+	var since time.Time
+	for _, start := range s.starts {
+		if since.IsZero() || start.Before(since) {
+			since = start
+		}
+	}
+	return since
+}
+
+// onlineMap keeps the active sessions of users by email and source IP.
+type onlineMap struct {
+	access   sync.Mutex
+	lastID   uint64
+	sessions map[string]map[string]*onlineSessions
+}
+
+// AddOnlineSession implements stats.OnlineTracker.
+func (m *Manager) AddOnlineSession(email string, source net.IP) func() {
+	o := &m.online
+	key := source.String()
+
+	o.access.Lock()
+	defer o.access.Unlock()
+
+	if o.sessions == nil {
+		o.sessions = make(map[string]map[string]*onlineSessions)
+	}
+	ips, found := o.sessions[email]
+	if !found {
+		ips = make(map[string]*onlineSessions)
+		o.sessions[email] = ips
+	}
+	sessions, found := ips[key]
+	if !found {
+		sessions = &onlineSessions{ip: source, starts: make(map[uint64]time.Time)}
+		ips[key] = sessions
+	}
+	o.lastID++
+	id := o.lastID
+	sessions.starts[id] = time.Now()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			o.access.Lock()
+			defer o.access.Unlock()
+
+			delete(sessions.starts, id)
+			if len(sessions.starts) == 0 {
+				delete(ips, key)
+			}
+			if len(ips) == 0 {
+				delete(o.sessions, email)
+			}
+		})
+	}
+}
+
+// GetOnlineUsers implements stats.OnlineTracker.
+func (m *Manager) GetOnlineUsers() []*stats.OnlineUser {
+	o := &m.online
+	o.access.Lock()
+	defer o.access.Unlock()
+
+	users := make([]*stats.OnlineUser, 0, len(o.sessions))
+	for email, ips := range o.sessions {
+		user := &stats.OnlineUser{Email: email}
+		for _, sessions := range ips {
+			ip := &stats.OnlineIP{
+				IP:          sessions.ip,
+				Since:       sessions.since(),
+				Connections: len(sessions.starts),
+			}
+			if user.Since.IsZero() || ip.Since.Before(user.Since) {
+				user.Since = ip.Since
+			}
+			user.Connections += ip.Connections
+			user.IPs = append(user.IPs, ip)
+		}
+		sort.Slice(user.IPs, func(i, j int) bool { return user.IPs[i].Since.Before(user.IPs[j].Since) })
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	return users
+}
@@ -0,0 +1,44 @@
+package stats_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/v2fly/v2ray-core/v4/app/stats"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
+)
+
+func TestOnlineUsers(t *testing.T) {
+	m, err := NewManager(context.Background(), &Config{})
+	common.Must(err)
+
+	source := net.TCPDestination(net.ParseAddress("1.2.3.4"), 5678)
+	done1 := stats.TrackOnlineSession(m, "love@v2fly.org", source)
+	done2 := stats.TrackOnlineSession(m, "love@v2fly.org", source)
+	done3 := stats.TrackOnlineSession(m, "love@v2fly.org", net.TCPDestination(net.ParseAddress("::1"), 80))
+	done4 := stats.TrackOnlineSession(m, "", source)
+
+	users := m.GetOnlineUsers()
+	if len(users) != 1 {
+		t.Fatal("expected 1 online user, got ", len(users))
+	}
+	if u := users[0]; u.Email != "love@v2fly.org" || u.Connections != 3 || len(u.IPs) != 2 {
+		t.Error("unexpected online user ", u)
+	}
+
+	done1()
+	done1()
+	done3()
+	done4()
+	users = m.GetOnlineUsers()
+	if len(users) != 1 || users[0].Connections != 1 || len(users[0].IPs) != 1 || users[0].IPs[0].IP.String() != "1.2.3.4" {
+		t.Error("unexpected online users ", users)
+	}
+
+	done2()
+	if users := m.GetOnlineUsers(); len(users) != 0 {
+		t.Error("expected no online user, got ", users)
+	}
+}
@@ -0,0 +1,133 @@
+package stats
+
+import (
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/v2fly/v2ray-core/v4/app/persistentstorage"
+	"github.com/v2fly/v2ray-core/v4/common/task"
+)
+
+const counterStorageKey = "stats/counters"
+
+// persister saves the counters matching its patterns to the persistent
+// storage periodically.
+type persister struct {
+	patterns []string
+	task     *task.Periodic
+	// pending are the saved values of the counters not registered yet. They
+	// are guarded by the lock of the manager.
+	pending map[string]int64
+	// stopped is set if the saved counters failed to restore, so that they
+	// are not overwritten.
+	stopped bool
+}
+
+func newPersister(m *Manager, config *PersistConfig) *persister {
+	p := &persister{
+		patterns: config.Pattern,
+		pending:  make(map[string]int64),
+	}
+	if len(p.patterns) == 0 {
+		p.patterns = []string{">>>traffic>>>"}
+	}
+	interval := time.Duration(config.Interval) * time.Second
+	if interval == 0 {
+		interval = time.Minute
+	}
+	p.task = &task.Periodic{
+		Interval: interval,
+		Execute: func() error {
+			if err := m.saveCounters(); err != nil {
+				newError("failed to save counters").Base(err).AtWarning().WriteToLog()
+			}
+			return nil
+		},
+	}
+	return p
+}
+
+func (p *persister) matches(name string) bool {
+	for _, pattern := range p.patterns {
+		if strings.Contains(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreCounters adds the values saved by saveCounters to the counters, or
+// keeps them until the counters are registered. It returns an error if the
+// saved counters exist but cannot be read.
+func (m *Manager) restoreCounters() error {
+	storage := persistentstorage.FromContext(m.ctx)
+	if storage == nil {
+		newError("no persistent storage available, counters will not be restored").AtWarning().WriteToLog()
+		return nil
+	}
+	data, err := storage.Get(m.ctx, []byte(counterStorageKey))
+	if persistentstorage.IsNotFound(data, err) {
+		newError("no saved counters").AtDebug().WriteToLog()
+		return nil
+	}
+	if err != nil {
+		return newError("failed to read saved counters").Base(err)
+	}
+	state := new(CounterState)
+	if err := proto.Unmarshal(data, state); err != nil {
+		return newError("failed to parse saved counters").Base(err)
+	}
+
+	m.access.Lock()
+	defer m.access.Unlock()
+
+	for _, saved := range state.Counter {
+		if !m.persist.matches(saved.Name) {
+			continue
+		}
+		c, found := m.counters[saved.Name]
+		if !found {
+			m.persist.pending[saved.Name] = saved.Value
+			continue
+		}
+		c.Add(saved.Value)
+		if m.history != nil {
+			// Restart the history, so that the restored value is not taken as traffic.
+			m.history.add(saved.Name, c)
+		}
+	}
+	newError("restored ", len(state.Counter), " counters").AtInfo().WriteToLog()
+	return nil
+}
+
+// saveCounters saves the counters matching the persist config, and those
+// restored but not registered yet, to the persistent storage.
+func (m *Manager) saveCounters() error {
+	storage := persistentstorage.FromContext(m.ctx)
+	if storage == nil || m.persist.stopped {
+		return nil
+	}
+
+	state := new(CounterState)
+	m.access.RLock()
+	for name, c := range m.counters {
+		if m.persist.matches(name) {
+			state.Counter = append(state.Counter, &CounterState_Counter{Name: name, Value: c.Value()})
+		}
+	}
+	for name, value := range m.persist.pending {
+		state.Counter = append(state.Counter, &CounterState_Counter{Name: name, Value: value})
+	}
+	m.access.RUnlock()
+
+	data, err := proto.Marshal(state)
+	if err != nil {
+		return newError("failed to encode counters").Base(err)
+	}
+	if err := storage.Put(m.ctx, []byte(counterStorageKey), data); err != nil {
+		return newError("failed to save counters").Base(err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package stats_test
+
+import (
+	"context"
+	"testing"
+
+	core "github.com/v2fly/v2ray-core/v4"
+	. "github.com/v2fly/v2ray-core/v4/app/stats"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/errors"
+	"github.com/v2fly/v2ray-core/v4/testing/storage"
+)
+
+// failingStorage fails to read the saved values.
+type failingStorage struct {
+	*storage.MemoryStorage
+}
+
+func (failingStorage) Get(context.Context, []byte) ([]byte, error) {
+	return nil, errors.New("storage unavailable")
+}
+
+func TestPersistCounters(t *testing.T) {
+	v, err := core.New(&core.Config{})
+	common.Must(err)
+	common.Must(v.AddFeature(storage.NewMemoryStorage()))
+
+	newManager := func() *Manager {
+		obj, err := core.CreateObject(v, &Config{Persist: &PersistConfig{}})
+		common.Must(err)
+		return obj.(*Manager)
+	}
+	counter := func(m *Manager, name string) int64 {
+		c := m.GetCounter(name)
+		if c == nil {
+			var err error
+			c, err = m.RegisterCounter(name)
+			common.Must(err)
+		}
+		return c.Value()
+	}
+
+	m := newManager()
+	common.Must(m.Start())
+	for name, value := range map[string]int64{
+		"inbound>>>socks>>>traffic>>>uplink":      100,
+		"user>>>a@v2fly.org>>>traffic>>>downlink": 7,
+		"dns>>>local>>>queries":                   5,
+	} {
+		c, err := m.RegisterCounter(name)
+		common.Must(err)
+		c.Set(value)
+	}
+	common.Must(m.Close())
+
+	m = newManager()
+	_, err = m.RegisterCounter("inbound>>>socks>>>traffic>>>uplink")
+	common.Must(err)
+	common.Must(m.Start())
+	if v := counter(m, "inbound>>>socks>>>traffic>>>uplink"); v != 100 {
+		t.Error("expected counter registered before start to be restored, got ", v)
+	}
+	if v := counter(m, "dns>>>local>>>queries"); v != 0 {
+		t.Error("expected counter not matching to be reset, got ", v)
+	}
+	m.GetCounter("inbound>>>socks>>>traffic>>>uplink").Add(1)
+	common.Must(m.Close())
+
+	// Counters that are not registered during a run are kept.
+	m = newManager()
+	common.Must(m.Start())
+	common.Must(m.Close())
+
+	m = newManager()
+	common.Must(m.Start())
+	if v := counter(m, "user>>>a@v2fly.org>>>traffic>>>downlink"); v != 7 {
+		t.Error("expected counter registered after start to be restored, got ", v)
+	}
+	if v := counter(m, "inbound>>>socks>>>traffic>>>uplink"); v != 101 {
+		t.Error("expected counter to be restored, got ", v)
+	}
+	common.Must(m.Close())
+}
+
+func TestPersistCountersAfterFailedRestore(t *testing.T) {
+	saved := storage.NewMemoryStorage()
+	common.Must(saved.Put(context.Background(), []byte("stats/counters"), []byte("saved")))
+
+	v, err := core.New(&core.Config{})
+	common.Must(err)
+	common.Must(v.AddFeature(failingStorage{saved}))
+
+	obj, err := core.CreateObject(v, &Config{Persist: &PersistConfig{}})
+	common.Must(err)
+	m := obj.(*Manager)
+	common.Must(m.Start())
+	c, err := m.RegisterCounter("inbound>>>socks>>>traffic>>>uplink")
+	common.Must(err)
+	c.Set(100)
+	common.Must(m.Close())
+
+	if data, _ := saved.Get(context.Background(), []byte("stats/counters")); string(data) != "saved" {
+		t.Error("expected saved counters not to be overwritten, got ", data)
+	}
+}
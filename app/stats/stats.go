@@ -4,6 +4,7 @@ package stats
 
 import (
 	"context"
+	"strings"
 	"sync"
 
 	"github.com/v2fly/v2ray-core/v4/common"
@@ -13,17 +14,38 @@ import (
 
 // Manager is an implementation of stats.Manager.
 type Manager struct {
-	access   sync.RWMutex
-	counters map[string]*Counter
-	channels map[string]*Channel
-	running  bool
+	access     sync.RWMutex
+	ctx        context.Context
+	config     *Config
+	counters   map[string]*Counter
+	channels   map[string]*Channel
+	gauges     map[string]*Gauge
+	histograms map[string]*Histogram
+	online     onlineMap
+	history    *history
+	persist    *persister
+	running    bool
 }
 
 // NewManager creates an instance of Statistics Manager.
 func NewManager(ctx context.Context, config *Config) (*Manager, error) {
 	m := &Manager{
-		counters: make(map[string]*Counter),
-		channels: make(map[string]*Channel),
+		ctx:        ctx,
+		config:     config,
+		counters:   make(map[string]*Counter),
+		channels:   make(map[string]*Channel),
+		gauges:     make(map[string]*Gauge),
+		histograms: make(map[string]*Histogram),
+	}
+	if config.History != nil {
+		h, err := newHistory(config.History)
+		if err != nil {
+			return nil, err
+		}
+		m.history = h
+	}
+	if config.Persist != nil {
+		m.persist = newPersister(m, config.Persist)
 	}
 
 	return m, nil
@@ -45,6 +67,15 @@ func (m *Manager) RegisterCounter(name string) (stats.Counter, error) {
 	newError("create new counter ", name).AtDebug().WriteToLog()
 	c := new(Counter)
 	m.counters[name] = c
+	if m.persist != nil {
+		if value, found := m.persist.pending[name]; found {
+			c.Set(value)
+			delete(m.persist.pending, name)
+		}
+	}
+	if m.history != nil {
+		m.history.add(name, c)
+	}
 	return c, nil
 }
 
@@ -56,6 +87,9 @@ func (m *Manager) UnregisterCounter(name string) error {
 	if _, found := m.counters[name]; found {
 		newError("remove counter ", name).AtDebug().WriteToLog()
 		delete(m.counters, name)
+		if m.history != nil {
+			m.history.remove(name)
+		}
 	}
 	return nil
 }
@@ -124,17 +158,146 @@ func (m *Manager) GetChannel(name string) stats.Channel {
 	return nil
 }
 
+// RegisterGauge implements stats.Manager.
+func (m *Manager) RegisterGauge(name string) (stats.Gauge, error) {
+	m.access.Lock()
+	defer m.access.Unlock()
+
+	if _, found := m.gauges[name]; found {
+		return nil, newError("Gauge ", name, " already registered.")
+	}
+	newError("create new gauge ", name).AtDebug().WriteToLog()
+	g := new(Gauge)
+	m.gauges[name] = g
+	return g, nil
+}
+
+// UnregisterGauge implements stats.Manager.
+func (m *Manager) UnregisterGauge(name string) error {
+	m.access.Lock()
+	defer m.access.Unlock()
+
+	if _, found := m.gauges[name]; found {
+		newError("remove gauge ", name).AtDebug().WriteToLog()
+		delete(m.gauges, name)
+	}
+	return nil
+}
+
+// GetGauge implements stats.Manager.
+func (m *Manager) GetGauge(name string) stats.Gauge {
+	m.access.RLock()
+	defer m.access.RUnlock()
+
+	if g, found := m.gauges[name]; found {
+		return g
+	}
+	return nil
+}
+
+// VisitGauges calls visitor function on all managed gauges.
+func (m *Manager) VisitGauges(visitor func(string, stats.Gauge) bool) {
+	m.access.RLock()
+	defer m.access.RUnlock()
+
+	for name, g := range m.gauges {
+		if !visitor(name, g) {
+			break
+		}
+	}
+}
+
+// histogramBuckets returns the configured buckets of the named histogram, or
+// the given ones if none is configured.
+func (m *Manager) histogramBuckets(name string, buckets []float64) []float64 {
+	if m.config == nil {
+		return buckets
+	}
+	for _, hc := range m.config.Histogram {
+		for _, pattern := range hc.Pattern {
+			if strings.Contains(name, pattern) {
+				return hc.Bucket
+			}
+		}
+	}
+	return buckets
+}
+
+// RegisterHistogram implements stats.Manager.
+func (m *Manager) RegisterHistogram(name string, buckets []float64) (stats.Histogram, error) {
+	m.access.Lock()
+	defer m.access.Unlock()
+
+	if _, found := m.histograms[name]; found {
+		return nil, newError("Histogram ", name, " already registered.")
+	}
+	newError("create new histogram ", name).AtDebug().WriteToLog()
+	h := NewHistogram(m.histogramBuckets(name, buckets))
+	m.histograms[name] = h
+	return h, nil
+}
+
+// UnregisterHistogram implements stats.Manager.
+func (m *Manager) UnregisterHistogram(name string) error {
+	m.access.Lock()
+	defer m.access.Unlock()
+
+	if _, found := m.histograms[name]; found {
+		newError("remove histogram ", name).AtDebug().WriteToLog()
+		delete(m.histograms, name)
+	}
+	return nil
+}
+
+// GetHistogram implements stats.Manager.
+func (m *Manager) GetHistogram(name string) stats.Histogram {
+	m.access.RLock()
+	defer m.access.RUnlock()
+
+	if h, found := m.histograms[name]; found {
+		return h
+	}
+	return nil
+}
+
+// VisitHistograms calls visitor function on all managed histograms.
+func (m *Manager) VisitHistograms(visitor func(string, stats.Histogram) bool) {
+	m.access.RLock()
+	defer m.access.RUnlock()
+
+	for name, h := range m.histograms {
+		if !visitor(name, h) {
+			break
+		}
+	}
+}
+
 // Start implements common.Runnable.
 func (m *Manager) Start() error {
+	errs := []error{}
+	if m.persist != nil {
+		if err := m.restoreCounters(); err != nil {
+			// Saving would overwrite the counters that failed to restore.
+			m.persist.stopped = true
+			newError("counters will not be saved").Base(err).AtError().WriteToLog()
+		} else if err := m.persist.task.Start(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	m.access.Lock()
 	defer m.access.Unlock()
 	m.running = true
-	errs := []error{}
 	for _, channel := range m.channels {
 		if err := channel.Start(); err != nil {
 			errs = append(errs, err)
 		}
 	}
+	if m.history != nil {
+		if err := m.history.task.Start(); err != nil {
+			errs = append(errs, err)
+		}
+	}
 	if len(errs) != 0 {
 		return errors.Combine(errs...)
 	}
@@ -143,10 +306,25 @@ func (m *Manager) Start() error {
 
 // Close implement common.Closable.
 func (m *Manager) Close() error {
+	errs := []error{}
+	if m.persist != nil {
+		// Flush the counters before locking, as saving takes the lock.
+		if err := m.persist.task.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := m.saveCounters(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	m.access.Lock()
 	defer m.access.Unlock()
 	m.running = false
-	errs := []error{}
+	if m.history != nil {
+		if err := m.history.task.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
 	for name, channel := range m.channels {
 		newError("remove channel ", name).AtDebug().WriteToLog()
 		delete(m.channels, name)
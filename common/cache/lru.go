@@ -10,6 +10,9 @@ type Lru interface {
 	Get(key interface{}) (value interface{}, ok bool)
 	GetKeyFromValue(value interface{}) (key interface{}, ok bool)
 	Put(key, value interface{})
+	// Range calls f for each entry from the least to the most recently used one,
+	// stopping early if f returns false. It does not change the usage order.
+	Range(f func(key, value interface{}) bool)
 }
 
 type lru struct {
@@ -78,3 +81,18 @@ func (l *lru) Put(key, value interface{}) {
 	}
 	l.mu.Unlock()
 }
+
+func (l *lru) Range(f func(key, value interface{}) bool) {
+	l.mu.Lock()
+	elements := make([]*lruElement, 0, l.doubleLinkedlist.Len())
+	for e := l.doubleLinkedlist.Back(); e != nil; e = e.Prev() {
+		elements = append(elements, e.Value.(*lruElement))
+	}
+	l.mu.Unlock()
+
+	for _, e := range elements {
+		if !f(e.key, e.value) {
+			return
+		}
+	}
+}
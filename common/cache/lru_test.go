@@ -67,3 +67,29 @@ func TestGetKeyFromValue(t *testing.T) {
 		t.Error("should get 2", v)
 	}
 }
+
+func TestLruRange(t *testing.T) {
+	lru := NewLru(3)
+	lru.Put(1, 1)
+	lru.Put(2, 2)
+	lru.Put(3, 3)
+	lru.Get(1)
+
+	var keys []interface{}
+	lru.Range(func(key, value interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 3 || keys[0] != 2 || keys[1] != 3 || keys[2] != 1 {
+		t.Error("unexpected range order ", keys)
+	}
+
+	count := 0
+	lru.Range(func(key, value interface{}) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Error("range should stop early, got ", count)
+	}
+}
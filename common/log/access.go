@@ -27,6 +27,9 @@ type AccessMessage struct {
 	Reason interface{}
 	Email  string
 	Detour string
+
+	RuleTag           string
+	MatchedConditions []string
 }
 
 func (m *AccessMessage) String() string {
@@ -53,6 +56,16 @@ func (m *AccessMessage) String() string {
 		builder.WriteString(m.Email)
 	}
 
+	if len(m.RuleTag) > 0 {
+		builder.WriteString(" rule: ")
+		builder.WriteString(m.RuleTag)
+	}
+
+	if len(m.MatchedConditions) > 0 {
+		builder.WriteString(" matched: ")
+		builder.WriteString(strings.Join(m.MatchedConditions, ", "))
+	}
+
 	return builder.String()
 }
 
@@ -0,0 +1,44 @@
+package log
+
+import (
+	"strings"
+	"time"
+
+	"github.com/v2fly/v2ray-core/v4/common/serial"
+)
+
+// DNSMessage is a log message of a query sent to a name server.
+type DNSMessage struct {
+	Server  string
+	Domain  string
+	QType   string
+	Answer  interface{}
+	Latency time.Duration
+	Cached  bool
+	Error   error
+}
+
+func (m *DNSMessage) String() string {
+	builder := strings.Builder{}
+	builder.WriteString(m.Server)
+	if m.Cached {
+		builder.WriteString(" cache")
+	}
+	builder.WriteByte(' ')
+	builder.WriteString(m.Domain)
+	builder.WriteByte(' ')
+	builder.WriteString(m.QType)
+
+	if m.Error != nil {
+		builder.WriteString(" failed: ")
+		builder.WriteString(m.Error.Error())
+	} else {
+		builder.WriteString(" -> ")
+		builder.WriteString(serial.ToString(m.Answer))
+	}
+
+	builder.WriteByte(' ')
+	builder.WriteString(m.Latency.Truncate(time.Microsecond).String())
+
+	return builder.String()
+}
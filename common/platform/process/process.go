@@ -0,0 +1,76 @@
+// Package process finds the local processes owning network connections.
+package process
+
+//go:generate go run github.com/v2fly/v2ray-core/v4/common/errors/errorgen
+
+import (
+	"sync"
+	"time"
+
+	"github.com/v2fly/v2ray-core/v4/common/net"
+)
+
+// cacheTTL is how long process lookups and local addresses are cached.
+const cacheTTL = 10 * time.Second
+
+// Info contains information of a local process.
+type Info struct {
+	// PID is the process ID. It is 0 if the process owning the socket could not be determined.
+	PID int
+	// UID is the user ID owning the socket.
+	UID uint32
+	// Name is the short name of the process, e.g. "firefox". It is the base name of the
+	// executable if accessible.
+	Name string
+	// Path is the absolute path of the process executable, if accessible.
+	Path string
+}
+
+// ErrNotSupported is returned by FindProcess if the current platform doesn't support process lookup.
+var ErrNotSupported = newError("process lookup is not supported on this platform")
+
+// FindProcess returns the local process owning the socket bound to the given source address.
+func FindProcess(network net.Network, source net.Destination) (*Info, error) {
+	if !source.IsValid() || !source.Address.Family().IsIP() {
+		return nil, newError("invalid source address: ", source)
+	}
+	return findProcess(network, source.Address.IP(), source.Port)
+}
+
+var localAddrs struct {
+	sync.Mutex
+	ips    []net.IP
+	expire time.Time
+}
+
+// IsLocalAddress returns whether the given IP belongs to the local machine, i.e. it is a loopback
+// address or assigned to one of the network interfaces. Connections from other hosts can not be
+// traced to a local process.
+func IsLocalAddress(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+
+	localAddrs.Lock()
+	defer localAddrs.Unlock()
+
+	if now := time.Now(); now.After(localAddrs.expire) {
+		localAddrs.ips = localAddrs.ips[:0]
+		if addrs, err := net.InterfaceAddrs(); err == nil {
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok {
+					localAddrs.ips = append(localAddrs.ips, ipNet.IP)
+				}
+			}
+		} else {
+			newError("failed to list local addresses").Base(err).AtDebug().WriteToLog()
+		}
+		localAddrs.expire = now.Add(cacheTTL)
+	}
+	for _, local := range localAddrs.ips {
+		if local.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
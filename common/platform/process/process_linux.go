@@ -0,0 +1,196 @@
+//go:build linux
+// +build linux
+
+package process
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/v2fly/v2ray-core/v4/common/net"
+)
+
+var (
+	procRoot  = "/proc"
+	processes processCache
+)
+
+func findProcess(network net.Network, ip net.IP, port net.Port) (*Info, error) {
+	var tables []string
+	switch network {
+	case net.Network_TCP:
+		tables = []string{"net/tcp", "net/tcp6"}
+	case net.Network_UDP:
+		tables = []string{"net/udp", "net/udp6"}
+	default:
+		return nil, newError("unsupported network: ", network)
+	}
+
+	for _, table := range tables {
+		uid, inode, found, err := findSocket(filepath.Join(procRoot, table), network, ip, port)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		entry, cached := processes.get(inode)
+		if !cached {
+			entry = lookupProcess(inode)
+			processes.put(inode, entry)
+		}
+		return &Info{UID: uid, PID: entry.pid, Name: entry.name, Path: entry.path}, nil
+	}
+	return nil, newError("no socket found for ", network, ":", ip, ":", port)
+}
+
+// lookupProcess finds the process holding the socket with the given inode. The name of the
+// process is the base name of its executable, as "comm" is truncated to 15 bytes.
+func lookupProcess(inode string) cachedProcess {
+	var entry cachedProcess
+	pid, found := findProcessBySocket(inode)
+	if !found {
+		return entry
+	}
+	entry.pid = pid
+	if exe, err := os.Readlink(filepath.Join(procRoot, strconv.Itoa(pid), "exe")); err == nil {
+		entry.path = strings.TrimSuffix(exe, " (deleted)")
+		entry.name = filepath.Base(entry.path)
+	} else if comm, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "comm")); err == nil {
+		entry.name = strings.TrimSpace(string(comm))
+	}
+	return entry
+}
+
+// findSocket looks up a socket table in /proc/net for the socket bound to the given address.
+func findSocket(path string, network net.Network, ip net.IP, port net.Port) (uint32, string, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, "", false, nil
+		}
+		return 0, "", false, newError("failed to open ", path).Base(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // Skip header line.
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		localIP, localPort, err := parseSocketAddress(fields[1])
+		if err != nil || localPort != port {
+			continue
+		}
+		// UDP sockets are usually not bound to a specific address.
+		if !localIP.Equal(ip) && !(network == net.Network_UDP && localIP.IsUnspecified()) {
+			continue
+		}
+		uid, err := strconv.ParseUint(fields[7], 10, 32)
+		if err != nil {
+			continue
+		}
+		return uint32(uid), fields[9], true, nil
+	}
+	return 0, "", false, scanner.Err()
+}
+
+// parseSocketAddress parses an address in /proc/net socket tables, e.g. "0100007F:1F90".
+// The IP is stored as 32-bit words in host byte order.
+func parseSocketAddress(s string) (net.IP, net.Port, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, 0, newError("invalid socket address: ", s)
+	}
+	rawIP, err := hex.DecodeString(parts[0])
+	if err != nil || (len(rawIP) != net.IPv4len && len(rawIP) != net.IPv6len) {
+		return nil, 0, newError("invalid socket address: ", s)
+	}
+	ip := make(net.IP, len(rawIP))
+	for i := 0; i < len(rawIP); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(rawIP[i:]))
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return nil, 0, newError("invalid socket address: ", s).Base(err)
+	}
+	return ip, net.Port(port), nil
+}
+
+// findProcessBySocket finds the process holding a file descriptor of the socket with the given inode.
+func findProcessBySocket(inode string) (int, bool) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return 0, false
+	}
+	target := "socket:[" + inode + "]"
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		fdDir := filepath.Join(procRoot, entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if link, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil && link == target {
+				return pid, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// processCache caches processes owning sockets by socket inode, as finding them requires
+// scanning file descriptors of all processes.
+type processCache struct {
+	sync.Mutex
+	entries map[string]cachedProcess
+}
+
+type cachedProcess struct {
+	pid    int
+	name   string
+	path   string
+	expire time.Time
+}
+
+func (c *processCache) get(inode string) (cachedProcess, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, found := c.entries[inode]
+	if !found || time.Now().After(entry.expire) {
+		return cachedProcess{}, false
+	}
+	return entry, true
+}
+
+func (c *processCache) put(inode string, entry cachedProcess) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = make(map[string]cachedProcess)
+	}
+	for key, e := range c.entries {
+		if now.After(e.expire) {
+			delete(c.entries, key)
+		}
+	}
+	entry.expire = now.Add(cacheTTL)
+	c.entries[inode] = entry
+}
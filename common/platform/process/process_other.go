@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package process
+
+import (
+	"github.com/v2fly/v2ray-core/v4/common/net"
+)
+
+func findProcess(net.Network, net.IP, net.Port) (*Info, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,71 @@
+package process_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/platform/process"
+)
+
+func TestFindProcess(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process lookup is only supported on Linux")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	common.Must(err)
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	common.Must(err)
+	defer conn.Close()
+
+	info, err := process.FindProcess(net.Network_TCP, net.DestinationFromAddr(conn.LocalAddr()))
+	common.Must(err)
+	if info.PID != os.Getpid() {
+		t.Error("expect pid ", os.Getpid(), ", but got ", info.PID)
+	}
+	if info.UID != uint32(os.Getuid()) {
+		t.Error("expect uid ", os.Getuid(), ", but got ", info.UID)
+	}
+	if exe, err := os.Executable(); err == nil {
+		if info.Path != exe {
+			t.Error("expect path ", exe, ", but got ", info.Path)
+		}
+		if info.Name != filepath.Base(exe) {
+			t.Error("expect name ", filepath.Base(exe), ", but got ", info.Name)
+		}
+	}
+
+	// A second lookup of the same socket is served from the cache.
+	cached, err := process.FindProcess(net.Network_TCP, net.DestinationFromAddr(conn.LocalAddr()))
+	common.Must(err)
+	if *cached != *info {
+		t.Error("expect ", *info, ", but got ", *cached)
+	}
+
+	if _, err := process.FindProcess(net.Network_TCP, net.TCPDestination(net.LocalHostIP, 1)); err == nil {
+		t.Error("expect error for address without socket")
+	}
+}
+
+func TestIsLocalAddress(t *testing.T) {
+	if !process.IsLocalAddress(net.LocalHostIP.IP()) {
+		t.Error("expect loopback address to be local")
+	}
+	if process.IsLocalAddress(net.ParseIP("192.0.2.1")) {
+		t.Error("expect documentation address to be non-local")
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	common.Must(err)
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !process.IsLocalAddress(ipNet.IP) {
+			t.Error("expect interface address ", ipNet.IP, " to be local")
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package dns
 
 import (
+	"context"
+
+	"golang.org/x/net/dns/dnsmessage"
+
 	"github.com/v2fly/v2ray-core/v4/common/errors"
 	"github.com/v2fly/v2ray-core/v4/common/net"
 	"github.com/v2fly/v2ray-core/v4/common/serial"
@@ -38,6 +42,27 @@ type IPv6Lookup interface {
 	LookupIPv6(domain string) ([]net.IP, error)
 }
 
+// ContextLookup is an optional feature for querying IP addresses on behalf of
+// the connection in the context.
+//
+// v2ray:api:beta
+type ContextLookup interface {
+	// LookupIPWithContext returns IP addresses for the given domain, limited by
+	// the IPv4Enable and IPv6Enable of option. The inbound in ctx, if any,
+	// identifies the client the lookup is made for.
+	LookupIPWithContext(ctx context.Context, domain string, option IPOption) ([]net.IP, error)
+}
+
+// RecordLookup is an optional feature for querying DNS records of any type.
+//
+// v2ray:api:beta
+type RecordLookup interface {
+	// LookupRecords returns the answer records of the given type for the domain.
+	// If fakeEnable is set, domains answered by FakeDNS get no records, so that
+	// their real records are not revealed.
+	LookupRecords(domain string, qType dnsmessage.Type, fakeEnable bool) ([]dnsmessage.Resource, error)
+}
+
 // ClientWithIPOption is an optional feature for querying DNS information.
 //
 // v2ray:api:beta
@@ -3,9 +3,14 @@ package extension
 import (
 	"context"
 
+	"github.com/v2fly/v2ray-core/v4/common/errors"
 	"github.com/v2fly/v2ray-core/v4/features"
 )
 
+// ErrPersistentStorageKeyNotFound is returned by PersistentStorageEngine.Get
+// for keys that hold no value.
+var ErrPersistentStorageKeyNotFound = errors.New("key not found")
+
 type PersistentStorageEngine interface {
 	features.Feature
 
@@ -14,3 +19,7 @@ type PersistentStorageEngine interface {
 	Get(ctx context.Context, key []byte) ([]byte, error)
 	List(ctx context.Context, keyPrefix []byte) ([][]byte, error)
 }
+
+func PersistentStorageEngineType() interface{} {
+	return (*PersistentStorageEngine)(nil)
+}
@@ -27,6 +27,8 @@ type Stats struct {
 	UserUplink bool
 	// Whether or not to enable stat counter for user downlink traffic.
 	UserDownlink bool
+	// Whether or not to track the sessions of users for online stats.
+	UserOnline bool
 }
 
 // Buffer contains settings for internal buffer.
@@ -45,6 +47,18 @@ type SystemStats struct {
 	OutboundUplink bool
 	// Whether or not to enable stat counter for downlink traffic in outbound handlers.
 	OutboundDownlink bool
+	// Whether or not to enable stat counter for hits of tagged routing rules.
+	RuleHits bool
+	// Whether or not to enable stat counter for uplink traffic routed by tagged routing rules.
+	RuleUplink bool
+	// Whether or not to enable stat counter for downlink traffic routed by tagged routing rules.
+	RuleDownlink bool
+	// Whether or not to enable handshake latency, session duration and session count stats in inbound handlers.
+	InboundLatency bool
+	// Whether or not to enable dial latency stats in outbound handlers.
+	OutboundLatency bool
+	// Whether or not to enable query, failure and latency stats of name servers.
+	DNSServer bool
 }
 
 // System contains policy settings at system level.
@@ -27,6 +27,12 @@ type Route interface {
 
 	// GetOutboundTag returns the tag of the outbound the connection was dispatched to.
 	GetOutboundTag() string
+
+	// GetRuleTag returns the tag of the routing rule that made the decision, if exists.
+	GetRuleTag() string
+
+	// GetMatchedConditions returns descriptions of the rule conditions that matched the connection.
+	GetMatchedConditions() []string
 }
 
 // RouterType return the type of Router interface. Can be used to implement common.HasType.
@@ -1,9 +1,12 @@
 package session
 
+//go:generate go run github.com/v2fly/v2ray-core/v4/common/errors/errorgen
+
 import (
 	"context"
 
 	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/platform/process"
 	"github.com/v2fly/v2ray-core/v4/common/session"
 	"github.com/v2fly/v2ray-core/v4/features/routing"
 )
@@ -13,6 +16,9 @@ type Context struct {
 	Inbound  *session.Inbound
 	Outbound *session.Outbound
 	Content  *session.Content
+
+	process         *process.Info
+	processResolved bool
 }
 
 // GetInboundTag implements routing.Context.
@@ -117,6 +123,30 @@ func (ctx *Context) GetSkipDNSResolve() bool {
 	return ctx.Content.SkipDNSResolve
 }
 
+// GetSourceProcess implements routing.Context.
+func (ctx *Context) GetSourceProcess() *process.Info {
+	if ctx.processResolved {
+		return ctx.process
+	}
+	ctx.processResolved = true
+
+	// Only connections from the local machine can be traced to a process. Besides loopback,
+	// local connections redirected by tproxy or redirect keep their interface address as source.
+	if ctx.Inbound == nil || !ctx.Inbound.Source.IsValid() || !ctx.Inbound.Source.Address.Family().IsIP() {
+		return nil
+	}
+	if !process.IsLocalAddress(ctx.Inbound.Source.Address.IP()) {
+		return nil
+	}
+	info, err := process.FindProcess(ctx.GetNetwork(), ctx.Inbound.Source)
+	if err != nil {
+		newError("failed to find source process of ", ctx.Inbound.Source).Base(err).AtDebug().WriteToLog()
+		return nil
+	}
+	ctx.process = info
+	return info
+}
+
 // AsRoutingContext creates a context from context.context with session info.
 func AsRoutingContext(ctx context.Context) routing.Context {
 	return &Context{
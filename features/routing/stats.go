@@ -0,0 +1,16 @@
+package routing
+
+// RuleHitsCounterName returns the name of the stats counter for hits of the routing rule with the given tag.
+func RuleHitsCounterName(ruleTag string) string {
+	return "rule>>>" + ruleTag + ">>>hits"
+}
+
+// RuleUplinkCounterName returns the name of the stats counter for uplink traffic routed by the rule with the given tag.
+func RuleUplinkCounterName(ruleTag string) string {
+	return "rule>>>" + ruleTag + ">>>traffic>>>uplink"
+}
+
+// RuleDownlinkCounterName returns the name of the stats counter for downlink traffic routed by the rule with the given tag.
+func RuleDownlinkCounterName(ruleTag string) string {
+	return "rule>>>" + ruleTag + ">>>traffic>>>downlink"
+}
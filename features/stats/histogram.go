@@ -0,0 +1,53 @@
+package stats
+
+import (
+	"time"
+)
+
+var (
+	// LatencyBuckets are the default buckets of latency histograms, in
+	// milliseconds.
+	LatencyBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000}
+	// DurationBuckets are the default buckets of session duration histograms,
+	// in seconds.
+	DurationBuckets = []float64{1, 5, 10, 30, 60, 300, 600, 1800, 3600, 7200, 21600, 86400}
+)
+
+// HistogramSnapshot is the state of a Histogram at some time.
+type HistogramSnapshot struct {
+	// Buckets are the upper bounds of the buckets, in increasing order.
+	Buckets []float64
+	// Counts are the numbers of values at most the upper bound of each
+	// bucket. Values above the last bound are only in Count.
+	Counts []uint64
+	// Count is the number of all values.
+	Count uint64
+	// Sum is the sum of all values.
+	Sum float64
+}
+
+// Quantile estimates the q-quantile of the values, 0 <= q <= 1, by linear
+// interpolation in the bucket the quantile falls in. It returns the last
+// bound if the quantile is above it, and 0 if there are no values.
+func (s *HistogramSnapshot) Quantile(q float64) float64 {
+	if s.Count == 0 || len(s.Buckets) == 0 {
+		return 0
+	}
+	rank := q * float64(s.Count)
+	lower, below := 0.0, uint64(0)
+	for i, upper := range s.Buckets {
+		if count := s.Counts[i]; float64(count) >= rank {
+			if count == below {
+				return upper
+			}
+			return lower + (upper-lower)*(rank-float64(below))/float64(count-below)
+		}
+		lower, below = upper, s.Counts[i]
+	}
+	return s.Buckets[len(s.Buckets)-1]
+}
+
+// ObserveDuration records d in the histogram, in units of unit.
+func ObserveDuration(h Histogram, d time.Duration, unit time.Duration) {
+	h.Observe(float64(d) / float64(unit))
+}
@@ -0,0 +1,49 @@
+package stats
+
+import (
+	"time"
+
+	"github.com/v2fly/v2ray-core/v4/common/net"
+)
+
+// OnlineIP is a source IP that a user has active sessions from.
+type OnlineIP struct {
+	IP net.IP
+	// Since is the start of the earliest active session from the IP.
+	Since time.Time
+	// Connections is the number of active sessions from the IP.
+	Connections int
+}
+
+// OnlineUser is a user with active sessions.
+type OnlineUser struct {
+	Email string
+	// Since is the start of the earliest active session of the user.
+	Since time.Time
+	// Connections is the number of active sessions of the user.
+	Connections int
+	IPs         []*OnlineIP
+}
+
+// OnlineTracker is an optional feature of Manager for tracking the users
+// with active sessions.
+//
+// v2ray:api:beta
+type OnlineTracker interface {
+	// AddOnlineSession records a session of the user from the source IP. The
+	// returned function removes the session, and must be called once it ends.
+	AddOnlineSession(email string, source net.IP) func()
+	// GetOnlineUsers returns the users with active sessions.
+	GetOnlineUsers() []*OnlineUser
+}
+
+// TrackOnlineSession records a session of the user from source with the
+// manager, if it supports tracking. It returns the function to call once the
+// session ends.
+func TrackOnlineSession(m Manager, email string, source net.Destination) func() {
+	tracker, ok := m.(OnlineTracker)
+	if !ok || len(email) == 0 || !source.IsValid() || !source.Address.Family().IsIP() {
+		return func() {}
+	}
+	return tracker.AddOnlineSession(email, source.Address.IP())
+}
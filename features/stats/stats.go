@@ -21,6 +21,34 @@ type Counter interface {
 	Add(int64) int64
 }
 
+// Gauge is the interface for stats gauges, whose value goes up and down, such
+// as the number of sessions in progress.
+//
+// v2ray:api:beta
+type Gauge interface {
+	// Value is the current value of the gauge.
+	Value() int64
+	// Set sets a new value to the gauge, and returns the previous one.
+	Set(int64) int64
+	// Add adds a value to the current gauge value, and returns the new value.
+	Add(int64) int64
+}
+
+// Histogram is the interface for stats histograms, which count observed values
+// in buckets, so that the distribution of the values can be told.
+//
+// v2ray:api:beta
+type Histogram interface {
+	// Buckets returns the upper bounds of the buckets, in increasing order.
+	Buckets() []float64
+	// Observe records a value in the histogram.
+	Observe(float64)
+	// Snapshot returns the current state of the histogram.
+	Snapshot() *HistogramSnapshot
+	// Reset clears the histogram, and returns its state before clearing.
+	Reset() *HistogramSnapshot
+}
+
 // Channel is the interface for stats channel.
 //
 // v2ray:api:stable
@@ -77,6 +105,21 @@ type Manager interface {
 	UnregisterChannel(string) error
 	// GetChannel returns a channel by its identifier.
 	GetChannel(string) Channel
+
+	// RegisterGauge registers a new gauge to the manager. The identifier string must not be empty, and unique among other gauges.
+	RegisterGauge(string) (Gauge, error)
+	// UnregisterGauge unregisters a gauge from the manager by its identifier.
+	UnregisterGauge(string) error
+	// GetGauge returns a gauge by its identifier.
+	GetGauge(string) Gauge
+
+	// RegisterHistogram registers a new histogram to the manager, with the upper bounds of its buckets. The manager may
+	// configure other buckets for the histogram. The identifier string must not be empty, and unique among other histograms.
+	RegisterHistogram(string, []float64) (Histogram, error)
+	// UnregisterHistogram unregisters a histogram from the manager by its identifier.
+	UnregisterHistogram(string) error
+	// GetHistogram returns a histogram by its identifier.
+	GetHistogram(string) Histogram
 }
 
 // GetOrRegisterCounter tries to get the StatCounter first. If not exist, it then tries to create a new counter.
@@ -99,6 +142,27 @@ func GetOrRegisterChannel(m Manager, name string) (Channel, error) {
 	return m.RegisterChannel(name)
 }
 
+// GetOrRegisterGauge tries to get the StatGauge first. If not exist, it then tries to create a new gauge.
+func GetOrRegisterGauge(m Manager, name string) (Gauge, error) {
+	gauge := m.GetGauge(name)
+	if gauge != nil {
+		return gauge, nil
+	}
+
+	return m.RegisterGauge(name)
+}
+
+// GetOrRegisterHistogram tries to get the StatHistogram first. If not exist, it then tries to create a new histogram
+// with the buckets.
+func GetOrRegisterHistogram(m Manager, name string, buckets []float64) (Histogram, error) {
+	histogram := m.GetHistogram(name)
+	if histogram != nil {
+		return histogram, nil
+	}
+
+	return m.RegisterHistogram(name, buckets)
+}
+
 // ManagerType returns the type of Manager interface. Can be used to implement common.HasType.
 //
 // v2ray:api:stable
@@ -144,6 +208,36 @@ func (NoopManager) GetChannel(string) Channel {
 	return nil
 }
 
+// RegisterGauge implements Manager.
+func (NoopManager) RegisterGauge(string) (Gauge, error) {
+	return nil, newError("not implemented")
+}
+
+// UnregisterGauge implements Manager.
+func (NoopManager) UnregisterGauge(string) error {
+	return nil
+}
+
+// GetGauge implements Manager.
+func (NoopManager) GetGauge(string) Gauge {
+	return nil
+}
+
+// RegisterHistogram implements Manager.
+func (NoopManager) RegisterHistogram(string, []float64) (Histogram, error) {
+	return nil, newError("not implemented")
+}
+
+// UnregisterHistogram implements Manager.
+func (NoopManager) UnregisterHistogram(string) error {
+	return nil
+}
+
+// GetHistogram implements Manager.
+func (NoopManager) GetHistogram(string) Histogram {
+	return nil
+}
+
 // Start implements common.Runnable.
 func (NoopManager) Start() error { return nil }
 
@@ -54,6 +54,14 @@ func (l *loader) LoadGeoSiteWithAttr(file string, siteWithAttr string) ([]*route
 	return filteredDomains, nil
 }
 
+// LoadIP loads CIDRs from MaxMind DB files by itself, and from other files with the underlying implementation.
+func (l *loader) LoadIP(filename, country string) ([]*routercommon.CIDR, error) {
+	if IsMMDBFile(filename) {
+		return LoadMMDBIP(filename, country)
+	}
+	return l.LoaderImplementation.LoadIP(filename, country)
+}
+
 func (l *loader) LoadGeoIP(country string) ([]*routercommon.CIDR, error) {
 	return l.LoadIP("geoip.dat", country)
 }
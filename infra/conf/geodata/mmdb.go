@@ -0,0 +1,137 @@
+package geodata
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/v2fly/v2ray-core/v4/app/router/routercommon"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/platform/filesystem"
+)
+
+const (
+	// DefaultMMDBCountryFile is the name of the MaxMind DB file to look up countries by default.
+	DefaultMMDBCountryFile = "GeoLite2-Country.mmdb"
+	// DefaultMMDBASNFile is the name of the MaxMind DB file to look up autonomous systems by default.
+	DefaultMMDBASNFile = "GeoLite2-ASN.mmdb"
+)
+
+// ASNPrefix is the prefix of codes that select networks by autonomous system number in MMDB files, e.g. "AS13335".
+const ASNPrefix = "AS"
+
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	ASN uint32 `maxminddb:"autonomous_system_number"`
+}
+
+// IsMMDBFile returns whether the file is a MaxMind DB file, judging by its name.
+func IsMMDBFile(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".mmdb")
+}
+
+// parseASN returns the AS number in codes like "AS13335".
+func parseASN(code string) (uint32, bool) {
+	if len(code) <= len(ASNPrefix) || !strings.EqualFold(code[:len(ASNPrefix)], ASNPrefix) {
+		return 0, false
+	}
+	asn, err := strconv.ParseUint(code[len(ASNPrefix):], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(asn), true
+}
+
+// mmdbReaderCache caches opened MaxMind DB files by file name, so that a file referenced by
+// several rules is only read once.
+type mmdbReaderCache struct {
+	sync.Mutex
+	readers map[string]*maxminddb.Reader
+}
+
+var mmdbReaders mmdbReaderCache
+
+func (c *mmdbReaderCache) Get(filename string) (*maxminddb.Reader, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if reader, found := c.readers[filename]; found {
+		return reader, nil
+	}
+	mmdbBytes, err := filesystem.ReadAsset(filename)
+	if err != nil {
+		return nil, newError("failed to open file: ", filename).Base(err)
+	}
+	reader, err := maxminddb.FromBytes(mmdbBytes)
+	if err != nil {
+		return nil, newError("failed to read MaxMind DB: ", filename).Base(err)
+	}
+	if c.readers == nil {
+		c.readers = make(map[string]*maxminddb.Reader)
+	}
+	c.readers[filename] = reader
+	return reader, nil
+}
+
+// LoadMMDBIP loads CIDRs of a country, e.g. "CN", or an autonomous system, e.g. "AS13335", from a MaxMind DB file.
+func LoadMMDBIP(filename, code string) ([]*routercommon.CIDR, error) {
+	reader, err := mmdbReaders.Get(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	asn, isASN := parseASN(code)
+	var cidrs []*routercommon.CIDR
+	networks := reader.Networks()
+	for networks.Next() {
+		var record mmdbRecord
+		network, err := networks.Network(&record)
+		if err != nil {
+			return nil, newError("failed to decode network in ", filename).Base(err)
+		}
+		if isASN && record.ASN != asn || !isASN && !strings.EqualFold(record.Country.ISOCode, code) {
+			continue
+		}
+		if cidr := mmdbNetworkToCIDR(network); cidr != nil {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	if err := networks.Err(); err != nil {
+		return nil, newError("failed to traverse MaxMind DB: ", filename).Base(err)
+	}
+
+	if len(cidrs) == 0 {
+		return nil, newError("code not found in ", filename, ": ", code)
+	}
+	return cidrs, nil
+}
+
+var (
+	mmdbIPv4Compatible = net.IPNet{IP: net.ParseIP("::"), Mask: net.CIDRMask(96, 128)}
+	mmdbIPv4Mapped     = net.IPNet{IP: net.ParseIP("::ffff:0:0"), Mask: net.CIDRMask(96, 128)}
+	mmdbIPv4Teredo     = net.IPNet{IP: net.ParseIP("2001::"), Mask: net.CIDRMask(32, 128)}
+	mmdbIPv4To6        = net.IPNet{IP: net.ParseIP("2002::"), Mask: net.CIDRMask(16, 128)}
+)
+
+// mmdbNetworkToCIDR converts a network in MaxMind DB to CIDR. IPv4 networks in IPv6 databases are
+// stored in ::/96, and aliased into several other subnets, which are skipped to avoid duplication.
+func mmdbNetworkToCIDR(network *net.IPNet) *routercommon.CIDR {
+	ones, bits := network.Mask.Size()
+	if bits == 8*net.IPv4len {
+		return &routercommon.CIDR{Ip: []byte(network.IP.To4()), Prefix: uint32(ones)}
+	}
+	for _, alias := range []net.IPNet{mmdbIPv4Mapped, mmdbIPv4Teredo, mmdbIPv4To6} {
+		aliasOnes, _ := alias.Mask.Size()
+		if ones >= aliasOnes && alias.Contains(network.IP) {
+			return nil
+		}
+	}
+	if ones >= 96 && mmdbIPv4Compatible.Contains(network.IP) {
+		return &routercommon.CIDR{Ip: []byte(network.IP[12:]), Prefix: uint32(ones - 96)}
+	}
+	return &routercommon.CIDR{Ip: []byte(network.IP.To16()), Prefix: uint32(ones)}
+}
@@ -0,0 +1,31 @@
+package mmdb
+
+import (
+	"github.com/v2fly/v2ray-core/v4/app/router/routercommon"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/infra/conf/geodata"
+	_ "github.com/v2fly/v2ray-core/v4/infra/conf/geodata/standard"
+)
+
+// mmdbLoader loads GeoIP from MaxMind DB files, and GeoSite with the standard loader.
+type mmdbLoader struct {
+	geodata.LoaderImplementation
+}
+
+func (l mmdbLoader) LoadIP(filename, country string) ([]*routercommon.CIDR, error) {
+	if filename == "geoip.dat" {
+		filename = geodata.DefaultMMDBCountryFile
+	}
+	if !geodata.IsMMDBFile(filename) {
+		return l.LoaderImplementation.LoadIP(filename, country)
+	}
+	return geodata.LoadMMDBIP(filename, country)
+}
+
+func init() {
+	geodata.RegisterGeoDataLoaderImplementationCreator("mmdb", func() geodata.LoaderImplementation {
+		standardLoader, err := geodata.GetGeoDataLoader("standard")
+		common.Must(err)
+		return mmdbLoader{standardLoader}
+	})
+}
@@ -0,0 +1,219 @@
+package geodata_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	stdnet "net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/v2fly/v2ray-core/v4/app/router/routercommon"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/platform"
+	"github.com/v2fly/v2ray-core/v4/infra/conf/geodata"
+	_ "github.com/v2fly/v2ray-core/v4/infra/conf/geodata/mmdb"
+)
+
+// mmdbWriter writes minimal IPv6 MaxMind DB files for testing.
+type mmdbWriter struct {
+	root *mmdbNode
+	data bytes.Buffer
+}
+
+type mmdbNode struct {
+	children [2]*mmdbNode
+	data     [2]int
+}
+
+func newMMDBNode() *mmdbNode {
+	return &mmdbNode{data: [2]int{-1, -1}}
+}
+
+func (w *mmdbWriter) insert(cidr string, record map[string]interface{}) {
+	_, network, err := stdnet.ParseCIDR(cidr)
+	common.Must(err)
+	ip := network.IP.To16()
+	if network.IP.To4() != nil && len(network.IP) == net.IPv4len {
+		ip = make(net.IP, net.IPv6len)
+		copy(ip[12:], network.IP)
+	}
+	ones, bits := network.Mask.Size()
+	ones += net.IPv6len*8 - bits
+
+	offset := w.data.Len()
+	w.encode(record)
+
+	if w.root == nil {
+		w.root = newMMDBNode()
+	}
+	node := w.root
+	for i := 0; i < ones; i++ {
+		bit := (ip[i/8] >> (7 - i%8)) & 1
+		if i == ones-1 {
+			node.data[bit] = offset
+			break
+		}
+		if node.children[bit] == nil {
+			node.children[bit] = newMMDBNode()
+		}
+		node = node.children[bit]
+	}
+}
+
+func (w *mmdbWriter) encode(value interface{}) {
+	w.encodeTo(&w.data, value)
+}
+
+func (w *mmdbWriter) encodeTo(b *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		b.WriteByte(2<<5 | byte(len(v)))
+		b.WriteString(v)
+	case uint16:
+		b.WriteByte(5<<5 | 2)
+		common.Must(binary.Write(b, binary.BigEndian, v))
+	case uint32:
+		b.WriteByte(6<<5 | 4)
+		common.Must(binary.Write(b, binary.BigEndian, v))
+	case uint64:
+		b.WriteByte(8)
+		b.WriteByte(9 - 7)
+		common.Must(binary.Write(b, binary.BigEndian, v))
+	case []interface{}:
+		b.WriteByte(byte(len(v)))
+		b.WriteByte(11 - 7)
+		for _, item := range v {
+			w.encodeTo(b, item)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b.WriteByte(7<<5 | byte(len(v)))
+		for _, key := range keys {
+			w.encodeTo(b, key)
+			w.encodeTo(b, v[key])
+		}
+	default:
+		panic("unsupported type")
+	}
+}
+
+func (w *mmdbWriter) bytes() []byte {
+	var nodes []*mmdbNode
+	index := make(map[*mmdbNode]int)
+	queue := []*mmdbNode{w.root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		index[node] = len(nodes)
+		nodes = append(nodes, node)
+		for _, child := range node.children {
+			if child != nil {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	nodeCount := len(nodes)
+	for _, node := range nodes {
+		for i := 0; i < 2; i++ {
+			record := nodeCount
+			switch {
+			case node.children[i] != nil:
+				record = index[node.children[i]]
+			case node.data[i] >= 0:
+				record = nodeCount + 16 + node.data[i]
+			}
+			b.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	b.Write(make([]byte, 16))
+	b.Write(w.data.Bytes())
+	b.WriteString("\xab\xcd\xefMaxMind.com")
+	w.encodeTo(&b, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(0),
+		"database_type":               "Test",
+		"description":                 map[string]interface{}{},
+		"ip_version":                  uint16(6),
+		"languages":                   []interface{}{},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+	})
+	return b.Bytes()
+}
+
+func TestLoadMMDBIP(t *testing.T) {
+	cloudflare := map[string]interface{}{
+		"autonomous_system_number": uint32(13335),
+		"country":                  map[string]interface{}{"iso_code": "AU"},
+	}
+	google := map[string]interface{}{
+		"autonomous_system_number": uint32(15169),
+		"country":                  map[string]interface{}{"iso_code": "US"},
+	}
+	w := new(mmdbWriter)
+	w.insert("1.1.1.0/24", cloudflare)
+	w.insert("::ffff:1.1.1.0/120", cloudflare) // IPv4-mapped alias
+	w.insert("2606:4700::/32", cloudflare)
+	w.insert("8.8.8.0/24", google)
+
+	const filename = "test.mmdb"
+	path := platform.GetAssetLocation(filename)
+	common.Must(os.MkdirAll(filepath.Dir(path), 0o755))
+	common.Must(os.WriteFile(path, w.bytes(), 0o644))
+	defer os.Remove(path)
+
+	loader, err := geodata.GetGeoDataLoader("mmdb")
+	common.Must(err)
+
+	cases := []struct {
+		code  string
+		cidrs []*routercommon.CIDR
+	}{
+		{
+			code: "AS13335",
+			cidrs: []*routercommon.CIDR{
+				{Ip: []byte{1, 1, 1, 0}, Prefix: 24},
+				{Ip: []byte(net.ParseIP("2606:4700::")), Prefix: 32},
+			},
+		},
+		{
+			code: "us",
+			cidrs: []*routercommon.CIDR{
+				{Ip: []byte{8, 8, 8, 0}, Prefix: 24},
+			},
+		},
+	}
+	for _, test := range cases {
+		cidrs, err := loader.LoadIP(filename, test.code)
+		common.Must(err)
+		if len(cidrs) != len(test.cidrs) {
+			t.Fatal("for code ", test.code, ", expect ", test.cidrs, ", but got ", cidrs)
+		}
+		for i := range cidrs {
+			if !reflect.DeepEqual(cidrs[i].Ip, test.cidrs[i].Ip) || cidrs[i].Prefix != test.cidrs[i].Prefix {
+				t.Error("for code ", test.code, ", expect ", test.cidrs[i], ", but got ", cidrs[i])
+			}
+		}
+	}
+
+	if _, err := loader.LoadIP(filename, "AS1"); err == nil {
+		t.Error("expect error for non-existent AS")
+	}
+
+	// The file is only read once.
+	common.Must(os.Remove(path))
+	if _, err := loader.LoadIP(filename, "AS13335"); err != nil {
+		t.Error("expect cached MaxMind DB, but got ", err)
+	}
+}
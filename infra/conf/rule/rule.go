@@ -10,6 +10,7 @@ import (
 	"github.com/v2fly/v2ray-core/v4/app/router/routercommon"
 	"github.com/v2fly/v2ray-core/v4/common/net"
 	"github.com/v2fly/v2ray-core/v4/infra/conf/cfgcommon"
+	"github.com/v2fly/v2ray-core/v4/infra/conf/geodata"
 )
 
 //go:generate go run github.com/v2fly/v2ray-core/v4/common/errors/errorgen
@@ -142,6 +143,31 @@ func toCidrList(ctx context.Context, ips cfgcommon.StringList) ([]*routercommon.
 			continue
 		}
 
+		if strings.HasPrefix(ip, "asn:") {
+			asn := ip[4:]
+			isReverseMatch := false
+			if strings.HasPrefix(ip, "asn:!") {
+				asn = ip[5:]
+				isReverseMatch = true
+			}
+			if _, err := strconv.ParseUint(asn, 10, 32); err != nil {
+				return nil, newError("invalid AS number in rule: ", ip).Base(err)
+			}
+			code := geodata.ASNPrefix + asn
+			geoip, err := geoLoader.LoadIP(geodata.DefaultMMDBASNFile, code)
+			if err != nil {
+				return nil, newError("failed to load ASN: ", asn, " from ", geodata.DefaultMMDBASNFile).Base(err)
+			}
+
+			geoipList = append(geoipList, &routercommon.GeoIP{
+				CountryCode:  code,
+				Cidr:         geoip,
+				InverseMatch: isReverseMatch,
+			})
+
+			continue
+		}
+
 		isExtDatFile := 0
 		{
 			const prefix = "ext:"
@@ -215,6 +241,9 @@ func parseFieldRule(ctx context.Context, msg json.RawMessage) (*router.RoutingRu
 		InboundTag *cfgcommon.StringList  `json:"inboundTag"`
 		Protocols  *cfgcommon.StringList  `json:"protocol"`
 		Attributes string                 `json:"attrs"`
+		Time       *cfgcommon.StringList  `json:"time"`
+		Process    *cfgcommon.StringList  `json:"process"`
+		RuleSet    *cfgcommon.StringList  `json:"ruleSet"`
 	}
 	rawFieldRule := new(RawFieldRule)
 	err := json.Unmarshal(msg, rawFieldRule)
@@ -240,6 +269,10 @@ func parseFieldRule(ctx context.Context, msg json.RawMessage) (*router.RoutingRu
 		rule.DomainMatcher = rawFieldRule.DomainMatcher
 	}
 
+	if rawFieldRule.RuleTag != "" {
+		rule.RuleTag = rawFieldRule.RuleTag
+	}
+
 	if rawFieldRule.Domain != nil {
 		for _, domain := range *rawFieldRule.Domain {
 			rules, err := parseDomainRule(ctx, domain)
@@ -310,6 +343,37 @@ func parseFieldRule(ctx context.Context, msg json.RawMessage) (*router.RoutingRu
 		rule.Attributes = rawFieldRule.Attributes
 	}
 
+	if rawFieldRule.Process != nil {
+		for _, s := range *rawFieldRule.Process {
+			switch {
+			case strings.HasPrefix(s, "uid:"):
+				uid, err := strconv.ParseUint(s[4:], 10, 32)
+				if err != nil {
+					return nil, newError("invalid process uid: ", s).Base(err)
+				}
+				rule.ProcessUid = append(rule.ProcessUid, uint32(uid))
+			case strings.HasPrefix(s, "/"):
+				rule.ProcessPath = append(rule.ProcessPath, s)
+			default:
+				rule.ProcessName = append(rule.ProcessName, s)
+			}
+		}
+	}
+
+	if rawFieldRule.RuleSet != nil {
+		rule.RuleSet = *rawFieldRule.RuleSet
+	}
+
+	if rawFieldRule.Time != nil {
+		for _, s := range *rawFieldRule.Time {
+			window, err := router.ParseTimeWindow(s)
+			if err != nil {
+				return nil, newError("failed to parse time window: ", s).Base(err)
+			}
+			rule.TimeWindow = append(rule.TimeWindow, window)
+		}
+	}
+
 	return rule, nil
 }
 
@@ -390,6 +454,7 @@ type RouterRule struct {
 	Type        string `json:"type"`
 	OutboundTag string `json:"outboundTag"`
 	BalancerTag string `json:"balancerTag"`
+	RuleTag     string `json:"ruleTag"`
 
 	DomainMatcher string `json:"domainMatcher"`
 }
@@ -24,6 +24,12 @@ type NameServerConfig struct {
 	SkipFallback bool
 	Domains      []string
 	ExpectIPs    cfgcommon.StringList
+	RaceGroup    string
+
+	ClientSubnetFromSource bool
+	ClientSubnetIPv4Prefix uint32
+	ClientSubnetIPv6Prefix uint32
+	ClientSubnets          map[string]string
 
 	cfgctx context.Context
 }
@@ -42,6 +48,12 @@ func (c *NameServerConfig) UnmarshalJSON(data []byte) error {
 		SkipFallback bool                 `json:"skipFallback"`
 		Domains      []string             `json:"domains"`
 		ExpectIPs    cfgcommon.StringList `json:"expectIps"`
+		RaceGroup    string               `json:"raceGroup"`
+
+		ClientSubnetFromSource bool              `json:"clientSubnetFromSource"`
+		ClientSubnetIPv4Prefix uint32            `json:"clientSubnetIPv4Prefix"`
+		ClientSubnetIPv6Prefix uint32            `json:"clientSubnetIPv6Prefix"`
+		ClientSubnets          map[string]string `json:"clientSubnets"`
 	}
 	if err := json.Unmarshal(data, &advanced); err == nil {
 		c.Address = advanced.Address
@@ -50,6 +62,11 @@ func (c *NameServerConfig) UnmarshalJSON(data []byte) error {
 		c.SkipFallback = advanced.SkipFallback
 		c.Domains = advanced.Domains
 		c.ExpectIPs = advanced.ExpectIPs
+		c.RaceGroup = advanced.RaceGroup
+		c.ClientSubnetFromSource = advanced.ClientSubnetFromSource
+		c.ClientSubnetIPv4Prefix = advanced.ClientSubnetIPv4Prefix
+		c.ClientSubnetIPv6Prefix = advanced.ClientSubnetIPv6Prefix
+		c.ClientSubnets = advanced.ClientSubnets
 		return nil
 	}
 
@@ -104,6 +121,36 @@ func (c *NameServerConfig) Build() (*dns.NameServer, error) {
 		})
 	}
 
+	// Client subnet rules are appended in a stable order, as the first
+	// matching rule wins.
+	subnetRules := make([]string, 0, len(c.ClientSubnets))
+	for rule := range c.ClientSubnets {
+		subnetRules = append(subnetRules, rule)
+	}
+	sort.Strings(subnetRules)
+	for _, rule := range subnetRules {
+		subnet, err := rule2.ParseIP(c.ClientSubnets[rule])
+		if err != nil {
+			return nil, newError("invalid client subnet for rule: ", rule).Base(err)
+		}
+		parsedDomain, err := rule2.ParseDomainRule(cfgctx, rule)
+		if err != nil {
+			return nil, newError("invalid domain rule: ", rule).Base(err)
+		}
+
+		for _, pd := range parsedDomain {
+			domains = append(domains, &dns.NameServer_PriorityDomain{
+				Type:         toDomainMatchingType(pd.Type),
+				Domain:       pd.Value,
+				ClientSubnet: subnet,
+			})
+		}
+		originalRules = append(originalRules, &dns.NameServer_OriginalRule{
+			Rule: rule,
+			Size: uint32(len(parsedDomain)),
+		})
+	}
+
 	geoipList, err := rule2.ToCidrList(cfgctx, c.ExpectIPs)
 	if err != nil {
 		return nil, newError("invalid IP rule: ", c.ExpectIPs).Base(err)
@@ -128,6 +175,11 @@ func (c *NameServerConfig) Build() (*dns.NameServer, error) {
 		PrioritizedDomain: domains,
 		Geoip:             geoipList,
 		OriginalRules:     originalRules,
+		RaceGroup:         c.RaceGroup,
+
+		ClientSubnetFromSource: c.ClientSubnetFromSource,
+		ClientSubnetIpv4Prefix: c.ClientSubnetIPv4Prefix,
+		ClientSubnetIpv6Prefix: c.ClientSubnetIPv6Prefix,
 	}, nil
 }
 
@@ -148,6 +200,13 @@ type DNSConfig struct {
 	DisableCache           bool                    `json:"disableCache"`
 	DisableFallback        bool                    `json:"disableFallback"`
 	DisableFallbackIfMatch bool                    `json:"disableFallbackIfMatch"`
+	CacheMinTTL            uint32                  `json:"cacheMinTTL"`
+	CacheMaxTTL            uint32                  `json:"cacheMaxTTL"`
+	ServeStaleTTL          uint32                  `json:"serveStaleTTL"`
+	Prefetch               bool                    `json:"prefetch"`
+	PersistCache           bool                    `json:"persistCache"`
+	DNSSEC                 bool                    `json:"dnssec"`
+	DNSSECTrustAnchors     []string                `json:"dnssecTrustAnchors"`
 	cfgctx                 context.Context
 }
 
@@ -228,6 +287,17 @@ func (c *DNSConfig) Build() (*dns.Config, error) {
 		DisableCache:           c.DisableCache,
 		DisableFallback:        c.DisableFallback,
 		DisableFallbackIfMatch: c.DisableFallbackIfMatch,
+		CacheMinTtl:            c.CacheMinTTL,
+		CacheMaxTtl:            c.CacheMaxTTL,
+		ServeStaleTtl:          c.ServeStaleTTL,
+		Prefetch:               c.Prefetch,
+		PersistCache:           c.PersistCache,
+		Dnssec:                 c.DNSSEC,
+		DnssecTrustAnchor:      c.DNSSECTrustAnchors,
+	}
+
+	if c.CacheMaxTTL > 0 && c.CacheMinTTL > c.CacheMaxTTL {
+		return nil, newError("cacheMinTTL ", c.CacheMinTTL, " is greater than cacheMaxTTL ", c.CacheMaxTTL)
 	}
 
 	if c.ClientIP != nil {
@@ -11,6 +11,7 @@ import (
 	"google.golang.org/protobuf/runtime/protoiface"
 
 	"github.com/v2fly/v2ray-core/v4/app/dns"
+	"github.com/v2fly/v2ray-core/v4/app/router/routercommon"
 	"github.com/v2fly/v2ray-core/v4/common"
 	"github.com/v2fly/v2ray-core/v4/common/net"
 	"github.com/v2fly/v2ray-core/v4/common/platform/filesystem"
@@ -67,7 +68,8 @@ func TestDNSConfigParsing(t *testing.T) {
 					"clientIp": "10.0.0.1",
 					"port": 5353,
 					"skipFallback": true,
-					"domains": ["domain:v2fly.org"]
+					"domains": ["domain:v2fly.org"],
+					"raceGroup": "fast"
 				}],
 				"hosts": {
 					"v2fly.org": "127.0.0.1",
@@ -80,7 +82,14 @@ func TestDNSConfigParsing(t *testing.T) {
 				"clientIp": "10.0.0.1",
 				"queryStrategy": "UseIPv4",
 				"disableCache": true,
-				"disableFallback": true
+				"disableFallback": true,
+				"cacheMinTTL": 30,
+				"cacheMaxTTL": 3600,
+				"serveStaleTTL": 86400,
+				"prefetch": true,
+				"persistCache": true,
+				"dnssec": true,
+				"dnssecTrustAnchors": [". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"]
 			}`,
 			Parser: parserCreator(),
 			Output: &dns.Config{
@@ -109,6 +118,7 @@ func TestDNSConfigParsing(t *testing.T) {
 								Size: 1,
 							},
 						},
+						RaceGroup: "fast",
 					},
 				},
 				StaticHosts: []*dns.HostMapping{
@@ -147,6 +157,70 @@ func TestDNSConfigParsing(t *testing.T) {
 				QueryStrategy:   dns.QueryStrategy_USE_IP4,
 				DisableCache:    true,
 				DisableFallback: true,
+				CacheMinTtl:     30,
+				CacheMaxTtl:     3600,
+				ServeStaleTtl:   86400,
+				Prefetch:        true,
+				PersistCache:    true,
+				Dnssec:          true,
+				DnssecTrustAnchor: []string{
+					". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+				},
+			},
+		},
+		{
+			Input: `{
+				"servers": [{
+					"address": "1.1.1.1",
+					"clientSubnetFromSource": true,
+					"clientSubnetIPv4Prefix": 20,
+					"clientSubnetIPv6Prefix": 48,
+					"clientSubnets": {
+						"full:example.com": "0.0.0.0/0",
+						"domain:v2fly.org": "1.2.3.0/24"
+					}
+				}]
+			}`,
+			Parser: parserCreator(),
+			Output: &dns.Config{
+				NameServer: []*dns.NameServer{
+					{
+						Address: &net.Endpoint{
+							Address: &net.IPOrDomain{
+								Address: &net.IPOrDomain_Ip{
+									Ip: []byte{1, 1, 1, 1},
+								},
+							},
+							Network: net.Network_UDP,
+						},
+						PrioritizedDomain: []*dns.NameServer_PriorityDomain{
+							{
+								Type:         dns.DomainMatchingType_Subdomain,
+								Domain:       "v2fly.org",
+								ClientSubnet: &routercommon.CIDR{Ip: []byte{1, 2, 3, 0}, Prefix: 24},
+							},
+							{
+								Type:         dns.DomainMatchingType_Full,
+								Domain:       "example.com",
+								ClientSubnet: &routercommon.CIDR{Ip: []byte{0, 0, 0, 0}, Prefix: 0},
+							},
+						},
+						OriginalRules: []*dns.NameServer_OriginalRule{
+							{
+								Rule: "domain:v2fly.org",
+								Size: 1,
+							},
+							{
+								Rule: "full:example.com",
+								Size: 1,
+							},
+						},
+						ClientSubnetFromSource: true,
+						ClientSubnetIpv4Prefix: 20,
+						ClientSubnetIpv6Prefix: 48,
+					},
+				},
+				QueryStrategy: dns.QueryStrategy_USE_IP,
 			},
 		},
 	})
@@ -17,6 +17,7 @@ func DefaultLogConfig() *log.Config {
 type LogConfig struct {
 	AccessLog string `json:"access"`
 	ErrorLog  string `json:"error"`
+	DNSLog    string `json:"dns"`
 	LogLevel  string `json:"loglevel"`
 }
 
@@ -41,6 +42,14 @@ func (v *LogConfig) Build() *log.Config {
 		config.Error.Path = v.ErrorLog
 		config.Error.Type = log.LogType_File
 	}
+	// The DNS log is off unless asked for, as it logs every query.
+	switch v.DNSLog {
+	case "", "none":
+	case "console":
+		config.Dns = &log.LogSpecification{Type: log.LogType_Console}
+	default:
+		config.Dns = &log.LogSpecification{Type: log.LogType_File, Path: v.DNSLog}
+	}
 
 	level := strings.ToLower(v.LogLevel)
 	switch level {
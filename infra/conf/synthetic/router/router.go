@@ -53,6 +53,12 @@ func (r *BalancingRule) Build() (*router.BalancingRule, error) {
 		strategy = strategyLeastLoad
 	case strategyLeastPing:
 		strategy = "leastping"
+	case strategyConsistentHash:
+		strategy = strategyConsistentHash
+	case strategyWeightedRoundRobin:
+		strategy = strategyWeightedRoundRobin
+	case strategyPriority:
+		strategy = strategyPriority
 	default:
 		return nil, newError("unknown balancing strategy: " + r.Strategy.Type)
 	}
@@ -82,11 +88,46 @@ func (r *BalancingRule) Build() (*router.BalancingRule, error) {
 	}, nil
 }
 
+type RuleSetConfig struct {
+	Tag            string `json:"tag"`
+	Path           string `json:"path"`
+	Format         string `json:"format"`
+	ReloadInterval uint32 `json:"reloadInterval"`
+}
+
+// Build builds the rule set config
+func (r *RuleSetConfig) Build() (*router.RuleSet, error) {
+	if r.Tag == "" {
+		return nil, newError("empty rule set tag")
+	}
+	if r.Path == "" {
+		return nil, newError("empty path for rule set ", r.Tag)
+	}
+
+	var format router.RuleSet_Format
+	switch strings.ToLower(r.Format) {
+	case "text", "":
+		format = router.RuleSet_Text
+	case "protobuf", "pb":
+		format = router.RuleSet_Protobuf
+	default:
+		return nil, newError("unknown rule set format: ", r.Format)
+	}
+
+	return &router.RuleSet{
+		Tag:            r.Tag,
+		Path:           r.Path,
+		Format:         format,
+		ReloadInterval: r.ReloadInterval,
+	}, nil
+}
+
 type RouterConfig struct {
 	Settings       *RouterRulesConfig `json:"settings"` // Deprecated
 	RuleList       []json.RawMessage  `json:"rules"`
 	DomainStrategy *string            `json:"domainStrategy"`
 	Balancers      []*BalancingRule   `json:"balancers"`
+	RuleSets       []*RuleSetConfig   `json:"ruleSets"`
 
 	DomainMatcher string `json:"domainMatcher"`
 
@@ -164,5 +205,12 @@ func (c *RouterConfig) Build() (*router.Config, error) {
 		}
 		config.BalancingRule = append(config.BalancingRule, balancer)
 	}
+	for _, rawRuleSet := range c.RuleSets {
+		ruleSet, err := rawRuleSet.Build()
+		if err != nil {
+			return nil, err
+		}
+		config.RuleSet = append(config.RuleSet, ruleSet)
+	}
 	return config, nil
 }
@@ -1,6 +1,8 @@
 package router
 
 import (
+	"strings"
+
 	"github.com/golang/protobuf/proto"
 
 	"github.com/v2fly/v2ray-core/v4/app/observatory/burst"
@@ -13,6 +15,10 @@ const (
 	strategyRandom    string = "random"
 	strategyLeastLoad string = "leastload"
 	strategyLeastPing string = "leastping"
+
+	strategyConsistentHash     string = "consistenthash"
+	strategyWeightedRoundRobin string = "weightedroundrobin"
+	strategyPriority           string = "priority"
 )
 
 var (
@@ -20,6 +26,10 @@ var (
 		strategyRandom:    func() interface{} { return new(strategyEmptyConfig) },
 		strategyLeastLoad: func() interface{} { return new(strategyLeastLoadConfig) },
 		strategyLeastPing: func() interface{} { return new(strategyLeastPingConfig) },
+
+		strategyConsistentHash:     func() interface{} { return new(strategyConsistentHashConfig) },
+		strategyWeightedRoundRobin: func() interface{} { return new(strategyWeightedRoundRobinConfig) },
+		strategyPriority:           func() interface{} { return new(strategyPriorityConfig) },
 	}, "type", "settings")
 )
 
@@ -101,3 +111,62 @@ type strategyLeastPingConfig struct {
 func (s strategyLeastPingConfig) Build() (proto.Message, error) {
 	return &router.StrategyLeastPingConfig{ObserverTag: s.ObserverTag}, nil
 }
+
+type strategyConsistentHashConfig struct {
+	// hash key: "sourceIP", "domain", "user" or "expression"
+	Key string `json:"key,omitempty"`
+	// Starlark expression evaluated to the hash key
+	Expression string `json:"expression,omitempty"`
+
+	ObserverTag string `json:"observerTag,omitempty"`
+}
+
+// Build implements Buildable.
+func (v *strategyConsistentHashConfig) Build() (proto.Message, error) {
+	config := &router.StrategyConsistentHashConfig{
+		Expression:  v.Expression,
+		ObserverTag: v.ObserverTag,
+	}
+	switch strings.ToLower(v.Key) {
+	case "sourceip", "source_ip", "source", "":
+		config.Key = router.StrategyConsistentHashConfig_SourceIp
+	case "domain":
+		config.Key = router.StrategyConsistentHashConfig_Domain
+	case "user", "email":
+		config.Key = router.StrategyConsistentHashConfig_User
+	case "expression":
+		config.Key = router.StrategyConsistentHashConfig_Expression
+		if len(v.Expression) == 0 {
+			return nil, newError("empty hash key expression")
+		}
+	default:
+		return nil, newError("unknown hash key: ", v.Key)
+	}
+	return config, nil
+}
+
+type strategyWeightedRoundRobinConfig struct {
+	Weights     []*router.StrategyWeight `json:"weights,omitempty"`
+	ObserverTag string                   `json:"observerTag,omitempty"`
+}
+
+// Build implements Buildable.
+func (v *strategyWeightedRoundRobinConfig) Build() (proto.Message, error) {
+	return &router.StrategyWeightedRoundRobinConfig{
+		Weights:     v.Weights,
+		ObserverTag: v.ObserverTag,
+	}, nil
+}
+
+type strategyPriorityConfig struct {
+	Priority    []string `json:"priority,omitempty"`
+	ObserverTag string   `json:"observerTag,omitempty"`
+}
+
+// Build implements Buildable.
+func (v *strategyPriorityConfig) Build() (proto.Message, error) {
+	return &router.StrategyPriorityConfig{
+		Priority:    v.Priority,
+		ObserverTag: v.ObserverTag,
+	}, nil
+}
@@ -44,7 +44,8 @@ func TestRouterConfig(t *testing.T) {
 								"baidu.com",
 								"qq.com"
 							],
-							"outboundTag": "direct"
+							"outboundTag": "direct",
+							"ruleTag": "cn"
 						},
 						{
 							"type": "field",
@@ -68,10 +69,23 @@ func TestRouterConfig(t *testing.T) {
 						},{
 							"type": "field",
 							"port": 123,
+							"time": ["Mon-Fri 09:00-18:00 UTC", "Sat,Sun"],
+							"process": ["firefox", "/opt/idea/bin/idea", "uid:1000"],
+							"outboundTag": "test"
+						},{
+							"type": "field",
+							"ruleSet": ["ads"],
 							"outboundTag": "test"
 						}
 					]
 				},
+				"ruleSets": [
+					{
+						"tag": "ads",
+						"path": "ads.txt",
+						"reloadInterval": 60
+					}
+				],
 				"balancers": [
 					{
 						"tag": "b1",
@@ -104,6 +118,17 @@ func TestRouterConfig(t *testing.T) {
 							}
 						},
 						"fallbackTag": "fall"
+					},
+					{
+						"tag": "b3",
+						"selector": ["test"],
+						"strategy": {
+							"type": "consistenthash",
+							"settings": {
+								"key": "domain",
+								"observerTag": "obs"
+							}
+						}
 					}
 				]
 			}`,
@@ -138,6 +163,15 @@ func TestRouterConfig(t *testing.T) {
 						}),
 						FallbackTag: "fall",
 					},
+					{
+						Tag:              "b3",
+						OutboundSelector: []string{"test"},
+						Strategy:         "consistenthash",
+						StrategySettings: serial.ToTypedMessage(&router.StrategyConsistentHashConfig{
+							Key:         router.StrategyConsistentHashConfig_Domain,
+							ObserverTag: "obs",
+						}),
+					},
 				},
 				Rule: []*router.RoutingRule{
 					{
@@ -154,6 +188,7 @@ func TestRouterConfig(t *testing.T) {
 						TargetTag: &router.RoutingRule_Tag{
 							Tag: "direct",
 						},
+						RuleTag: "cn",
 					},
 					{
 						Domain: []*routercommon.Domain{
@@ -207,10 +242,38 @@ func TestRouterConfig(t *testing.T) {
 								{From: 123, To: 123},
 							},
 						},
+						ProcessName: []string{"firefox"},
+						ProcessPath: []string{"/opt/idea/bin/idea"},
+						ProcessUid:  []uint32{1000},
+						TimeWindow: []*router.TimeWindow{
+							{
+								Weekday:  []uint32{1, 2, 3, 4, 5},
+								Start:    9 * 60,
+								End:      18 * 60,
+								TimeZone: "UTC",
+							},
+							{
+								Weekday: []uint32{6, 0},
+							},
+						},
 						TargetTag: &router.RoutingRule_Tag{
 							Tag: "test",
 						},
 					},
+					{
+						RuleSet: []string{"ads"},
+						TargetTag: &router.RoutingRule_Tag{
+							Tag: "test",
+						},
+					},
+				},
+				RuleSet: []*router.RuleSet{
+					{
+						Tag:            "ads",
+						Path:           "ads.txt",
+						Format:         router.RuleSet_Text,
+						ReloadInterval: 60,
+					},
 				},
 			},
 		},
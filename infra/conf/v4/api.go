@@ -8,6 +8,8 @@ import (
 	"google.golang.org/protobuf/types/known/anypb"
 
 	"github.com/v2fly/v2ray-core/v4/app/commander"
+	connectionservice "github.com/v2fly/v2ray-core/v4/app/dispatcher/command"
+	dnsservice "github.com/v2fly/v2ray-core/v4/app/dns/command"
 	loggerservice "github.com/v2fly/v2ray-core/v4/app/log/command"
 	observatoryservice "github.com/v2fly/v2ray-core/v4/app/observatory/command"
 	handlerservice "github.com/v2fly/v2ray-core/v4/app/proxyman/command"
@@ -41,6 +43,10 @@ func (c *APIConfig) Build() (*commander.Config, error) {
 			services = append(services, serial.ToTypedMessage(&observatoryservice.Config{}))
 		case "routingservice":
 			services = append(services, serial.ToTypedMessage(&routerservice.Config{}))
+		case "dnsservice":
+			services = append(services, serial.ToTypedMessage(&dnsservice.Config{}))
+		case "connectionservice":
+			services = append(services, serial.ToTypedMessage(&connectionservice.Config{}))
 		default:
 			if !strings.HasPrefix(s, "#") {
 				continue
@@ -28,3 +28,28 @@ func (c *DNSOutboundConfig) Build() (proto.Message, error) {
 	}
 	return config, nil
 }
+
+type DNSInboundConfig struct {
+	Network   cfgcommon.Network  `json:"network"`
+	Address   *cfgcommon.Address `json:"address"`
+	Port      uint16             `json:"port"`
+	UserLevel uint32             `json:"userLevel"`
+	DoH       bool               `json:"doh"`
+	DoHPath   string             `json:"dohPath"`
+}
+
+func (c *DNSInboundConfig) Build() (proto.Message, error) {
+	config := &dns.ServerConfig{
+		UserLevel: c.UserLevel,
+		Doh:       c.DoH,
+		DohPath:   c.DoHPath,
+	}
+	if c.Address != nil {
+		config.Server = &net.Endpoint{
+			Network: c.Network.Build(),
+			Address: c.Address.Build(),
+			Port:    uint32(c.Port),
+		}
+	}
+	return config, nil
+}
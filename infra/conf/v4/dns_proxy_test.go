@@ -33,3 +33,34 @@ func TestDnsProxyConfig(t *testing.T) {
 		},
 	})
 }
+
+func TestDnsInboundConfig(t *testing.T) {
+	creator := func() cfgcommon.Buildable {
+		return new(v4.DNSInboundConfig)
+	}
+
+	testassist.RunMultiTestCase(t, []testassist.TestCase{
+		{
+			Input: `{
+				"address": "8.8.8.8",
+				"port": 53,
+				"network": "udp",
+				"doh": true
+			}`,
+			Parser: testassist.LoadJSON(creator),
+			Output: &dns.ServerConfig{
+				Server: &net.Endpoint{
+					Network: net.Network_UDP,
+					Address: net.NewIPOrDomain(net.IPAddress([]byte{8, 8, 8, 8})),
+					Port:    53,
+				},
+				Doh: true,
+			},
+		},
+		{
+			Input:  `{}`,
+			Parser: testassist.LoadJSON(creator),
+			Output: &dns.ServerConfig{},
+		},
+	})
+}
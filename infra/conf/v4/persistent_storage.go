@@ -0,0 +1,41 @@
+package v4
+
+import (
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/v2fly/v2ray-core/v4/app/persistentstorage/filesystemstorage"
+)
+
+// persistentStorageServicePrefix starts the names of the persistent storage
+// engines that can be loaded as services.
+const persistentStorageServicePrefix = "v2ray.core.app.persistentstorage."
+
+type PersistentStorageConfig struct {
+	Path string `json:"path"`
+}
+
+func (c *PersistentStorageConfig) Build() (proto.Message, error) {
+	c.Path = strings.TrimSpace(c.Path)
+	if c.Path == "" {
+		return nil, newError("persistent storage requires a path")
+	}
+	return &filesystemstorage.Config{
+		Path: c.Path,
+	}, nil
+}
+
+// hasPersistentStorage reports whether c configures a persistent storage
+// engine, either as persistentStorage or as a service.
+func (c *Config) hasPersistentStorage() bool {
+	if c.PersistentStorage != nil {
+		return true
+	}
+	for name := range c.Services {
+		if strings.HasPrefix(name, persistentStorageServicePrefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -11,6 +11,7 @@ type Policy struct {
 	DownlinkOnly      *uint32 `json:"downlinkOnly"`
 	StatsUserUplink   bool    `json:"statsUserUplink"`
 	StatsUserDownlink bool    `json:"statsUserDownlink"`
+	StatsUserOnline   bool    `json:"statsUserOnline"`
 	BufferSize        *int32  `json:"bufferSize"`
 }
 
@@ -34,6 +35,7 @@ func (t *Policy) Build() (*policy.Policy, error) {
 		Stats: &policy.Policy_Stats{
 			UserUplink:   t.StatsUserUplink,
 			UserDownlink: t.StatsUserDownlink,
+			UserOnline:   t.StatsUserOnline,
 		},
 	}
 
@@ -55,6 +57,12 @@ type SystemPolicy struct {
 	StatsInboundDownlink  bool `json:"statsInboundDownlink"`
 	StatsOutboundUplink   bool `json:"statsOutboundUplink"`
 	StatsOutboundDownlink bool `json:"statsOutboundDownlink"`
+	StatsRuleHits         bool `json:"statsRuleHits"`
+	StatsRuleUplink       bool `json:"statsRuleUplink"`
+	StatsRuleDownlink     bool `json:"statsRuleDownlink"`
+	StatsInboundLatency   bool `json:"statsInboundLatency"`
+	StatsOutboundLatency  bool `json:"statsOutboundLatency"`
+	StatsDNSServer        bool `json:"statsDnsServer"`
 }
 
 func (p *SystemPolicy) Build() (*policy.SystemPolicy, error) {
@@ -64,6 +72,12 @@ func (p *SystemPolicy) Build() (*policy.SystemPolicy, error) {
 			InboundDownlink:  p.StatsInboundDownlink,
 			OutboundUplink:   p.StatsOutboundUplink,
 			OutboundDownlink: p.StatsOutboundDownlink,
+			RuleHits:         p.StatsRuleHits,
+			RuleUplink:       p.StatsRuleUplink,
+			RuleDownlink:     p.StatsRuleDownlink,
+			InboundLatency:   p.StatsInboundLatency,
+			OutboundLatency:  p.StatsOutboundLatency,
+			DnsServer:        p.StatsDNSServer,
 		},
 	}, nil
 }
@@ -30,6 +30,7 @@ var (
 		"vless":         func() interface{} { return new(VLessInboundConfig) },
 		"vmess":         func() interface{} { return new(VMessInboundConfig) },
 		"trojan":        func() interface{} { return new(TrojanServerConfig) },
+		"dns":           func() interface{} { return new(DNSInboundConfig) },
 	}, "protocol", "settings")
 
 	outboundConfigLoader = loader.NewJSONConfigLoader(loader.ConfigCreatorCache{
@@ -263,11 +264,65 @@ func (c *OutboundDetourConfig) Build() (*core.OutboundHandlerConfig, error) {
 	}, nil
 }
 
-type StatsConfig struct{}
+type StatsHistogramConfig struct {
+	Patterns []string  `json:"patterns"`
+	Buckets  []float64 `json:"buckets"`
+}
+
+type StatsHistoryResolution struct {
+	Interval uint32 `json:"interval"`
+	Duration uint32 `json:"duration"`
+}
+
+type StatsHistoryConfig struct {
+	Patterns    []string                  `json:"patterns"`
+	Resolutions []*StatsHistoryResolution `json:"resolutions"`
+}
+
+type StatsPersistConfig struct {
+	Patterns []string `json:"patterns"`
+	Interval uint32   `json:"interval"`
+}
+
+type StatsConfig struct {
+	Histograms []*StatsHistogramConfig `json:"histograms"`
+	History    *StatsHistoryConfig     `json:"history"`
+	Persist    *StatsPersistConfig     `json:"persist"`
+}
 
 // Build implements Buildable.
 func (c *StatsConfig) Build() (*stats.Config, error) {
-	return &stats.Config{}, nil
+	config := &stats.Config{}
+	for _, hc := range c.Histograms {
+		if len(hc.Patterns) == 0 || len(hc.Buckets) == 0 {
+			return nil, newError("histogram config requires patterns and buckets")
+		}
+		config.Histogram = append(config.Histogram, &stats.HistogramConfig{
+			Pattern: hc.Patterns,
+			Bucket:  hc.Buckets,
+		})
+	}
+	if c.History != nil {
+		config.History = &stats.HistoryConfig{
+			Pattern: c.History.Patterns,
+		}
+		for _, r := range c.History.Resolutions {
+			if r.Interval == 0 || r.Duration < r.Interval {
+				return nil, newError("invalid stats history resolution of ", r.Duration, "s in ", r.Interval, "s")
+			}
+			config.History.Resolution = append(config.History.Resolution, &stats.HistoryResolution{
+				Interval: r.Interval,
+				Duration: r.Duration,
+			})
+		}
+	}
+	if c.Persist != nil {
+		config.Persist = &stats.PersistConfig{
+			Pattern:  c.Persist.Patterns,
+			Interval: c.Persist.Interval,
+		}
+	}
+	return config, nil
 }
 
 type Config struct {
@@ -308,6 +363,8 @@ type Config struct {
 	BurstObservatory *BurstObservatoryConfig `json:"burstObservatory"`
 	MultiObservatory *MultiObservatoryConfig `json:"multiObservatory"`
 
+	PersistentStorage *PersistentStorageConfig `json:"persistentStorage"`
+
 	Services map[string]*json.RawMessage `json:"services"`
 }
 
@@ -373,6 +430,23 @@ func (c *Config) Build() (*core.Config, error) {
 		config.App = append(config.App, serial.ToTypedMessage(apiConf))
 	}
 
+	if !c.hasPersistentStorage() {
+		if c.Stats != nil && c.Stats.Persist != nil {
+			return nil, newError("stats persist requires persistentStorage")
+		}
+		if c.DNSConfig != nil && c.DNSConfig.PersistCache {
+			return nil, newError("DNS persistCache requires persistentStorage")
+		}
+	}
+
+	if c.PersistentStorage != nil {
+		r, err := c.PersistentStorage.Build()
+		if err != nil {
+			return nil, err
+		}
+		config.App = append(config.App, serial.ToTypedMessage(r))
+	}
+
 	if c.Stats != nil {
 		statsConf, err := c.Stats.Build()
 		if err != nil {
@@ -11,6 +11,7 @@ import (
 	core "github.com/v2fly/v2ray-core/v4"
 	"github.com/v2fly/v2ray-core/v4/app/dispatcher"
 	"github.com/v2fly/v2ray-core/v4/app/log"
+	"github.com/v2fly/v2ray-core/v4/app/persistentstorage/filesystemstorage"
 	"github.com/v2fly/v2ray-core/v4/app/proxyman"
 	"github.com/v2fly/v2ray-core/v4/app/router"
 	"github.com/v2fly/v2ray-core/v4/app/router/routercommon"
@@ -382,3 +383,38 @@ func TestMuxConfig_Build(t *testing.T) {
 		})
 	}
 }
+
+func TestPersistentStorageConfig(t *testing.T) {
+	build := func(s string) (*core.Config, error) {
+		config := new(v4.Config)
+		common.Must(json.Unmarshal([]byte(s), config))
+		return config.Build()
+	}
+
+	for _, s := range []string{
+		`{"stats": {"persist": {}}}`,
+		`{"dns": {"persistCache": true}}`,
+		`{"stats": {"persist": {}}, "persistentStorage": {}}`,
+	} {
+		if _, err := build(s); err == nil {
+			t.Error("expected error for ", s)
+		}
+	}
+
+	config, err := build(`{"stats": {"persist": {}}, "dns": {"persistCache": true}, "persistentStorage": {"path": "/var/lib/v2ray"}}`)
+	common.Must(err)
+	found := false
+	for _, app := range config.App {
+		if app.MessageIs(&filesystemstorage.Config{}) {
+			msg, err := app.UnmarshalNew()
+			common.Must(err)
+			if path := msg.(*filesystemstorage.Config).Path; path != "/var/lib/v2ray" {
+				t.Error("unexpected persistent storage path ", path)
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected persistent storage in apps")
+	}
+}
@@ -15,5 +15,8 @@ var CmdAPI = &base.Command{
 		cmdStats,
 		cmdBalancerInfo,
 		cmdBalancerOverride,
+		cmdListRules,
+		cmdDNS,
+		cmdConns,
 	},
 }
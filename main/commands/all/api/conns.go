@@ -0,0 +1,203 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	connService "github.com/v2fly/v2ray-core/v4/app/dispatcher/command"
+	"github.com/v2fly/v2ray-core/v4/common/units"
+	"github.com/v2fly/v2ray-core/v4/main/commands/base"
+)
+
+var cmdConns = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} api conns [--server=127.0.0.1:8080] [action] [arguments]",
+	Short:       "list and close connections",
+	Long: `
+Show the connections V2Ray is handling, and close them.
+
+> Make sure you have "ConnectionService" set in "config.api.services"
+of server config.
+
+> It ignores -timeout flag while watching connections
+
+Actions:
+
+	list
+		List the connections in flight. This is the default action.
+
+	watch
+		Print connections as they are opened and closed.
+
+	close <id>...
+		Close the connections with the given IDs.
+
+Arguments:
+
+	-inbound <tag>
+		Only show connections from the inbound.
+
+	-outbound <tag>
+		Only show connections to the outbound.
+
+	-user <email>
+		Only show connections of the user.
+
+	-source <address>
+		Only show connections whose source contains address.
+
+	-target <address>
+		Only show connections whose target or sniffed domain contains
+		address.
+
+	-json
+		Use json output.
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout seconds to call API. Default 3
+
+Example:
+
+    {{.Exec}} {{.LongName}} --server=127.0.0.1:8080 list -user love@v2fly.org
+    {{.Exec}} {{.LongName}} watch -inbound socks
+    {{.Exec}} {{.LongName}} close 12 13
+`,
+	Run: executeConns,
+}
+
+func executeConns(cmd *base.Command, args []string) {
+	request := &connService.ListConnectionsRequest{}
+	cmd.Flag.StringVar(&request.InboundTag, "inbound", "", "")
+	cmd.Flag.StringVar(&request.OutboundTag, "outbound", "", "")
+	cmd.Flag.StringVar(&request.User, "user", "", "")
+	cmd.Flag.StringVar(&request.Source, "source", "", "")
+	cmd.Flag.StringVar(&request.Target, "target", "", "")
+	setSharedFlags(cmd)
+	// flags are accepted both before and after the action
+	cmd.Flag.Parse(args)
+	action := "list"
+	if cmd.Flag.NArg() > 0 {
+		action = cmd.Flag.Arg(0)
+		cmd.Flag.Parse(cmd.Flag.Args()[1:])
+	}
+	unnamed := cmd.Flag.Args()
+
+	switch action {
+	case "list":
+		listConnections(request)
+	case "watch":
+		watchConnections(&connService.StreamConnectionEventsRequest{
+			InboundTag:  request.InboundTag,
+			OutboundTag: request.OutboundTag,
+			User:        request.User,
+			Source:      request.Source,
+			Target:      request.Target,
+		})
+	case "close":
+		if len(unnamed) == 0 {
+			base.Fatalf("close requires at least one connection ID")
+		}
+		closeConnections(unnamed)
+	default:
+		base.Fatalf("unknown action: %s", action)
+	}
+}
+
+func listConnections(request *connService.ListConnectionsRequest) {
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := connService.NewConnectionServiceClient(conn)
+	resp, err := client.ListConnections(ctx, request)
+	if err != nil {
+		base.Fatalf("failed to list connections: %s", err)
+	}
+	if apiJSON {
+		showJSONResponse(resp)
+		return
+	}
+	showConnections(resp.Connection)
+}
+
+func watchConnections(request *connService.StreamConnectionEventsRequest) {
+	conn, ctx, close := dialAPIServerWithoutTimeout()
+	defer close()
+
+	client := connService.NewConnectionServiceClient(conn)
+	stream, err := client.StreamConnectionEvents(ctx, request)
+	if err != nil {
+		base.Fatalf("failed to watch connections: %s", err)
+	}
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			base.Fatalf("failed to receive connection event: %s", err)
+		}
+		if apiJSON {
+			showJSONResponse(event)
+			continue
+		}
+		c := event.Connection
+		switch event.Type {
+		case connService.ConnectionEvent_Opened:
+			fmt.Fprintf(os.Stdout, "%s open  #%d %s %s -> %s via %s\n",
+				time.Now().Format("15:04:05"), c.Id, c.InboundTag, c.Source, connectionTarget(c), c.OutboundTag)
+		case connService.ConnectionEvent_Closed:
+			fmt.Fprintf(os.Stdout, "%s close #%d %s -> %s, %s up, %s down in %s\n",
+				time.Now().Format("15:04:05"), c.Id, c.Source, connectionTarget(c),
+				units.ByteSize(c.Uplink), units.ByteSize(c.Downlink), connectionDuration(c))
+		}
+	}
+}
+
+func closeConnections(ids []string) {
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := connService.NewConnectionServiceClient(conn)
+	for _, s := range ids {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			base.Fatalf("invalid connection ID: %s", s)
+		}
+		if _, err := client.CloseConnection(ctx, &connService.CloseConnectionRequest{Id: id}); err != nil {
+			base.Fatalf("failed to close connection %d: %s", id, err)
+		}
+	}
+}
+
+func showConnections(conns []*connService.Connection) {
+	const tableIndent = 0
+	sb := new(strings.Builder)
+	titles := []string{"ID", "Inbound", "User", "Source", "Target", "Outbound", "Time", "Up", "Down"}
+	formats := []string{"%-8s ", "%-12s ", "%-20s ", "%-24s ", "%-40s ", "%-12s ", "%-10s ", "%-10s ", "%s"}
+	writeRow(sb, tableIndent, 0, titles, formats)
+	for i, c := range conns {
+		writeRow(sb, tableIndent, i+1, []string{
+			strconv.FormatUint(c.Id, 10), c.InboundTag, c.User, c.Source, connectionTarget(c), c.OutboundTag,
+			connectionDuration(c).String(), units.ByteSize(c.Uplink).String(), units.ByteSize(c.Downlink).String(),
+		}, formats)
+	}
+	fmt.Fprint(os.Stdout, sb.String())
+}
+
+func connectionTarget(c *connService.Connection) string {
+	if c.Domain != "" && !strings.Contains(c.Target, c.Domain) {
+		return c.Domain + " (" + c.Target + ")"
+	}
+	return c.Target
+}
+
+func connectionDuration(c *connService.Connection) time.Duration {
+	return time.Since(time.Unix(c.StartTime, 0)).Truncate(time.Second)
+}
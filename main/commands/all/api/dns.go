@@ -0,0 +1,217 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	dnsService "github.com/v2fly/v2ray-core/v4/app/dns/command"
+	"github.com/v2fly/v2ray-core/v4/main/commands/base"
+)
+
+var cmdDNS = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} api dns [--server=127.0.0.1:8080] <action> [arguments]",
+	Short:       "manage the DNS module",
+	Long: `
+Query and manage the DNS module of V2Ray.
+
+> Make sure you have "DNSService" set in "config.api.services"
+of server config.
+
+Actions:
+
+	lookup [-4|-6] [-nocache] <domain>
+		Resolve domain with the configured name servers, and show
+		which server answered.
+
+	flush [domain]
+		Remove domain from the DNS cache, or clear the cache if no
+		domain is given.
+
+	cache [filter]
+		List the cached answers, only those whose domain contains
+		filter if given.
+
+	fake
+		List the domains that have been assigned a fake IP.
+
+	reverse <ip>
+		Show the domain a fake IP was assigned to.
+
+Arguments:
+
+	-4
+		Lookup IPv4 addresses only.
+
+	-6
+		Lookup IPv6 addresses only.
+
+	-nocache
+		Bypass the DNS cache on lookup.
+
+	-json
+		Use json output.
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout seconds to call API. Default 3
+
+Example:
+
+    {{.Exec}} {{.LongName}} --server=127.0.0.1:8080 lookup -nocache example.com
+    {{.Exec}} {{.LongName}} flush example.com
+    {{.Exec}} {{.LongName}} reverse 198.18.0.3
+`,
+	Run: executeDNS,
+}
+
+func executeDNS(cmd *base.Command, args []string) {
+	var (
+		ipv4Only     bool
+		ipv6Only     bool
+		disableCache bool
+	)
+	cmd.Flag.BoolVar(&ipv4Only, "4", false, "")
+	cmd.Flag.BoolVar(&ipv6Only, "6", false, "")
+	cmd.Flag.BoolVar(&disableCache, "nocache", false, "")
+	setSharedFlags(cmd)
+	// flags are accepted both before and after the action
+	cmd.Flag.Parse(args)
+	if cmd.Flag.NArg() == 0 {
+		base.Fatalf("no action specified")
+	}
+	action := cmd.Flag.Arg(0)
+	cmd.Flag.Parse(cmd.Flag.Args()[1:])
+	unnamed := cmd.Flag.Args()
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := dnsService.NewDNSServiceClient(conn)
+	switch action {
+	case "lookup":
+		if len(unnamed) != 1 {
+			base.Fatalf("lookup requires exactly one domain")
+		}
+		resp, err := client.Lookup(ctx, &dnsService.LookupRequest{
+			Domain:       unnamed[0],
+			Ipv4Only:     ipv4Only,
+			Ipv6Only:     ipv6Only,
+			DisableCache: disableCache,
+		})
+		if err != nil {
+			base.Fatalf("failed to lookup %s: %s", unnamed[0], err)
+		}
+		if apiJSON {
+			showJSONResponse(resp)
+			return
+		}
+		fmt.Fprintf(os.Stdout, "%s (from %s)\n", strings.Join(bytesToIPStrings(resp.Ip), ", "), resp.Server)
+	case "flush":
+		if len(unnamed) > 1 {
+			base.Fatalf("flush accepts at most one domain")
+		}
+		req := &dnsService.FlushCacheRequest{}
+		if len(unnamed) == 1 {
+			req.Domain = unnamed[0]
+		}
+		resp, err := client.FlushCache(ctx, req)
+		if err != nil {
+			base.Fatalf("failed to flush DNS cache: %s", err)
+		}
+		if apiJSON {
+			showJSONResponse(resp)
+			return
+		}
+		fmt.Fprintf(os.Stdout, "%d entries removed\n", resp.Count)
+	case "cache":
+		req := &dnsService.ListCacheRequest{}
+		if len(unnamed) > 0 {
+			req.Domain = unnamed[0]
+		}
+		resp, err := client.ListCache(ctx, req)
+		if err != nil {
+			base.Fatalf("failed to list DNS cache: %s", err)
+		}
+		if apiJSON {
+			showJSONResponse(resp)
+			return
+		}
+		showDNSCache(resp)
+	case "fake":
+		resp, err := client.ListFakeDNSMappings(ctx, &dnsService.ListFakeDNSMappingsRequest{})
+		if err != nil {
+			base.Fatalf("failed to list fake DNS mappings: %s", err)
+		}
+		if apiJSON {
+			showJSONResponse(resp)
+			return
+		}
+		showFakeDNSMappings(resp)
+	case "reverse":
+		if len(unnamed) != 1 {
+			base.Fatalf("reverse requires exactly one IP")
+		}
+		ip := net.ParseIP(unnamed[0])
+		if ip == nil {
+			base.Fatalf("invalid IP: %s", unnamed[0])
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		resp, err := client.ReverseFakeIP(ctx, &dnsService.ReverseFakeIPRequest{Ip: ip})
+		if err != nil {
+			base.Fatalf("failed to reverse fake IP: %s", err)
+		}
+		if apiJSON {
+			showJSONResponse(resp)
+			return
+		}
+		if resp.Domain == "" {
+			base.Fatalf("%s is not a fake IP in use", unnamed[0])
+		}
+		fmt.Fprintln(os.Stdout, resp.Domain)
+	default:
+		base.Fatalf("unknown action: %s", action)
+	}
+}
+
+func showDNSCache(resp *dnsService.ListCacheResponse) {
+	const tableIndent = 0
+	sb := new(strings.Builder)
+	titles := []string{"Domain", "Type", "Server", "RCode", "TTL", "IP"}
+	formats := []string{"%-32s ", "%-6s ", "%-24s ", "%-10s ", "%-8s ", "%s"}
+	writeRow(sb, tableIndent, 0, titles, formats)
+	now := time.Now()
+	for i, e := range resp.Entry {
+		ttl := time.Unix(e.Expire, 0).Sub(now).Truncate(time.Second)
+		writeRow(sb, tableIndent, i+1, []string{
+			e.Domain, e.Type, e.Server, e.Rcode, ttl.String(), strings.Join(bytesToIPStrings(e.Ip), ", "),
+		}, formats)
+	}
+	fmt.Fprint(os.Stdout, sb.String())
+}
+
+func showFakeDNSMappings(resp *dnsService.ListFakeDNSMappingsResponse) {
+	const tableIndent = 0
+	sb := new(strings.Builder)
+	formats := []string{"%-40s ", "%s"}
+	writeRow(sb, tableIndent, 0, []string{"IP", "Domain"}, formats)
+	for i, m := range resp.Mapping {
+		writeRow(sb, tableIndent, i+1, []string{net.IP(m.Ip).String(), m.Domain}, formats)
+	}
+	fmt.Fprint(os.Stdout, sb.String())
+}
+
+func bytesToIPStrings(ips [][]byte) []string {
+	strs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		strs = append(strs, net.IP(ip).String())
+	}
+	return strs
+}
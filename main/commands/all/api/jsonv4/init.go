@@ -7,5 +7,8 @@ func init() {
 		cmdAddInbounds,
 		cmdAddOutbounds,
 		cmdRemoveInbounds,
-		cmdRemoveOutbounds)
+		cmdRemoveOutbounds,
+		cmdAddRules,
+		cmdRemoveRules,
+		cmdReplaceRules)
 }
@@ -0,0 +1,94 @@
+package jsonv4
+
+import (
+	"fmt"
+
+	routerService "github.com/v2fly/v2ray-core/v4/app/router/command"
+	"github.com/v2fly/v2ray-core/v4/main/commands/all/api"
+	"github.com/v2fly/v2ray-core/v4/main/commands/base"
+	"github.com/v2fly/v2ray-core/v4/main/commands/helpers"
+)
+
+var cmdAddRules = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} api adr [--server=127.0.0.1:8080] [c1.json] [dir1]...",
+	Short:       "add routing rules",
+	Long: `
+Add routing rules to V2Ray. Rules are taken from "routing.rules" 
+of the input configs, and the balancers they refer to must 
+already exist.
+
+> Make sure you have "RoutingService" set in "config.api.services" 
+of server config.
+
+Arguments:
+
+	-format <format>
+		The input format.
+		Available values: "auto", "json", "toml", "yaml"
+		Default: "auto"
+
+	-r
+		Load folders recursively.
+
+	-prepend
+		Insert the rules before existing rules, instead of 
+		appending them.
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout seconds to call API. Default 3
+
+Example:
+
+    {{.Exec}} {{.LongName}} dir
+    {{.Exec}} {{.LongName}} -prepend c1.json c2.yaml
+`,
+	Run: executeAddRules,
+}
+
+func executeAddRules(cmd *base.Command, args []string) {
+	api.SetSharedFlags(cmd)
+	api.SetSharedConfigFlags(cmd)
+	prepend := cmd.Flag.Bool("prepend", false, "")
+	cmd.Flag.Parse(args)
+	c, err := helpers.LoadConfig(cmd.Flag.Args(), api.APIConfigFormat, api.APIConfigRecursively)
+	if err != nil {
+		base.Fatalf("failed to load: %s", err)
+	}
+	if c.RouterConfig == nil {
+		base.Fatalf("no valid routing rule found")
+	}
+	config, err := c.RouterConfig.Build()
+	if err != nil {
+		base.Fatalf("failed to build conf: %s", err)
+	}
+	if len(config.Rule) == 0 {
+		base.Fatalf("no valid routing rule found")
+	}
+
+	conn, ctx, close := api.DialAPIServer()
+	defer close()
+
+	client := routerService.NewRoutingServiceClient(conn)
+	rules := config.Rule
+	if *prepend {
+		// prepend in reverse order, to keep the order of rules in configs
+		for i, j := 0, len(rules)-1; i < j; i, j = i+1, j-1 {
+			rules[i], rules[j] = rules[j], rules[i]
+		}
+	}
+	for _, rule := range rules {
+		fmt.Println("adding:", rule.RuleTag)
+		r := &routerService.AddRuleRequest{
+			Rule:    rule,
+			Prepend: *prepend,
+		}
+		_, err = client.AddRule(ctx, r)
+		if err != nil {
+			base.Fatalf("failed to add rule: %s", err)
+		}
+	}
+}
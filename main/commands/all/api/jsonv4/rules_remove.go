@@ -0,0 +1,94 @@
+package jsonv4
+
+import (
+	"fmt"
+
+	routerService "github.com/v2fly/v2ray-core/v4/app/router/command"
+	"github.com/v2fly/v2ray-core/v4/main/commands/all/api"
+	"github.com/v2fly/v2ray-core/v4/main/commands/base"
+	"github.com/v2fly/v2ray-core/v4/main/commands/helpers"
+)
+
+var cmdRemoveRules = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} api rmr [--server=127.0.0.1:8080] [c1.json] [dir1]...",
+	Short:       "remove routing rules",
+	Long: `
+Remove routing rules from V2Ray by their rule tags.
+
+> Make sure you have "RoutingService" set in "config.api.services" 
+of server config.
+
+Arguments:
+
+	-format <format>
+		The input format.
+		Available values: "auto", "json", "toml", "yaml"
+		Default: "auto"
+
+	-r
+		Load folders recursively.
+
+	-tags
+		The input are rule tags instead of config files
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout seconds to call API. Default 3
+
+Example:
+
+    {{.Exec}} {{.LongName}} dir
+    {{.Exec}} {{.LongName}} c1.json c2.yaml
+    {{.Exec}} {{.LongName}} -tags tag1 tag2
+`,
+	Run: executeRemoveRules,
+}
+
+func executeRemoveRules(cmd *base.Command, args []string) {
+	api.SetSharedFlags(cmd)
+	api.SetSharedConfigFlags(cmd)
+	isTags := cmd.Flag.Bool("tags", false, "")
+	cmd.Flag.Parse(args)
+
+	var tags []string
+	if *isTags {
+		tags = cmd.Flag.Args()
+	} else {
+		c, err := helpers.LoadConfig(cmd.Flag.Args(), api.APIConfigFormat, api.APIConfigRecursively)
+		if err != nil {
+			base.Fatalf("failed to load: %s", err)
+		}
+		if c.RouterConfig != nil {
+			config, err := c.RouterConfig.Build()
+			if err != nil {
+				base.Fatalf("failed to build conf: %s", err)
+			}
+			for _, rule := range config.Rule {
+				if rule.RuleTag != "" {
+					tags = append(tags, rule.RuleTag)
+				}
+			}
+		}
+	}
+	if len(tags) == 0 {
+		base.Fatalf("no rule to remove")
+	}
+
+	conn, ctx, close := api.DialAPIServer()
+	defer close()
+
+	client := routerService.NewRoutingServiceClient(conn)
+	for _, tag := range tags {
+		fmt.Println("removing:", tag)
+		r := &routerService.RemoveRuleRequest{
+			RuleTag: tag,
+		}
+		_, err := client.RemoveRule(ctx, r)
+		if err != nil {
+			base.Fatalf("failed to remove rule: %s", err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package jsonv4
+
+import (
+	routerService "github.com/v2fly/v2ray-core/v4/app/router/command"
+	"github.com/v2fly/v2ray-core/v4/main/commands/all/api"
+	"github.com/v2fly/v2ray-core/v4/main/commands/base"
+	"github.com/v2fly/v2ray-core/v4/main/commands/helpers"
+)
+
+var cmdReplaceRules = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} api rpr [--server=127.0.0.1:8080] [c1.json] [dir1]...",
+	Short:       "replace routing rules",
+	Long: `
+Replace all routing rules, balancers and rule sets of V2Ray with 
+the "routing.rules", "routing.balancers" and "routing.ruleSets" of 
+the input configs, in one step.
+
+> Make sure you have "RoutingService" set in "config.api.services" 
+of server config.
+
+Arguments:
+
+	-format <format>
+		The input format.
+		Available values: "auto", "json", "toml", "yaml"
+		Default: "auto"
+
+	-r
+		Load folders recursively.
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout seconds to call API. Default 3
+
+Example:
+
+    {{.Exec}} {{.LongName}} dir
+    {{.Exec}} {{.LongName}} c1.json c2.yaml
+`,
+	Run: executeReplaceRules,
+}
+
+func executeReplaceRules(cmd *base.Command, args []string) {
+	api.SetSharedFlags(cmd)
+	api.SetSharedConfigFlags(cmd)
+	cmd.Flag.Parse(args)
+	c, err := helpers.LoadConfig(cmd.Flag.Args(), api.APIConfigFormat, api.APIConfigRecursively)
+	if err != nil {
+		base.Fatalf("failed to load: %s", err)
+	}
+	if c.RouterConfig == nil {
+		base.Fatalf("no routing config found")
+	}
+	config, err := c.RouterConfig.Build()
+	if err != nil {
+		base.Fatalf("failed to build conf: %s", err)
+	}
+
+	conn, ctx, close := api.DialAPIServer()
+	defer close()
+
+	client := routerService.NewRoutingServiceClient(conn)
+	r := &routerService.ReplaceRulesRequest{
+		Rule:          config.Rule,
+		BalancingRule: config.BalancingRule,
+		RuleSet:       config.RuleSet,
+	}
+	_, err = client.ReplaceRules(ctx, r)
+	if err != nil {
+		base.Fatalf("failed to replace rules: %s", err)
+	}
+}
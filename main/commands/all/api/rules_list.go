@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	routerService "github.com/v2fly/v2ray-core/v4/app/router/command"
+	"github.com/v2fly/v2ray-core/v4/main/commands/base"
+)
+
+var cmdListRules = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} api lsr [--server=127.0.0.1:8080]",
+	Short:       "list routing rules",
+	Long: `
+List routing rules, balancers and rule sets currently used by V2Ray.
+
+> Make sure you have "RoutingService" set in "config.api.services" 
+of server config.
+
+Arguments:
+
+	-json
+		Use json output.
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout seconds to call API. Default 3
+
+Example:
+
+    {{.Exec}} {{.LongName}} --server=127.0.0.1:8080
+`,
+	Run: executeListRules,
+}
+
+func executeListRules(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	cmd.Flag.Parse(args)
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := routerService.NewRoutingServiceClient(conn)
+	resp, err := client.ListRules(ctx, &routerService.ListRulesRequest{})
+	if err != nil {
+		base.Fatalf("failed to list rules: %s", err)
+	}
+
+	if apiJSON {
+		showJSONResponse(resp)
+		return
+	}
+
+	showRules(resp)
+}
+
+func showRules(resp *routerService.ListRulesResponse) {
+	const tableIndent = 4
+	sb := new(strings.Builder)
+	sb.WriteString("  - Rules:\n")
+	titles := []string{"Rule Tag", "Outbound", "Balancer"}
+	formats := []string{"%-24s ", "%-24s ", "%s"}
+	writeRow(sb, tableIndent, 0, titles, formats)
+	for i, r := range resp.Rule {
+		writeRow(sb, tableIndent, i+1, []string{r.RuleTag, r.GetTag(), r.GetBalancingTag()}, formats)
+	}
+	sb.WriteString("  - Balancers:\n")
+	for i, b := range resp.BalancingRule {
+		writeRow(sb, tableIndent, i+1, []string{b.Tag, b.Strategy, strings.Join(b.OutboundSelector, ",")}, formats)
+	}
+	sb.WriteString("  - Rule Sets:\n")
+	for i, s := range resp.RuleSet {
+		writeRow(sb, tableIndent, i+1, []string{s.Tag, s.Format.String(), s.Path}, formats)
+	}
+	fmt.Fprint(os.Stdout, sb.String())
+}
@@ -9,6 +9,7 @@ import (
 
 	statsService "github.com/v2fly/v2ray-core/v4/app/stats/command"
 	"github.com/v2fly/v2ray-core/v4/common/units"
+	feature_stats "github.com/v2fly/v2ray-core/v4/features/stats"
 	"github.com/v2fly/v2ray-core/v4/main/commands/base"
 )
 
@@ -33,6 +34,26 @@ Arguments:
 	-runtime
 		Get runtime statistics.
 
+	-gauge
+		Query gauges instead of counters.
+
+	-histogram
+		Query histograms instead of counters, showing the count, the
+		average and the estimated 50th, 90th and 99th percentiles.
+
+	-history
+		Query the history of counters instead of their values, showing
+		the rates per second of the last bucket, and the average and
+		peak ones, and the total, over the history.
+
+	-interval <seconds>
+		Length of the buckets of the history. Default to the finest
+		one kept.
+
+	-online
+		List the online users and their source IPs. The patterns, if
+		any, are the emails of the users to list.
+
 	-json
 		Use json output.
 
@@ -45,6 +66,9 @@ Arguments:
 Example:
 
 	{{.Exec}} {{.LongName}} -runtime
+	{{.Exec}} {{.LongName}} -online love@v2fly.org
+	{{.Exec}} {{.LongName}} -histogram 'latency>>>dial'
+	{{.Exec}} {{.LongName}} -history -interval 60 'user>>>'
 	{{.Exec}} {{.LongName}} node1
 	{{.Exec}} {{.LongName}} -json node1 node2
 	{{.Exec}} {{.LongName}} -regexp 'node1.+downlink'
@@ -55,11 +79,21 @@ Example:
 func executeStats(cmd *base.Command, args []string) {
 	setSharedFlags(cmd)
 	var (
-		runtime bool
-		regexp  bool
-		reset   bool
+		runtime   bool
+		online    bool
+		gauge     bool
+		histogram bool
+		history   bool
+		interval  uint
+		regexp    bool
+		reset     bool
 	)
 	cmd.Flag.BoolVar(&runtime, "runtime", false, "")
+	cmd.Flag.BoolVar(&online, "online", false, "")
+	cmd.Flag.BoolVar(&gauge, "gauge", false, "")
+	cmd.Flag.BoolVar(&histogram, "histogram", false, "")
+	cmd.Flag.BoolVar(&history, "history", false, "")
+	cmd.Flag.UintVar(&interval, "interval", 0, "")
 	cmd.Flag.BoolVar(&regexp, "regexp", false, "")
 	cmd.Flag.BoolVar(&reset, "reset", false, "")
 	cmd.Flag.Parse(args)
@@ -68,6 +102,22 @@ func executeStats(cmd *base.Command, args []string) {
 		getRuntimeStats(apiJSON)
 		return
 	}
+	if online {
+		getOnlineUsers(unnamed, apiJSON)
+		return
+	}
+	if gauge {
+		getGauges(unnamed, regexp, apiJSON)
+		return
+	}
+	if histogram {
+		getHistograms(unnamed, regexp, reset, apiJSON)
+		return
+	}
+	if history {
+		getStatsHistory(unnamed, regexp, uint32(interval), apiJSON)
+		return
+	}
 	getStats(unnamed, regexp, reset, apiJSON)
 }
 
@@ -113,6 +163,49 @@ func showRuntimeStats(s *statsService.SysStatsResponse) {
 	os.Stdout.WriteString(sb.String())
 }
 
+func getOnlineUsers(emails []string, jsonOutput bool) {
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := statsService.NewStatsServiceClient(conn)
+	if len(emails) == 0 {
+		emails = []string{""}
+	}
+	resp := &statsService.GetOnlineUsersResponse{}
+	for _, email := range emails {
+		r, err := client.GetOnlineUsers(ctx, &statsService.GetOnlineUsersRequest{Email: email})
+		if err != nil {
+			base.Fatalf("failed to get online users: %s", err)
+		}
+		resp.User = append(resp.User, r.User...)
+	}
+	if jsonOutput {
+		showJSONResponse(resp)
+		return
+	}
+	showOnlineUsers(resp.User)
+}
+
+func showOnlineUsers(users []*statsService.OnlineUser) {
+	formats := []string{"%-30s ", "%-40s ", "%-12s ", "%s"}
+	sb := new(strings.Builder)
+	writeRow(sb, 0, 0,
+		[]string{"User", "IP", "Connections", "Online"},
+		formats,
+	)
+	idx := 0
+	for _, user := range users {
+		for _, ip := range user.Ip {
+			idx++
+			writeRow(sb, 0, idx, []string{
+				user.Email, ip.Ip, fmt.Sprintf("%d", ip.Connections),
+				time.Since(time.Unix(ip.Since, 0)).Truncate(time.Second).String(),
+			}, formats)
+		}
+	}
+	os.Stdout.WriteString(sb.String())
+}
+
 func getStats(patterns []string, regexp, reset, jsonOutput bool) {
 	conn, ctx, close := dialAPIServer()
 	defer close()
@@ -137,6 +230,149 @@ func getStats(patterns []string, regexp, reset, jsonOutput bool) {
 	showStats(resp.Stat)
 }
 
+func getGauges(patterns []string, regexp, jsonOutput bool) {
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := statsService.NewStatsServiceClient(conn)
+	r := &statsService.QueryStatsRequest{
+		Patterns: patterns,
+		Regexp:   regexp,
+	}
+	resp, err := client.QueryGauges(ctx, r)
+	if err != nil {
+		base.Fatalf("failed to query gauges: %s", err)
+	}
+	if jsonOutput {
+		showJSONResponse(resp)
+		return
+	}
+	sort.Slice(resp.Stat, func(i, j int) bool {
+		return resp.Stat[i].Name < resp.Stat[j].Name
+	})
+	formats := []string{"%-12s", "%s"}
+	sb := new(strings.Builder)
+	writeRow(sb, 0, 0, []string{"Value", "Name"}, formats)
+	for i, stat := range resp.Stat {
+		writeRow(sb, 0, i+1, []string{fmt.Sprintf("%d", stat.Value), stat.Name}, formats)
+	}
+	os.Stdout.WriteString(sb.String())
+}
+
+func getHistograms(patterns []string, regexp, reset, jsonOutput bool) {
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := statsService.NewStatsServiceClient(conn)
+	r := &statsService.QueryStatsRequest{
+		Patterns: patterns,
+		Regexp:   regexp,
+		Reset_:   reset,
+	}
+	resp, err := client.QueryHistograms(ctx, r)
+	if err != nil {
+		base.Fatalf("failed to query histograms: %s", err)
+	}
+	if jsonOutput {
+		showJSONResponse(resp)
+		return
+	}
+	sort.Slice(resp.Histogram, func(i, j int) bool {
+		return resp.Histogram[i].Name < resp.Histogram[j].Name
+	})
+	showHistograms(resp.Histogram)
+}
+
+func showHistograms(histograms []*statsService.Histogram) {
+	formats := []string{"%-10s", "%-10s", "%-10s", "%-10s", "%-10s", "%s"}
+	sb := new(strings.Builder)
+	writeRow(sb, 0, 0,
+		[]string{"Count", "Avg", "P50", "P90", "P99", "Name"},
+		formats,
+	)
+	for i, h := range histograms {
+		snapshot := &feature_stats.HistogramSnapshot{Count: h.Count, Sum: h.Sum}
+		for _, b := range h.Bucket {
+			snapshot.Buckets = append(snapshot.Buckets, b.UpperBound)
+			snapshot.Counts = append(snapshot.Counts, b.Count)
+		}
+		avg := 0.0
+		if h.Count > 0 {
+			avg = h.Sum / float64(h.Count)
+		}
+		writeRow(sb, 0, i+1, []string{
+			fmt.Sprintf("%d", h.Count),
+			fmt.Sprintf("%.4g", avg),
+			fmt.Sprintf("%.4g", snapshot.Quantile(0.5)),
+			fmt.Sprintf("%.4g", snapshot.Quantile(0.9)),
+			fmt.Sprintf("%.4g", snapshot.Quantile(0.99)),
+			h.Name,
+		}, formats)
+	}
+	os.Stdout.WriteString(sb.String())
+}
+
+func getStatsHistory(patterns []string, regexp bool, interval uint32, jsonOutput bool) {
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := statsService.NewStatsServiceClient(conn)
+	r := &statsService.QueryStatsHistoryRequest{
+		Patterns: patterns,
+		Regexp:   regexp,
+		Interval: interval,
+	}
+	resp, err := client.QueryStatsHistory(ctx, r)
+	if err != nil {
+		base.Fatalf("failed to query stats history: %s", err)
+	}
+	if jsonOutput {
+		showJSONResponse(resp)
+		return
+	}
+	sort.Slice(resp.History, func(i, j int) bool {
+		return resp.History[i].Name < resp.History[j].Name
+	})
+	showStatsHistory(resp.History)
+}
+
+func showStatsHistory(histories []*statsService.StatsHistory) {
+	formats := []string{"%-12s", "%-12s", "%-12s", "%-12s", "%-10s", "%s"}
+	sb := new(strings.Builder)
+	writeRow(sb, 0, 0,
+		[]string{"Last/s", "Avg/s", "Peak/s", "Total", "Window", "Name"},
+		formats,
+	)
+	for i, h := range histories {
+		if len(h.Value) == 0 || h.Interval == 0 {
+			continue
+		}
+		var total, peak int64
+		for _, v := range h.Value {
+			total += v
+			if v > peak {
+				peak = v
+			}
+		}
+		// the last bucket is in progress
+		last := h.Value[len(h.Value)-1]
+		if len(h.Value) > 1 {
+			last = h.Value[len(h.Value)-2]
+		}
+		interval := int64(h.Interval)
+		window := time.Duration(int64(len(h.Value))*interval) * time.Second
+		writeRow(sb, 0, i+1, []string{
+			units.ByteSize(last / interval).String(),
+			units.ByteSize(total / (int64(len(h.Value)) * interval)).String(),
+			units.ByteSize(peak / interval).String(),
+			units.ByteSize(total).String(),
+			window.String(),
+			h.Name,
+		}, formats)
+	}
+	os.Stdout.WriteString(sb.String())
+}
+
 func showStats(stats []*statsService.Stat) {
 	if len(stats) == 0 {
 		return
@@ -22,6 +22,7 @@ import (
 	_ "github.com/v2fly/v2ray-core/v4/app/dns"
 	_ "github.com/v2fly/v2ray-core/v4/app/dns/fakedns"
 	_ "github.com/v2fly/v2ray-core/v4/app/log"
+	_ "github.com/v2fly/v2ray-core/v4/app/persistentstorage/filesystemstorage"
 	_ "github.com/v2fly/v2ray-core/v4/app/policy"
 	_ "github.com/v2fly/v2ray-core/v4/app/reverse"
 	_ "github.com/v2fly/v2ray-core/v4/app/router"
@@ -71,6 +72,7 @@ import (
 
 	// Geo loaders
 	_ "github.com/v2fly/v2ray-core/v4/infra/conf/geodata/memconservative"
+	_ "github.com/v2fly/v2ray-core/v4/infra/conf/geodata/mmdb"
 	_ "github.com/v2fly/v2ray-core/v4/infra/conf/geodata/standard"
 
 	// JSON, TOML, YAML config support. (jsonv4) This disable selective compile
@@ -49,6 +49,8 @@ type Handler struct {
 	client          dns.Client
 	ipv4Lookup      dns.IPv4Lookup
 	ipv6Lookup      dns.IPv6Lookup
+	contextLookup   dns.ContextLookup
+	recordLookup    dns.RecordLookup
 	ownLinkVerifier ownLinkVerifier
 	server          net.Destination
 	timeout         time.Duration
@@ -70,6 +72,14 @@ func (h *Handler) Init(config *Config, dnsClient dns.Client, policyManager polic
 		return newError("dns.Client doesn't implement IPv6Lookup")
 	}
 
+	if contextLookup, ok := dnsClient.(dns.ContextLookup); ok {
+		h.contextLookup = contextLookup
+	}
+
+	if recordLookup, ok := dnsClient.(dns.RecordLookup); ok {
+		h.recordLookup = recordLookup
+	}
+
 	if v, ok := dnsClient.(ownLinkVerifier); ok {
 		h.ownLinkVerifier = v
 	}
@@ -169,6 +179,15 @@ func (h *Handler) Process(ctx context.Context, link *transport.Link, d internet.
 		}
 	}
 
+	// Queries are forwarded from the request loop as well as from record
+	// lookups that fall back to the upstream server.
+	var connAccess sync.Mutex
+	forward := func(b *buf.Buffer) error {
+		connAccess.Lock()
+		defer connAccess.Unlock()
+		return connWriter.WriteMessage(b)
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 	timer := signal.CancelAfterInactivity(ctx, cancel, h.timeout)
 
@@ -190,12 +209,18 @@ func (h *Handler) Process(ctx context.Context, link *transport.Link, d internet.
 			if !h.isOwnLink(ctx) {
 				isIPQuery, domain, id, qType := parseIPQuery(b.Bytes())
 				if isIPQuery {
-					go h.handleIPQuery(id, qType, domain, writer)
+					go h.handleIPQuery(ctx, id, qType, domain, writer)
 					continue
 				}
+				if h.recordLookup != nil {
+					if query, ok := parseRecordQuery(b.Bytes()); ok {
+						go h.handleRecordQuery(query, b, writer, forward)
+						continue
+					}
+				}
 			}
 
-			if err := connWriter.WriteMessage(b); err != nil {
+			if err := forward(b); err != nil {
 				return err
 			}
 		}
@@ -227,24 +252,33 @@ func (h *Handler) Process(ctx context.Context, link *transport.Link, d internet.
 	return nil
 }
 
-func (h *Handler) handleIPQuery(id uint16, qType dnsmessage.Type, domain string, writer dns_proto.MessageWriter) {
+func (h *Handler) handleIPQuery(ctx context.Context, id uint16, qType dnsmessage.Type, domain string, writer dns_proto.MessageWriter) {
 	var ips []net.IP
 	var err error
 
 	var ttl uint32 = 600
 
-	// Do NOT skip FakeDNS
-	if c, ok := h.client.(dns.ClientWithIPOption); ok {
-		c.SetFakeDNSOption(true)
+	if h.contextLookup != nil {
+		// Do NOT skip FakeDNS
+		ips, err = h.contextLookup.LookupIPWithContext(ctx, domain, dns.IPOption{
+			IPv4Enable: qType == dnsmessage.TypeA,
+			IPv6Enable: qType == dnsmessage.TypeAAAA,
+			FakeEnable: true,
+		})
 	} else {
-		newError("dns.Client doesn't implement ClientWithIPOption")
-	}
+		// Do NOT skip FakeDNS
+		if c, ok := h.client.(dns.ClientWithIPOption); ok {
+			c.SetFakeDNSOption(true)
+		} else {
+			newError("dns.Client doesn't implement ClientWithIPOption")
+		}
 
-	switch qType {
-	case dnsmessage.TypeA:
-		ips, err = h.ipv4Lookup.LookupIPv4(domain)
-	case dnsmessage.TypeAAAA:
-		ips, err = h.ipv6Lookup.LookupIPv6(domain)
+		switch qType {
+		case dnsmessage.TypeA:
+			ips, err = h.ipv4Lookup.LookupIPv4(domain)
+		case dnsmessage.TypeAAAA:
+			ips, err = h.ipv6Lookup.LookupIPv6(domain)
+		}
 	}
 
 	rcode := dns.RCodeFromError(err)
@@ -253,11 +287,91 @@ func (h *Handler) handleIPQuery(id uint16, qType dnsmessage.Type, domain string,
 		return
 	}
 
+	b, err := buildIPResponse(id, qType, domain, dnsmessage.RCode(rcode), ips, ttl)
+	if err != nil {
+		newError("pack message").Base(err).WriteToLog()
+		return
+	}
+
+	if err := writer.WriteMessage(b); err != nil {
+		newError("write IP answer").Base(err).WriteToLog()
+	}
+}
+
+// parseRecordQuery parses a query with a single question for records other than
+// A and AAAA, which can be answered by dns.RecordLookup.
+func parseRecordQuery(b []byte) (*dnsmessage.Message, bool) {
+	query := new(dnsmessage.Message)
+	if err := query.Unpack(b); err != nil {
+		return nil, false
+	}
+	if query.Header.Response || len(query.Questions) != 1 {
+		return nil, false
+	}
+	q := query.Questions[0]
+	if q.Class != dnsmessage.ClassINET {
+		return nil, false
+	}
+	switch q.Type {
+	case dnsmessage.TypeA, dnsmessage.TypeAAAA, dnsmessage.TypeALL, dnsmessage.TypeAXFR, dnsmessage.TypeOPT:
+		return nil, false
+	}
+	return query, true
+}
+
+// handleRecordQuery answers the query in b with the records looked up by the
+// DNS client. If the lookup fails without an answer from any name server, for
+// example because none of them supports the record type, the query is
+// forwarded to the upstream server instead.
+func (h *Handler) handleRecordQuery(query *dnsmessage.Message, b *buf.Buffer, writer dns_proto.MessageWriter, forward func(*buf.Buffer) error) {
+	q := query.Questions[0]
+	// Do NOT skip FakeDNS
+	records, err := h.recordLookup.LookupRecords(q.Name.String(), q.Type, true)
+
+	rcode := dns.RCodeFromError(err)
+	if rcode == 0 && len(records) == 0 && err != dns.ErrEmptyResponse {
+		newError("failed to lookup ", q.Type, " records for ", q.Name, ", forwarding the query").Base(err).AtDebug().WriteToLog()
+		if err := forward(b); err != nil {
+			newError("forward record query").Base(err).WriteToLog()
+		}
+		return
+	}
+	b.Release()
+
+	resp, err := buildRecordResponse(query, dnsmessage.RCode(rcode), records)
+	if err != nil {
+		newError("pack message").Base(err).WriteToLog()
+		return
+	}
+
+	if err := writer.WriteMessage(resp); err != nil {
+		newError("write record answer").Base(err).WriteToLog()
+	}
+}
+
+// buildRecordResponse builds the response of the query with the answers.
+func buildRecordResponse(query *dnsmessage.Message, rcode dnsmessage.RCode, answers []dnsmessage.Resource) (*buf.Buffer, error) {
+	return dns_proto.PackMessage(&dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 query.Header.ID,
+			Response:           true,
+			OpCode:             query.Header.OpCode,
+			RecursionDesired:   query.Header.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              rcode,
+		},
+		Questions: query.Questions,
+		Answers:   answers,
+	})
+}
+
+// buildIPResponse builds the response of an A or AAAA query.
+func buildIPResponse(id uint16, qType dnsmessage.Type, domain string, rcode dnsmessage.RCode, ips []net.IP, ttl uint32) (*buf.Buffer, error) {
 	b := buf.New()
 	rawBytes := b.Extend(buf.Size)
 	builder := dnsmessage.NewBuilder(rawBytes[:0], dnsmessage.Header{
 		ID:                 id,
-		RCode:              dnsmessage.RCode(rcode),
+		RCode:              rcode,
 		RecursionAvailable: true,
 		RecursionDesired:   true,
 		Response:           true,
@@ -285,15 +399,11 @@ func (h *Handler) handleIPQuery(id uint16, qType dnsmessage.Type, domain string,
 	}
 	msgBytes, err := builder.Finish()
 	if err != nil {
-		newError("pack message").Base(err).WriteToLog()
 		b.Release()
-		return
+		return nil, err
 	}
 	b.Resize(0, int32(len(msgBytes)))
-
-	if err := writer.WriteMessage(b); err != nil {
-		newError("write IP answer").Base(err).WriteToLog()
-	}
+	return b, nil
 }
 
 type outboundConn struct {
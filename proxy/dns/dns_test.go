@@ -68,6 +68,16 @@ func (*staticHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			common.Must(err)
 			ans.Answer = append(ans.Answer, rr)
 
+		case q.Name == "google.com." && q.Qtype == dns.TypeTXT:
+			rr, err := dns.NewRR(`google.com. IN TXT "v=spf1 -all"`)
+			common.Must(err)
+			ans.Answer = append(ans.Answer, rr)
+
+		case q.Name == "google.com." && q.Qtype == dns.TypeHTTPS:
+			rr, err := dns.NewRR(`google.com. IN HTTPS 1 . alpn="h2,h3"`)
+			common.Must(err)
+			ans.Answer = append(ans.Answer, rr)
+
 		case q.Name == "notexist.google.com." && q.Qtype == dns.TypeAAAA:
 			ans.MsgHdr.Rcode = dns.RcodeNameError
 		}
@@ -193,6 +203,75 @@ func TestUDPDNSTunnel(t *testing.T) {
 	}
 }
 
+func TestUDPDNSTunnelForwardsUnsupportedRecords(t *testing.T) {
+	port := udp.PickPort()
+
+	dnsServer := dns.Server{
+		Addr:    "127.0.0.1:" + port.String(),
+		Net:     "udp",
+		Handler: &staticHandler{},
+		UDPSize: 1200,
+	}
+	defer dnsServer.Shutdown()
+
+	go dnsServer.ListenAndServe()
+	time.Sleep(time.Second)
+
+	serverPort := udp.PickPort()
+	config := &core.Config{
+		App: []*anypb.Any{
+			serial.ToTypedMessage(&dnsapp.Config{
+				NameServer: []*dnsapp.NameServer{
+					{Address: &net.Endpoint{Network: net.Network_UDP, Address: net.NewIPOrDomain(net.DomainAddress("localhost"))}},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(&proxyman.InboundConfig{}),
+			serial.ToTypedMessage(&policy.Config{}),
+		},
+		Inbound: []*core.InboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&dokodemo.Config{
+					Address:  net.NewIPOrDomain(net.LocalHostIP),
+					Port:     uint32(port),
+					Networks: []net.Network{net.Network_UDP},
+				}),
+				ReceiverSettings: serial.ToTypedMessage(&proxyman.ReceiverConfig{
+					PortRange: net.SinglePortRange(serverPort),
+					Listen:    net.NewIPOrDomain(net.LocalHostIP),
+				}),
+			},
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&dns_proxy.Config{}),
+			},
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+	common.Must(v.Start())
+	defer v.Close()
+
+	// The local name server doesn't support HTTPS records, so the query goes
+	// to the upstream server.
+	m1 := new(dns.Msg)
+	m1.SetQuestion("google.com.", dns.TypeHTTPS)
+
+	c := &dns.Client{Timeout: 5 * time.Second}
+	in, _, err := c.Exchange(m1, "127.0.0.1:"+strconv.Itoa(int(serverPort)))
+	common.Must(err)
+
+	if len(in.Answer) != 1 {
+		t.Fatal("len(answer): ", len(in.Answer))
+	}
+	if _, ok := in.Answer[0].(*dns.HTTPS); !ok {
+		t.Error("not HTTPS record: ", in.Answer[0])
+	}
+}
+
 func TestTCPDNSTunnel(t *testing.T) {
 	port := udp.PickPort()
 
@@ -0,0 +1,401 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/buf"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	dns_proto "github.com/v2fly/v2ray-core/v4/common/protocol/dns"
+	"github.com/v2fly/v2ray-core/v4/common/session"
+	"github.com/v2fly/v2ray-core/v4/common/signal"
+	"github.com/v2fly/v2ray-core/v4/common/task"
+	"github.com/v2fly/v2ray-core/v4/features/dns"
+	"github.com/v2fly/v2ray-core/v4/features/policy"
+	"github.com/v2fly/v2ray-core/v4/features/routing"
+	"github.com/v2fly/v2ray-core/v4/transport/internet"
+)
+
+const (
+	defaultDoHPath  = "/dns-query"
+	dohMediaType    = "application/dns-message"
+	forwardTimeout  = time.Second * 5
+	maxDoHQuerySize = 65535
+	// maxDoHHeaderSize limits the size of the request line and headers of
+	// each DoH request.
+	maxDoHHeaderSize = 16 * 1024
+	answerTTL        = 600
+)
+
+func init() {
+	common.Must(common.RegisterConfig((*ServerConfig)(nil), func(ctx context.Context, config interface{}) (interface{}, error) {
+		s := new(Server)
+		if err := core.RequireFeatures(ctx, func(dnsClient dns.Client, policyManager policy.Manager) error {
+			return s.Init(config.(*ServerConfig), dnsClient, policyManager)
+		}); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}))
+
+	common.Must(common.RegisterConfig((*SimplifiedServerConfig)(nil), func(ctx context.Context, config interface{}) (interface{}, error) {
+		simplifiedServer := config.(*SimplifiedServerConfig)
+		fullConfig := &ServerConfig{
+			Server:  simplifiedServer.Server,
+			Doh:     simplifiedServer.Doh,
+			DohPath: simplifiedServer.DohPath,
+		}
+		return common.CreateObject(ctx, fullConfig)
+	}))
+}
+
+// Server is an inbound handler serving DNS queries with the internal DNS client, so that hosts,
+// FakeDNS and per-domain name servers are available to other devices. A and AAAA queries, and
+// other record queries if the DNS client supports them, are answered by the DNS client. The
+// remaining queries are forwarded to the upstream server.
+type Server struct {
+	config        *ServerConfig
+	client        dns.Client
+	ipv4Lookup    dns.IPv4Lookup
+	ipv6Lookup    dns.IPv6Lookup
+	contextLookup dns.ContextLookup
+	recordLookup  dns.RecordLookup
+	policyManager policy.Manager
+	server        net.Destination
+}
+
+// Init initializes the Server instance with necessary parameters.
+func (s *Server) Init(config *ServerConfig, dnsClient dns.Client, policyManager policy.Manager) error {
+	s.config = config
+	s.client = dnsClient
+	s.policyManager = policyManager
+
+	if ipv4lookup, ok := dnsClient.(dns.IPv4Lookup); ok {
+		s.ipv4Lookup = ipv4lookup
+	} else {
+		return newError("dns.Client doesn't implement IPv4Lookup")
+	}
+
+	if ipv6lookup, ok := dnsClient.(dns.IPv6Lookup); ok {
+		s.ipv6Lookup = ipv6lookup
+	} else {
+		return newError("dns.Client doesn't implement IPv6Lookup")
+	}
+
+	if contextLookup, ok := dnsClient.(dns.ContextLookup); ok {
+		s.contextLookup = contextLookup
+	}
+
+	if recordLookup, ok := dnsClient.(dns.RecordLookup); ok {
+		s.recordLookup = recordLookup
+	}
+
+	if config.Server != nil {
+		s.server = config.Server.AsDestination()
+		if s.server.Network == net.Network_Unknown {
+			s.server.Network = net.Network_UDP
+		}
+		if s.server.Port == 0 {
+			s.server.Port = 53
+		}
+	}
+	return nil
+}
+
+// Network implements proxy.Inbound.
+func (s *Server) Network() []net.Network {
+	return []net.Network{net.Network_UDP, net.Network_TCP}
+}
+
+// Process implements proxy.Inbound.
+func (s *Server) Process(ctx context.Context, network net.Network, conn internet.Connection, dispatcher routing.Dispatcher) error {
+	if network == net.Network_TCP && s.config.Doh {
+		return s.serveDoH(ctx, conn, dispatcher)
+	}
+
+	var reader dns_proto.MessageReader
+	var writer dns_proto.MessageWriter
+	if network == net.Network_TCP {
+		reader = dns_proto.NewTCPReader(buf.NewReader(conn))
+		writer = &dns_proto.TCPWriter{
+			Writer: buf.NewWriter(conn),
+		}
+	} else {
+		reader = &dns_proto.UDPReader{
+			Reader: buf.NewPacketReader(conn),
+		}
+		writer = &dns_proto.UDPWriter{
+			Writer: buf.NewWriter(conn),
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	timer := signal.CancelAfterInactivity(ctx, cancel, s.policyManager.ForLevel(s.config.UserLevel).Timeouts.ConnectionIdle)
+
+	var writeAccess sync.Mutex
+	serve := func() error {
+		for {
+			b, err := reader.ReadMessage()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			timer.Update()
+
+			go func() {
+				defer b.Release()
+				resp, err := s.answer(ctx, b.Bytes(), dispatcher)
+				if err != nil {
+					newError("failed to answer DNS query").Base(err).WriteToLog(session.ExportIDToError(ctx))
+					return
+				}
+				timer.Update()
+
+				writeAccess.Lock()
+				defer writeAccess.Unlock()
+				if err := writer.WriteMessage(resp); err != nil {
+					newError("failed to write DNS response").Base(err).WriteToLog(session.ExportIDToError(ctx))
+				}
+			}()
+		}
+	}
+
+	if err := task.Run(ctx, serve); err != nil {
+		return newError("connection ends").Base(err)
+	}
+	return nil
+}
+
+// answer returns the response of the DNS query.
+func (s *Server) answer(ctx context.Context, query []byte, dispatcher routing.Dispatcher) (*buf.Buffer, error) {
+	isIPQuery, domain, id, qType := parseIPQuery(query)
+	if !isIPQuery {
+		if s.recordLookup != nil {
+			if msg, ok := parseRecordQuery(query); ok {
+				return s.answerRecords(ctx, msg, query, dispatcher)
+			}
+		}
+		return s.forward(ctx, query, dispatcher)
+	}
+
+	var ips []net.IP
+	var err error
+	if s.contextLookup != nil {
+		// Do NOT skip FakeDNS
+		ips, err = s.contextLookup.LookupIPWithContext(ctx, domain, dns.IPOption{
+			IPv4Enable: qType == dnsmessage.TypeA,
+			IPv6Enable: qType == dnsmessage.TypeAAAA,
+			FakeEnable: true,
+		})
+	} else {
+		// Do NOT skip FakeDNS
+		if c, ok := s.client.(dns.ClientWithIPOption); ok {
+			c.SetFakeDNSOption(true)
+		}
+
+		switch qType {
+		case dnsmessage.TypeA:
+			ips, err = s.ipv4Lookup.LookupIPv4(domain)
+		case dnsmessage.TypeAAAA:
+			ips, err = s.ipv6Lookup.LookupIPv6(domain)
+		}
+	}
+
+	rcode := dnsmessage.RCode(dns.RCodeFromError(err))
+	if rcode == 0 && len(ips) == 0 && err != dns.ErrEmptyResponse {
+		newError("failed to lookup ", domain).Base(err).AtDebug().WriteToLog(session.ExportIDToError(ctx))
+		rcode = dnsmessage.RCodeServerFailure
+	}
+	return buildIPResponse(id, qType, domain, rcode, ips, answerTTL)
+}
+
+// answerRecords returns the response of a query for records other than A and
+// AAAA. Queries the DNS client fails to answer are forwarded to the upstream
+// server.
+func (s *Server) answerRecords(ctx context.Context, query *dnsmessage.Message, raw []byte, dispatcher routing.Dispatcher) (*buf.Buffer, error) {
+	q := query.Questions[0]
+	// Do NOT skip FakeDNS
+	records, err := s.recordLookup.LookupRecords(q.Name.String(), q.Type, true)
+
+	rcode := dnsmessage.RCode(dns.RCodeFromError(err))
+	if rcode == 0 && len(records) == 0 && err != dns.ErrEmptyResponse {
+		newError("failed to lookup ", q.Type, " records for ", q.Name, ", forwarding the query").Base(err).AtDebug().WriteToLog(session.ExportIDToError(ctx))
+		return s.forward(ctx, raw, dispatcher)
+	}
+	return buildRecordResponse(query, rcode, records)
+}
+
+// forward sends the DNS query to the upstream server and returns its response.
+func (s *Server) forward(ctx context.Context, query []byte, dispatcher routing.Dispatcher) (*buf.Buffer, error) {
+	if !s.server.IsValid() {
+		return buildErrorResponse(query, dnsmessage.RCodeRefused)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, forwardTimeout)
+	defer cancel()
+	ctx = session.ContextWithContent(ctx, &session.Content{
+		Protocol:       "dns",
+		SkipDNSResolve: true,
+	})
+
+	link, err := dispatcher.Dispatch(ctx, s.server)
+	if err != nil {
+		return nil, newError("failed to dispatch DNS query to ", s.server).Base(err)
+	}
+	defer common.Close(link.Writer)
+	defer common.Interrupt(link.Reader)
+
+	var reader dns_proto.MessageReader
+	var writer dns_proto.MessageWriter
+	if s.server.Network == net.Network_TCP {
+		reader = dns_proto.NewTCPReader(link.Reader)
+		writer = &dns_proto.TCPWriter{Writer: link.Writer}
+	} else {
+		reader = &dns_proto.UDPReader{Reader: link.Reader}
+		writer = &dns_proto.UDPWriter{Writer: link.Writer}
+	}
+
+	b := buf.New()
+	if _, err := b.Write(query); err != nil {
+		b.Release()
+		return nil, err
+	}
+	if err := writer.WriteMessage(b); err != nil {
+		return nil, newError("failed to forward DNS query").Base(err)
+	}
+
+	type result struct {
+		resp *buf.Buffer
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := reader.ReadMessage()
+		done <- result{resp, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, newError("failed to read DNS response from ", s.server).Base(r.err)
+		}
+		return r.resp, nil
+	case <-ctx.Done():
+		return buildErrorResponse(query, dnsmessage.RCodeServerFailure)
+	}
+}
+
+// buildErrorResponse builds a response to the query with the error code and no answers.
+func buildErrorResponse(query []byte, rcode dnsmessage.RCode) (*buf.Buffer, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil, newError("failed to parse DNS query").Base(err)
+	}
+	return buildRecordResponse(&msg, rcode, nil)
+}
+
+// serveDoH serves DNS over HTTPS (RFC 8484) requests on the connection. HTTP/1.1 is supported.
+// The first request must arrive within the handshake timeout of the user level, and the following
+// ones within the connection idle timeout.
+func (s *Server) serveDoH(ctx context.Context, conn internet.Connection, dispatcher routing.Dispatcher) error {
+	path := s.config.DohPath
+	if len(path) == 0 {
+		path = defaultDoHPath
+	}
+
+	timeouts := s.policyManager.ForLevel(s.config.UserLevel).Timeouts
+	timeout := timeouts.Handshake
+	limited := &io.LimitedReader{R: conn}
+	reader := bufio.NewReader(limited)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return newError("failed to set read deadline").Base(err)
+		}
+		limited.N = maxDoHHeaderSize
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return newError("failed to read DoH request").Base(err)
+		}
+		timeout = timeouts.ConnectionIdle
+		// The body, drained below if it is not read, is bounded separately.
+		limited.N += maxDoHQuerySize
+
+		resp := &http.Response{
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Close:      req.Close,
+		}
+		query, status := readDoHQuery(req, path)
+		// Drain the request body, so that the next request on the connection can be read.
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+
+		var answer *buf.Buffer
+		if status == http.StatusOK {
+			answer, err = s.answer(ctx, query, dispatcher)
+			if err != nil {
+				newError("failed to answer DoH query").Base(err).WriteToLog(session.ExportIDToError(ctx))
+				status = http.StatusBadRequest
+			} else {
+				resp.Header.Set("Content-Type", dohMediaType)
+				resp.Body = io.NopCloser(bytes.NewReader(answer.Bytes()))
+				resp.ContentLength = int64(answer.Len())
+			}
+		}
+		resp.StatusCode = status
+		resp.Status = strconv.Itoa(status) + " " + http.StatusText(status)
+
+		err = resp.Write(conn)
+		if answer != nil {
+			answer.Release()
+		}
+		if err != nil {
+			return newError("failed to write DoH response").Base(err)
+		}
+		if req.Close {
+			return nil
+		}
+	}
+}
+
+// readDoHQuery reads the DNS query in a DoH request, in either GET or POST method.
+func readDoHQuery(req *http.Request, path string) ([]byte, int) {
+	if req.URL.Path != path {
+		return nil, http.StatusNotFound
+	}
+	switch req.Method {
+	case http.MethodGet:
+		query, err := base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+		if err != nil || len(query) == 0 {
+			return nil, http.StatusBadRequest
+		}
+		return query, http.StatusOK
+	case http.MethodPost:
+		if req.Header.Get("Content-Type") != dohMediaType {
+			return nil, http.StatusUnsupportedMediaType
+		}
+		query, err := io.ReadAll(io.LimitReader(req.Body, maxDoHQuerySize))
+		if err != nil || len(query) == 0 {
+			return nil, http.StatusBadRequest
+		}
+		return query, http.StatusOK
+	default:
+		return nil, http.StatusMethodNotAllowed
+	}
+}
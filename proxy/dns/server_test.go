@@ -0,0 +1,392 @@
+package dns_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/dns/dnsmessage"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	core "github.com/v2fly/v2ray-core/v4"
+	"github.com/v2fly/v2ray-core/v4/app/dispatcher"
+	dnsapp "github.com/v2fly/v2ray-core/v4/app/dns"
+	"github.com/v2fly/v2ray-core/v4/app/dns/fakedns"
+	"github.com/v2fly/v2ray-core/v4/app/policy"
+	"github.com/v2fly/v2ray-core/v4/app/proxyman"
+	"github.com/v2fly/v2ray-core/v4/common"
+	"github.com/v2fly/v2ray-core/v4/common/net"
+	"github.com/v2fly/v2ray-core/v4/common/serial"
+	feature_dns "github.com/v2fly/v2ray-core/v4/features/dns"
+	dns_proxy "github.com/v2fly/v2ray-core/v4/proxy/dns"
+	"github.com/v2fly/v2ray-core/v4/proxy/freedom"
+	"github.com/v2fly/v2ray-core/v4/testing/servers/tcp"
+	"github.com/v2fly/v2ray-core/v4/testing/servers/udp"
+)
+
+func TestDNSServerInbound(t *testing.T) {
+	port := udp.PickPort()
+
+	dnsServer := dns.Server{
+		Addr:    "127.0.0.1:" + port.String(),
+		Net:     "udp",
+		Handler: &staticHandler{},
+		UDPSize: 1200,
+	}
+	defer dnsServer.Shutdown()
+
+	go dnsServer.ListenAndServe()
+	time.Sleep(time.Second)
+
+	upstream := &net.Endpoint{
+		Network: net.Network_UDP,
+		Address: net.NewIPOrDomain(net.LocalHostIP),
+		Port:    uint32(port),
+	}
+	serverPort := tcp.PickPort()
+	dohPort := tcp.PickPort()
+	config := &core.Config{
+		App: []*anypb.Any{
+			serial.ToTypedMessage(&dnsapp.Config{
+				NameServer: []*dnsapp.NameServer{
+					{Address: upstream},
+				},
+				StaticHosts: []*dnsapp.HostMapping{
+					{
+						Type:   dnsapp.DomainMatchingType_Full,
+						Domain: "v2fly.org",
+						Ip:     [][]byte{{1, 2, 3, 4}},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(&proxyman.InboundConfig{}),
+			serial.ToTypedMessage(&policy.Config{}),
+		},
+		Inbound: []*core.InboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&dns_proxy.ServerConfig{
+					Server: upstream,
+				}),
+				ReceiverSettings: serial.ToTypedMessage(&proxyman.ReceiverConfig{
+					PortRange: net.SinglePortRange(serverPort),
+					Listen:    net.NewIPOrDomain(net.LocalHostIP),
+				}),
+			},
+			{
+				ProxySettings: serial.ToTypedMessage(&dns_proxy.ServerConfig{
+					Doh: true,
+				}),
+				ReceiverSettings: serial.ToTypedMessage(&proxyman.ReceiverConfig{
+					PortRange: net.SinglePortRange(dohPort),
+					Listen:    net.NewIPOrDomain(net.LocalHostIP),
+				}),
+			},
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&freedom.Config{}),
+			},
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+	common.Must(v.Start())
+	defer v.Close()
+
+	serverAddr := "127.0.0.1:" + strconv.Itoa(int(serverPort))
+	query := func(network string, name string, qtype uint16) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, qtype)
+		c := &dns.Client{Net: network, Timeout: 5 * time.Second}
+		in, _, err := c.Exchange(m, serverAddr)
+		common.Must(err)
+		return in
+	}
+
+	for _, network := range []string{"udp", "tcp"} {
+		in := query(network, "v2fly.org.", dns.TypeA)
+		if len(in.Answer) != 1 || !in.Answer[0].(*dns.A).A.Equal(net.IP{1, 2, 3, 4}) {
+			t.Error("unexpected answer from static hosts over ", network, ": ", in.Answer)
+		}
+
+		in = query(network, "google.com.", dns.TypeA)
+		if len(in.Answer) != 1 || !in.Answer[0].(*dns.A).A.Equal(net.IP{8, 8, 8, 8}) {
+			t.Error("unexpected answer from name server over ", network, ": ", in.Answer)
+		}
+
+		in = query(network, "google.com.", dns.TypeTXT)
+		if len(in.Answer) != 1 || in.Answer[0].(*dns.TXT).Txt[0] != "v=spf1 -all" {
+			t.Error("unexpected TXT answer over ", network, ": ", in.Answer)
+		}
+	}
+
+	// DNS over HTTPS, in plain HTTP here as TLS is provided by stream settings.
+	m := new(dns.Msg)
+	m.SetQuestion("v2fly.org.", dns.TypeA)
+	packed, err := m.Pack()
+	common.Must(err)
+	resp, err := http.Post("http://127.0.0.1:"+strconv.Itoa(int(dohPort))+"/dns-query", "application/dns-message", bytes.NewReader(packed))
+	common.Must(err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("unexpected DoH status: ", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	common.Must(err)
+	in := new(dns.Msg)
+	common.Must(in.Unpack(body))
+	if len(in.Answer) != 1 || !in.Answer[0].(*dns.A).A.Equal(net.IP{1, 2, 3, 4}) {
+		t.Error("unexpected DoH answer: ", in.Answer)
+	}
+
+	// TXT queries are answered by the DNS client over DoH too.
+	m.SetQuestion("google.com.", dns.TypeTXT)
+	packed, err = m.Pack()
+	common.Must(err)
+	resp, err = http.Post("http://127.0.0.1:"+strconv.Itoa(int(dohPort))+"/dns-query", "application/dns-message", bytes.NewReader(packed))
+	common.Must(err)
+	defer resp.Body.Close()
+	body, err = io.ReadAll(resp.Body)
+	common.Must(err)
+	common.Must(in.Unpack(body))
+	if len(in.Answer) != 1 || in.Answer[0].(*dns.TXT).Txt[0] != "v=spf1 -all" {
+		t.Error("unexpected DoH TXT answer: ", in.Answer)
+	}
+
+	// Queries the DNS client cannot answer are refused without upstream server.
+	m.SetQuestion("google.com.", dns.TypeANY)
+	packed, err = m.Pack()
+	common.Must(err)
+	resp, err = http.Post("http://127.0.0.1:"+strconv.Itoa(int(dohPort))+"/dns-query", "application/dns-message", bytes.NewReader(packed))
+	common.Must(err)
+	defer resp.Body.Close()
+	body, err = io.ReadAll(resp.Body)
+	common.Must(err)
+	common.Must(in.Unpack(body))
+	if in.Rcode != dns.RcodeRefused {
+		t.Error("expect refused, but got ", in.Rcode)
+	}
+}
+
+func TestDNSServerInboundForwardsUnsupportedRecords(t *testing.T) {
+	port := udp.PickPort()
+
+	dnsServer := dns.Server{
+		Addr:    "127.0.0.1:" + port.String(),
+		Net:     "udp",
+		Handler: &staticHandler{},
+		UDPSize: 1200,
+	}
+	defer dnsServer.Shutdown()
+
+	go dnsServer.ListenAndServe()
+	time.Sleep(time.Second)
+
+	serverPort := tcp.PickPort()
+	config := &core.Config{
+		App: []*anypb.Any{
+			serial.ToTypedMessage(&dnsapp.Config{
+				NameServer: []*dnsapp.NameServer{
+					{Address: &net.Endpoint{Network: net.Network_UDP, Address: net.NewIPOrDomain(net.DomainAddress("localhost"))}},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(&proxyman.InboundConfig{}),
+			serial.ToTypedMessage(&policy.Config{}),
+		},
+		Inbound: []*core.InboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&dns_proxy.ServerConfig{
+					Server: &net.Endpoint{
+						Network: net.Network_UDP,
+						Address: net.NewIPOrDomain(net.LocalHostIP),
+						Port:    uint32(port),
+					},
+				}),
+				ReceiverSettings: serial.ToTypedMessage(&proxyman.ReceiverConfig{
+					PortRange: net.SinglePortRange(serverPort),
+					Listen:    net.NewIPOrDomain(net.LocalHostIP),
+				}),
+			},
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&freedom.Config{}),
+			},
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+	common.Must(v.Start())
+	defer v.Close()
+
+	// The local name server doesn't support HTTPS records, so the query goes
+	// to the upstream server.
+	m := new(dns.Msg)
+	m.SetQuestion("google.com.", dns.TypeHTTPS)
+	c := &dns.Client{Net: "udp", Timeout: 5 * time.Second}
+	in, _, err := c.Exchange(m, "127.0.0.1:"+strconv.Itoa(int(serverPort)))
+	common.Must(err)
+	if len(in.Answer) != 1 {
+		t.Fatal("len(answer): ", len(in.Answer))
+	}
+	if _, ok := in.Answer[0].(*dns.HTTPS); !ok {
+		t.Error("not HTTPS record: ", in.Answer[0])
+	}
+}
+
+func TestDNSServerInboundDoHLimits(t *testing.T) {
+	dohPort := tcp.PickPort()
+	config := &core.Config{
+		App: []*anypb.Any{
+			serial.ToTypedMessage(&dnsapp.Config{}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(&proxyman.InboundConfig{}),
+			serial.ToTypedMessage(&policy.Config{
+				Level: map[uint32]*policy.Policy{
+					0: {
+						Timeout: &policy.Policy_Timeout{
+							Handshake:      &policy.Second{Value: 1},
+							ConnectionIdle: &policy.Second{Value: 1},
+						},
+					},
+				},
+			}),
+		},
+		Inbound: []*core.InboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&dns_proxy.ServerConfig{
+					Doh: true,
+				}),
+				ReceiverSettings: serial.ToTypedMessage(&proxyman.ReceiverConfig{
+					PortRange: net.SinglePortRange(dohPort),
+					Listen:    net.NewIPOrDomain(net.LocalHostIP),
+				}),
+			},
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&freedom.Config{}),
+			},
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+	common.Must(v.Start())
+	defer v.Close()
+
+	// expectClosed sends the request and expects the server to close the
+	// connection without a response before the deadline.
+	expectClosed := func(name string, request []byte) {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(int(dohPort)))
+		common.Must(err)
+		defer conn.Close()
+		common.Must(conn.SetDeadline(time.Now().Add(5 * time.Second)))
+		if _, err := conn.Write(request); err != nil {
+			return
+		}
+		// The connection may be reset if the server closes it with unread data.
+		start := time.Now()
+		if n, err := conn.Read(make([]byte, 1)); n > 0 || err == nil || time.Since(start) > 4*time.Second {
+			t.Error(name, ": expected connection to be closed, got ", n, " bytes and ", err)
+		}
+	}
+
+	expectClosed("incomplete request", []byte("GET /dns-query HTTP/1.1\r\n"))
+	expectClosed("large header", append([]byte("GET /dns-query HTTP/1.1\r\nX-Large: "), bytes.Repeat([]byte{'a'}, 64*1024)...))
+}
+
+func TestDNSServerInboundFakeDNSOption(t *testing.T) {
+	port := udp.PickPort()
+
+	dnsServer := dns.Server{
+		Addr:    "127.0.0.1:" + port.String(),
+		Net:     "udp",
+		Handler: &staticHandler{},
+		UDPSize: 1200,
+	}
+	defer dnsServer.Shutdown()
+
+	go dnsServer.ListenAndServe()
+	time.Sleep(time.Second)
+
+	serverPort := tcp.PickPort()
+	config := &core.Config{
+		App: []*anypb.Any{
+			serial.ToTypedMessage(&fakedns.FakeDnsPool{
+				IpPool:  "198.18.0.0/15",
+				LruSize: 16,
+			}),
+			serial.ToTypedMessage(&dnsapp.Config{
+				NameServer: []*dnsapp.NameServer{
+					{Address: &net.Endpoint{Network: net.Network_UDP, Address: net.NewIPOrDomain(net.DomainAddress("fakedns"))}},
+					{Address: &net.Endpoint{Network: net.Network_UDP, Address: net.NewIPOrDomain(net.LocalHostIP), Port: uint32(port)}},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(&proxyman.InboundConfig{}),
+			serial.ToTypedMessage(&policy.Config{}),
+		},
+		Inbound: []*core.InboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&dns_proxy.ServerConfig{}),
+				ReceiverSettings: serial.ToTypedMessage(&proxyman.ReceiverConfig{
+					PortRange: net.SinglePortRange(serverPort),
+					Listen:    net.NewIPOrDomain(net.LocalHostIP),
+				}),
+			},
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&freedom.Config{}),
+			},
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+	common.Must(v.Start())
+	defer v.Close()
+
+	m := new(dns.Msg)
+	m.SetQuestion("google.com.", dns.TypeA)
+	c := &dns.Client{Net: "udp", Timeout: 5 * time.Second}
+	in, _, err := c.Exchange(m, "127.0.0.1:"+strconv.Itoa(int(serverPort)))
+	common.Must(err)
+	if len(in.Answer) != 1 || !in.Answer[0].(*dns.A).A.Equal(net.IP{198, 18, 0, 0}) {
+		t.Fatal("expected fake IP, got ", in.Answer)
+	}
+
+	// Records of a domain answered with fake IPs are not revealed.
+	m.SetQuestion("google.com.", dns.TypeHTTPS)
+	in, _, err = c.Exchange(m, "127.0.0.1:"+strconv.Itoa(int(serverPort)))
+	common.Must(err)
+	if in.Rcode != dns.RcodeSuccess || len(in.Answer) != 0 {
+		t.Error("expected no HTTPS records, got ", in.Rcode, " ", in.Answer)
+	}
+
+	// Other lookups of the DNS client still skip FakeDNS.
+	client := v.GetFeature(feature_dns.ClientType()).(feature_dns.Client)
+	ips, err := client.LookupIP("google.com")
+	common.Must(err)
+	if len(ips) != 1 || !ips[0].Equal(net.IP{8, 8, 8, 8}) {
+		t.Error("expected real IP, got ", ips)
+	}
+	records, err := client.(feature_dns.RecordLookup).LookupRecords("google.com", dnsmessage.Type(dns.TypeHTTPS), false)
+	common.Must(err)
+	if len(records) != 1 {
+		t.Error("expected real HTTPS records, got ", records)
+	}
+}
@@ -65,24 +65,37 @@ func (h *Handler) policy() policy.Session {
 }
 
 func (h *Handler) resolveIP(ctx context.Context, domain string, localAddr net.Address) net.Address {
-	if c, ok := h.dns.(dns.ClientWithIPOption); ok {
-		c.SetFakeDNSOption(false) // Skip FakeDNS
+	ipv4 := h.config.DomainStrategy == Config_USE_IP4 || (localAddr != nil && localAddr.Family().IsIPv4())
+	ipv6 := !ipv4 && (h.config.DomainStrategy == Config_USE_IP6 || (localAddr != nil && localAddr.Family().IsIPv6()))
+
+	var ips []net.IP
+	var err error
+	if c, ok := h.dns.(dns.ContextLookup); ok {
+		ips, err = c.LookupIPWithContext(ctx, domain, dns.IPOption{
+			IPv4Enable: !ipv6,
+			IPv6Enable: !ipv4,
+			FakeEnable: false, // Skip FakeDNS
+		})
 	} else {
-		newError("DNS client doesn't implement ClientWithIPOption")
-	}
-
-	var lookupFunc = h.dns.LookupIP
-	if h.config.DomainStrategy == Config_USE_IP4 || (localAddr != nil && localAddr.Family().IsIPv4()) {
-		if lookupIPv4, ok := h.dns.(dns.IPv4Lookup); ok {
-			lookupFunc = lookupIPv4.LookupIPv4
+		if c, ok := h.dns.(dns.ClientWithIPOption); ok {
+			c.SetFakeDNSOption(false) // Skip FakeDNS
+		} else {
+			newError("DNS client doesn't implement ClientWithIPOption")
 		}
-	} else if h.config.DomainStrategy == Config_USE_IP6 || (localAddr != nil && localAddr.Family().IsIPv6()) {
-		if lookupIPv6, ok := h.dns.(dns.IPv6Lookup); ok {
-			lookupFunc = lookupIPv6.LookupIPv6
+
+		var lookupFunc = h.dns.LookupIP
+		if ipv4 {
+			if lookupIPv4, ok := h.dns.(dns.IPv4Lookup); ok {
+				lookupFunc = lookupIPv4.LookupIPv4
+			}
+		} else if ipv6 {
+			if lookupIPv6, ok := h.dns.(dns.IPv6Lookup); ok {
+				lookupFunc = lookupIPv6.LookupIPv6
+			}
 		}
+		ips, err = lookupFunc(domain)
 	}
 
-	ips, err := lookupFunc(domain)
 	if err != nil {
 		newError("failed to get IP address for domain ", domain).Base(err).WriteToLog(session.ExportIDToError(ctx))
 	}
@@ -22,6 +22,7 @@ import (
 	"github.com/v2fly/v2ray-core/v4/common/task"
 	"github.com/v2fly/v2ray-core/v4/features/policy"
 	"github.com/v2fly/v2ray-core/v4/features/routing"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
 	"github.com/v2fly/v2ray-core/v4/transport/internet"
 )
 
@@ -29,6 +30,7 @@ import (
 type Server struct {
 	config        *ServerConfig
 	policyManager policy.Manager
+	statsManager  stats.Manager
 }
 
 // NewServer creates a new HTTP inbound handler.
@@ -37,6 +39,7 @@ func NewServer(ctx context.Context, config *ServerConfig) (*Server, error) {
 	s := &Server{
 		config:        config,
 		policyManager: v.GetFeature(policy.ManagerType()).(policy.Manager),
+		statsManager:  v.GetFeature(stats.ManagerType()).(stats.Manager),
 	}
 
 	return s, nil
@@ -91,6 +94,7 @@ func (s *Server) Process(ctx context.Context, network net.Network, conn internet
 	}
 
 	reader := bufio.NewReaderSize(readerOnly{conn}, buf.Size)
+	online := false
 
 Start:
 	if err := conn.SetReadDeadline(time.Now().Add(s.policy().Timeouts.Handshake)); err != nil {
@@ -113,6 +117,11 @@ Start:
 		}
 		if inbound != nil {
 			inbound.User.Email = user
+			// Requests on a kept-alive connection are a single session.
+			if !online && s.policy().Stats.UserOnline {
+				online = true
+				defer stats.TrackOnlineSession(s.statsManager, user, inbound.Source)()
+			}
 		}
 	}
 
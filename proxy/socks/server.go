@@ -18,6 +18,7 @@ import (
 	"github.com/v2fly/v2ray-core/v4/features"
 	"github.com/v2fly/v2ray-core/v4/features/policy"
 	"github.com/v2fly/v2ray-core/v4/features/routing"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
 	"github.com/v2fly/v2ray-core/v4/transport/internet"
 	"github.com/v2fly/v2ray-core/v4/transport/internet/udp"
 )
@@ -26,6 +27,7 @@ import (
 type Server struct {
 	config        *ServerConfig
 	policyManager policy.Manager
+	statsManager  stats.Manager
 }
 
 // NewServer creates a new Server object.
@@ -34,6 +36,7 @@ func NewServer(ctx context.Context, config *ServerConfig) (*Server, error) {
 	s := &Server{
 		config:        config,
 		policyManager: v.GetFeature(policy.ManagerType()).(policy.Manager),
+		statsManager:  v.GetFeature(stats.ManagerType()).(stats.Manager),
 	}
 	return s, nil
 }
@@ -110,6 +113,9 @@ func (s *Server) processTCP(ctx context.Context, conn internet.Connection, dispa
 	}
 	if request.User != nil {
 		inbound.User.Email = request.User.Email
+		if plcy.Stats.UserOnline {
+			defer stats.TrackOnlineSession(s.statsManager, request.User.Email, inbound.Source)()
+		}
 	}
 
 	if err := conn.SetReadDeadline(time.Time{}); err != nil {
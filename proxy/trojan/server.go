@@ -20,6 +20,7 @@ import (
 	"github.com/v2fly/v2ray-core/v4/common/task"
 	"github.com/v2fly/v2ray-core/v4/features/policy"
 	"github.com/v2fly/v2ray-core/v4/features/routing"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
 	"github.com/v2fly/v2ray-core/v4/transport/internet"
 	"github.com/v2fly/v2ray-core/v4/transport/internet/tls"
 	"github.com/v2fly/v2ray-core/v4/transport/internet/udp"
@@ -34,6 +35,7 @@ func init() {
 // Server is an inbound connection handler that handles messages in trojan protocol.
 type Server struct {
 	policyManager policy.Manager
+	statsManager  stats.Manager
 	validator     *Validator
 	fallbacks     map[string]map[string]*Fallback // or nil
 }
@@ -55,6 +57,7 @@ func NewServer(ctx context.Context, config *ServerConfig) (*Server, error) {
 	v := core.MustFromContext(ctx)
 	server := &Server{
 		policyManager: v.GetFeature(policy.ManagerType()).(policy.Manager),
+		statsManager:  v.GetFeature(stats.ManagerType()).(stats.Manager),
 		validator:     validator,
 	}
 
@@ -186,6 +189,9 @@ func (s *Server) Process(ctx context.Context, network net.Network, conn internet
 	}
 	inbound.User = user
 	sessionPolicy = s.policyManager.ForLevel(user.Level)
+	if sessionPolicy.Stats.UserOnline {
+		defer stats.TrackOnlineSession(s.statsManager, user.Email, inbound.Source)()
+	}
 
 	if destination.Network == net.Network_UDP { // handle udp request
 		return s.handleUDPPayload(ctx, &PacketReader{Reader: clientReader}, &PacketWriter{Writer: conn}, dispatcher)
@@ -24,6 +24,7 @@ import (
 	feature_inbound "github.com/v2fly/v2ray-core/v4/features/inbound"
 	"github.com/v2fly/v2ray-core/v4/features/policy"
 	"github.com/v2fly/v2ray-core/v4/features/routing"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
 	"github.com/v2fly/v2ray-core/v4/proxy/vless"
 	"github.com/v2fly/v2ray-core/v4/proxy/vless/encoding"
 	"github.com/v2fly/v2ray-core/v4/transport/internet"
@@ -65,6 +66,7 @@ func init() {
 type Handler struct {
 	inboundHandlerManager feature_inbound.Manager
 	policyManager         policy.Manager
+	statsManager          stats.Manager
 	validator             *vless.Validator
 	dns                   dns.Client
 	fallbacks             map[string]map[string]*Fallback // or nil
@@ -77,6 +79,7 @@ func New(ctx context.Context, config *Config, dc dns.Client) (*Handler, error) {
 	handler := &Handler{
 		inboundHandlerManager: v.GetFeature(feature_inbound.ManagerType()).(feature_inbound.Manager),
 		policyManager:         v.GetFeature(policy.ManagerType()).(policy.Manager),
+		statsManager:          v.GetFeature(stats.ManagerType()).(stats.Manager),
 		validator:             new(vless.Validator),
 		dns:                   dc,
 	}
@@ -382,6 +385,9 @@ func (h *Handler) Process(ctx context.Context, network net.Network, connection i
 	}
 
 	sessionPolicy = h.policyManager.ForLevel(request.User.Level)
+	if sessionPolicy.Stats.UserOnline {
+		defer stats.TrackOnlineSession(h.statsManager, request.User.Email, inbound.Source)()
+	}
 	ctx, cancel := context.WithCancel(ctx)
 	timer := signal.CancelAfterInactivity(ctx, cancel, sessionPolicy.Timeouts.ConnectionIdle)
 	ctx = policy.ContextWithBufferPolicy(ctx, sessionPolicy.Buffer)
@@ -25,6 +25,7 @@ import (
 	feature_inbound "github.com/v2fly/v2ray-core/v4/features/inbound"
 	"github.com/v2fly/v2ray-core/v4/features/policy"
 	"github.com/v2fly/v2ray-core/v4/features/routing"
+	"github.com/v2fly/v2ray-core/v4/features/stats"
 	"github.com/v2fly/v2ray-core/v4/proxy/vmess"
 	"github.com/v2fly/v2ray-core/v4/proxy/vmess/encoding"
 	"github.com/v2fly/v2ray-core/v4/transport/internet"
@@ -103,6 +104,7 @@ func (v *userByEmail) Remove(email string) bool {
 // Handler is an inbound connection handler that handles messages in VMess protocol.
 type Handler struct {
 	policyManager         policy.Manager
+	statsManager          stats.Manager
 	inboundHandlerManager feature_inbound.Manager
 	clients               *vmess.TimedUserValidator
 	usersByEmail          *userByEmail
@@ -116,6 +118,7 @@ func New(ctx context.Context, config *Config) (*Handler, error) {
 	v := core.MustFromContext(ctx)
 	handler := &Handler{
 		policyManager:         v.GetFeature(policy.ManagerType()).(policy.Manager),
+		statsManager:          v.GetFeature(stats.ManagerType()).(stats.Manager),
 		inboundHandlerManager: v.GetFeature(feature_inbound.ManagerType()).(feature_inbound.Manager),
 		clients:               vmess.NewTimedUserValidator(protocol.DefaultIDHash),
 		detours:               config.Detour,
@@ -275,6 +278,9 @@ func (h *Handler) Process(ctx context.Context, network net.Network, connection i
 	inbound.User = request.User
 
 	sessionPolicy = h.policyManager.ForLevel(request.User.Level)
+	if sessionPolicy.Stats.UserOnline {
+		defer stats.TrackOnlineSession(h.statsManager, request.User.Email, inbound.Source)()
+	}
 
 	ctx, cancel := context.WithCancel(ctx)
 	timer := signal.CancelAfterInactivity(ctx, cancel, sessionPolicy.Timeouts.ConnectionIdle)
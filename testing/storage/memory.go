@@ -0,0 +1,64 @@
+// Package storage contains the persistent storage engines used in tests.
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/v2fly/v2ray-core/v4/features/extension"
+)
+
+// MemoryStorage is a persistent storage engine that keeps the values in
+// memory, so that they last as long as the engine.
+type MemoryStorage struct {
+	access sync.Mutex
+	values map[string][]byte
+}
+
+// NewMemoryStorage creates an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{values: make(map[string][]byte)}
+}
+
+func (*MemoryStorage) Type() interface{} {
+	return extension.PersistentStorageEngineType()
+}
+
+func (*MemoryStorage) Start() error { return nil }
+
+func (*MemoryStorage) Close() error { return nil }
+
+func (*MemoryStorage) PersistentStorageEngine() {}
+
+func (s *MemoryStorage) Put(_ context.Context, key []byte, value []byte) error {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.values[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *MemoryStorage) Get(_ context.Context, key []byte) ([]byte, error) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	value, found := s.values[string(key)]
+	if !found {
+		return nil, extension.ErrPersistentStorageKeyNotFound
+	}
+	return value, nil
+}
+
+func (s *MemoryStorage) List(_ context.Context, keyPrefix []byte) ([][]byte, error) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	var keys [][]byte
+	for k := range s.values {
+		if strings.HasPrefix(k, string(keyPrefix)) {
+			keys = append(keys, []byte(k))
+		}
+	}
+	return keys, nil
+}
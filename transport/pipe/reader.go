@@ -25,3 +25,8 @@ func (r *Reader) ReadMultiBufferTimeout(d time.Duration) (buf.MultiBuffer, error
 func (r *Reader) Interrupt() {
 	r.pipe.Interrupt()
 }
+
+// Done returns a channel that is closed once the pipe is closed or interrupted.
+func (r *Reader) Done() <-chan struct{} {
+	return r.pipe.done.Wait()
+}